@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Identity is the authenticated caller of an RPC
+type Identity struct {
+	Subject string
+	Roles   []string
+	// Method is the credential that authenticated the caller: "token", "jwt", "hmac" or "mtls"
+	Method string
+}
+
+// HasRole reports whether the identity carries role
+func (id *Identity) HasRole(role string) bool {
+	for _, r := range id.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+type identityKey struct{}
+
+// NewContext returns a copy of ctx carrying the caller identity
+func NewContext(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// FromContext returns the caller identity placed on ctx by the server interceptors
+func FromContext(ctx context.Context) (*Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(*Identity)
+	return id, ok
+}
+
+// Principal is a caller known to the server, read from a credentials file
+type Principal struct {
+	Subject string
+	Secret  string
+	Roles   []string
+}
+
+// LoadPrincipals reads a credentials file with one principal per line:
+//
+//	<subject> <secret> [role,role...]
+//
+// Blank lines and lines starting with # are ignored. For bearer tokens the
+// secret is the token; for HMAC signatures the subject is the key ID.
+func LoadPrincipals(path string) ([]Principal, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open credentials file: %w", err)
+	}
+	defer f.Close()
+
+	var principals []Principal
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("%s:%d: expected <subject> <secret> [roles]", path, line)
+		}
+
+		principal := Principal{Subject: fields[0], Secret: fields[1]}
+		if len(fields) == 3 {
+			principal.Roles = strings.Split(fields[2], ",")
+		}
+		principals = append(principals, principal)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read credentials file: %w", err)
+	}
+	return principals, nil
+}
@@ -0,0 +1,166 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Policy authorizes calls by full method name. A call is denied when any deny
+// rule matches both the caller and the method, whatever the other rules say.
+// Otherwise it is allowed when any rule matches both; everything else is denied.
+//
+// A policy file is JSON:
+//
+//	{
+//	  "rules": [
+//	    {"roles": ["admin"], "methods": ["*"]},
+//	    {"roles": ["operator"], "methods": [
+//	      "/reflect.SyncService/*",
+//	      "/reflect.TestService/*",
+//	      "/grpc.reflection.v1.ServerReflection/*"
+//	    ]},
+//	    {"roles": ["operator"], "methods": ["/reflect.TestService/Run"], "deny": true}
+//	  ]
+//	}
+//
+// In method patterns * matches any run of characters, so "*" matches every
+// method. The reflection service is authorized like any other service. A rule
+// with the subject "*" also matches anonymous callers.
+type Policy struct {
+	Rules []Rule `json:"rules"`
+}
+
+// Rule grants the listed subjects and roles access to the matching methods,
+// or denies it when Deny is set
+type Rule struct {
+	Subjects []string `json:"subjects,omitempty"`
+	Roles    []string `json:"roles,omitempty"`
+	Methods  []string `json:"methods"`
+	Deny     bool     `json:"deny,omitempty"`
+
+	patterns []*regexp.Regexp
+}
+
+// LoadPolicy reads and compiles a policy file
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy file: %w", err)
+	}
+
+	policy := &Policy{}
+	if err := json.Unmarshal(data, policy); err != nil {
+		return nil, fmt.Errorf("failed to parse policy file: %w", err)
+	}
+	if err := policy.compile(); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+func (p *Policy) compile() error {
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if len(rule.Subjects) == 0 && len(rule.Roles) == 0 {
+			return fmt.Errorf("policy rule %d has no subjects or roles", i)
+		}
+
+		rule.patterns = make([]*regexp.Regexp, 0, len(rule.Methods))
+		for _, method := range rule.Methods {
+			if method != "*" && !strings.HasPrefix(method, "/") {
+				method = "/" + method
+			}
+			expr := "^" + strings.ReplaceAll(regexp.QuoteMeta(method), `\*`, ".*") + "$"
+			pattern, err := regexp.Compile(expr)
+			if err != nil {
+				return fmt.Errorf("invalid method pattern %q: %w", method, err)
+			}
+			rule.patterns = append(rule.patterns, pattern)
+		}
+	}
+	return nil
+}
+
+// Allowed reports whether id may call fullMethod. id is nil for anonymous callers.
+func (p *Policy) Allowed(id *Identity, fullMethod string) bool {
+	allowed := false
+	for _, rule := range p.Rules {
+		if !rule.matchesCaller(id) || !rule.matchesMethod(fullMethod) {
+			continue
+		}
+		if rule.Deny {
+			return false
+		}
+		allowed = true
+	}
+	return allowed
+}
+
+func (r *Rule) matchesCaller(id *Identity) bool {
+	for _, subject := range r.Subjects {
+		if subject == "*" || (id != nil && subject == id.Subject) {
+			return true
+		}
+	}
+	if id == nil {
+		return false
+	}
+	for _, role := range r.Roles {
+		if id.HasRole(role) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Rule) matchesMethod(fullMethod string) bool {
+	for _, pattern := range r.patterns {
+		if pattern.MatchString(fullMethod) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Policy) authorize(ctx context.Context, fullMethod string) error {
+	id, _ := FromContext(ctx)
+	if p.Allowed(id, fullMethod) {
+		return nil
+	}
+
+	subject := "anonymous caller"
+	if id != nil {
+		subject = id.Subject
+	}
+	return status.Errorf(codes.PermissionDenied, "%s is not allowed to call %s", subject, fullMethod)
+}
+
+// UnaryServerInterceptor enforces the policy on unary calls. It must run after
+// the authentication interceptor so the caller identity is on the context.
+func (p *Policy) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if err := p.authorize(ctx, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor enforces the policy on streaming calls. It must run
+// after the authentication interceptor so the caller identity is on the context.
+func (p *Policy) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := p.authorize(ss.Context(), info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
@@ -0,0 +1,121 @@
+package auth_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	auth "github.com/vedantkulkarni/reflect-poc/auth"
+	service_proto "github.com/vedantkulkarni/reflect-poc/service-proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func loadPolicy(t *testing.T, policy string) (*auth.Policy, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "policy.json")
+	if err := os.WriteFile(path, []byte(policy), 0o600); err != nil {
+		t.Fatalf("failed to write policy: %v", err)
+	}
+	return auth.LoadPolicy(path)
+}
+
+const testPolicy = `{
+  "rules": [
+    {"roles": ["admin"], "methods": ["*"]},
+    {"roles": ["operator"], "methods": ["/reflect.SyncService/*", "reflect.TestService/Test*"]},
+    {"subjects": ["monitor"], "methods": ["/grpc.health.v1.Health/Check"]},
+    {"subjects": ["*"], "methods": ["/grpc.reflection.v1.ServerReflection/ServerReflectionInfo"]},
+    {"roles": ["operator"], "methods": ["/reflect.SyncService/SyncBidiStream"], "deny": true},
+    {"subjects": ["intern"], "methods": ["*"], "deny": true}
+  ]
+}`
+
+func TestPolicyAllowed(t *testing.T) {
+	policy, err := loadPolicy(t, testPolicy)
+	if err != nil {
+		t.Fatalf("failed to load policy: %v", err)
+	}
+
+	admin := &auth.Identity{Subject: "alice", Roles: []string{"admin"}}
+	operator := &auth.Identity{Subject: "bob", Roles: []string{"operator"}}
+	monitor := &auth.Identity{Subject: "monitor"}
+	intern := &auth.Identity{Subject: "intern", Roles: []string{"admin"}}
+
+	for _, tc := range []struct {
+		name   string
+		id     *auth.Identity
+		method string
+		want   bool
+	}{
+		{"wildcard matches every method", admin, "/reflect.TestService/Run", true},
+		{"service wildcard", operator, "/reflect.SyncService/Sync", true},
+		{"pattern without leading slash", operator, "/reflect.TestService/TestServerStream", true},
+		{"prefix wildcard does not match other methods", operator, "/reflect.TestService/Run", false},
+		{"wildcard does not cross services", operator, "/reflect.SyncServiceX/Sync", false},
+		{"exact method by subject", monitor, "/grpc.health.v1.Health/Check", true},
+		{"exact method does not match its prefix", monitor, "/grpc.health.v1.Health/CheckAll", false},
+		{"other method of an exact rule", monitor, "/grpc.health.v1.Health/Watch", false},
+		{"anonymous caller matched by subject *", nil, "/grpc.reflection.v1.ServerReflection/ServerReflectionInfo", true},
+		{"anonymous caller without a matching rule", nil, "/reflect.SyncService/Sync", false},
+		{"deny rule wins over a service wildcard", operator, "/reflect.SyncService/SyncBidiStream", false},
+		{"deny rule wins over an earlier role grant", intern, "/reflect.TestService/Test", false},
+		{"deny rule only applies to its callers", admin, "/reflect.SyncService/SyncBidiStream", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := policy.Allowed(tc.id, tc.method); got != tc.want {
+				t.Errorf("Allowed(%v, %s) = %v, want %v", tc.id, tc.method, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestLoadPolicyErrors(t *testing.T) {
+	for name, policy := range map[string]string{
+		"invalid JSON":         `{"rules": [`,
+		"rule without callers": `{"rules": [{"methods": ["*"]}]}`,
+		"deny without callers": `{"rules": [{"methods": ["*"], "deny": true}]}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := loadPolicy(t, policy); err == nil {
+				t.Error("policy loaded")
+			}
+		})
+	}
+}
+
+func TestPolicyInterceptors(t *testing.T) {
+	policy, err := loadPolicy(t, testPolicy)
+	if err != nil {
+		t.Fatalf("failed to load policy: %v", err)
+	}
+	// Authenticate every caller as an operator
+	operator := &auth.Identity{Subject: "bob", Roles: []string{"operator"}}
+	authenticate := func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		return handler(auth.NewContext(ctx, operator), req)
+	}
+	dial := serve(t,
+		[]grpc.UnaryServerInterceptor{authenticate, policy.UnaryServerInterceptor()},
+		[]grpc.StreamServerInterceptor{policy.StreamServerInterceptor()})
+	client := dial()
+	ctx := context.Background()
+
+	if _, err := client.Test(ctx, &service_proto.TestMessageRequest{}); err != nil {
+		t.Errorf("allowed unary call failed: %v", err)
+	}
+	if _, err := client.Run(ctx, &service_proto.RunMessageRequest{}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("denied unary call returned %v, want PermissionDenied", err)
+	}
+
+	// The stream has no authenticated identity, so the caller is anonymous
+	stream, err := client.TestServerStream(ctx, &service_proto.TestMessageRequest{})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("anonymous stream returned %v, want PermissionDenied", err)
+	}
+}
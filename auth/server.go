@@ -0,0 +1,362 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// ErrNoCredentials is returned by an Authenticator when the call does not
+// carry the kind of credential it checks
+var ErrNoCredentials = errors.New("no credentials")
+
+// Call is what an Authenticator sees of an incoming RPC
+type Call struct {
+	FullMethod string
+	Metadata   metadata.MD
+	// Request is the decoded request of a unary call and nil for streams
+	Request any
+}
+
+// Authenticator checks one kind of credential on an incoming call
+type Authenticator interface {
+	Authenticate(ctx context.Context, call Call) (*Identity, error)
+}
+
+// ServerConfig selects the credentials accepted by the device
+type ServerConfig struct {
+	// TokensFile lists static bearer tokens, see LoadPrincipals
+	TokensFile string
+	// JWTSecret verifies HS256 tokens
+	JWTSecret string
+	// JWTPublicKeyFile verifies RS256 or ES256 tokens with a PEM encoded public key
+	JWTPublicKeyFile string
+	// JWTAudience is required in the aud claim when set, normally the bridge ID
+	JWTAudience string
+	// HMACKeysFile lists HMAC key IDs and secrets, see LoadPrincipals
+	HMACKeysFile string
+	// MaxClockSkew bounds the age of HMAC signatures, and how long their
+	// nonces are remembered to reject replays
+	MaxClockSkew time.Duration
+	// PeerCertificates accepts callers that presented a client certificate over
+	// end-to-end mutual TLS
+	PeerCertificates bool
+}
+
+// Authenticators builds the authenticators enabled in the configuration
+func (c ServerConfig) Authenticators() ([]Authenticator, error) {
+	var authenticators []Authenticator
+
+	if c.TokensFile != "" {
+		principals, err := LoadPrincipals(c.TokensFile)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, NewTokenAuthenticator(principals))
+	}
+
+	if c.JWTSecret != "" || c.JWTPublicKeyFile != "" {
+		var key any = []byte(c.JWTSecret)
+		if c.JWTPublicKeyFile != "" {
+			publicKey, err := loadPublicKey(c.JWTPublicKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			key = publicKey
+		}
+		authenticators = append(authenticators, NewJWTAuthenticator(key, c.JWTAudience))
+	}
+
+	if c.HMACKeysFile != "" {
+		principals, err := LoadPrincipals(c.HMACKeysFile)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, NewHMACAuthenticator(principals, c.MaxClockSkew))
+	}
+
+	if c.PeerCertificates {
+		authenticators = append(authenticators, NewPeerCertificateAuthenticator())
+	}
+
+	return authenticators, nil
+}
+
+func loadPublicKey(path string) (any, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWT public key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", path)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse JWT public key: %w", err)
+	}
+	return key, nil
+}
+
+// UnaryServerInterceptor authenticates unary calls and puts the caller identity on the handler context
+func UnaryServerInterceptor(authenticators ...Authenticator) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		id, err := authenticate(ctx, Call{FullMethod: info.FullMethod, Request: req}, authenticators)
+		if err != nil {
+			return nil, err
+		}
+		return handler(NewContext(ctx, id), req)
+	}
+}
+
+// StreamServerInterceptor authenticates streaming calls and puts the caller identity on the stream context
+func StreamServerInterceptor(authenticators ...Authenticator) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		id, err := authenticate(ss.Context(), Call{FullMethod: info.FullMethod}, authenticators)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &identityStream{ServerStream: ss, ctx: NewContext(ss.Context(), id)})
+	}
+}
+
+// authenticate returns the identity from the first authenticator that recognises the call
+func authenticate(ctx context.Context, call Call, authenticators []Authenticator) (*Identity, error) {
+	call.Metadata, _ = metadata.FromIncomingContext(ctx)
+	for _, authenticator := range authenticators {
+		id, err := authenticator.Authenticate(ctx, call)
+		if errors.Is(err, ErrNoCredentials) {
+			continue
+		}
+		if err != nil {
+			return nil, status.Errorf(codes.Unauthenticated, "%v", err)
+		}
+		return id, nil
+	}
+	return nil, status.Error(codes.Unauthenticated, "missing credentials")
+}
+
+type identityStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *identityStream) Context() context.Context {
+	return s.ctx
+}
+
+// authorization returns the credential of the authorization header for scheme
+func authorization(md metadata.MD, scheme string) (string, bool) {
+	for _, value := range md.Get(authorizationHeader) {
+		if rest, ok := strings.CutPrefix(value, scheme+" "); ok {
+			return strings.TrimSpace(rest), true
+		}
+	}
+	return "", false
+}
+
+// isJWT reports whether a bearer credential looks like a JWT rather than an opaque token
+func isJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+type tokenAuthenticator struct {
+	principals []Principal
+}
+
+// NewTokenAuthenticator accepts opaque bearer tokens equal to a principal secret
+func NewTokenAuthenticator(principals []Principal) Authenticator {
+	return &tokenAuthenticator{principals: principals}
+}
+
+func (a *tokenAuthenticator) Authenticate(ctx context.Context, call Call) (*Identity, error) {
+	token, ok := authorization(call.Metadata, bearerScheme)
+	if !ok || isJWT(token) {
+		return nil, ErrNoCredentials
+	}
+	for _, p := range a.principals {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(p.Secret)) == 1 {
+			return &Identity{Subject: p.Subject, Roles: p.Roles, Method: "token"}, nil
+		}
+	}
+	return nil, errors.New("invalid bearer token")
+}
+
+type jwtAuthenticator struct {
+	key      any
+	audience string
+}
+
+// NewJWTAuthenticator accepts JWT bearer tokens signed with key, an HMAC
+// secret as []byte or an RSA or ECDSA public key
+func NewJWTAuthenticator(key any, audience string) Authenticator {
+	return &jwtAuthenticator{key: key, audience: audience}
+}
+
+func (a *jwtAuthenticator) Authenticate(ctx context.Context, call Call) (*Identity, error) {
+	token, ok := authorization(call.Metadata, bearerScheme)
+	if !ok || !isJWT(token) {
+		return nil, ErrNoCredentials
+	}
+
+	var methods []string
+	switch a.key.(type) {
+	case []byte:
+		methods = []string{"HS256", "HS384", "HS512"}
+	default:
+		methods = []string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}
+	}
+	options := []jwt.ParserOption{jwt.WithValidMethods(methods), jwt.WithExpirationRequired()}
+	if a.audience != "" {
+		options = append(options, jwt.WithAudience(a.audience))
+	}
+
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (any, error) {
+		return a.key, nil
+	}, options...)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWT: %w", err)
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("invalid JWT: missing subject")
+	}
+	return &Identity{Subject: claims.Subject, Roles: claims.Roles, Method: "jwt"}, nil
+}
+
+// defaultMaxClockSkew is used when the configuration does not set one
+const defaultMaxClockSkew = 5 * time.Minute
+
+type hmacAuthenticator struct {
+	principals map[string]Principal
+	maxSkew    time.Duration
+
+	// seen holds the nonces of accepted signatures until they expire
+	mu        sync.Mutex
+	seen      map[string]time.Time
+	nextSweep time.Time
+}
+
+// NewHMACAuthenticator accepts calls signed with the secret of a principal,
+// whose subject is the key ID. Signatures older than maxSkew are rejected, as
+// are signatures whose nonce was already accepted within that window.
+func NewHMACAuthenticator(principals []Principal, maxSkew time.Duration) Authenticator {
+	if maxSkew <= 0 {
+		maxSkew = defaultMaxClockSkew
+	}
+	byKeyID := make(map[string]Principal, len(principals))
+	for _, p := range principals {
+		byKeyID[p.Subject] = p
+	}
+	return &hmacAuthenticator{principals: byKeyID, maxSkew: maxSkew, seen: make(map[string]time.Time)}
+}
+
+func (a *hmacAuthenticator) Authenticate(ctx context.Context, call Call) (*Identity, error) {
+	credential, ok := authorization(call.Metadata, hmacScheme)
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+
+	parts := strings.Split(credential, ":")
+	if len(parts) != 4 || parts[2] == "" {
+		return nil, errors.New("malformed HMAC credential")
+	}
+	keyID, timestamp, nonce, signature := parts[0], parts[1], parts[2], parts[3]
+
+	principal, ok := a.principals[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown HMAC key %q", keyID)
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, errors.New("malformed HMAC timestamp")
+	}
+	signedAt := time.Unix(seconds, 0)
+	if age := time.Since(signedAt); age > a.maxSkew || age < -a.maxSkew {
+		return nil, errors.New("HMAC signature expired")
+	}
+
+	var body []byte
+	if call.Request != nil {
+		body, err = marshalForSignature(call.Request)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	expected := signHMAC([]byte(principal.Secret), call.FullMethod, timestamp, nonce, body)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return nil, errors.New("invalid HMAC signature")
+	}
+	// Only valid signatures reach the cache, so callers cannot fill it
+	if !a.remember(keyID+":"+nonce, signedAt.Add(a.maxSkew)) {
+		return nil, errors.New("HMAC nonce already used")
+	}
+	return &Identity{Subject: principal.Subject, Roles: principal.Roles, Method: "hmac"}, nil
+}
+
+// remember records a nonce until expiry and reports whether it was new
+func (a *hmacAuthenticator) remember(nonce string, expiry time.Time) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	if now.After(a.nextSweep) {
+		for n, e := range a.seen {
+			if now.After(e) {
+				delete(a.seen, n)
+			}
+		}
+		a.nextSweep = now.Add(a.maxSkew)
+	}
+	if e, ok := a.seen[nonce]; ok && !now.After(e) {
+		return false
+	}
+	a.seen[nonce] = expiry
+	return true
+}
+
+type peerCertificateAuthenticator struct{}
+
+// NewPeerCertificateAuthenticator accepts callers that presented a verified
+// client certificate, identified by its common name with its organizational
+// units as roles
+func NewPeerCertificateAuthenticator() Authenticator {
+	return peerCertificateAuthenticator{}
+}
+
+func (peerCertificateAuthenticator) Authenticate(ctx context.Context, call Call) (*Identity, error) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.PeerCertificates) == 0 {
+		return nil, ErrNoCredentials
+	}
+
+	cert := tlsInfo.State.PeerCertificates[0]
+	return &Identity{
+		Subject: cert.Subject.CommonName,
+		Roles:   cert.Subject.OrganizationalUnit,
+		Method:  "mtls",
+	}, nil
+}
@@ -0,0 +1,158 @@
+package auth_test
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	auth "github.com/vedantkulkarni/reflect-poc/auth"
+	server "github.com/vedantkulkarni/reflect-poc/server"
+	service_proto "github.com/vedantkulkarni/reflect-poc/service-proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// serve runs the test service behind the server interceptors and returns a
+// function that dials it with the given dial options
+func serve(t *testing.T, unary []grpc.UnaryServerInterceptor, stream []grpc.StreamServerInterceptor) func(...grpc.DialOption) service_proto.TestServiceClient {
+	t.Helper()
+	dial := serveConn(t, unary, stream)
+	return func(opts ...grpc.DialOption) service_proto.TestServiceClient {
+		t.Helper()
+		return service_proto.NewTestServiceClient(dial(opts...))
+	}
+}
+
+// serveConn is serve for callers that open streams on the connection themselves
+func serveConn(t *testing.T, unary []grpc.UnaryServerInterceptor, stream []grpc.StreamServerInterceptor) func(...grpc.DialOption) *grpc.ClientConn {
+	t.Helper()
+	listener := bufconn.Listen(1 << 20)
+	grpcServer := grpc.NewServer(grpc.ChainUnaryInterceptor(unary...), grpc.ChainStreamInterceptor(stream...))
+	service_proto.RegisterTestServiceServer(grpcServer, &server.MyTestService{})
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	return func(opts ...grpc.DialOption) *grpc.ClientConn {
+		t.Helper()
+		opts = append(opts,
+			grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+				return listener.DialContext(ctx)
+			}),
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+		)
+		conn, err := grpc.NewClient("passthrough:///bufconn", opts...)
+		if err != nil {
+			t.Fatalf("failed to dial: %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+}
+
+// headerRecorder keeps the authorization headers of the calls it sees
+type headerRecorder struct {
+	mu      sync.Mutex
+	headers []string
+}
+
+func (r *headerRecorder) unary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	r.mu.Lock()
+	r.headers = append(r.headers, md.Get("authorization")...)
+	r.mu.Unlock()
+	return handler(ctx, req)
+}
+
+func TestHMACRejectsReplays(t *testing.T) {
+	hmac := auth.NewHMACAuthenticator([]auth.Principal{{Subject: "device-ops", Secret: "s3cret"}}, 0)
+	recorder := &headerRecorder{}
+	dial := serve(t,
+		[]grpc.UnaryServerInterceptor{recorder.unary, auth.UnaryServerInterceptor(hmac)},
+		[]grpc.StreamServerInterceptor{auth.StreamServerInterceptor(hmac)})
+
+	opts, err := auth.ClientConfig{HMACKeyID: "device-ops", HMACSecret: "s3cret"}.DialOptions("echo-service1")
+	if err != nil {
+		t.Fatalf("failed to build credentials: %v", err)
+	}
+	signed := dial(opts...)
+	ctx := context.Background()
+
+	// Every call is signed with a fresh nonce
+	for i := 0; i < 2; i++ {
+		if _, err := signed.Test(ctx, &service_proto.TestMessageRequest{Message: "hi"}); err != nil {
+			t.Fatalf("signed call %d failed: %v", i, err)
+		}
+	}
+	stream, err := signed.TestServerStream(ctx, &service_proto.TestMessageRequest{})
+	if err != nil {
+		t.Fatalf("failed to open signed stream: %v", err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		t.Fatalf("signed stream failed: %v", err)
+	}
+
+	recorder.mu.Lock()
+	captured := recorder.headers[0]
+	recorder.mu.Unlock()
+	if parts := strings.Split(strings.TrimPrefix(captured, "HMAC-SHA256 "), ":"); len(parts) != 4 {
+		t.Fatalf("credential %q is not keyID:timestamp:nonce:signature", captured)
+	}
+
+	// Replaying a captured signature within the clock skew window fails
+	unsigned := dial()
+	replayCtx := metadata.AppendToOutgoingContext(ctx, "authorization", captured)
+	_, err = unsigned.Test(replayCtx, &service_proto.TestMessageRequest{Message: "hi"})
+	if status.Code(err) != codes.Unauthenticated || !strings.Contains(err.Error(), "nonce") {
+		t.Errorf("replayed call returned %v, want Unauthenticated for a used nonce", err)
+	}
+}
+
+// TestHMACSignsUnaryCallsOverStreams checks that a unary call made through
+// NewStream, as the reflection client does, is signed over its request
+func TestHMACSignsUnaryCallsOverStreams(t *testing.T) {
+	hmac := auth.NewHMACAuthenticator([]auth.Principal{{Subject: "device-ops", Secret: "s3cret"}}, 0)
+	dial := serveConn(t,
+		[]grpc.UnaryServerInterceptor{auth.UnaryServerInterceptor(hmac)},
+		[]grpc.StreamServerInterceptor{auth.StreamServerInterceptor(hmac)})
+	opts, err := auth.ClientConfig{HMACKeyID: "device-ops", HMACSecret: "s3cret"}.DialOptions("echo-service1")
+	if err != nil {
+		t.Fatalf("failed to build credentials: %v", err)
+	}
+	conn := dial(opts...)
+
+	stream, err := conn.NewStream(context.Background(), &grpc.StreamDesc{StreamName: "Test"}, service_proto.TestService_Test_FullMethodName)
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+	if err := stream.SendMsg(&service_proto.TestMessageRequest{Message: "hi"}); err != nil {
+		t.Fatalf("failed to send request: %v", err)
+	}
+	if err := stream.CloseSend(); err != nil {
+		t.Fatalf("failed to close send: %v", err)
+	}
+	resp := &service_proto.TestMessageResponse{}
+	if err := stream.RecvMsg(resp); err != nil {
+		t.Fatalf("signed unary call over a stream failed: %v", err)
+	}
+	if resp.GetMessage() == "" {
+		t.Error("received an empty response")
+	}
+}
+
+func TestHMACRejectsCredentialsWithoutNonce(t *testing.T) {
+	hmac := auth.NewHMACAuthenticator([]auth.Principal{{Subject: "device-ops", Secret: "s3cret"}}, 0)
+	dial := serve(t, []grpc.UnaryServerInterceptor{auth.UnaryServerInterceptor(hmac)}, nil)
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "HMAC-SHA256 device-ops:1700000000:signature")
+	_, err := dial().Test(ctx, &service_proto.TestMessageRequest{})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("call returned %v, want Unauthenticated", err)
+	}
+}
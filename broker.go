@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/url"
+	"os/signal"
+	"syscall"
+
+	embedded "github.com/vedantkulkarni/reflect-poc/embedded"
+	transport "github.com/vedantkulkarni/reflect-poc/transport"
+	"go.uber.org/zap"
+)
+
+// registerEmbeddedBrokerFlag adds the flag of commands that can host the broker they use
+func registerEmbeddedBrokerFlag(fs *flag.FlagSet) *bool {
+	return fs.Bool("embedded-broker", false, "run an MQTT broker in this process, listening on the -broker address, instead of using an external one")
+}
+
+// startEmbeddedBroker starts a broker listening on the address of broker.URL
+// and connects broker to it in-process
+func startEmbeddedBroker(broker *transport.BrokerConfig, logger *zap.Logger) (*embedded.Broker, error) {
+	brokerURL := broker.URL
+	if brokerURL == "" {
+		brokerURL = transport.DefaultBrokerURL
+	}
+	parsed, err := url.Parse(brokerURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid broker url %q: %w", brokerURL, err)
+	}
+	if parsed.Scheme != "tcp" && parsed.Scheme != "mqtt" {
+		return nil, fmt.Errorf("the embedded broker only listens on tcp:// URLs, not %q", brokerURL)
+	}
+
+	b, err := embedded.Start(embedded.Config{Addr: parsed.Host, Logger: logger})
+	if err != nil {
+		return nil, err
+	}
+	broker.Dial = b.Dial
+	logger.Info("Started embedded MQTT broker", zap.String("url", b.URL()))
+	return b, nil
+}
+
+// runBroker runs a standalone embedded broker until SIGINT or SIGTERM
+func runBroker(args []string) error {
+	fs := flag.NewFlagSet("broker", flag.ExitOnError)
+	listen := fs.String("listen", "localhost:1883", "TCP address the broker listens on")
+	var logOpts logOptions
+	logOpts.register(fs, "info")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	logger, err := logOpts.logger()
+	if err != nil {
+		return err
+	}
+	defer logger.Sync()
+
+	b, err := embedded.Start(embedded.Config{Addr: *listen, Logger: logger})
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+	logger.Info("Serving MQTT broker", zap.String("url", b.URL()))
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+// runCall invokes a method on a device with JSON requests and prints the responses
+func runCall(args []string) error {
+	fs := flag.NewFlagSet("call", flag.ExitOnError)
+	var opts clientOptions
+	opts.register(fs)
+	opts.registerOfflineFlag(fs)
+	data := fs.String("d", "", "JSON request body; @file reads it from a file and @- from stdin. Streaming methods take a sequence of objects")
+	format := fs.String("format", "json", "output format: "+strings.Join(outputFormats, ", "))
+	emitDefaults := fs.Bool("emit-defaults", false, "include fields with default values in JSON output")
+	verbose := fs.Bool("v", false, "print response headers and trailers to stderr")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s call [flags] [bridge-id] <pkg.Service/Method>\n", os.Args[0])
+		fmt.Fprintln(fs.Output(), "The bridge ID defaults to -bridge-id.")
+		fs.PrintDefaults()
+	}
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	bridgeID, method, err := methodArgs(fs, opts.bridgeID)
+	if err != nil {
+		return err
+	}
+
+	formatter, err := newFormatter(*format, *emitDefaults)
+	if err != nil {
+		return err
+	}
+	in, err := requestInput(*data)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	reflectionClient, ctx, closeClient, err := opts.connect(bridgeID)
+	if err != nil {
+		return err
+	}
+	defer closeClient()
+
+	var header, trailer metadata.MD
+	err = reflectionClient.Call(ctx, method, in, func(response proto.Message) error {
+		out, err := formatter(response)
+		if err != nil {
+			return fmt.Errorf("failed to format response: %w", err)
+		}
+		fmt.Println(strings.TrimSpace(string(out)))
+		return nil
+	}, grpc.Header(&header), grpc.Trailer(&trailer))
+
+	if *verbose {
+		printMetadata("Response headers", header)
+		printMetadata("Response trailers", trailer)
+	}
+	return err
+}
+
+// requestInput opens the -d argument: inline JSON, @file or @- for stdin
+func requestInput(data string) (io.ReadCloser, error) {
+	switch {
+	case data == "@-":
+		return io.NopCloser(os.Stdin), nil
+	case strings.HasPrefix(data, "@"):
+		file, err := os.Open(data[1:])
+		if err != nil {
+			return nil, fmt.Errorf("failed to open request file: %w", err)
+		}
+		return file, nil
+	}
+	return io.NopCloser(strings.NewReader(data)), nil
+}
+
+func printMetadata(title string, md metadata.MD) {
+	fmt.Fprintf(os.Stderr, "%s:\n", title)
+	for key, values := range md {
+		for _, value := range values {
+			fmt.Fprintf(os.Stderr, "  %s: %s\n", key, value)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	bridge "github.com/golain-io/mqtt-bridge"
+	embedded "github.com/vedantkulkarni/reflect-poc/embedded"
+	server "github.com/vedantkulkarni/reflect-poc/server"
+	service_proto "github.com/vedantkulkarni/reflect-poc/service-proto"
+	transport "github.com/vedantkulkarni/reflect-poc/transport"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/reflection"
+)
+
+// serveDevice runs the test services as bridgeID on an embedded broker until
+// the test ends, and returns the URL of the broker
+func serveDevice(t *testing.T, bridgeID string) string {
+	t.Helper()
+	b, err := embedded.Start(embedded.Config{Addr: "127.0.0.1:0"})
+	if err != nil {
+		t.Fatalf("failed to start broker: %v", err)
+	}
+	t.Cleanup(func() { b.Close() })
+
+	grpcServer := grpc.NewServer()
+	service_proto.RegisterTestServiceServer(grpcServer, &server.MyTestService{})
+	reflection.RegisterV1(grpcServer)
+	mqttClient, err := transport.Connect(transport.BrokerConfig{
+		URL:            embedded.InProcessURL,
+		ClientID:       bridgeID,
+		Dial:           b.Dial,
+		ConnectTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("failed to connect device: %v", err)
+	}
+	lifecycle := &server.Lifecycle{
+		GRPCServer:   grpcServer,
+		Listener:     transport.BufferListener(mqttClient.TrackListener(bridge.NewMQTTNetBridge(mqttClient, zap.NewNop(), bridgeID))),
+		MQTTClient:   mqttClient,
+		BridgeID:     bridgeID,
+		DrainTimeout: 100 * time.Millisecond,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- lifecycle.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return b.URL()
+}
+
+// captureOutput runs fn with os.Stdout and os.Stderr redirected, and returns
+// what it wrote to each
+func captureOutput(t *testing.T, fn func() error) (stdout, stderr string, err error) {
+	t.Helper()
+	read := func(target **os.File) (func() string, error) {
+		r, w, err := os.Pipe()
+		if err != nil {
+			return nil, err
+		}
+		original := *target
+		*target = w
+		var buf bytes.Buffer
+		copied := make(chan struct{})
+		go func() {
+			io.Copy(&buf, r)
+			close(copied)
+		}()
+		return func() string {
+			*target = original
+			w.Close()
+			<-copied
+			r.Close()
+			return buf.String()
+		}, nil
+	}
+	stopStdout, err := read(&os.Stdout)
+	if err != nil {
+		t.Fatalf("failed to capture stdout: %v", err)
+	}
+	stopStderr, err := read(&os.Stderr)
+	if err != nil {
+		stopStdout()
+		t.Fatalf("failed to capture stderr: %v", err)
+	}
+	err = fn()
+	return stopStdout(), stopStderr(), err
+}
+
+// TestDebugLogsStayOffStdout checks that commands print only their results to
+// stdout, so that their output can be piped, even with debug logs on
+func TestDebugLogsStayOffStdout(t *testing.T) {
+	clearEnv(t)
+	const bridgeID = "stdout-device"
+	brokerURL := serveDevice(t, bridgeID)
+	flags := []string{"-broker", brokerURL, "-bridge-id", bridgeID, "-log-level", "debug", "-log-format", "console"}
+
+	for _, tc := range []struct {
+		name string
+		run  func([]string) error
+		args []string
+		want string
+		// isJSON compares stdout as JSON, which protojson spaces at random
+		isJSON bool
+	}{
+		{"list", runList, []string{"reflect.TestService"}, strings.Join([]string{
+			"reflect.TestService.Test",
+			"reflect.TestService.TestServerStream",
+			"reflect.TestService.TestClientStream",
+			"reflect.TestService.TestBidiStream",
+			"reflect.TestService.Run",
+		}, "\n") + "\n", false},
+		{"call", runCall, []string{"-format", "compact", "-d", `{"message": "hi"}`, "reflect.TestService/Test"}, `{"message":"Response from Test method"}`, true},
+	} {
+		stdout, stderr, err := captureOutput(t, func() error {
+			return tc.run(append(append([]string{}, flags...), tc.args...))
+		})
+		if err != nil {
+			t.Fatalf("%s failed: %v\n%s", tc.name, err, stderr)
+		}
+		if tc.isJSON {
+			var compact bytes.Buffer
+			if err := json.Compact(&compact, []byte(stdout)); err == nil {
+				stdout = compact.String()
+			}
+		}
+		if stdout != tc.want {
+			t.Errorf("%s wrote %q to stdout, want %q", tc.name, stdout, tc.want)
+		}
+		if !strings.Contains(stderr, "DEBUG") {
+			t.Errorf("%s logged no debug messages to stderr:\n%s", tc.name, stderr)
+		}
+	}
+}
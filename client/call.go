@@ -0,0 +1,190 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	reflection "github.com/vedantkulkarni/reflect-poc/reflection"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// ErrInvalidRequest is returned by Call when the JSON input does not match the request type
+var ErrInvalidRequest = errors.New("invalid request")
+
+// Call invokes a method on the device, given as pkg.Service/Method, with the
+// request and response types resolved through server reflection, or through
+// the schema set with UseSchema.
+//
+// Requests are read from in as a sequence of JSON objects. Unary and
+// server-streaming methods take at most one, and an empty input sends an empty
+// request; client and bidi-streaming methods send every object and then close
+// the stream. Each response is passed to onResponse as it arrives.
+func (drs *ReflectionClient) Call(ctx context.Context, methodName string, in io.Reader, onResponse func(proto.Message) error, opts ...grpc.CallOption) error {
+	start := time.Now()
+	responses := 0
+	err := drs.call(ctx, methodName, in, func(response proto.Message) error {
+		responses++
+		return onResponse(response)
+	}, opts...)
+
+	drs.logger.Debug("Call finished",
+		zap.String("method", methodName),
+		zap.Stringer("code", status.Code(err)),
+		zap.Duration("duration", time.Since(start)),
+		zap.Int("responses", responses),
+		zap.Error(err))
+	return err
+}
+
+func (drs *ReflectionClient) call(ctx context.Context, methodName string, in io.Reader, onResponse func(proto.Message) error, opts ...grpc.CallOption) error {
+	method, err := reflection.FindMethod(ctx, drs.source, methodName)
+	if err != nil {
+		return err
+	}
+	requests := newRequestDecoder(in, method.Input())
+
+	// Read the single request up front so a bad payload never reaches the device
+	var single proto.Message
+	if !method.IsStreamingClient() {
+		single, err = requests.single()
+		if err != nil {
+			return fmt.Errorf("%w for %s: %w", ErrInvalidRequest, method.FullName(), err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := drs.NewStream(ctx, method, opts...)
+	if err != nil {
+		return err
+	}
+
+	sendErr := make(chan error, 1)
+	go func() {
+		var err error
+		if single != nil {
+			err = stream.SendMsg(single)
+		} else {
+			err = sendAll(stream, requests)
+		}
+		if err == nil || errors.Is(err, io.EOF) {
+			// io.EOF means the call ended; its status comes from RecvMsg
+			stream.CloseSend()
+			sendErr <- nil
+			return
+		}
+		sendErr <- err
+		cancel()
+	}()
+
+	for {
+		response := dynamicpb.NewMessage(method.Output())
+		err := stream.RecvMsg(response)
+		if err != nil {
+			select {
+			case err := <-sendErr:
+				if err != nil {
+					return err
+				}
+			default:
+			}
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if err := onResponse(response); err != nil {
+			return err
+		}
+	}
+}
+
+// NewStream opens a stream for method, for callers that send requests and
+// receive responses as dynamic messages themselves
+func (drs *ReflectionClient) NewStream(ctx context.Context, method protoreflect.MethodDescriptor, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	stream, err := drs.conn.NewStream(ctx, &grpc.StreamDesc{
+		StreamName:    string(method.Name()),
+		ServerStreams: method.IsStreamingServer(),
+		ClientStreams: method.IsStreamingClient(),
+	}, FullMethodName(method), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stream: %w", err)
+	}
+	return stream, nil
+}
+
+// FullMethodName returns the /pkg.Service/Method path gRPC uses for a method
+func FullMethodName(method protoreflect.MethodDescriptor) string {
+	return fmt.Sprintf("/%s/%s", method.Parent().FullName(), method.Name())
+}
+
+func sendAll(stream grpc.ClientStream, requests *requestDecoder) error {
+	for {
+		request, err := requests.next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+		}
+		if err := stream.SendMsg(request); err != nil {
+			return err
+		}
+	}
+}
+
+// requestDecoder reads a sequence of JSON objects as messages of one type
+type requestDecoder struct {
+	decoder *json.Decoder
+	desc    protoreflect.MessageDescriptor
+}
+
+func newRequestDecoder(in io.Reader, desc protoreflect.MessageDescriptor) *requestDecoder {
+	if in == nil {
+		in = eofReader{}
+	}
+	return &requestDecoder{decoder: json.NewDecoder(in), desc: desc}
+}
+
+// next returns the next request, or io.EOF when the input is exhausted
+func (d *requestDecoder) next() (proto.Message, error) {
+	var raw json.RawMessage
+	if err := d.decoder.Decode(&raw); err != nil {
+		return nil, err
+	}
+	message := dynamicpb.NewMessage(d.desc)
+	if err := protojson.Unmarshal(raw, message); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// single returns the only request in the input, or an empty one if there is none
+func (d *requestDecoder) single() (proto.Message, error) {
+	message, err := d.next()
+	if errors.Is(err, io.EOF) {
+		return dynamicpb.NewMessage(d.desc), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := d.next(); !errors.Is(err, io.EOF) {
+		return nil, errors.New("expected a single request")
+	}
+	return message, nil
+}
+
+type eofReader struct{}
+
+func (eofReader) Read([]byte) (int, error) { return 0, io.EOF }
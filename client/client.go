@@ -3,352 +3,269 @@ package client
 import (
 	"context"
 	"fmt"
-	"log"
 	"net"
 	"time"
 
-	mqtt "github.com/eclipse/paho.mqtt.golang"
 	bridge "github.com/golain-io/mqtt-bridge"
+	"github.com/google/uuid"
+	auth "github.com/vedantkulkarni/reflect-poc/auth"
+	metrics "github.com/vedantkulkarni/reflect-poc/metrics"
 	reflection "github.com/vedantkulkarni/reflect-poc/reflection"
+	tracing "github.com/vedantkulkarni/reflect-poc/tracing"
+	transport "github.com/vedantkulkarni/reflect-poc/transport"
 	"go.uber.org/zap"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
-	"google.golang.org/grpc/resolver"
-	"google.golang.org/protobuf/encoding/protojson"
-	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/protobuf/reflect/protoreflect"
 )
 
 type ReflectionClient struct {
-	conn       *grpc.ClientConn
-	mqttClient mqtt.Client
+	conn *grpc.ClientConn
+	// netBridge carries the connection over MQTT and holds its handshake
+	// subscription until Close
+	netBridge  *bridge.MQTTNetBridge
+	mqttClient *transport.Client
 	helper     *reflection.GRPCReflectionHelper
+	// source resolves methods and types; the reflection helper unless UseSchema was called
+	source reflection.DescriptorSource
+	logger *zap.Logger
+	cancel context.CancelFunc
+	// ownsMQTT is set when the client made its own broker connection
+	ownsMQTT bool
 }
 
-func NewReflectionClient() *ReflectionClient {
-	// Create MQTT client
-	opts := mqtt.NewClientOptions().
-		AddBroker("tcp://localhost:1883").
-		SetClientID("echo-net-client")
-
-	mqttClient := mqtt.NewClient(opts)
-	token := mqttClient.Connect()
-	if token.Wait() && token.Error() != nil {
-		log.Fatal("Failed to connect to MQTT broker:", token.Error())
-	}
-	conn:= GetNewMQTTGRPCBridge(mqttClient, zap.NewExample(), "echo-service1")
-	conn.Connect()
-	return &ReflectionClient{
-		conn:       conn,
-		mqttClient: mqttClient,
-		helper:     reflection.NewGRPCReflectionHelper(conn),
-	}
+// Config describes the broker to connect through and the bridge to call
+type Config struct {
+	Broker   transport.BrokerConfig
+	BridgeID string
+	// TLS secures the gRPC stream end to end with the device, on top of the
+	// MQTT session, so the broker cannot read call payloads
+	TLS transport.TLSConfig
+	// Auth selects the per-RPC credentials sent to the device
+	Auth auth.ClientConfig
+	// OnStateChange is called with every connectivity state of the gRPC
+	// connection to the device, starting with the current one. Calls that are
+	// in flight when the broker connection drops fail with Unavailable; the
+	// connection then goes through TRANSIENT_FAILURE and redials on demand.
+	OnStateChange func(connectivity.State)
+	// Logger is shared with the broker connection, the bridge and the
+	// reflection helper; nil disables logging
+	Logger *zap.Logger
+	// Metrics records the calls made, the MQTT traffic and the reflection
+	// cache hit rate; nil disables metrics
+	Metrics *metrics.Metrics
+	// Tracing starts a span per call and propagates it to the device; nil
+	// disables tracing
+	Tracing *tracing.Tracing
 }
 
-// Test unary RPC
-func (drs *ReflectionClient) TestUnaryRPC(ctx context.Context) {
-	serviceName := "TestService"
-	methodName := "Test"
-
-	// Get the method descriptor
-	_, err := drs.helper.GetMethodDescriptor(ctx, serviceName, methodName)
-	if err != nil {
-		fmt.Println("Error getting method descriptor:", err)
-		return
-	}
-
-	inputDesc, outputDesc, err := drs.helper.GetInputOutputTypes(ctx, serviceName, methodName)
-	if err != nil {
-		fmt.Println("Error getting input/output types:", err)
-		return
+func NewReflectionClient(cfg Config) (*ReflectionClient, error) {
+	if cfg.BridgeID == "" {
+		cfg.BridgeID = "echo-service1"
 	}
+	cfg = cfg.withDefaults()
 
-	// Create a new message using the input descriptor
-	message := dynamicpb.NewMessage(inputDesc)
-
-	// Create the input JSON with the correct message structure
-	dummyJson := `{
-		"message": "This is a Unary RPC test message!"
-	}`
-
-	err = protojson.Unmarshal([]byte(dummyJson), message)
-	if err != nil {
-		fmt.Println("Error unmarshaling JSON:", err)
-		return
-	}
-
-	fullMethodName := fmt.Sprintf("/%s.%s/%s", "reflect", serviceName, methodName)
-
-	// Create response message using output descriptor
-	response := dynamicpb.NewMessage(outputDesc)
-
-	// Invoke the RPC
-	err = drs.conn.Invoke(ctx, fullMethodName, message, response)
+	// Create MQTT client
+	mqttClient, err := transport.Connect(cfg.Broker)
 	if err != nil {
-		fmt.Println("Error invoking RPC:", err)
-		return
+		return nil, err
 	}
-
-	// Format and print the response
-	responseJson, err := protojson.Marshal(response)
+	drs, err := newReflectionClient(mqttClient, cfg)
 	if err != nil {
-		fmt.Println("Error marshaling response:", err)
-		return
+		mqttClient.Disconnect(250)
+		return nil, err
 	}
-	fmt.Println("Response:", string(responseJson))
+	drs.ownsMQTT = true
+	return drs, nil
 }
 
-// Test server streaming RPC
-func (drs *ReflectionClient) TestServerStreamRPC(ctx context.Context) {
-	serviceName := "TestService"
-	methodName := "TestServerStream"
-
-	inputDesc, outputDesc, err := drs.helper.GetInputOutputTypes(ctx, serviceName, methodName)
-	if err != nil {
-		fmt.Println("Error getting input/output types:", err)
-		return
+// withDefaults fills in the client ID and logger and shares the logger and
+// metrics with the broker connection
+func (cfg Config) withDefaults() Config {
+	if cfg.Broker.ClientID == "" {
+		cfg.Broker.ClientID = "echo-net-client"
 	}
-
-	// Create input message
-	message := dynamicpb.NewMessage(inputDesc)
-	dummyJson := `{
-        "message": "Start server streaming!"
-    }`
-
-	err = protojson.Unmarshal([]byte(dummyJson), message)
-	if err != nil {
-		fmt.Println("Error unmarshaling JSON:", err)
-		return
-	}
-
-	fullMethodName := fmt.Sprintf("/%s.%s/%s", "reflect", serviceName, methodName)
-
-	// Create server stream
-	stream, err := drs.conn.NewStream(ctx, &grpc.StreamDesc{
-		StreamName:    methodName,
-		ServerStreams: true,
-	}, fullMethodName)
-	if err != nil {
-		fmt.Println("Error creating stream:", err)
-		return
+	if cfg.Logger == nil {
+		cfg.Logger = zap.NewNop()
 	}
-
-	// Send the initial message
-	if err := stream.SendMsg(message); err != nil {
-		fmt.Println("Error sending message:", err)
-		return
+	if cfg.Broker.Logger == nil {
+		cfg.Broker.Logger = cfg.Logger
 	}
-
-	// Receive messages
-	for {
-		response := dynamicpb.NewMessage(outputDesc)
-		err := stream.RecvMsg(response)
-		if err != nil {
-			if err.Error() == "EOF" {
-				break
-			}
-			fmt.Println("Error receiving message:", err)
-			return
-		}
-
-		responseJson, err := protojson.Marshal(response)
-		if err != nil {
-			fmt.Println("Error marshaling response:", err)
-			continue
-		}
-		fmt.Println("Received:", string(responseJson))
+	if cfg.Metrics != nil && cfg.Broker.Observer == nil {
+		cfg.Broker.Observer = cfg.Metrics
 	}
+	return cfg
 }
 
-// Test client streaming RPC
-func (drs *ReflectionClient) TestClientStreamRPC(ctx context.Context) {
-	serviceName := "TestService"
-	methodName := "TestClientStream"
-
-	inputDesc, outputDesc, err := drs.helper.GetInputOutputTypes(ctx, serviceName, methodName)
+// newReflectionClient opens a gRPC connection to cfg.BridgeID through mqttClient
+func newReflectionClient(mqttClient *transport.Client, cfg Config) (*ReflectionClient, error) {
+	creds, err := transport.ClientCredentials(cfg.TLS, cfg.BridgeID)
 	if err != nil {
-		fmt.Println("Error getting input/output types:", err)
-		return
+		return nil, fmt.Errorf("failed to build transport credentials: %w", err)
 	}
-
-	fullMethodName := fmt.Sprintf("/%s.%s/%s", "reflect", serviceName, methodName)
-
-	// Create client stream
-	stream, err := drs.conn.NewStream(ctx, &grpc.StreamDesc{
-		StreamName:    methodName,
-		ClientStreams: true,
-	}, fullMethodName)
+	dialOpts, err := cfg.Auth.DialOptions(cfg.BridgeID)
 	if err != nil {
-		fmt.Println("Error creating stream:", err)
-		return
+		return nil, fmt.Errorf("failed to build call credentials: %w", err)
 	}
-
-	// Send multiple messages
-	for i := 1; i <= 3; i++ {
-		message := dynamicpb.NewMessage(inputDesc)
-		dummyJson := fmt.Sprintf(`{
-            "message": "Client stream message %d"
-        }`, i)
-
-		err = protojson.Unmarshal([]byte(dummyJson), message)
-		if err != nil {
-			fmt.Println("Error unmarshaling JSON:", err)
-			return
-		}
-
-		if err := stream.SendMsg(message); err != nil {
-			fmt.Println("Error sending message:", err)
-			return
-		}
+	// Tracing runs first so that the metrics of a call are recorded inside its span
+	if cfg.Tracing != nil {
+		dialOpts = append(dialOpts,
+			grpc.WithChainUnaryInterceptor(cfg.Tracing.UnaryClientInterceptor()),
+			grpc.WithChainStreamInterceptor(cfg.Tracing.StreamClientInterceptor()),
+		)
 	}
-
-	// send a final message to close the stream
-	stream.CloseSend()
-
-	// Receive final response
-	response := dynamicpb.NewMessage(outputDesc)
-	if err := stream.RecvMsg(response); err != nil {
-		fmt.Println("Error receiving response:", err)
-		return
+	if cfg.Metrics != nil {
+		dialOpts = append(dialOpts,
+			grpc.WithChainUnaryInterceptor(cfg.Metrics.UnaryClientInterceptor()),
+			grpc.WithChainStreamInterceptor(cfg.Metrics.StreamClientInterceptor()),
+		)
 	}
 
-	responseJson, err := protojson.Marshal(response)
+	conn, netBridge, err := GetNewMQTTGRPCBridge(mqttClient, cfg.Logger, cfg.BridgeID, creds, dialOpts...)
 	if err != nil {
-		fmt.Println("Error marshaling response:", err)
-		return
+		return nil, err
 	}
-	fmt.Println("Final response:", string(responseJson))
-}
-
-// Test bidirectional streaming RPC
-func (drs *ReflectionClient) TestBidiStreamRPC(ctx context.Context) {
-	serviceName := "TestService"
-	methodName := "TestBidiStream"
+	conn.Connect()
 
-	inputDesc, outputDesc, err := drs.helper.GetInputOutputTypes(ctx, serviceName, methodName)
-	if err != nil {
-		fmt.Println("Error getting input/output types:", err)
-		return
+	helper := reflection.NewGRPCReflectionHelper(conn, cfg.Logger)
+	if cfg.Metrics != nil {
+		helper.OnCacheLookup(cfg.Metrics.CacheLookup)
 	}
 
-	fullMethodName := fmt.Sprintf("/%s.%s/%s", "reflect", serviceName, methodName)
-
-	// Create bidirectional stream
-	stream, err := drs.conn.NewStream(ctx, &grpc.StreamDesc{
-		StreamName:    methodName,
-		ServerStreams: true,
-		ClientStreams: true,
-	}, fullMethodName)
-	if err != nil {
-		fmt.Println("Error creating stream:", err)
-		return
+	ctx, cancel := context.WithCancel(context.Background())
+	drs := &ReflectionClient{
+		conn:       conn,
+		netBridge:  netBridge,
+		mqttClient: mqttClient,
+		helper:     helper,
+		source:     helper,
+		logger:     cfg.Logger.With(zap.String("bridge_id", cfg.BridgeID)),
+		cancel:     cancel,
 	}
+	if cfg.OnStateChange != nil {
+		go drs.watchState(ctx, cfg.OnStateChange)
+	}
+	return drs, nil
+}
 
-	// Start a goroutine to receive messages
-	go func() {
-		for {
-			response := dynamicpb.NewMessage(outputDesc)
-			err := stream.RecvMsg(response)
-			if err != nil {
-				if err.Error() == "EOF" {
-					return
-				}
-				fmt.Println("Error receiving message:", err)
-				return
-			}
-
-			responseJson, err := protojson.Marshal(response)
-			if err != nil {
-				fmt.Println("Error marshaling response:", err)
-				continue
-			}
-			fmt.Println("Received:", string(responseJson))
-		}
-	}()
-
-	// Send messages
-	for i := 1; i <= 3; i++ {
-		message := dynamicpb.NewMessage(inputDesc)
-		dummyJson := fmt.Sprintf(`{
-            "message": "Bidi stream message %d"
-        }`, i)
-
-		err = protojson.Unmarshal([]byte(dummyJson), message)
-		if err != nil {
-			fmt.Println("Error unmarshaling JSON:", err)
-			return
-		}
-
-		if err := stream.SendMsg(message); err != nil {
-			fmt.Println("Error sending message:", err)
+// watchState reports connectivity state changes until ctx is cancelled
+func (drs *ReflectionClient) watchState(ctx context.Context, onChange func(connectivity.State)) {
+	state := drs.conn.GetState()
+	for {
+		onChange(state)
+		if !drs.conn.WaitForStateChange(ctx, state) {
 			return
 		}
-		time.Sleep(time.Second) // Add delay between messages
+		state = drs.conn.GetState()
 	}
 }
 
-// Test Run RPC (another unary RPC)
-func (drs *ReflectionClient) TestRunRPC(ctx context.Context) {
-	serviceName := "TestService"
-	methodName := "Run"
+// State returns the current connectivity state of the gRPC connection
+func (drs *ReflectionClient) State() connectivity.State {
+	return drs.conn.GetState()
+}
 
-	inputDesc, outputDesc, err := drs.helper.GetInputOutputTypes(ctx, serviceName, methodName)
-	if err != nil {
-		fmt.Println("Error getting input/output types:", err)
-		return
-	}
+// UseSchema resolves services, methods and message types through source, such
+// as a schema loaded with LoadSchema, instead of the reflection service of the
+// device. Calls still go to the device.
+func (drs *ReflectionClient) UseSchema(source reflection.DescriptorSource) {
+	drs.source = source
+}
 
-	message := dynamicpb.NewMessage(inputDesc)
-	dummyJson := `{
-        "message": "Run command test",
-        "id": 12345
-    }`
+// ListServices returns the services exposed by the device
+func (drs *ReflectionClient) ListServices(ctx context.Context) ([]string, error) {
+	return drs.source.ListServices(ctx)
+}
 
-	err = protojson.Unmarshal([]byte(dummyJson), message)
-	if err != nil {
-		fmt.Println("Error unmarshaling JSON:", err)
-		return
-	}
+// FindSymbol resolves a fully-qualified service, method, message or enum name on the device
+func (drs *ReflectionClient) FindSymbol(ctx context.Context, name string) (protoreflect.Descriptor, error) {
+	return drs.source.FindSymbol(ctx, name)
+}
 
-	fullMethodName := fmt.Sprintf("/%s.%s/%s", "reflect", serviceName, methodName)
+// MQTTClient returns the broker connection the client calls through
+func (drs *ReflectionClient) MQTTClient() *transport.Client {
+	return drs.mqttClient
+}
 
-	response := dynamicpb.NewMessage(outputDesc)
+// Conn returns the gRPC connection to the device, for calls that do not go
+// through reflection
+func (drs *ReflectionClient) Conn() *grpc.ClientConn {
+	return drs.conn
+}
 
-	err = drs.conn.Invoke(ctx, fullMethodName, message, response)
-	if err != nil {
-		fmt.Println("Error invoking RPC:", err)
-		return
+// WaitForReady blocks until the connection to the device is ready or ctx is done
+func (drs *ReflectionClient) WaitForReady(ctx context.Context) error {
+	for {
+		state := drs.conn.GetState()
+		if state == connectivity.Ready {
+			return nil
+		}
+		if state == connectivity.Idle {
+			drs.conn.Connect()
+		}
+		if !drs.conn.WaitForStateChange(ctx, state) {
+			return fmt.Errorf("failed to connect to the device: %w", ctx.Err())
+		}
 	}
+}
 
-	responseJson, err := protojson.Marshal(response)
-	if err != nil {
-		fmt.Println("Error marshaling response:", err)
-		return
+// Close closes the gRPC connection and its bridge, and disconnects from the
+// broker, unless the broker connection is shared through a Pool
+func (drs *ReflectionClient) Close() error {
+	drs.cancel()
+	err := drs.conn.Close()
+	drs.netBridge.Close()
+	if drs.ownsMQTT {
+		drs.mqttClient.Disconnect(250)
 	}
-	fmt.Println("Response:", string(responseJson))
+	return err
 }
 
-func GetNewMQTTGRPCBridge(mqttClient mqtt.Client, logger *zap.Logger, bridgID string) *grpc.ClientConn {
-	bridge := bridge.NewMQTTNetBridge(mqttClient, logger, bridgID)
-	resolver.Register(bridge)
+// GetNewMQTTGRPCBridge returns a gRPC connection to bridgID over a bridge of
+// its own on mqttClient; the caller closes the bridge after the connection
+func GetNewMQTTGRPCBridge(mqttClient *transport.Client, logger *zap.Logger, bridgID string, creds credentials.TransportCredentials, opts ...grpc.DialOption) (*grpc.ClientConn, *bridge.MQTTNetBridge, error) {
+	// The local bridge must not share the target's ID, otherwise it answers
+	// its own handshake requests instead of the device
+	netBridge := bridge.NewMQTTNetBridge(mqttClient, logger, "client-"+uuid.NewString())
+	dial := mqttClient.TrackDialer(netBridge.Dial)
+	opts = append([]grpc.DialOption{
+		grpc.WithTransportCredentials(creds),
+		// The bridge resolves mqtt:// targets for this connection only, so
+		// that dials do not touch the global resolver registry
+		grpc.WithResolvers(netBridge),
+		// The bridge does not signal when the device closes a session, so
+		// keepalive pings are what detect a session that went away
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:    20 * time.Second,
+			Timeout: 10 * time.Second,
+		}),
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			logger.Debug("Dialing device", zap.String("bridge_id", addr))
+			conn, err := dial(ctx, addr)
+			if err != nil {
+				return nil, err
+			}
+			return transport.BufferConn(conn), nil
+		}),
+	}, opts...)
 	conn, err := grpc.NewClient(
 		// "localhost:1884",
 		"mqtt://"+bridgID,
 		// bridgID,
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
-		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
-			fmt.Printf("Dialing %s\n", addr)
-			return bridge.Dial(ctx, addr)
-		}),
+		opts...,
 	)
 	if err != nil {
-		log.Fatal("Failed to dial server:", err)
+		netBridge.Close()
+		return nil, nil, fmt.Errorf("failed to create gRPC client: %w", err)
 	}
-	return conn
-
+	return conn, netBridge, nil
 }
 
-func GetNewGRPCBridge() (*grpc.ClientConn, *bridge.MQTTNetBridge) {
+func GetNewGRPCBridge() (*grpc.ClientConn, error) {
 
 	conn, err := grpc.NewClient(
 		"localhost:1884",
@@ -360,8 +277,7 @@ func GetNewGRPCBridge() (*grpc.ClientConn, *bridge.MQTTNetBridge) {
 		grpc.WithTimeout(30*time.Second),
 	)
 	if err != nil {
-		log.Fatal("Failed to dial server:", err)
+		return nil, fmt.Errorf("failed to dial server: %w", err)
 	}
-	fmt.Printf("Connected to %s\n", conn.GetState())
 	return conn, nil
 }
@@ -0,0 +1,72 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	reflection "github.com/vedantkulkarni/reflect-poc/reflection"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// EncodeRequests reads the JSON requests of a method from in, like Call does,
+// and returns them in the protobuf wire format. The types are resolved through
+// source, so requests can be built while the device is offline.
+func EncodeRequests(ctx context.Context, source reflection.DescriptorSource, methodName string, in io.Reader) ([][]byte, error) {
+	method, err := reflection.FindMethod(ctx, source, methodName)
+	if err != nil {
+		return nil, err
+	}
+	requests := newRequestDecoder(in, method.Input())
+
+	var messages []proto.Message
+	if method.IsStreamingClient() {
+		for {
+			request, err := requests.next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%w for %s: %w", ErrInvalidRequest, method.FullName(), err)
+			}
+			messages = append(messages, request)
+		}
+	} else {
+		request, err := requests.single()
+		if err != nil {
+			return nil, fmt.Errorf("%w for %s: %w", ErrInvalidRequest, method.FullName(), err)
+		}
+		messages = append(messages, request)
+	}
+
+	encoded := make([][]byte, 0, len(messages))
+	for _, message := range messages {
+		payload, err := proto.Marshal(message)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal request: %w", err)
+		}
+		encoded = append(encoded, payload)
+	}
+	return encoded, nil
+}
+
+// DecodeMessage decodes a request, or with response set a response, of a
+// method from the protobuf wire format, such as a message of captured traffic.
+// The types are resolved through source.
+func DecodeMessage(ctx context.Context, source reflection.DescriptorSource, methodName string, payload []byte, response bool) (proto.Message, error) {
+	method, err := reflection.FindMethod(ctx, source, methodName)
+	if err != nil {
+		return nil, err
+	}
+	desc := method.Input()
+	if response {
+		desc = method.Output()
+	}
+	message := dynamicpb.NewMessage(desc)
+	if err := proto.Unmarshal(payload, message); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", desc.FullName(), err)
+	}
+	return message, nil
+}
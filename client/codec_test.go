@@ -0,0 +1,95 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	client "github.com/vedantkulkarni/reflect-poc/client"
+	reflection "github.com/vedantkulkarni/reflect-poc/reflection"
+
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+func testSource(t *testing.T) *reflection.FileSource {
+	t.Helper()
+	source, err := reflection.NewFileSource(testDescriptors(t, "reflect.TestService"))
+	if err != nil {
+		t.Fatalf("failed to build source: %v", err)
+	}
+	return source
+}
+
+func TestEncodeDecodeRoundTrip(t *testing.T) {
+	source := testSource(t)
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		method string
+		in     string
+		want   []string
+	}{
+		{"reflect.TestService/Test", `{"message":"hi"}`, []string{`{"message":"hi"}`}},
+		{"reflect.TestService/Test", ``, []string{`{}`}},
+		{"reflect.TestService/TestClientStream", `{"message":"a"} {"message":"b"}`, []string{`{"message":"a"}`, `{"message":"b"}`}},
+		{"reflect.TestService/TestBidiStream", ``, nil},
+	} {
+		encoded, err := client.EncodeRequests(ctx, source, tc.method, strings.NewReader(tc.in))
+		if err != nil {
+			t.Errorf("EncodeRequests(%s, %q) failed: %v", tc.method, tc.in, err)
+			continue
+		}
+		if len(encoded) != len(tc.want) {
+			t.Errorf("EncodeRequests(%s, %q) returned %d messages, want %d", tc.method, tc.in, len(encoded), len(tc.want))
+			continue
+		}
+		for i, payload := range encoded {
+			message, err := client.DecodeMessage(ctx, source, tc.method, payload, false)
+			if err != nil {
+				t.Errorf("DecodeMessage failed: %v", err)
+				continue
+			}
+			got, _ := protojson.MarshalOptions{}.Marshal(message)
+			if strings.ReplaceAll(string(got), " ", "") != tc.want[i] {
+				t.Errorf("request %d of %s decoded as %s, want %s", i, tc.method, got, tc.want[i])
+			}
+		}
+	}
+}
+
+func TestEncodeRequestsErrors(t *testing.T) {
+	source := testSource(t)
+	for _, tc := range []struct {
+		method  string
+		in      string
+		invalid bool
+	}{
+		{"reflect.TestService/Test", `{"message":1}`, true},
+		{"reflect.TestService/Test", `{"message":"a"} {"message":"b"}`, true},
+		{"reflect.TestService/Test", `{"nope":"a"}`, true},
+		{"reflect.TestService/NoSuchMethod", `{}`, false},
+	} {
+		_, err := client.EncodeRequests(context.Background(), source, tc.method, strings.NewReader(tc.in))
+		if err == nil {
+			t.Errorf("EncodeRequests(%s, %q) succeeded", tc.method, tc.in)
+		} else if errors.Is(err, client.ErrInvalidRequest) != tc.invalid {
+			t.Errorf("EncodeRequests(%s, %q) returned %v", tc.method, tc.in, err)
+		}
+	}
+}
+
+func TestDecodeResponse(t *testing.T) {
+	source := testSource(t)
+	// Field 1, a string "ok"
+	message, err := client.DecodeMessage(context.Background(), source, "reflect.TestService/Test", []byte{0x0a, 0x02, 'o', 'k'}, true)
+	if err != nil {
+		t.Fatalf("DecodeMessage failed: %v", err)
+	}
+	if name := message.ProtoReflect().Descriptor().FullName(); name != "reflect.TestMessageResponse" {
+		t.Errorf("response decoded as %s", name)
+	}
+	if _, err := client.DecodeMessage(context.Background(), source, "reflect.TestService/Test", []byte{0x0a, 0x05}, true); err == nil {
+		t.Error("truncated message decoded")
+	}
+}
@@ -0,0 +1,78 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	server "github.com/vedantkulkarni/reflect-poc/server"
+)
+
+// WatchPresence subscribes to the presence announcements of every device and
+// calls onPresence with each one, starting with the retained announcements,
+// until ctx is done. onPresence runs on the MQTT client's delivery goroutine.
+// A cleared retained message is reported as the device going offline.
+func WatchPresence(ctx context.Context, mqttClient mqtt.Client, onPresence func(server.Presence)) error {
+	token := mqttClient.Subscribe(server.PresenceTopicFilter, 1, func(_ mqtt.Client, msg mqtt.Message) {
+		presence, err := parsePresence(msg.Topic(), msg.Payload())
+		if err != nil {
+			return
+		}
+		onPresence(presence)
+	})
+	if token.Wait() && token.Error() != nil {
+		return fmt.Errorf("failed to subscribe to presence: %w", token.Error())
+	}
+	<-ctx.Done()
+	mqttClient.Unsubscribe(server.PresenceTopicFilter).Wait()
+	return nil
+}
+
+// Discover returns the presence of every device announced on the broker,
+// sorted by bridge ID, after waiting for the retained announcements to arrive
+// for up to wait. Offline devices are included when all is set.
+func Discover(ctx context.Context, mqttClient mqtt.Client, wait time.Duration, all bool) ([]server.Presence, error) {
+	var mu sync.Mutex
+	devices := make(map[string]server.Presence)
+
+	ctx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+	if err := WatchPresence(ctx, mqttClient, func(presence server.Presence) {
+		mu.Lock()
+		devices[presence.BridgeID] = presence
+		mu.Unlock()
+	}); err != nil {
+		return nil, err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	found := make([]server.Presence, 0, len(devices))
+	for _, presence := range devices {
+		if presence.Online || all {
+			found = append(found, presence)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].BridgeID < found[j].BridgeID })
+	return found, nil
+}
+
+// parsePresence decodes an announcement. The bridge ID falls back to the last
+// topic level, which is all a cleared retained message carries.
+func parsePresence(topic string, payload []byte) (server.Presence, error) {
+	var presence server.Presence
+	if len(payload) > 0 {
+		if err := json.Unmarshal(payload, &presence); err != nil {
+			return presence, fmt.Errorf("invalid presence on %s: %w", topic, err)
+		}
+	}
+	if presence.BridgeID == "" {
+		presence.BridgeID = topic[strings.LastIndex(topic, "/")+1:]
+	}
+	return presence, nil
+}
@@ -0,0 +1,109 @@
+package client_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	client "github.com/vedantkulkarni/reflect-poc/client"
+	server "github.com/vedantkulkarni/reflect-poc/server"
+)
+
+// retainedBroker delivers a fixed set of messages to every subscription
+type retainedBroker struct {
+	mqtt.Client
+	messages []retainedMessage
+}
+
+type retainedMessage struct {
+	mqtt.Message
+	topic   string
+	payload string
+}
+
+func (m retainedMessage) Topic() string   { return m.topic }
+func (m retainedMessage) Payload() []byte { return []byte(m.payload) }
+
+func (b *retainedBroker) Subscribe(topic string, qos byte, callback mqtt.MessageHandler) mqtt.Token {
+	for _, msg := range b.messages {
+		callback(b, msg)
+	}
+	return &mqtt.DummyToken{}
+}
+
+func (b *retainedBroker) Unsubscribe(topics ...string) mqtt.Token {
+	return &mqtt.DummyToken{}
+}
+
+func presenceBroker() *retainedBroker {
+	return &retainedBroker{messages: []retainedMessage{
+		{topic: "/bridge/presence/pump-2", payload: `{"bridge_id":"pump-2","online":true,"services":["reflect.TestService"],"version":"1.2.0","descriptor_hash":"abc","timestamp":"2024-05-01T10:00:00Z"}`},
+		{topic: "/bridge/presence/pump-1", payload: `{"bridge_id":"pump-1","online":false,"timestamp":"2024-05-01T09:00:00Z"}`},
+		// Presences without a bridge ID take it from the topic
+		{topic: "/bridge/presence/valve-1", payload: `{"online":true}`},
+		// A cleared retained message means the device is gone
+		{topic: "/bridge/presence/valve-2", payload: `{"bridge_id":"valve-2","online":true}`},
+		{topic: "/bridge/presence/valve-2", payload: ""},
+		// Invalid payloads are skipped
+		{topic: "/bridge/presence/broken", payload: `{"online":`},
+	}}
+}
+
+func TestDiscover(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		all  bool
+		want []string
+	}{
+		{"online devices", false, []string{"pump-2", "valve-1"}},
+		{"all devices", true, []string{"pump-1", "pump-2", "valve-1", "valve-2"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			devices, err := client.Discover(context.Background(), presenceBroker(), 10*time.Millisecond, tc.all)
+			if err != nil {
+				t.Fatalf("Discover failed: %v", err)
+			}
+			var got []string
+			for _, device := range devices {
+				got = append(got, device.BridgeID)
+			}
+			if len(got) != len(tc.want) {
+				t.Fatalf("discovered %v, want %v", got, tc.want)
+			}
+			for i := range tc.want {
+				if got[i] != tc.want[i] {
+					t.Fatalf("discovered %v, want %v", got, tc.want)
+				}
+			}
+		})
+	}
+}
+
+func TestWatchPresenceParsesPayloads(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var presences []server.Presence
+	if err := client.WatchPresence(ctx, presenceBroker(), func(presence server.Presence) {
+		presences = append(presences, presence)
+	}); err != nil {
+		t.Fatalf("WatchPresence failed: %v", err)
+	}
+	if len(presences) != 5 {
+		t.Fatalf("got %d presences, want 5 without the invalid one: %+v", len(presences), presences)
+	}
+
+	full := presences[0]
+	if full.BridgeID != "pump-2" || !full.Online || full.Version != "1.2.0" || full.DescriptorHash != "abc" ||
+		len(full.Services) != 1 || full.Services[0] != "reflect.TestService" ||
+		!full.Timestamp.Equal(time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("parsed %+v", full)
+	}
+	if presences[2].BridgeID != "valve-1" || !presences[2].Online {
+		t.Errorf("presence without bridge ID parsed as %+v", presences[2])
+	}
+	if cleared := presences[4]; cleared.BridgeID != "valve-2" || cleared.Online {
+		t.Errorf("cleared presence parsed as %+v", cleared)
+	}
+}
@@ -0,0 +1,38 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// WatchHealth streams the health of service on the device, "" for the device
+// as a whole, through grpc.health.v1.Health/Watch. onStatus is called with
+// the current status and then with every change until it returns an error,
+// ctx is done or the stream ends.
+func (drs *ReflectionClient) WatchHealth(ctx context.Context, service string, onStatus func(healthpb.HealthCheckResponse_ServingStatus) error) error {
+	stream, err := healthpb.NewHealthClient(drs.conn).Watch(ctx, &healthpb.HealthCheckRequest{Service: service})
+	if err != nil {
+		return fmt.Errorf("failed to watch health: %w", err)
+	}
+	for {
+		resp, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if status.Code(err) == codes.Unimplemented {
+			return errors.New("device does not implement grpc.health.v1.Health")
+		}
+		if err != nil {
+			return fmt.Errorf("failed to watch health: %w", err)
+		}
+		if err := onStatus(resp.GetStatus()); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package client
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+
+	transport "github.com/vedantkulkarni/reflect-poc/transport"
+	"go.uber.org/zap"
+)
+
+// errPoolClosed is returned by Pool.Client after Close
+var errPoolClosed = errors.New("client pool is closed")
+
+// DefaultMaxPoolClients is how many device clients a Pool keeps by default
+const DefaultMaxPoolClients = 256
+
+// Pool shares one broker connection between the clients of many devices,
+// for servers such as the gateway that call whichever device a request names.
+// Clients are created on first use. Once the pool holds its maximum, the
+// least recently used client is closed to make room for a new one, so that
+// requests naming many devices cannot grow the pool without bound.
+type Pool struct {
+	cfg        Config
+	mqttClient *transport.Client
+	maxClients int
+
+	mu      sync.Mutex
+	clients map[string]*list.Element
+	// lru orders the pooled clients from most to least recently used
+	lru    *list.List
+	closed bool
+}
+
+// pooledClient is an element of Pool.lru
+type pooledClient struct {
+	bridgeID string
+	client   *ReflectionClient
+}
+
+// NewPool connects to the broker described by cfg and keeps up to
+// DefaultMaxPoolClients clients. cfg.BridgeID is ignored; every other setting
+// applies to each client of the pool.
+func NewPool(cfg Config) (*Pool, error) {
+	return NewPoolWithLimit(cfg, DefaultMaxPoolClients)
+}
+
+// NewPoolWithLimit is NewPool keeping up to maxClients clients
+func NewPoolWithLimit(cfg Config, maxClients int) (*Pool, error) {
+	if maxClients <= 0 {
+		return nil, errors.New("pool must hold at least one client")
+	}
+	cfg = cfg.withDefaults()
+	mqttClient, err := transport.Connect(cfg.Broker)
+	if err != nil {
+		return nil, err
+	}
+	return &Pool{
+		cfg:        cfg,
+		mqttClient: mqttClient,
+		maxClients: maxClients,
+		clients:    make(map[string]*list.Element),
+		lru:        list.New(),
+	}, nil
+}
+
+// MQTTClient returns the broker connection shared by the pool
+func (p *Pool) MQTTClient() *transport.Client {
+	return p.mqttClient
+}
+
+// Client returns the client of bridgeID, creating it on first use. The
+// connection to the device is made lazily by the first call. Calls still in
+// flight on a client evicted to make room fail with Canceled.
+func (p *Pool) Client(bridgeID string) (*ReflectionClient, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil, errPoolClosed
+	}
+	if elem, ok := p.clients[bridgeID]; ok {
+		p.lru.MoveToFront(elem)
+		return elem.Value.(*pooledClient).client, nil
+	}
+
+	cfg := p.cfg
+	cfg.BridgeID = bridgeID
+	drs, err := newReflectionClient(p.mqttClient, cfg)
+	if err != nil {
+		return nil, err
+	}
+	p.cfg.Logger.Debug("Created pooled client", zap.String("bridge_id", bridgeID))
+	p.clients[bridgeID] = p.lru.PushFront(&pooledClient{bridgeID: bridgeID, client: drs})
+
+	for p.lru.Len() > p.maxClients {
+		oldest := p.lru.Remove(p.lru.Back()).(*pooledClient)
+		delete(p.clients, oldest.bridgeID)
+		p.cfg.Logger.Debug("Evicted pooled client", zap.String("bridge_id", oldest.bridgeID))
+		oldest.client.Close()
+	}
+	return drs, nil
+}
+
+// Len returns the number of clients in the pool
+func (p *Pool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.lru.Len()
+}
+
+// Close closes every client and disconnects from the broker
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+
+	var errs []error
+	for elem := p.lru.Front(); elem != nil; elem = elem.Next() {
+		errs = append(errs, elem.Value.(*pooledClient).client.Close())
+	}
+	p.mqttClient.Disconnect(250)
+	return errors.Join(errs...)
+}
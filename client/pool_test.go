@@ -0,0 +1,155 @@
+package client_test
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	client "github.com/vedantkulkarni/reflect-poc/client"
+	embedded "github.com/vedantkulkarni/reflect-poc/embedded"
+	transport "github.com/vedantkulkarni/reflect-poc/transport"
+
+	"google.golang.org/grpc/connectivity"
+)
+
+func TestPoolEvictsLeastRecentlyUsed(t *testing.T) {
+	b, err := embedded.Start(embedded.Config{})
+	if err != nil {
+		t.Fatalf("failed to start broker: %v", err)
+	}
+	defer b.Close()
+	pool, err := client.NewPoolWithLimit(client.Config{
+		Broker: transport.BrokerConfig{URL: embedded.InProcessURL, Dial: b.Dial},
+	}, 2)
+	if err != nil {
+		t.Fatalf("failed to create pool: %v", err)
+	}
+	defer pool.Close()
+
+	get := func(bridgeID string) *client.ReflectionClient {
+		t.Helper()
+		drs, err := pool.Client(bridgeID)
+		if err != nil {
+			t.Fatalf("failed to get client of %s: %v", bridgeID, err)
+		}
+		return drs
+	}
+
+	a, bClient := get("device-a"), get("device-b")
+	if get("device-a") != a {
+		t.Error("pool created a second client for device-a")
+	}
+	// device-b is now the least recently used
+	get("device-c")
+	if n := pool.Len(); n != 2 {
+		t.Errorf("pool holds %d clients, want 2", n)
+	}
+	if state := bClient.State(); state != connectivity.Shutdown {
+		t.Errorf("evicted client is %s, want SHUTDOWN", state)
+	}
+	if a.State() == connectivity.Shutdown {
+		t.Error("recently used client was closed")
+	}
+	if get("device-b") == bClient {
+		t.Error("pool returned an evicted client")
+	}
+
+	if err := pool.Close(); err != nil {
+		t.Fatalf("failed to close pool: %v", err)
+	}
+	if _, err := pool.Client("device-a"); err == nil {
+		t.Error("closed pool returned a client")
+	}
+}
+
+// handshakeObserver records the handshake topics subscribed and the messages
+// delivered to them
+type handshakeObserver struct {
+	mu         sync.Mutex
+	subscribed []string
+	received   map[string]int
+}
+
+func (o *handshakeObserver) Published(string, int) {}
+func (o *handshakeObserver) Reconnected()          {}
+
+func (o *handshakeObserver) Subscribed(topic string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if strings.HasPrefix(topic, "/bridge/handshake/client-") {
+		o.subscribed = append(o.subscribed, topic)
+	}
+}
+
+func (o *handshakeObserver) Received(topic string, _ int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.received[topic]++
+}
+
+func (o *handshakeObserver) count(topic string) int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.received[topic]
+}
+
+// TestPoolEvictionReleasesBridge checks that evicting a client unsubscribes
+// the handshake topic of its bridge from the shared broker connection
+func TestPoolEvictionReleasesBridge(t *testing.T) {
+	b, err := embedded.Start(embedded.Config{})
+	if err != nil {
+		t.Fatalf("failed to start broker: %v", err)
+	}
+	defer b.Close()
+	observer := &handshakeObserver{received: make(map[string]int)}
+	pool, err := client.NewPoolWithLimit(client.Config{
+		Broker: transport.BrokerConfig{URL: embedded.InProcessURL, Dial: b.Dial, Observer: observer},
+	}, 1)
+	if err != nil {
+		t.Fatalf("failed to create pool: %v", err)
+	}
+	defer pool.Close()
+
+	if _, err := pool.Client("device-a"); err != nil {
+		t.Fatalf("failed to get client of device-a: %v", err)
+	}
+	live, err := pool.Client("device-b")
+	if err != nil {
+		t.Fatalf("failed to get client of device-b: %v", err)
+	}
+	observer.mu.Lock()
+	topics := append([]string(nil), observer.subscribed...)
+	observer.mu.Unlock()
+	if len(topics) != 2 {
+		t.Fatalf("subscribed handshake topics %v, want one per client", topics)
+	}
+
+	// A probe is not a connect request, so the bridges ignore it
+	probe := func(topic string) string {
+		return strings.TrimSuffix(topic, "+") + "probe"
+	}
+	evicted, current := probe(topics[0]), probe(topics[1])
+	for _, topic := range []string{evicted, current} {
+		token := live.MQTTClient().Publish(topic, 1, false, []byte("probe"))
+		if token.Wait(); token.Error() != nil {
+			t.Fatalf("failed to publish to %s: %v", topic, token.Error())
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for observer.count(current) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if observer.count(current) == 0 {
+		t.Fatal("the pooled client's bridge did not receive its handshake probe")
+	}
+	if n := observer.count(evicted); n != 0 {
+		t.Errorf("the evicted client's bridge still received %d handshake messages", n)
+	}
+}
+
+func TestNewPoolWithLimitRejectsEmptyPool(t *testing.T) {
+	if _, err := client.NewPoolWithLimit(client.Config{}, 0); err == nil {
+		t.Error("created a pool that holds no clients")
+	}
+}
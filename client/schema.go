@@ -0,0 +1,69 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	reflection "github.com/vedantkulkarni/reflect-poc/reflection"
+	server "github.com/vedantkulkarni/reflect-poc/server"
+	transport "github.com/vedantkulkarni/reflect-poc/transport"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// LoadSchema builds a descriptor source from the descriptors bridgeID
+// published on the broker. It needs no round trip to the device, so requests
+// can be built and captured traffic decoded while the device is offline.
+func LoadSchema(ctx context.Context, mqttClient mqtt.Client, bridgeID string) (*reflection.FileSource, error) {
+	payload, err := transport.ReadRetained(ctx, mqttClient, server.PresenceTopic(bridgeID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read presence of %s: %w", bridgeID, err)
+	}
+	var presence server.Presence
+	if err := json.Unmarshal(payload, &presence); err != nil {
+		return nil, fmt.Errorf("invalid presence of %s: %w", bridgeID, err)
+	}
+	if presence.DescriptorHash == "" {
+		return nil, errors.New(bridgeID + " has not published its descriptors")
+	}
+
+	set, err := reflection.ReadDescriptorSet(ctx, mqttClient, server.DescriptorTopic(presence.DescriptorHash))
+	if err != nil {
+		return nil, err
+	}
+	if hash, err := server.DescriptorHash(set); err != nil || hash != presence.DescriptorHash {
+		return nil, fmt.Errorf("descriptors of %s do not match hash %s", bridgeID, presence.DescriptorHash)
+	}
+	return reflection.NewFileSource(set)
+}
+
+// ExportDescriptors returns the FileDescriptorSet of every service of source,
+// such as a device reached through reflection, except the reflection service
+// itself. The set can be saved as a protoset or served by a mock device.
+func ExportDescriptors(ctx context.Context, source reflection.DescriptorSource) (*descriptorpb.FileDescriptorSet, error) {
+	names, err := source.ListServices(ctx)
+	if err != nil {
+		return nil, err
+	}
+	services := make([]string, 0, len(names))
+	for _, name := range names {
+		if !strings.HasPrefix(name, "grpc.reflection.") {
+			services = append(services, name)
+		}
+	}
+	return server.DescriptorSet(sourceResolver{ctx: ctx, source: source}, services)
+}
+
+// sourceResolver resolves descriptors through a DescriptorSource
+type sourceResolver struct {
+	ctx    context.Context
+	source reflection.DescriptorSource
+}
+
+func (r sourceResolver) FindDescriptorByName(name protoreflect.FullName) (protoreflect.Descriptor, error) {
+	return r.source.FindSymbol(r.ctx, string(name))
+}
@@ -0,0 +1,194 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	client "github.com/vedantkulkarni/reflect-poc/client"
+	embedded "github.com/vedantkulkarni/reflect-poc/embedded"
+	reflection "github.com/vedantkulkarni/reflect-poc/reflection"
+	server "github.com/vedantkulkarni/reflect-poc/server"
+	service_proto "github.com/vedantkulkarni/reflect-poc/service-proto"
+	transport "github.com/vedantkulkarni/reflect-poc/transport"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	grpcreflection "google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// connectBroker starts an embedded broker and connects to it
+func connectBroker(t *testing.T) *transport.Client {
+	t.Helper()
+	b, err := embedded.Start(embedded.Config{})
+	if err != nil {
+		t.Fatalf("failed to start broker: %v", err)
+	}
+	t.Cleanup(func() { b.Close() })
+	mqttClient, err := transport.Connect(transport.BrokerConfig{URL: embedded.InProcessURL, ClientID: "schema-test", Dial: b.Dial})
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	t.Cleanup(func() { mqttClient.Disconnect(0) })
+	return mqttClient
+}
+
+func testDescriptors(t *testing.T, services ...string) *descriptorpb.FileDescriptorSet {
+	t.Helper()
+	set, err := server.FileDescriptorSet(services)
+	if err != nil {
+		t.Fatalf("failed to build descriptors: %v", err)
+	}
+	return set
+}
+
+func TestLoadSchema(t *testing.T) {
+	mqttClient := connectBroker(t)
+	set := testDescriptors(t, "reflect.TestService")
+	hash, err := server.PublishDescriptors(mqttClient, set)
+	if err != nil {
+		t.Fatalf("failed to publish descriptors: %v", err)
+	}
+	// The device is offline; its retained presence still names its descriptors
+	if err := server.PublishPresence(mqttClient, server.Presence{BridgeID: "pump-1", DescriptorHash: hash}); err != nil {
+		t.Fatalf("failed to publish presence: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	source, err := client.LoadSchema(ctx, mqttClient, "pump-1")
+	if err != nil {
+		t.Fatalf("LoadSchema failed: %v", err)
+	}
+	services, err := source.ListServices(ctx)
+	if err != nil || len(services) != 2 || services[1] != "reflect.TestService" {
+		t.Errorf("schema lists %v, %v", services, err)
+	}
+	if _, err := source.FindMethod(ctx, "reflect.TestService/Test"); err != nil {
+		t.Errorf("schema does not resolve methods: %v", err)
+	}
+}
+
+func TestLoadSchemaErrors(t *testing.T) {
+	mqttClient := connectBroker(t)
+	publish := func(topic string, payload []byte) {
+		t.Helper()
+		token := mqttClient.Publish(topic, 1, true, payload)
+		if token.Wait() && token.Error() != nil {
+			t.Fatalf("failed to publish: %v", token.Error())
+		}
+	}
+
+	publish(server.PresenceTopic("invalid"), []byte(`{"bridge_id":`))
+	if err := server.PublishPresence(mqttClient, server.Presence{BridgeID: "no-hash"}); err != nil {
+		t.Fatalf("failed to publish presence: %v", err)
+	}
+	if err := server.PublishPresence(mqttClient, server.Presence{BridgeID: "no-descriptors", DescriptorHash: "0123"}); err != nil {
+		t.Fatalf("failed to publish presence: %v", err)
+	}
+
+	// Descriptors published under the hash of different descriptors
+	hash, err := server.DescriptorHash(testDescriptors(t, "reflect.TestService"))
+	if err != nil {
+		t.Fatalf("failed to hash descriptors: %v", err)
+	}
+	other, err := server.PublishDescriptors(mqttClient, testDescriptors(t, "grpc.health.v1.Health"))
+	if err != nil {
+		t.Fatalf("failed to publish descriptors: %v", err)
+	}
+	payload, err := transport.ReadRetained(context.Background(), mqttClient, server.DescriptorTopic(other))
+	if err != nil {
+		t.Fatalf("failed to read descriptors: %v", err)
+	}
+	publish(server.DescriptorTopic(hash), payload)
+	if err := server.PublishPresence(mqttClient, server.Presence{BridgeID: "tampered", DescriptorHash: hash}); err != nil {
+		t.Fatalf("failed to publish presence: %v", err)
+	}
+
+	for _, bridgeID := range []string{"missing", "invalid", "no-hash", "no-descriptors", "tampered"} {
+		t.Run(bridgeID, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+			if _, err := client.LoadSchema(ctx, mqttClient, bridgeID); err == nil {
+				t.Error("schema loaded")
+			}
+		})
+	}
+}
+
+func TestExportDescriptors(t *testing.T) {
+	listener := bufconn.Listen(1 << 20)
+	grpcServer := grpc.NewServer()
+	service_proto.RegisterTestServiceServer(grpcServer, &server.MyTestService{})
+	server.NewHealth(nil).Register(grpcServer)
+	grpcreflection.RegisterV1(grpcServer)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	set, err := client.ExportDescriptors(context.Background(), reflection.NewGRPCReflectionHelper(conn, nil))
+	if err != nil {
+		t.Fatalf("failed to export descriptors: %v", err)
+	}
+	for _, file := range set.File {
+		if strings.HasPrefix(file.GetPackage(), "grpc.reflection.") {
+			t.Errorf("exported the reflection service in %s", file.GetName())
+		}
+	}
+
+	// The export describes the device as its own descriptors do
+	source, err := reflection.NewFileSource(set)
+	if err != nil {
+		t.Fatalf("exported descriptors do not build: %v", err)
+	}
+	services, err := source.ListServices(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// SyncService comes along in the file it shares with TestService
+	if want := []string{"grpc.health.v1.Health", "reflect.SyncService", "reflect.TestService"}; !slices.Equal(services, want) {
+		t.Errorf("exported services %v, want %v", services, want)
+	}
+	exported, err := server.DescriptorHash(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local, err := server.DescriptorHash(testDescriptors(t, "grpc.health.v1.Health", "reflect.TestService"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exported != local {
+		t.Errorf("exported descriptors hash to %s, the device's own to %s", exported, local)
+	}
+}
+
+// failingSource fails to list its services
+type failingSource struct {
+	reflection.DescriptorSource
+}
+
+func (failingSource) ListServices(context.Context) ([]string, error) {
+	return nil, errors.New("device unreachable")
+}
+
+func TestExportDescriptorsError(t *testing.T) {
+	if _, err := client.ExportDescriptors(context.Background(), failingSource{}); err == nil {
+		t.Error("exported descriptors of a source that cannot list its services")
+	}
+}
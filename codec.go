@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	client "github.com/vedantkulkarni/reflect-poc/client"
+)
+
+// runEncode prints the JSON requests of a method in the protobuf wire format,
+// base64 encoded, one message per line
+func runEncode(args []string) error {
+	fs := flag.NewFlagSet("encode", flag.ExitOnError)
+	var opts clientOptions
+	opts.register(fs)
+	opts.registerOfflineFlag(fs)
+	data := fs.String("d", "", "JSON request body; @file reads it from a file and @- from stdin. Streaming methods take a sequence of objects")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s encode [flags] [bridge-id] <pkg.Service/Method>\n", os.Args[0])
+		fmt.Fprintln(fs.Output(), "Prints each request as a base64 encoded protobuf message on its own line.")
+		fs.PrintDefaults()
+	}
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	bridgeID, method, err := methodArgs(fs, opts.bridgeID)
+	if err != nil {
+		return err
+	}
+
+	in, err := requestInput(*data)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	source, ctx, closeSource, err := opts.schema(bridgeID)
+	if err != nil {
+		return err
+	}
+	defer closeSource()
+
+	requests, err := client.EncodeRequests(ctx, source, method, in)
+	if err != nil {
+		return err
+	}
+	for _, request := range requests {
+		fmt.Println(base64.StdEncoding.EncodeToString(request))
+	}
+	return nil
+}
+
+// runDecode prints base64 encoded protobuf messages of a method, one per line
+// of the input, such as captured traffic
+func runDecode(args []string) error {
+	fs := flag.NewFlagSet("decode", flag.ExitOnError)
+	var opts clientOptions
+	opts.register(fs)
+	opts.registerOfflineFlag(fs)
+	data := fs.String("d", "@-", "base64 encoded messages, one per line; @file reads them from a file and @- from stdin")
+	response := fs.Bool("response", false, "decode responses instead of requests")
+	format := fs.String("format", "json", "output format: "+strings.Join(outputFormats, ", "))
+	emitDefaults := fs.Bool("emit-defaults", false, "include fields with default values in JSON output")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s decode [flags] [bridge-id] <pkg.Service/Method>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	bridgeID, method, err := methodArgs(fs, opts.bridgeID)
+	if err != nil {
+		return err
+	}
+
+	formatter, err := newFormatter(*format, *emitDefaults)
+	if err != nil {
+		return err
+	}
+	in, err := requestInput(*data)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	source, ctx, closeSource, err := opts.schema(bridgeID)
+	if err != nil {
+		return err
+	}
+	defer closeSource()
+
+	lines := bufio.NewScanner(in)
+	lines.Buffer(nil, 16<<20)
+	for line := 1; lines.Scan(); line++ {
+		text := strings.TrimSpace(lines.Text())
+		if text == "" {
+			continue
+		}
+		payload, err := base64.StdEncoding.DecodeString(text)
+		if err != nil {
+			return fmt.Errorf("line %d: invalid base64: %w", line, err)
+		}
+		message, err := client.DecodeMessage(ctx, source, method, payload, *response)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		out, err := formatter(message)
+		if err != nil {
+			return fmt.Errorf("failed to format message: %w", err)
+		}
+		fmt.Println(strings.TrimSpace(string(out)))
+	}
+	if err := lines.Err(); err != nil {
+		return fmt.Errorf("failed to read messages: %w", err)
+	}
+	return nil
+}
+
+// methodArgs returns the [bridge-id] <method> arguments of fs, where the
+// method may be any symbol for describe
+func methodArgs(fs *flag.FlagSet, defaultBridgeID string) (string, string, error) {
+	switch fs.NArg() {
+	case 1:
+		return defaultBridgeID, fs.Arg(0), nil
+	case 2:
+		return fs.Arg(0), fs.Arg(1), nil
+	}
+	fs.Usage()
+	return "", "", errUsage
+}
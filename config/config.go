@@ -0,0 +1,163 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// File holds named connection profiles. A config file is JSON:
+//
+//	{
+//	  "default": "lab-bench",
+//	  "profiles": {
+//	    "lab-bench": {
+//	      "broker": "tcp://10.0.0.5:1883",
+//	      "bridge_id": "bench-01"
+//	    },
+//	    "staging-eu": {
+//	      "broker": "ssl://mqtt.staging-eu.example.com:8883",
+//	      "broker_tls": {"ca_file": "/etc/reflect/staging-ca.pem"},
+//	      "grpc_client_tls": {"ca_file": "/etc/reflect/devices-ca.pem"},
+//	      "headers": {"x-operator": "oncall"},
+//	      "timeout": "10s"
+//	    }
+//	  }
+//	}
+//
+// Paths are relative to the directory of the config file.
+type File struct {
+	Default  string              `json:"default,omitempty"`
+	Profiles map[string]*Profile `json:"profiles"`
+
+	dir string
+}
+
+// Profile is a named set of connection settings. Empty fields keep the
+// built-in defaults.
+type Profile struct {
+	Broker               string `json:"broker,omitempty"`
+	ClientID             string `json:"client_id,omitempty"`
+	Username             string `json:"username,omitempty"`
+	Password             string `json:"password,omitempty"`
+	BrokerTLS            TLS    `json:"broker_tls,omitempty"`
+	MaxReconnectInterval string `json:"max_reconnect_interval,omitempty"`
+
+	// BridgeID is the device called by client commands, or served by serve
+	BridgeID string `json:"bridge_id,omitempty"`
+	// GRPCClientTLS is used by client commands to verify the device and to
+	// present a client certificate
+	GRPCClientTLS TLS `json:"grpc_client_tls,omitempty"`
+	// GRPCServerTLS is the certificate served by serve, and the CA it
+	// verifies callers with
+	GRPCServerTLS TLS  `json:"grpc_server_tls,omitempty"`
+	Auth          Auth `json:"auth,omitempty"`
+
+	// Headers are sent with every call; -H replaces those of the same name
+	Headers        map[string]string `json:"headers,omitempty"`
+	Timeout        string            `json:"timeout,omitempty"`
+	ConnectTimeout string            `json:"connect_timeout,omitempty"`
+	DrainTimeout   string            `json:"drain_timeout,omitempty"`
+}
+
+// TLS holds certificate material
+type TLS struct {
+	CAFile             string `json:"ca_file,omitempty"`
+	CertFile           string `json:"cert_file,omitempty"`
+	KeyFile            string `json:"key_file,omitempty"`
+	ServerName         string `json:"server_name,omitempty"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"`
+}
+
+// Auth holds the per-RPC credentials sent by client commands
+type Auth struct {
+	Token      string   `json:"token,omitempty"`
+	JWTSecret  string   `json:"jwt_secret,omitempty"`
+	JWTSubject string   `json:"jwt_subject,omitempty"`
+	JWTRoles   []string `json:"jwt_roles,omitempty"`
+	HMACKeyID  string   `json:"hmac_key_id,omitempty"`
+	HMACSecret string   `json:"hmac_secret,omitempty"`
+}
+
+// DefaultPath returns the config file used when none is given
+func DefaultPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "reflect-poc", "config.json")
+}
+
+// Load reads a config file
+func Load(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	file := &File{dir: filepath.Dir(path)}
+	if err := json.Unmarshal(data, file); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return file, nil
+}
+
+// Profile returns the named profile, or the default profile when name is
+// empty. It returns nil without error when name is empty and there is no default.
+func (f *File) Profile(name string) (*Profile, error) {
+	if name == "" {
+		name = f.Default
+	}
+	if name == "" {
+		return nil, nil
+	}
+
+	profile, ok := f.Profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown profile %q, known profiles: %s", name, strings.Join(f.Names(), ", "))
+	}
+	resolved := *profile
+	resolved.resolvePaths(f.dir)
+	return &resolved, nil
+}
+
+// Names returns the profile names in order
+func (f *File) Names() []string {
+	names := make([]string, 0, len(f.Profiles))
+	for name := range f.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (p *Profile) resolvePaths(dir string) {
+	for _, path := range []*string{
+		&p.BrokerTLS.CAFile, &p.BrokerTLS.CertFile, &p.BrokerTLS.KeyFile,
+		&p.GRPCClientTLS.CAFile, &p.GRPCClientTLS.CertFile, &p.GRPCClientTLS.KeyFile,
+		&p.GRPCServerTLS.CAFile, &p.GRPCServerTLS.CertFile, &p.GRPCServerTLS.KeyFile,
+	} {
+		if *path != "" && !filepath.IsAbs(*path) {
+			*path = filepath.Join(dir, *path)
+		}
+	}
+}
+
+// ErrNotFound is returned by LoadDefault when there is no config file at the default path
+var ErrNotFound = errors.New("config file not found")
+
+// LoadDefault reads the config file at DefaultPath
+func LoadDefault() (*File, error) {
+	path := DefaultPath()
+	if path == "" {
+		return nil, ErrNotFound
+	}
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return Load(path)
+}
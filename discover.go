@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/uuid"
+	client "github.com/vedantkulkarni/reflect-poc/client"
+	server "github.com/vedantkulkarni/reflect-poc/server"
+	transport "github.com/vedantkulkarni/reflect-poc/transport"
+)
+
+// runDiscover lists the devices that announced themselves on the broker
+func runDiscover(args []string) error {
+	fs := flag.NewFlagSet("discover", flag.ExitOnError)
+	broker := transport.BrokerConfig{ClientID: "reflect-discover-" + uuid.NewString()[:8]}
+	registerBrokerFlags(fs, &broker)
+	var logOpts logOptions
+	logOpts.register(fs, "warn")
+	wait := fs.Duration("wait", 2*time.Second, "how long to collect announcements")
+	all := fs.Bool("all", false, "include devices that are offline")
+	watch := fs.Bool("watch", false, "keep printing announcements until interrupted")
+	format := fs.String("format", "table", "output format: table or json")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s discover [flags]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return errUsage
+	}
+	if *format != "table" && *format != "json" {
+		return fmt.Errorf("invalid output format %q", *format)
+	}
+
+	logger, err := logOpts.logger()
+	if err != nil {
+		return err
+	}
+	defer logger.Sync()
+	broker.Logger = logger
+
+	mqttClient, err := transport.Connect(broker)
+	if err != nil {
+		return err
+	}
+	defer mqttClient.Disconnect(250)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	printer := newPresencePrinter(os.Stdout, *format)
+	if *watch {
+		printer.header()
+		return client.WatchPresence(ctx, mqttClient, func(presence server.Presence) {
+			if presence.Online || *all {
+				// Columns are aligned per row since rows arrive one at a time
+				printer.print(presence)
+				printer.flush()
+			}
+		})
+	}
+
+	devices, err := client.Discover(ctx, mqttClient, *wait, *all)
+	if err != nil {
+		return err
+	}
+	if len(devices) == 0 && *format == "table" {
+		fmt.Fprintln(os.Stderr, "no devices found")
+		return nil
+	}
+	printer.header()
+	for _, presence := range devices {
+		printer.print(presence)
+	}
+	printer.flush()
+	return nil
+}
+
+// presencePrinter writes presence announcements as table rows or JSON lines
+type presencePrinter struct {
+	out    io.Writer
+	table  *tabwriter.Writer
+	format string
+}
+
+func newPresencePrinter(out io.Writer, format string) *presencePrinter {
+	return &presencePrinter{out: out, table: tabwriter.NewWriter(out, 0, 0, 2, ' ', 0), format: format}
+}
+
+func (p *presencePrinter) header() {
+	if p.format == "table" {
+		fmt.Fprintln(p.table, "BRIDGE ID\tSTATUS\tVERSION\tDESCRIPTOR\tSINCE\tSERVICES")
+	}
+}
+
+func (p *presencePrinter) print(presence server.Presence) {
+	if p.format == "json" {
+		line, _ := json.Marshal(presence)
+		fmt.Fprintln(p.out, string(line))
+		return
+	}
+
+	status := "offline"
+	if presence.Online {
+		status = "online"
+	}
+	hash := presence.DescriptorHash
+	if len(hash) > 12 {
+		hash = hash[:12]
+	}
+	since := ""
+	if !presence.Timestamp.IsZero() {
+		since = presence.Timestamp.Local().Format(time.DateTime)
+	}
+	fmt.Fprintf(p.table, "%s\t%s\t%s\t%s\t%s\t%s\n", presence.BridgeID, status, orDash(presence.Version),
+		orDash(hash), orDash(since), orDash(strings.Join(presence.Services, ",")))
+}
+
+// flush writes the buffered table rows
+func (p *presencePrinter) flush() {
+	p.table.Flush()
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
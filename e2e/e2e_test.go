@@ -0,0 +1,270 @@
+package e2e_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"testing"
+	"time"
+
+	client "github.com/vedantkulkarni/reflect-poc/client"
+	service_proto "github.com/vedantkulkarni/reflect-poc/service-proto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+func TestUnary(t *testing.T) {
+	h := newHarness(t)
+	ctx := testContext(t)
+
+	for _, tc := range []struct {
+		method, body, want string
+	}{
+		{"reflect.TestService/Test", `{"message":"hi"}`, "Response from Test method"},
+		{"reflect.TestService/Run", `{"message":"go","id":7}`, "Response from Run method"},
+		{"reflect.SyncService/Sync", `{"message":"hi"}`, "Received: hi"},
+		// An empty body sends an empty request
+		{"reflect.SyncService/Sync", ``, "Received: "},
+	} {
+		messages, err := h.call(ctx, tc.method, tc.body)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.method, err)
+		}
+		if !slices.Equal(messages, []string{tc.want}) {
+			t.Errorf("%s returned %q, want %q", tc.method, messages, tc.want)
+		}
+	}
+}
+
+func TestServerStreaming(t *testing.T) {
+	h := newHarness(t)
+
+	messages, err := h.call(testContext(t), "reflect.TestService/TestServerStream", `{"message":"hi"}`)
+	if err != nil {
+		t.Fatalf("call failed: %v", err)
+	}
+	var want []string
+	for i := 0; i < 5; i++ {
+		want = append(want, fmt.Sprintf("Server streaming response %d", i))
+	}
+	if !slices.Equal(messages, want) {
+		t.Errorf("received %q, want %q", messages, want)
+	}
+}
+
+func TestClientStreaming(t *testing.T) {
+	h := newHarness(t)
+	ctx := testContext(t)
+
+	for _, method := range []string{"reflect.TestService/TestClientStream", "reflect.SyncService/SyncClientStream"} {
+		messages, err := h.call(ctx, method, `{"message":"a"} {"message":"b"} {"message":"c"}`)
+		if err != nil {
+			t.Fatalf("%s: %v", method, err)
+		}
+		if want := []string{"Received messages: a, b, c"}; !slices.Equal(messages, want) {
+			t.Errorf("%s returned %q, want %q", method, messages, want)
+		}
+	}
+}
+
+func TestBidiStreaming(t *testing.T) {
+	h := newHarness(t)
+	ctx := testContext(t)
+
+	messages, err := h.call(ctx, "reflect.SyncService/SyncBidiStream", `{"message":"a"} {"message":"b"}`)
+	if err != nil {
+		t.Fatalf("call failed: %v", err)
+	}
+	if want := []string{"Echoing: a", "Echoing: b"}; !slices.Equal(messages, want) {
+		t.Errorf("received %q, want %q", messages, want)
+	}
+
+	// Interleave sends and receives on one stream through the bridge
+	stream, err := service_proto.NewTestServiceClient(h.client.Conn()).TestBidiStream(ctx)
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+	for _, message := range []string{"one", "two", "three"} {
+		if err := stream.Send(&service_proto.TestMessageRequest{Message: message}); err != nil {
+			t.Fatalf("failed to send %q: %v", message, err)
+		}
+		response, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("failed to receive reply to %q: %v", message, err)
+		}
+		if want := "Received: " + message; response.Message != want {
+			t.Errorf("received %q, want %q", response.Message, want)
+		}
+	}
+	if err := stream.CloseSend(); err != nil {
+		t.Fatalf("failed to close stream: %v", err)
+	}
+	if _, err := stream.Recv(); !errors.Is(err, io.EOF) {
+		t.Errorf("stream ended with %v, want io.EOF", err)
+	}
+}
+
+func TestReflection(t *testing.T) {
+	h := newHarness(t)
+	ctx := testContext(t)
+
+	services, err := h.client.ListServices(ctx)
+	if err != nil {
+		t.Fatalf("failed to list services: %v", err)
+	}
+	for _, want := range []string{"reflect.TestService", "reflect.SyncService", "grpc.health.v1.Health", "grpc.reflection.v1.ServerReflection"} {
+		if !slices.Contains(services, want) {
+			t.Errorf("services %q do not include %s", services, want)
+		}
+	}
+
+	desc, err := h.client.FindSymbol(ctx, "reflect.SyncService")
+	if err != nil {
+		t.Fatalf("failed to find service: %v", err)
+	}
+	service, ok := desc.(protoreflect.ServiceDescriptor)
+	if !ok {
+		t.Fatalf("reflect.SyncService resolved to %T", desc)
+	}
+	methods := map[string][2]bool{
+		"Sync":             {false, false},
+		"SyncServerStream": {false, true},
+		"SyncClientStream": {true, false},
+		"SyncBidiStream":   {true, true},
+	}
+	if got := service.Methods().Len(); got != len(methods) {
+		t.Errorf("service has %d methods, want %d", got, len(methods))
+	}
+	for name, streaming := range methods {
+		method := service.Methods().ByName(protoreflect.Name(name))
+		if method == nil {
+			t.Errorf("method %s not found", name)
+			continue
+		}
+		if got := [2]bool{method.IsStreamingClient(), method.IsStreamingServer()}; got != streaming {
+			t.Errorf("%s streams (client, server) = %v, want %v", name, got, streaming)
+		}
+	}
+
+	desc, err = h.client.FindSymbol(ctx, "reflect.RunMessageResponse")
+	if err != nil {
+		t.Fatalf("failed to find message: %v", err)
+	}
+	message, ok := desc.(protoreflect.MessageDescriptor)
+	if !ok {
+		t.Fatalf("reflect.RunMessageResponse resolved to %T", desc)
+	}
+	if field := message.Fields().ByName("success"); field == nil || field.Kind() != protoreflect.BoolKind {
+		t.Errorf("reflect.RunMessageResponse.success is %v, want a bool field", field)
+	}
+
+	if _, err := h.client.FindSymbol(ctx, "reflect.NoSuchService"); err == nil {
+		t.Error("found a symbol the device does not define")
+	}
+}
+
+func TestPublishedSchema(t *testing.T) {
+	h := newHarness(t)
+	ctx := testContext(t)
+
+	// Calls resolve methods from the descriptors the device published instead
+	// of its reflection service
+	source, err := client.LoadSchema(ctx, h.client.MQTTClient(), h.bridgeID)
+	if err != nil {
+		t.Fatalf("failed to load schema: %v", err)
+	}
+	h.client.UseSchema(source)
+
+	messages, err := h.call(ctx, "reflect.TestService/TestServerStream", `{"message":"hi"}`)
+	if err != nil {
+		t.Fatalf("call failed: %v", err)
+	}
+	if len(messages) != 5 {
+		t.Errorf("received %q, want 5 responses", messages)
+	}
+	services, err := h.client.ListServices(ctx)
+	if err != nil || !slices.Contains(services, "reflect.SyncService") {
+		t.Errorf("schema lists %q, %v", services, err)
+	}
+}
+
+func TestCancellation(t *testing.T) {
+	h := newHarness(t)
+
+	// SyncServerStream sends one response a second; cancel after the first
+	ctx, cancel := context.WithCancel(testContext(t))
+	responses := 0
+	err := h.client.Call(ctx, "reflect.SyncService/SyncServerStream", nil, func(proto.Message) error {
+		responses++
+		cancel()
+		return nil
+	})
+	if status.Code(err) != codes.Canceled {
+		t.Errorf("cancelled call returned %v, want Canceled", err)
+	}
+	if responses != 1 {
+		t.Errorf("received %d responses, want 1", responses)
+	}
+
+	ctx, cancel = context.WithTimeout(testContext(t), 500*time.Millisecond)
+	defer cancel()
+	_, err = h.call(ctx, "reflect.SyncService/SyncServerStream", `{"message":"slow"}`)
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("call past its deadline returned %v, want DeadlineExceeded", err)
+	}
+
+	// The device keeps serving after cancelled calls
+	if _, err := h.call(testContext(t), "reflect.TestService/Test", `{}`); err != nil {
+		t.Errorf("call after cancellation failed: %v", err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	h := newHarness(t)
+	ctx := testContext(t)
+
+	t.Run("invalid request", func(t *testing.T) {
+		for _, body := range []string{
+			`{"message":`,
+			`{"unknown":1}`,
+			`{"message":1}`,
+			// Unary methods take a single request
+			`{"message":"a"} {"message":"b"}`,
+		} {
+			if _, err := h.call(ctx, "reflect.TestService/Test", body); !errors.Is(err, client.ErrInvalidRequest) {
+				t.Errorf("body %s returned %v, want ErrInvalidRequest", body, err)
+			}
+		}
+		if _, err := h.call(ctx, "reflect.TestService/TestClientStream", `{"message":"a"} {"id":1}`); !errors.Is(err, client.ErrInvalidRequest) {
+			t.Errorf("bad streamed request returned %v, want ErrInvalidRequest", err)
+		}
+	})
+
+	t.Run("unknown method", func(t *testing.T) {
+		for _, method := range []string{"reflect.TestService/NoSuchMethod", "reflect.NoSuchService/Test", "reflect.TestMessageRequest"} {
+			if _, err := h.call(ctx, method, `{}`); err == nil {
+				t.Errorf("call of %s succeeded", method)
+			}
+		}
+	})
+
+	t.Run("unimplemented", func(t *testing.T) {
+		// Bypass reflection to reach the device with a method it does not serve
+		err := h.client.Conn().Invoke(ctx, "/reflect.NoSuchService/Test", &service_proto.TestMessageRequest{}, &service_proto.TestMessageResponse{})
+		if status.Code(err) != codes.Unimplemented {
+			t.Errorf("call returned %v, want Unimplemented", err)
+		}
+	})
+
+	t.Run("status from device", func(t *testing.T) {
+		_, err := h.call(ctx, "grpc.health.v1.Health/Check", `{"service":"reflect.NoSuchService"}`)
+		if status.Code(err) != codes.NotFound {
+			t.Errorf("health check of an unknown service returned %v, want NotFound", err)
+		}
+	})
+}
@@ -0,0 +1,161 @@
+// Package e2e_test runs the services, the bridge and the reflection client
+// against an in-process broker, without any network access.
+package e2e_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	bridge "github.com/golain-io/mqtt-bridge"
+	client "github.com/vedantkulkarni/reflect-poc/client"
+	embedded "github.com/vedantkulkarni/reflect-poc/embedded"
+	server "github.com/vedantkulkarni/reflect-poc/server"
+	service_proto "github.com/vedantkulkarni/reflect-poc/service-proto"
+	transport "github.com/vedantkulkarni/reflect-poc/transport"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// testTimeout bounds every test, so a stuck bridge fails instead of hanging
+const testTimeout = 30 * time.Second
+
+// drainTimeout is how long the device waits for client sessions on shutdown
+const drainTimeout = 100 * time.Millisecond
+
+// harness is a device serving the test services over an embedded broker and
+// a reflection client connected to it
+type harness struct {
+	bridgeID string
+	broker   *embedded.Broker
+	health   *server.Health
+	client   *client.ReflectionClient
+}
+
+// newHarness starts a broker, a device named after the test and a client,
+// all stopped when the test ends
+func newHarness(t *testing.T) *harness {
+	t.Helper()
+	logger := zaptest.NewLogger(t, zaptest.Level(zap.WarnLevel))
+	h := &harness{bridgeID: "e2e-" + strings.ReplaceAll(t.Name(), "/", "-")}
+
+	b, err := embedded.Start(embedded.Config{Logger: logger})
+	if err != nil {
+		t.Fatalf("failed to start broker: %v", err)
+	}
+	t.Cleanup(func() { b.Close() })
+	h.broker = b
+
+	h.serve(t, logger)
+
+	drs, err := client.NewReflectionClient(client.Config{
+		Broker:   h.brokerConfig("e2e-client"),
+		BridgeID: h.bridgeID,
+		Logger:   logger,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	t.Cleanup(func() { drs.Close() })
+	h.client = drs
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	if err := drs.WaitForReady(ctx); err != nil {
+		t.Fatalf("device not ready: %v", err)
+	}
+	return h
+}
+
+// brokerConfig returns the configuration of an in-process broker connection
+func (h *harness) brokerConfig(clientID string) transport.BrokerConfig {
+	return transport.BrokerConfig{
+		URL:            embedded.InProcessURL,
+		ClientID:       clientID,
+		Dial:           h.broker.Dial,
+		ConnectTimeout: 5 * time.Second,
+	}
+}
+
+// serve runs the device like the serve command does, until the test ends
+func (h *harness) serve(t *testing.T, logger *zap.Logger) {
+	t.Helper()
+	grpcServer := grpc.NewServer(server.Interceptors{Logger: logger}.ServerOptions()...)
+	service_proto.RegisterTestServiceServer(grpcServer, &server.MyTestService{Logger: logger})
+	service_proto.RegisterSyncServiceServer(grpcServer, &server.MySyncService{Logger: logger})
+	reflection.RegisterV1(grpcServer)
+	health := server.NewHealth(logger)
+	health.Register(grpcServer)
+	h.health = health
+
+	presence, descriptors, err := server.NewPresence(grpcServer, h.bridgeID, "e2e")
+	if err != nil {
+		t.Fatalf("failed to describe services: %v", err)
+	}
+	cfg := h.brokerConfig("e2e-device")
+	if cfg.Will, err = server.PresenceWill(presence); err != nil {
+		t.Fatalf("failed to build will: %v", err)
+	}
+	mqttClient, err := transport.Connect(cfg)
+	if err != nil {
+		t.Fatalf("failed to connect device: %v", err)
+	}
+	netBridge := bridge.NewMQTTNetBridge(mqttClient, logger, h.bridgeID)
+
+	lifecycle := &server.Lifecycle{
+		GRPCServer: grpcServer,
+		Listener:   transport.BufferListener(mqttClient.TrackListener(netBridge)),
+		MQTTClient: mqttClient,
+		BridgeID:   h.bridgeID,
+		// The bridge does not tell the device when a client closes its
+		// session, so shutdown always waits for the drain timeout
+		DrainTimeout: drainTimeout,
+		Health:       health,
+		Presence:     presence,
+		Descriptors:  descriptors,
+		Logger:       logger,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- lifecycle.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("device stopped with error: %v", err)
+		}
+	})
+}
+
+// call invokes method with the JSON requests in body and returns the
+// "message" field of every response
+func (h *harness) call(ctx context.Context, method, body string) ([]string, error) {
+	var messages []string
+	err := h.client.Call(ctx, method, strings.NewReader(body), func(response proto.Message) error {
+		messages = append(messages, messageField(response))
+		return nil
+	})
+	return messages, err
+}
+
+// messageField returns the "message" field of a dynamic response
+func messageField(m proto.Message) string {
+	msg := m.ProtoReflect()
+	field := msg.Descriptor().Fields().ByName(protoreflect.Name("message"))
+	if field == nil {
+		return ""
+	}
+	return msg.Get(field).String()
+}
+
+func testContext(t *testing.T) context.Context {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	t.Cleanup(cancel)
+	return ctx
+}
@@ -0,0 +1,72 @@
+package e2e_test
+
+import (
+	"errors"
+	"testing"
+
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+var errStop = errors.New("stop watching")
+
+func TestHealth(t *testing.T) {
+	h := newHarness(t)
+	ctx := testContext(t)
+
+	for _, tc := range []struct {
+		service string
+		want    healthpb.HealthCheckResponse_ServingStatus
+	}{
+		{"", healthpb.HealthCheckResponse_SERVING},
+		{"reflect.TestService", healthpb.HealthCheckResponse_SERVING},
+		{"reflect.NoSuchService", healthpb.HealthCheckResponse_SERVICE_UNKNOWN},
+	} {
+		var got healthpb.HealthCheckResponse_ServingStatus
+		err := h.client.WatchHealth(ctx, tc.service, func(status healthpb.HealthCheckResponse_ServingStatus) error {
+			got = status
+			return errStop
+		})
+		if !errors.Is(err, errStop) {
+			t.Fatalf("WatchHealth(%q) returned %v, want the callback error", tc.service, err)
+		}
+		if got != tc.want {
+			t.Errorf("WatchHealth(%q) reported %s, want %s", tc.service, got, tc.want)
+		}
+	}
+}
+
+func TestHealthWatchChanges(t *testing.T) {
+	h := newHarness(t)
+	ctx := testContext(t)
+
+	var statuses []healthpb.HealthCheckResponse_ServingStatus
+	err := h.client.WatchHealth(ctx, "reflect.SyncService", func(status healthpb.HealthCheckResponse_ServingStatus) error {
+		statuses = append(statuses, status)
+		switch len(statuses) {
+		case 1:
+			h.health.SetServingStatus("reflect.SyncService", healthpb.HealthCheckResponse_NOT_SERVING)
+		case 2:
+			h.health.SetServingStatus("reflect.SyncService", healthpb.HealthCheckResponse_SERVING)
+		default:
+			return errStop
+		}
+		return nil
+	})
+	if !errors.Is(err, errStop) {
+		t.Fatalf("WatchHealth returned %v, want the callback error", err)
+	}
+	want := []healthpb.HealthCheckResponse_ServingStatus{
+		healthpb.HealthCheckResponse_SERVING,
+		healthpb.HealthCheckResponse_NOT_SERVING,
+		healthpb.HealthCheckResponse_SERVING,
+	}
+	if len(statuses) != len(want) {
+		t.Fatalf("WatchHealth reported %v, want %v", statuses, want)
+	}
+	for i := range want {
+		if statuses[i] != want[i] {
+			t.Errorf("WatchHealth reported %v, want %v", statuses, want)
+			break
+		}
+	}
+}
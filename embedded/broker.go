@@ -0,0 +1,142 @@
+// Package embedded runs an MQTT broker inside the process, so that devices,
+// clients and tests work on one machine without an external broker.
+package embedded
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"go.uber.org/zap"
+)
+
+// InProcessURL is the broker URL of clients that connect through Broker.Dial;
+// it names the broker in logs and is never dialed
+const InProcessURL = "tcp://embedded:1883"
+
+// Config describes the embedded broker
+type Config struct {
+	// Addr, if set, is the TCP address on which the broker also accepts
+	// clients of other processes, e.g. 127.0.0.1:1883
+	Addr string
+	// Logger records broker events; nil disables logging
+	Logger *zap.Logger
+}
+
+// Broker is an MQTT broker accepting every client, with retained messages and
+// Last Wills held in memory
+type Broker struct {
+	server   *mqtt.Server
+	inProc   *pipeListener
+	tcpAddr  net.Addr
+	closeErr error
+	once     sync.Once
+}
+
+// Start starts a broker that accepts in-process connections through Dial and,
+// if cfg.Addr is set, TCP connections
+func Start(cfg Config) (*Broker, error) {
+	logger := cfg.Logger
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	server := mqtt.New(&mqtt.Options{Logger: newSlogLogger(logger.Named("broker"))})
+	if err := server.AddHook(new(auth.AllowHook), nil); err != nil {
+		return nil, fmt.Errorf("failed to configure broker: %w", err)
+	}
+
+	b := &Broker{server: server, inProc: newPipeListener()}
+	if err := server.AddListener(listeners.NewNet("in-process", b.inProc)); err != nil {
+		return nil, fmt.Errorf("failed to add in-process listener: %w", err)
+	}
+	if cfg.Addr != "" {
+		tcp, err := net.Listen("tcp", cfg.Addr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to listen on %s: %w", cfg.Addr, err)
+		}
+		if err := server.AddListener(listeners.NewNet("tcp", tcp)); err != nil {
+			tcp.Close()
+			return nil, fmt.Errorf("failed to add TCP listener: %w", err)
+		}
+		b.tcpAddr = tcp.Addr()
+	}
+
+	if err := server.Serve(); err != nil {
+		server.Close()
+		return nil, fmt.Errorf("failed to start broker: %w", err)
+	}
+	return b, nil
+}
+
+// URL returns the URL other processes connect to, or InProcessURL when the
+// broker only accepts in-process connections
+func (b *Broker) URL() string {
+	if b.tcpAddr == nil {
+		return InProcessURL
+	}
+	return "tcp://" + b.tcpAddr.String()
+}
+
+// Dial opens an in-process connection to the broker, for use as
+// transport.BrokerConfig.Dial
+func (b *Broker) Dial() (net.Conn, error) {
+	return b.inProc.dial()
+}
+
+// Close disconnects every client and stops the broker
+func (b *Broker) Close() error {
+	b.once.Do(func() {
+		b.closeErr = b.server.Close()
+	})
+	return b.closeErr
+}
+
+var errBrokerClosed = errors.New("embedded broker closed")
+
+// pipeListener accepts the broker ends of in-memory connections
+type pipeListener struct {
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+func newPipeListener() *pipeListener {
+	return &pipeListener{conns: make(chan net.Conn), done: make(chan struct{})}
+}
+
+func (l *pipeListener) dial() (net.Conn, error) {
+	client, server := net.Pipe()
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.done:
+		client.Close()
+		server.Close()
+		return nil, errBrokerClosed
+	}
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, errBrokerClosed
+	}
+}
+
+func (l *pipeListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr { return pipeAddr{} }
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "in-process" }
@@ -0,0 +1,178 @@
+package embedded
+
+import (
+	"errors"
+	"net"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	transport "github.com/vedantkulkarni/reflect-poc/transport"
+)
+
+func startBroker(t *testing.T, cfg Config) *Broker {
+	t.Helper()
+	b, err := Start(cfg)
+	if err != nil {
+		t.Fatalf("failed to start broker: %v", err)
+	}
+	t.Cleanup(func() { b.Close() })
+	return b
+}
+
+// connect opens an in-process client of b
+func connect(t *testing.T, b *Broker, cfg transport.BrokerConfig) *transport.Client {
+	t.Helper()
+	cfg.URL = InProcessURL
+	if cfg.Dial == nil {
+		cfg.Dial = b.Dial
+	}
+	client, err := transport.Connect(cfg)
+	if err != nil {
+		t.Fatalf("failed to connect %s: %v", cfg.ClientID, err)
+	}
+	t.Cleanup(func() { client.Disconnect(0) })
+	return client
+}
+
+// subscribe returns the messages published on topic
+func subscribe(t *testing.T, client *transport.Client, topic string) <-chan mqtt.Message {
+	t.Helper()
+	messages := make(chan mqtt.Message, 10)
+	token := client.Subscribe(topic, 1, func(_ mqtt.Client, msg mqtt.Message) {
+		messages <- msg
+	})
+	if token.Wait() && token.Error() != nil {
+		t.Fatalf("failed to subscribe to %s: %v", topic, token.Error())
+	}
+	return messages
+}
+
+func receive(t *testing.T, messages <-chan mqtt.Message) mqtt.Message {
+	t.Helper()
+	select {
+	case msg := <-messages:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message delivered")
+		return nil
+	}
+}
+
+// TestPipeListenerClosed checks that Close releases blocked dials and
+// accepts, and that both fail afterwards
+func TestPipeListenerClosed(t *testing.T) {
+	goroutines := runtime.NumGoroutine()
+
+	// Nothing accepts on one listener and nothing dials the other
+	dialed, accepted := newPipeListener(), newPipeListener()
+	errs := make(chan error, 2)
+	go func() {
+		_, err := dialed.dial()
+		errs <- err
+	}()
+	go func() {
+		_, err := accepted.Accept()
+		errs <- err
+	}()
+	dialed.Close()
+	accepted.Close()
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errs:
+			if !errors.Is(err, errBrokerClosed) {
+				t.Errorf("blocked call returned %v, want %v", err, errBrokerClosed)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Close did not release a blocked call")
+		}
+	}
+
+	if _, err := dialed.dial(); !errors.Is(err, errBrokerClosed) {
+		t.Errorf("dial after Close returned %v, want %v", err, errBrokerClosed)
+	}
+	if _, err := accepted.Accept(); !errors.Is(err, errBrokerClosed) {
+		t.Errorf("Accept after Close returned %v, want %v", err, errBrokerClosed)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > goroutines {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines left running, had %d", runtime.NumGoroutine(), goroutines)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDialAfterClose(t *testing.T) {
+	b := startBroker(t, Config{})
+	b.Close()
+	if conn, err := b.Dial(); !errors.Is(err, errBrokerClosed) {
+		if conn != nil {
+			conn.Close()
+		}
+		t.Errorf("Dial after Close returned %v, want %v", err, errBrokerClosed)
+	}
+}
+
+func TestURL(t *testing.T) {
+	if got := startBroker(t, Config{}).URL(); got != InProcessURL {
+		t.Errorf("URL of an in-process broker = %q, want %q", got, InProcessURL)
+	}
+
+	b := startBroker(t, Config{Addr: "127.0.0.1:0"})
+	host, port, err := net.SplitHostPort(strings.TrimPrefix(b.URL(), "tcp://"))
+	if !strings.HasPrefix(b.URL(), "tcp://") || err != nil || host != "127.0.0.1" || port == "0" {
+		t.Fatalf("URL = %q, want tcp:// and the bound address", b.URL())
+	}
+	// Other processes reach the broker at its URL
+	client, err := transport.Connect(transport.BrokerConfig{URL: b.URL(), ClientID: "tcp-client"})
+	if err != nil {
+		t.Fatalf("failed to connect to %s: %v", b.URL(), err)
+	}
+	client.Disconnect(0)
+}
+
+func TestRetainedMessage(t *testing.T) {
+	b := startBroker(t, Config{})
+	publisher := connect(t, b, transport.BrokerConfig{ClientID: "publisher"})
+	token := publisher.Publish("/test/retained", 1, true, "on")
+	if token.Wait() && token.Error() != nil {
+		t.Fatalf("failed to publish: %v", token.Error())
+	}
+
+	// A client subscribing later still gets the message
+	subscriber := connect(t, b, transport.BrokerConfig{ClientID: "subscriber"})
+	msg := receive(t, subscribe(t, subscriber, "/test/retained"))
+	if string(msg.Payload()) != "on" || !msg.Retained() {
+		t.Errorf("got %q (retained %v), want the retained %q", msg.Payload(), msg.Retained(), "on")
+	}
+}
+
+func TestWillDelivered(t *testing.T) {
+	b := startBroker(t, Config{})
+	watcher := connect(t, b, transport.BrokerConfig{ClientID: "watcher"})
+	wills := subscribe(t, watcher, "/test/will")
+
+	conns := make(chan net.Conn, 1)
+	connect(t, b, transport.BrokerConfig{
+		ClientID:  "device",
+		Reconnect: transport.ReconnectConfig{Disabled: true},
+		Will:      &transport.Will{Topic: "/test/will", Payload: []byte("offline"), QoS: 1},
+		Dial: func() (net.Conn, error) {
+			conn, err := b.Dial()
+			if err == nil {
+				conns <- conn
+			}
+			return conn, err
+		},
+	})
+
+	// Dropping the connection without a disconnect publishes the will
+	(<-conns).Close()
+	if msg := receive(t, wills); string(msg.Payload()) != "offline" {
+		t.Errorf("got will %q, want %q", msg.Payload(), "offline")
+	}
+}
@@ -0,0 +1,77 @@
+package embedded
+
+import (
+	"context"
+	"log/slog"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// newSlogLogger returns a slog.Logger, as used by the broker, that writes to logger
+func newSlogLogger(logger *zap.Logger) *slog.Logger {
+	return slog.New(&zapHandler{logger: logger.WithOptions(zap.WithCaller(false))})
+}
+
+// zapHandler is a minimal slog.Handler over a zap.Logger
+type zapHandler struct {
+	logger *zap.Logger
+	group  string
+}
+
+func (h *zapHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return h.logger.Core().Enabled(zapLevel(level))
+}
+
+func (h *zapHandler) Handle(_ context.Context, record slog.Record) error {
+	fields := make([]zap.Field, 0, record.NumAttrs())
+	record.Attrs(func(attr slog.Attr) bool {
+		fields = append(fields, h.field(attr))
+		return true
+	})
+	level := zapLevel(record.Level)
+	if record.Message == "" && level == zapcore.WarnLevel {
+		// The broker reports clients dropping their connection as
+		// anonymous warnings, which are routine for the bridge
+		level = zapcore.DebugLevel
+	}
+	if entry := h.logger.Check(level, record.Message); entry != nil {
+		entry.Write(fields...)
+	}
+	return nil
+}
+
+func (h *zapHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	fields := make([]zap.Field, 0, len(attrs))
+	for _, attr := range attrs {
+		fields = append(fields, h.field(attr))
+	}
+	return &zapHandler{logger: h.logger.With(fields...), group: h.group}
+}
+
+func (h *zapHandler) WithGroup(name string) slog.Handler {
+	if h.group != "" {
+		name = h.group + "." + name
+	}
+	return &zapHandler{logger: h.logger, group: name}
+}
+
+func (h *zapHandler) field(attr slog.Attr) zap.Field {
+	key := attr.Key
+	if h.group != "" {
+		key = h.group + "." + key
+	}
+	return zap.Any(key, attr.Value.Resolve().Any())
+}
+
+// zapLevel maps broker levels to zap levels; the broker's informational
+// messages are internal details and are logged at debug level
+func zapLevel(level slog.Level) zapcore.Level {
+	switch {
+	case level >= slog.LevelError:
+		return zapcore.ErrorLevel
+	case level >= slog.LevelWarn:
+		return zapcore.WarnLevel
+	}
+	return zapcore.DebugLevel
+}
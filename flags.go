@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	auth "github.com/vedantkulkarni/reflect-poc/auth"
+	client "github.com/vedantkulkarni/reflect-poc/client"
+	metrics "github.com/vedantkulkarni/reflect-poc/metrics"
+	reflection "github.com/vedantkulkarni/reflect-poc/reflection"
+	server "github.com/vedantkulkarni/reflect-poc/server"
+	transport "github.com/vedantkulkarni/reflect-poc/transport"
+	"google.golang.org/grpc/metadata"
+)
+
+// defaultBridgeID is the bridge the demo server listens on
+const defaultBridgeID = "echo-service1"
+
+// registerBrokerFlags adds the flags that describe the MQTT broker connection
+func registerBrokerFlags(fs *flag.FlagSet, broker *transport.BrokerConfig) {
+	fs.StringVar(&broker.URL, "broker", transport.DefaultBrokerURL, "MQTT broker URL (tcp://, ssl://, ws://, wss://)")
+	fs.StringVar(&broker.ClientID, "client-id", broker.ClientID, "MQTT client ID")
+	fs.StringVar(&broker.Username, "username", "", "MQTT username")
+	fs.StringVar(&broker.Password, "password", "", "MQTT password (or $MQTT_PASSWORD)")
+	fs.StringVar(&broker.TLS.CAFile, "ca-file", "", "CA bundle used to verify the broker certificate")
+	fs.StringVar(&broker.TLS.CertFile, "cert-file", "", "client certificate presented to the broker")
+	fs.StringVar(&broker.TLS.KeyFile, "key-file", "", "private key for -cert-file")
+	fs.StringVar(&broker.TLS.ServerName, "server-name", "", "override the server name checked against the broker certificate")
+	fs.BoolVar(&broker.TLS.InsecureSkipVerify, "insecure-skip-verify", false, "do not verify the broker certificate")
+	fs.DurationVar(&broker.Reconnect.MaxInterval, "max-reconnect-interval", 30*time.Second, "upper bound of the backoff between broker reconnection attempts")
+	fs.BoolVar(&broker.Reconnect.RetryInitialConnect, "connect-retry", false, "keep retrying until the broker is reachable instead of exiting")
+}
+
+// headerFlags collects repeated -H "name: value" flags. Headers of the
+// profile come first, and -H headers replace those with the same name.
+type headerFlags struct {
+	profile []string
+	flags   []string
+}
+
+func (h *headerFlags) String() string {
+	return strings.Join(h.headers(), ", ")
+}
+
+func (h *headerFlags) Set(value string) error {
+	if err := checkHeader(value); err != nil {
+		return err
+	}
+	h.flags = append(h.flags, value)
+	return nil
+}
+
+// setProfile adds a header of the profile
+func (h *headerFlags) setProfile(value string) error {
+	if err := checkHeader(value); err != nil {
+		return err
+	}
+	h.profile = append(h.profile, value)
+	return nil
+}
+
+func checkHeader(value string) error {
+	name, _, ok := strings.Cut(value, ":")
+	if !ok || strings.TrimSpace(name) == "" {
+		return fmt.Errorf("header %q is not in the form 'name: value'", value)
+	}
+	return nil
+}
+
+// headers returns the profile headers not replaced by -H, then the -H headers
+func (h *headerFlags) headers() []string {
+	replaced := make(map[string]bool, len(h.flags))
+	for _, header := range h.flags {
+		replaced[headerName(header)] = true
+	}
+	headers := make([]string, 0, len(h.profile)+len(h.flags))
+	for _, header := range h.profile {
+		if !replaced[headerName(header)] {
+			headers = append(headers, header)
+		}
+	}
+	return append(headers, h.flags...)
+}
+
+// headerName returns the metadata key of a "name: value" header
+func headerName(header string) string {
+	name, _, _ := strings.Cut(header, ":")
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// metadata returns the headers as gRPC metadata key/value pairs
+func (h *headerFlags) metadata() []string {
+	headers := h.headers()
+	pairs := make([]string, 0, 2*len(headers))
+	for _, header := range headers {
+		_, value, _ := strings.Cut(header, ":")
+		pairs = append(pairs, headerName(header), strings.TrimSpace(value))
+	}
+	return pairs
+}
+
+// clientOptions are the flags shared by the commands that call a device
+type clientOptions struct {
+	config         client.Config
+	bridgeID       string
+	roles          string
+	headers        headerFlags
+	timeout        time.Duration
+	connectTimeout time.Duration
+	log            logOptions
+	metricsAddr    string
+	metrics        *metrics.Server
+	trace          traceOptions
+	flushTraces    func()
+	offline        bool
+}
+
+func (o *clientOptions) register(fs *flag.FlagSet) {
+	o.config.Broker.ClientID = "reflect-client-" + uuid.NewString()[:8]
+	registerBrokerFlags(fs, &o.config.Broker)
+	fs.StringVar(&o.bridgeID, "bridge-id", defaultBridgeID, "bridge ID of the device")
+	fs.Var(&o.headers, "H", "header sent with every call, as 'name: value' (repeatable)")
+	fs.DurationVar(&o.timeout, "timeout", 30*time.Second, "overall timeout of the command")
+	fs.DurationVar(&o.connectTimeout, "connect-timeout", 10*time.Second, "how long to wait for the device to accept the connection")
+	o.log.register(fs, "warn")
+	fs.StringVar(&o.metricsAddr, "metrics-addr", "", "serve Prometheus metrics on this address, e.g. 127.0.0.1:9090")
+	o.trace.register(fs)
+
+	// End-to-end TLS with the device, inside the MQTT session
+	fs.StringVar(&o.config.TLS.CAFile, "grpc-tls-ca", "", "CA bundle used to verify the device certificate")
+	fs.StringVar(&o.config.TLS.CertFile, "grpc-tls-cert", "", "client certificate presented to the device")
+	fs.StringVar(&o.config.TLS.KeyFile, "grpc-tls-key", "", "private key for -grpc-tls-cert")
+	fs.StringVar(&o.config.TLS.ServerName, "grpc-tls-server-name", "", "name expected in the device certificate instead of the bridge ID")
+
+	// Per-RPC credentials
+	fs.StringVar(&o.config.Auth.Token, "token", "", "bearer token sent with every call (or $AUTH_TOKEN)")
+	fs.StringVar(&o.config.Auth.JWTSecret, "jwt-secret", "", "sign a JWT for every call with this HS256 secret")
+	fs.StringVar(&o.config.Auth.Subject, "jwt-subject", "", "subject of the signed JWT")
+	fs.StringVar(&o.roles, "jwt-roles", "", "comma separated roles of the signed JWT")
+	fs.StringVar(&o.config.Auth.HMACKeyID, "hmac-key-id", "", "key ID used to sign every call")
+	fs.StringVar(&o.config.Auth.HMACSecret, "hmac-secret", "", "secret used to sign every call (or $AUTH_HMAC_SECRET)")
+	fs.BoolVar(&o.config.Auth.AllowInsecure, "allow-insecure-credentials", false, "send tokens without end-to-end TLS")
+}
+
+// prepare completes the client configuration from the flags and starts the
+// metrics listener and trace exporter; close releases them
+func (o *clientOptions) prepare(serviceName string) error {
+	if o.roles != "" {
+		o.config.Auth.Roles = strings.Split(o.roles, ",")
+	}
+	logger, err := o.log.logger()
+	if err != nil {
+		return err
+	}
+	o.config.Logger = logger
+	o.config.Tracing, o.flushTraces, err = o.trace.start(serviceName, logger)
+	if err != nil {
+		return err
+	}
+	if o.metricsAddr != "" {
+		o.config.Metrics = metrics.New()
+		o.metrics, err = o.config.Metrics.Serve(o.metricsAddr, logger)
+		if err != nil {
+			o.close()
+			return err
+		}
+	}
+	return nil
+}
+
+// dial opens a reflection client to bridgeID and waits until the device
+// accepts the connection
+func (o *clientOptions) dial(bridgeID string) (*client.ReflectionClient, error) {
+	o.config.BridgeID = bridgeID
+	if err := o.prepare("reflect-client"); err != nil {
+		return nil, err
+	}
+
+	reflectionClient, err := client.NewReflectionClient(o.config)
+	if err != nil {
+		o.close()
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), o.connectTimeout)
+	defer cancel()
+	if err := reflectionClient.WaitForReady(ctx); err != nil {
+		reflectionClient.Close()
+		o.close()
+		return nil, fmt.Errorf("%s: %w", bridgeID, err)
+	}
+	return reflectionClient, nil
+}
+
+// close stops the -metrics-addr listener, if any, and flushes traces
+func (o *clientOptions) close() {
+	if o.metrics != nil {
+		o.metrics.Close()
+		o.metrics = nil
+	}
+	if o.flushTraces != nil {
+		o.flushTraces()
+		o.flushTraces = nil
+	}
+}
+
+// withHeaders attaches the -H headers to ctx
+func (o *clientOptions) withHeaders(ctx context.Context) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, o.headers.metadata()...)
+}
+
+// connect dials bridgeID and returns a context bounded by -timeout that
+// carries the -H headers. With -offline, methods and types are resolved from
+// the schema the device published on the broker.
+func (o *clientOptions) connect(bridgeID string) (*client.ReflectionClient, context.Context, context.CancelFunc, error) {
+	reflectionClient, err := o.dial(bridgeID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if o.offline {
+		loadCtx, cancelLoad := context.WithTimeout(context.Background(), o.connectTimeout)
+		source, err := client.LoadSchema(loadCtx, reflectionClient.MQTTClient(), bridgeID)
+		cancelLoad()
+		if err != nil {
+			reflectionClient.Close()
+			o.close()
+			return nil, nil, nil, err
+		}
+		reflectionClient.UseSchema(source)
+	}
+
+	ctx, cancel := context.WithTimeout(o.withHeaders(context.Background()), o.timeout)
+	return reflectionClient, ctx, func() {
+		cancel()
+		reflectionClient.Close()
+		o.close()
+	}, nil
+}
+
+// registerOfflineFlag adds -offline to the commands that resolve methods and types
+func (o *clientOptions) registerOfflineFlag(fs *flag.FlagSet) {
+	fs.BoolVar(&o.offline, "offline", false, "read the schema the device published on the broker instead of calling its reflection service")
+}
+
+// schema returns the descriptors of bridgeID, from its reflection service or,
+// with -offline, from the broker, together with a context bounded by -timeout
+func (o *clientOptions) schema(bridgeID string) (reflection.DescriptorSource, context.Context, context.CancelFunc, error) {
+	if !o.offline {
+		return o.connect(bridgeID)
+	}
+
+	logger, err := o.log.logger()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	broker := o.config.Broker
+	broker.Logger = logger
+	mqttClient, err := transport.Connect(broker)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), o.timeout)
+	closeAll := func() {
+		cancel()
+		mqttClient.Disconnect(250)
+	}
+
+	loadCtx, cancelLoad := context.WithTimeout(ctx, o.connectTimeout)
+	defer cancelLoad()
+	source, err := client.LoadSchema(loadCtx, mqttClient, bridgeID)
+	if err != nil {
+		closeAll()
+		return nil, nil, nil, err
+	}
+	return source, ctx, closeAll, nil
+}
+
+// registerServerAuthFlags registers the flags that select the credentials a device accepts
+func registerServerAuthFlags(fs *flag.FlagSet, cfg *auth.ServerConfig, policyFile *string) {
+	fs.StringVar(&cfg.TokensFile, "auth-tokens", "", "file of accepted bearer tokens: <subject> <token> [roles]")
+	fs.StringVar(&cfg.JWTSecret, "auth-jwt-secret", "", "secret used to verify HS256 JWTs (or $AUTH_JWT_SECRET)")
+	fs.StringVar(&cfg.JWTPublicKeyFile, "auth-jwt-public-key", "", "PEM public key used to verify RS256/ES256 JWTs")
+	fs.StringVar(&cfg.HMACKeysFile, "auth-hmac-keys", "", "file of accepted HMAC keys: <key-id> <secret> [roles]")
+	fs.BoolVar(&cfg.PeerCertificates, "auth-mtls", false, "accept callers identified by their end-to-end TLS client certificate")
+	fs.StringVar(policyFile, "auth-policy", "", "JSON policy mapping subjects and roles to allowed methods")
+}
+
+// installServerAuth appends the authenticators and policy selected by the
+// flags of registerServerAuthFlags to interceptors, and reports whether
+// callers are authenticated. Authentication and authorization run inside the
+// built-in chain, so rejected calls are logged like any other.
+func installServerAuth(cfg auth.ServerConfig, policyFile string, interceptors *server.Interceptors) (bool, error) {
+	authenticators, err := cfg.Authenticators()
+	if err != nil {
+		return false, fmt.Errorf("failed to load authenticators: %w", err)
+	}
+	if len(authenticators) > 0 {
+		interceptors.Unary = append(interceptors.Unary, auth.UnaryServerInterceptor(authenticators...))
+		interceptors.Stream = append(interceptors.Stream, auth.StreamServerInterceptor(authenticators...))
+	}
+	if policyFile != "" {
+		policy, err := auth.LoadPolicy(policyFile)
+		if err != nil {
+			return false, fmt.Errorf("failed to load policy: %w", err)
+		}
+		interceptors.Unary = append(interceptors.Unary, policy.UnaryServerInterceptor())
+		interceptors.Stream = append(interceptors.Stream, policy.StreamServerInterceptor())
+	}
+	return len(authenticators) > 0, nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	transport "github.com/vedantkulkarni/reflect-poc/transport"
+)
+
+// clearEnv keeps the user's config file and environment out of a test
+func clearEnv(t *testing.T) {
+	t.Helper()
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	for _, name := range []string{
+		"REFLECT_CONFIG", "REFLECT_PROFILE", "REFLECT_BROKER", "REFLECT_BRIDGE_ID",
+		"REFLECT_TIMEOUT", "REFLECT_CONNECT_TIMEOUT", "REFLECT_PASSWORD", "REFLECT_TOKEN",
+		"MQTT_PASSWORD", "AUTH_TOKEN", "AUTH_HMAC_SECRET",
+	} {
+		t.Setenv(name, "")
+	}
+}
+
+// parseClientOptions registers and parses the flags of a client command
+func parseClientOptions(t *testing.T, args []string) *clientOptions {
+	t.Helper()
+	fs := flag.NewFlagSet("call", flag.ContinueOnError)
+	var opts clientOptions
+	opts.register(fs)
+	if err := parseFlags(fs, args); err != nil {
+		t.Fatalf("failed to parse %q: %v", args, err)
+	}
+	return &opts
+}
+
+func TestClientOptionsDefaults(t *testing.T) {
+	clearEnv(t)
+	opts := parseClientOptions(t, nil)
+	if opts.config.Broker.URL != transport.DefaultBrokerURL {
+		t.Errorf("broker = %q, want %q", opts.config.Broker.URL, transport.DefaultBrokerURL)
+	}
+	if !strings.HasPrefix(opts.config.Broker.ClientID, "reflect-client-") {
+		t.Errorf("client ID = %q, want a reflect-client- prefix", opts.config.Broker.ClientID)
+	}
+	if opts.bridgeID != defaultBridgeID {
+		t.Errorf("bridge ID = %q, want %q", opts.bridgeID, defaultBridgeID)
+	}
+	if opts.timeout != 30*time.Second || opts.connectTimeout != 10*time.Second {
+		t.Errorf("timeouts = %v and %v, want 30s and 10s", opts.timeout, opts.connectTimeout)
+	}
+	if opts.log.level != "warn" || opts.log.format != "json" {
+		t.Errorf("logs = %s %s, want warn json", opts.log.level, opts.log.format)
+	}
+	if len(opts.headers.headers()) != 0 || opts.config.Auth.Enabled() || opts.config.TLS.Enabled() {
+		t.Errorf("headers %q, auth %+v and TLS %+v set by default", opts.headers.headers(), opts.config.Auth, opts.config.TLS)
+	}
+}
+
+func TestClientOptionsPrecedence(t *testing.T) {
+	config := writeConfig(t)
+	for _, tc := range []struct {
+		name     string
+		env      map[string]string
+		args     []string
+		broker   string
+		bridgeID string
+		timeout  time.Duration
+		password string
+	}{
+		{
+			name:   "defaults",
+			broker: transport.DefaultBrokerURL, bridgeID: defaultBridgeID, timeout: 30 * time.Second,
+		},
+		{
+			name:   "profile",
+			args:   []string{"-config", config},
+			broker: "tcp://profile:1883", bridgeID: "profile-bridge", timeout: 3 * time.Second, password: "profile-password",
+		},
+		{
+			name:   "environment over profile",
+			env:    map[string]string{"REFLECT_BRIDGE_ID": "env-bridge", "REFLECT_TIMEOUT": "4s", "MQTT_PASSWORD": "env-password"},
+			args:   []string{"-config", config},
+			broker: "tcp://profile:1883", bridgeID: "env-bridge", timeout: 4 * time.Second, password: "env-password",
+		},
+		{
+			name:   "flags over environment and profile",
+			env:    map[string]string{"REFLECT_BRIDGE_ID": "env-bridge", "REFLECT_TIMEOUT": "4s"},
+			args:   []string{"-config", config, "-bridge-id", "flag-bridge", "-timeout", "5s", "-password", "flag-password"},
+			broker: "tcp://profile:1883", bridgeID: "flag-bridge", timeout: 5 * time.Second, password: "flag-password",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			clearEnv(t)
+			for name, value := range tc.env {
+				t.Setenv(name, value)
+			}
+			opts := parseClientOptions(t, tc.args)
+			if got := opts.config.Broker.URL; got != tc.broker {
+				t.Errorf("broker = %q, want %q", got, tc.broker)
+			}
+			if opts.bridgeID != tc.bridgeID {
+				t.Errorf("bridge ID = %q, want %q", opts.bridgeID, tc.bridgeID)
+			}
+			if opts.timeout != tc.timeout {
+				t.Errorf("timeout = %v, want %v", opts.timeout, tc.timeout)
+			}
+			if got := opts.config.Broker.Password; got != tc.password {
+				t.Errorf("password = %q, want %q", got, tc.password)
+			}
+		})
+	}
+}
+
+// positionalFlags returns a flag set holding the positional args
+func positionalFlags(t *testing.T, args []string) *flag.FlagSet {
+	t.Helper()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	if err := fs.Parse(args); err != nil {
+		t.Fatalf("failed to parse %q: %v", args, err)
+	}
+	return fs
+}
+
+func TestMethodArgs(t *testing.T) {
+	for _, tc := range []struct {
+		args     []string
+		bridgeID string
+		method   string
+		usage    bool
+	}{
+		{args: []string{"reflect.TestService/Test"}, bridgeID: "flag-bridge", method: "reflect.TestService/Test"},
+		{args: []string{"pump-7", "reflect.TestService/Test"}, bridgeID: "pump-7", method: "reflect.TestService/Test"},
+		{args: []string{"plant.pump:7", "reflect.TestMessageRequest"}, bridgeID: "plant.pump:7", method: "reflect.TestMessageRequest"},
+		{args: nil, usage: true},
+		{args: []string{"pump-7", "reflect.TestService/Test", "extra"}, usage: true},
+	} {
+		bridgeID, method, err := methodArgs(positionalFlags(t, tc.args), "flag-bridge")
+		if tc.usage {
+			if err != errUsage {
+				t.Errorf("methodArgs(%q) returned %v, want a usage error", tc.args, err)
+			}
+			continue
+		}
+		if err != nil || bridgeID != tc.bridgeID || method != tc.method {
+			t.Errorf("methodArgs(%q) = %q, %q, %v, want %q, %q", tc.args, bridgeID, method, err, tc.bridgeID, tc.method)
+		}
+	}
+}
+
+func TestListArgs(t *testing.T) {
+	for _, tc := range []struct {
+		args     []string
+		bridgeID string
+		service  string
+		usage    bool
+	}{
+		{args: nil, bridgeID: "flag-bridge"},
+		{args: []string{"pump-7"}, bridgeID: "pump-7"},
+		{args: []string{"reflect.TestService"}, bridgeID: "flag-bridge", service: "reflect.TestService"},
+		{args: []string{"plant.pump:7", "reflect.TestService"}, bridgeID: "plant.pump:7", service: "reflect.TestService"},
+		{args: []string{"pump-7", "reflect.TestService", "extra"}, usage: true},
+	} {
+		bridgeID, service, err := listArgs(positionalFlags(t, tc.args), "flag-bridge")
+		if tc.usage {
+			if err != errUsage {
+				t.Errorf("listArgs(%q) returned %v, want a usage error", tc.args, err)
+			}
+			continue
+		}
+		if err != nil || bridgeID != tc.bridgeID || service != tc.service {
+			t.Errorf("listArgs(%q) = %q, %q, %v, want %q, %q", tc.args, bridgeID, service, err, tc.bridgeID, tc.service)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	client "github.com/vedantkulkarni/reflect-poc/client"
+	gateway "github.com/vedantkulkarni/reflect-poc/gateway"
+	"go.uber.org/zap"
+)
+
+// runGateway serves HTTP/JSON calls to devices until SIGINT or SIGTERM
+func runGateway(args []string) error {
+	fs := flag.NewFlagSet("gateway", flag.ExitOnError)
+	var opts clientOptions
+	opts.register(fs)
+	listen := fs.String("listen", "127.0.0.1:8080", "address the HTTP gateway listens on")
+	maxBody := fs.Int64("max-body-bytes", gateway.DefaultMaxBodyBytes, "largest request body or WebSocket frame accepted")
+	allowedOrigins := fs.String("allowed-origins", "", "comma separated browser origins allowed to open WebSockets, or *")
+	maxClients := fs.Int("max-clients", client.DefaultMaxPoolClients, "most device clients kept open; the least recently used is closed to make room")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if err := opts.prepare("reflect-gateway"); err != nil {
+		return err
+	}
+	defer opts.close()
+	logger := opts.config.Logger
+
+	pool, err := client.NewPoolWithLimit(opts.config, *maxClients)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+
+	return serveHTTP(*listen, (&gateway.Gateway{
+		Pool:           pool,
+		Timeout:        opts.timeout,
+		MaxBodyBytes:   *maxBody,
+		AllowedOrigins: splitList(*allowedOrigins),
+		Logger:         logger,
+	}).Handler(), "HTTP gateway", logger)
+}
+
+// runGRPCWeb serves gRPC-Web calls to devices until SIGINT or SIGTERM
+func runGRPCWeb(args []string) error {
+	fs := flag.NewFlagSet("grpc-web", flag.ExitOnError)
+	var opts clientOptions
+	opts.register(fs)
+	listen := fs.String("listen", "127.0.0.1:8081", "address the gRPC-Web proxy listens on")
+	maxBody := fs.Int64("max-body-bytes", gateway.DefaultMaxBodyBytes, "largest request body accepted")
+	allowedOrigins := fs.String("allowed-origins", "", "comma separated browser origins allowed to make cross-origin calls, or *")
+	maxClients := fs.Int("max-clients", client.DefaultMaxPoolClients, "most device clients kept open; the least recently used is closed to make room")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if err := opts.prepare("reflect-grpc-web"); err != nil {
+		return err
+	}
+	defer opts.close()
+	logger := opts.config.Logger
+
+	pool, err := client.NewPoolWithLimit(opts.config, *maxClients)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+
+	return serveHTTP(*listen, (&gateway.GRPCWebProxy{
+		Pool:           pool,
+		BridgeID:       opts.bridgeID,
+		MaxBodyBytes:   *maxBody,
+		AllowedOrigins: splitList(*allowedOrigins),
+		Logger:         logger,
+	}).Handler(), "gRPC-Web proxy", logger)
+}
+
+// serveHTTP serves handler on addr until SIGINT or SIGTERM, then lets
+// in-flight requests finish for a few seconds
+func serveHTTP(addr string, handler http.Handler, name string, logger *zap.Logger) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	httpServer := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		httpServer.Shutdown(shutdownCtx)
+	}()
+
+	logger.Info("Serving "+name, zap.String("addr", listener.Addr().String()))
+	if err := httpServer.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("failed to serve: %w", err)
+	}
+	return nil
+}
+
+// splitList splits a comma separated flag value, ignoring empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
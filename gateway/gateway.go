@@ -0,0 +1,240 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	client "github.com/vedantkulkarni/reflect-poc/client"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// DefaultMaxBodyBytes bounds the JSON body of a call
+const DefaultMaxBodyBytes = 4 << 20
+
+// MetadataHeaderPrefix marks HTTP headers forwarded as gRPC metadata, and
+// response metadata returned as HTTP headers, as in grpc-gateway
+const MetadataHeaderPrefix = "Grpc-Metadata-"
+
+// forwardedHeaders are passed to the device as metadata without the prefix
+var forwardedHeaders = []string{"Authorization", "X-Request-Id"}
+
+// Gateway serves HTTP/JSON calls to devices at POST /{bridgeId}/{pkg.Service}/{Method}.
+//
+// The body is the JSON request, sent as application/json; client and
+// bidi-streaming methods take a sequence of JSON objects. Unary responses are returned as a JSON object.
+// Server-streaming responses are returned as Server-Sent Events when the
+// request accepts text/event-stream and as newline-delimited JSON otherwise.
+// gRPC errors are returned as a google.rpc.Status JSON object with the
+// matching HTTP status, or as the last event of a stream.
+//
+// A WebSocket upgrade of GET on the same path relays one call of any kind,
+// including client and bidi-streaming ones, frame by frame.
+type Gateway struct {
+	// Pool provides the client of each device
+	Pool *client.Pool
+	// Timeout bounds each call; zero leaves calls bounded by the HTTP request only
+	Timeout time.Duration
+	// MaxBodyBytes bounds the request body; zero means DefaultMaxBodyBytes
+	MaxBodyBytes int64
+	// AllowedOrigins are the browser origins other than the gateway's own
+	// allowed to make calls and open WebSockets; "*" allows any
+	AllowedOrigins []string
+	// Logger records handled calls; nil disables logging
+	Logger *zap.Logger
+}
+
+// Handler returns the HTTP handler of the gateway
+func (g *Gateway) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /{bridge}/{service}/{method}", g.handleCall)
+	mux.HandleFunc("GET /{bridge}/{service}/{method}", g.handleStream)
+	return mux
+}
+
+func (g *Gateway) logger() *zap.Logger {
+	if g.Logger == nil {
+		return zap.NewNop()
+	}
+	return g.Logger
+}
+
+func (g *Gateway) handleCall(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	bridgeID, fullMethod := r.PathValue("bridge"), r.PathValue("service")+"/"+r.PathValue("method")
+	writer := &responseWriter{ResponseWriter: w}
+	err := g.call(r, writer, bridgeID, fullMethod)
+	if err != nil {
+		writer.writeError(toStatus(err))
+	}
+
+	g.logger().Info("Handled HTTP call",
+		zap.String("bridge_id", bridgeID),
+		zap.String("method", fullMethod),
+		zap.Stringer("code", toStatus(err).Code()),
+		zap.Int("http_status", writer.status),
+		zap.Duration("duration", time.Since(start)),
+		zap.String("remote_addr", r.RemoteAddr),
+		zap.Error(err))
+}
+
+func (g *Gateway) call(r *http.Request, w *responseWriter, bridgeID, fullMethod string) error {
+	// Browsers send text/plain and form posts across origins without asking,
+	// so only JSON bodies from allowed origins are accepted
+	if !checkOrigin(r, g.AllowedOrigins) {
+		return status.Errorf(codes.PermissionDenied, "origin %q is not allowed", r.Header.Get("Origin"))
+	}
+	if !isJSONContentType(r.Header.Get("Content-Type")) {
+		return status.Errorf(codes.InvalidArgument, "content type %q is not application/json", r.Header.Get("Content-Type"))
+	}
+	ctx := r.Context()
+	if g.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, g.Timeout)
+		defer cancel()
+	}
+	ctx, drs, method, err := g.resolve(ctx, r.Header, bridgeID, fullMethod)
+	if err != nil {
+		return err
+	}
+	body := http.MaxBytesReader(w, r.Body, g.maxBodyBytes())
+
+	var header, trailer metadata.MD
+	opts := []grpc.CallOption{grpc.Header(&header), grpc.Trailer(&trailer)}
+	if !method.IsStreamingServer() {
+		var response proto.Message
+		err := drs.Call(ctx, fullMethod, body, func(m proto.Message) error {
+			response = m
+			return nil
+		}, opts...)
+		setMetadataHeaders(w.Header(), header)
+		setMetadataHeaders(w.Header(), trailer)
+		if err != nil {
+			return err
+		}
+		return w.writeJSON(response)
+	}
+
+	stream := newStreamWriter(w, acceptsEventStream(r.Header))
+	return drs.Call(ctx, fullMethod, body, stream.write, opts...)
+}
+
+// isJSONContentType accepts application/json, with parameters such as a
+// charset, and application/x-ndjson for streams of requests
+func isJSONContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && (mediaType == "application/json" || mediaType == "application/x-ndjson")
+}
+
+// resolve returns the client of bridgeID and the descriptor of fullMethod,
+// with a context carrying the metadata forwarded from h
+func (g *Gateway) resolve(ctx context.Context, h http.Header, bridgeID, fullMethod string) (context.Context, *client.ReflectionClient, protoreflect.MethodDescriptor, error) {
+	if err := ValidateBridgeID(bridgeID); err != nil {
+		return nil, nil, nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	drs, err := g.Pool.Client(bridgeID)
+	if err != nil {
+		return nil, nil, nil, status.Errorf(codes.Unavailable, "failed to open client for %s: %v", bridgeID, err)
+	}
+	ctx = metadata.NewOutgoingContext(ctx, incomingMetadata(h))
+	method, err := findMethod(ctx, drs, fullMethod)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return ctx, drs, method, nil
+}
+
+func (g *Gateway) maxBodyBytes() int64 {
+	if g.MaxBodyBytes == 0 {
+		return DefaultMaxBodyBytes
+	}
+	return g.MaxBodyBytes
+}
+
+// findMethod resolves pkg.Service/Method on the device, reporting an unknown
+// symbol as NotFound
+func findMethod(ctx context.Context, drs *client.ReflectionClient, fullMethod string) (protoreflect.MethodDescriptor, error) {
+	desc, err := drs.FindSymbol(ctx, fullMethod)
+	if err != nil {
+		if _, ok := status.FromError(err); ok {
+			return nil, err
+		}
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	method, ok := desc.(protoreflect.MethodDescriptor)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "%s is not a method", desc.FullName())
+	}
+	return method, nil
+}
+
+// maxBridgeIDLength bounds the bridge IDs accepted from requests
+const maxBridgeIDLength = 128
+
+// ValidateBridgeID accepts bridge IDs of up to 128 letters, digits, '.', '_',
+// '-' and ':'. Anything else, such as MQTT wildcards, topic levels, '$'
+// prefixes, whitespace or control characters, is rejected.
+func ValidateBridgeID(bridgeID string) error {
+	if bridgeID == "" || len(bridgeID) > maxBridgeIDLength {
+		return fmt.Errorf("invalid bridge ID %q: must be 1 to %d characters", bridgeID, maxBridgeIDLength)
+	}
+	for _, r := range bridgeID {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '.', r == '_', r == '-', r == ':':
+		default:
+			return fmt.Errorf("invalid bridge ID %q: unexpected character %q", bridgeID, r)
+		}
+	}
+	return nil
+}
+
+// incomingMetadata returns the HTTP headers forwarded to the device
+func incomingMetadata(h http.Header) metadata.MD {
+	md := metadata.MD{}
+	for name, values := range h {
+		if key, ok := strings.CutPrefix(name, MetadataHeaderPrefix); ok {
+			md.Append(strings.ToLower(key), values...)
+		}
+	}
+	for _, name := range forwardedHeaders {
+		if values := h.Values(name); len(values) > 0 {
+			md.Append(strings.ToLower(name), values...)
+		}
+	}
+	return md
+}
+
+// responseMetadata drops the gRPC framing details from response metadata
+func responseMetadata(md metadata.MD) metadata.MD {
+	md = md.Copy()
+	delete(md, "content-type")
+	return md
+}
+
+// setMetadataHeaders returns response metadata as prefixed HTTP headers
+func setMetadataHeaders(h http.Header, md metadata.MD) {
+	for key, values := range responseMetadata(md) {
+		for _, value := range values {
+			h.Add(MetadataHeaderPrefix+key, value)
+		}
+	}
+}
+
+func acceptsEventStream(h http.Header) bool {
+	for _, accept := range h.Values("Accept") {
+		if strings.Contains(accept, "text/event-stream") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+package gateway_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	gateway "github.com/vedantkulkarni/reflect-poc/gateway"
+)
+
+func TestValidateBridgeID(t *testing.T) {
+	for _, tc := range []struct {
+		bridgeID string
+		valid    bool
+	}{
+		{"echo-service1", true},
+		{"plant_3.pump:7", true},
+		{"", false},
+		{"pumps/1", false},
+		{"pump+", false},
+		{"#", false},
+		{"$SYS", false},
+		{"pump 1", false},
+		{"pump\n1", false},
+		{"pump\x00", false},
+		{"pümp", false},
+		{string(make([]byte, 129)), false},
+	} {
+		if err := gateway.ValidateBridgeID(tc.bridgeID); (err == nil) != tc.valid {
+			t.Errorf("ValidateBridgeID(%q) = %v, want valid %v", tc.bridgeID, err, tc.valid)
+		}
+	}
+	long := make([]byte, 128)
+	for i := range long {
+		long[i] = 'a'
+	}
+	if err := gateway.ValidateBridgeID(string(long)); err != nil {
+		t.Errorf("rejected a bridge ID of 128 characters: %v", err)
+	}
+}
+
+// TestCallRejectsCrossSiteRequests checks that calls a page on another site
+// could send without a CORS preflight never reach a device
+func TestCallRejectsCrossSiteRequests(t *testing.T) {
+	handler := (&gateway.Gateway{AllowedOrigins: []string{"https://console.example"}}).Handler()
+	for _, tc := range []struct {
+		name        string
+		origin      string
+		contentType string
+		status      int
+	}{
+		{"text/plain body", "", "text/plain", http.StatusBadRequest},
+		{"form body", "", "application/x-www-form-urlencoded", http.StatusBadRequest},
+		{"no content type", "", "", http.StatusBadRequest},
+		{"JSON from another origin", "https://evil.example", "application/json", http.StatusForbidden},
+		{"text/plain from another origin", "https://evil.example", "text/plain", http.StatusForbidden},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "http://127.0.0.1:8082/echo-service1/reflect.TestService/Test", strings.NewReader(`{}`))
+		if tc.origin != "" {
+			req.Header.Set("Origin", tc.origin)
+		}
+		if tc.contentType != "" {
+			req.Header.Set("Content-Type", tc.contentType)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		if recorder.Code != tc.status {
+			t.Errorf("%s: status %d, want %d", tc.name, recorder.Code, tc.status)
+		}
+	}
+}
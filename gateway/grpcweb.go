@@ -0,0 +1,417 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	client "github.com/vedantkulkarni/reflect-poc/client"
+	transport "github.com/vedantkulkarni/reflect-poc/transport"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// BridgeIDHeader selects the device of a gRPC-Web call instead of the proxy's
+// default bridge ID
+const BridgeIDHeader = "X-Bridge-Id"
+
+const (
+	grpcWebContentType     = "application/grpc-web"
+	grpcWebTextContentType = "application/grpc-web-text"
+
+	// frameHeaderSize is the flag byte and the big-endian length of a frame
+	frameHeaderSize = 5
+	// compressedFlag marks a compressed message frame
+	compressedFlag = 0x01
+	// trailerFlag marks the frame carrying the trailers of a response
+	trailerFlag = 0x80
+)
+
+// requestHeaders are the HTTP headers of a browser request that are not call
+// metadata
+var requestHeaders = []string{
+	"accept", "accept-encoding", "accept-language", "cache-control", "connection",
+	"content-length", "content-type", "cookie", "grpc-timeout", "host", "origin",
+	"pragma", "referer", "te", "user-agent", "x-grpc-web", "x-user-agent",
+	strings.ToLower(BridgeIDHeader),
+}
+
+// GRPCWebProxy serves the gRPC-Web protocol, in its binary and base64 text
+// encodings, at POST /{pkg.Service}/{Method}.
+//
+// Calls are forwarded to the device without decoding their messages, so the
+// proxy needs no descriptors. Unary and server-streaming calls are supported,
+// which is all gRPC-Web clients make. The device is the one named by the
+// X-Bridge-Id header, or BridgeID.
+type GRPCWebProxy struct {
+	// Pool provides the connection to each device
+	Pool *client.Pool
+	// BridgeID is the device called when a request has no X-Bridge-Id header
+	BridgeID string
+	// MaxBodyBytes bounds the request body; zero means DefaultMaxBodyBytes
+	MaxBodyBytes int64
+	// AllowedOrigins are the browser origins allowed to make cross-origin
+	// calls; "*" allows any
+	AllowedOrigins []string
+	// Logger records handled calls; nil disables logging
+	Logger *zap.Logger
+}
+
+// Handler returns the HTTP handler of the proxy
+func (p *GRPCWebProxy) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /{service}/{method}", p.handleCall)
+	mux.HandleFunc("OPTIONS /{service}/{method}", p.handlePreflight)
+	return mux
+}
+
+func (p *GRPCWebProxy) logger() *zap.Logger {
+	if p.Logger == nil {
+		return zap.NewNop()
+	}
+	return p.Logger
+}
+
+// handlePreflight answers CORS preflight requests from AllowedOrigins
+func (p *GRPCWebProxy) handlePreflight(w http.ResponseWriter, r *http.Request) {
+	if !p.allowCORS(w, r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	if requested := r.Header.Get("Access-Control-Request-Headers"); requested != "" {
+		w.Header().Set("Access-Control-Allow-Headers", requested)
+	}
+	w.Header().Set("Access-Control-Max-Age", "600")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// allowCORS sets the CORS headers of a request from an allowed origin and
+// reports whether the origin is allowed. A "*" entry is answered with a
+// literal "*" and no credentials, so browsers never send cookies to any origin.
+func (p *GRPCWebProxy) allowCORS(w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Add("Vary", "Origin")
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if !checkOrigin(r, p.AllowedOrigins) {
+		return false
+	}
+	if slices.Contains(p.AllowedOrigins, origin) {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	} else if slices.Contains(p.AllowedOrigins, "*") {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	}
+	return true
+}
+
+func (p *GRPCWebProxy) handleCall(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	fullMethod := "/" + r.PathValue("service") + "/" + r.PathValue("method")
+	bridgeID := r.Header.Get(BridgeIDHeader)
+	if bridgeID == "" {
+		bridgeID = p.BridgeID
+	}
+
+	contentType := r.Header.Get("Content-Type")
+	text := strings.HasPrefix(contentType, grpcWebTextContentType)
+	if !text && !strings.HasPrefix(contentType, grpcWebContentType) {
+		http.Error(w, fmt.Sprintf("unsupported content type %q", contentType), http.StatusUnsupportedMediaType)
+		return
+	}
+	if !p.allowCORS(w, r) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+
+	response := &grpcWebResponse{w: w, contentType: contentType, text: text}
+	responses, err := p.call(r, response, bridgeID, fullMethod)
+	response.writeTrailer(toStatus(err))
+
+	p.logger().Info("Handled gRPC-Web call",
+		zap.String("bridge_id", bridgeID),
+		zap.String("method", fullMethod),
+		zap.Stringer("code", toStatus(err).Code()),
+		zap.Bool("text", text),
+		zap.Int("responses", responses),
+		zap.Duration("duration", time.Since(start)),
+		zap.String("remote_addr", r.RemoteAddr),
+		zap.Error(err))
+}
+
+// call forwards the request frame to the device and relays every response
+// frame, returning the number of responses
+func (p *GRPCWebProxy) call(r *http.Request, response *grpcWebResponse, bridgeID, fullMethod string) (int, error) {
+	if err := ValidateBridgeID(bridgeID); err != nil {
+		return 0, status.Error(codes.InvalidArgument, err.Error())
+	}
+	maxBody := p.MaxBodyBytes
+	if maxBody == 0 {
+		maxBody = DefaultMaxBodyBytes
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(response.w, r.Body, maxBody))
+	if err != nil {
+		return 0, err
+	}
+	if response.text {
+		if body, err = decodeBase64Chunks(body); err != nil {
+			return 0, status.Errorf(codes.InvalidArgument, "invalid grpc-web-text body: %v", err)
+		}
+	}
+	request, err := requestMessage(body)
+	if err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	if timeout := r.Header.Get("Grpc-Timeout"); timeout != "" {
+		d, err := parseTimeout(timeout)
+		if err != nil {
+			return 0, status.Errorf(codes.InvalidArgument, "invalid grpc-timeout %q: %v", timeout, err)
+		}
+		ctx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
+	}
+	ctx = metadata.NewOutgoingContext(ctx, callMetadata(r.Header))
+
+	drs, err := p.Pool.Client(bridgeID)
+	if err != nil {
+		return 0, status.Errorf(codes.Unavailable, "failed to open client for %s: %v", bridgeID, err)
+	}
+	// Unary methods are called as server-streaming ones: the device does not
+	// tell them apart on the wire
+	stream, err := drs.Conn().NewStream(ctx, &grpc.StreamDesc{ServerStreams: true}, fullMethod, grpc.ForceCodec(transport.FrameCodec{}))
+	if err != nil {
+		return 0, err
+	}
+	if err := stream.SendMsg(&request); err != nil && !errors.Is(err, io.EOF) {
+		return 0, err
+	}
+	stream.CloseSend()
+
+	header, _ := stream.Header()
+	response.writeHeader(header)
+	defer func() { response.trailer = stream.Trailer() }()
+
+	responses := 0
+	for {
+		var message []byte
+		if err := stream.RecvMsg(&message); err != nil {
+			if errors.Is(err, io.EOF) {
+				return responses, nil
+			}
+			return responses, err
+		}
+		responses++
+		if err := response.writeFrame(0, message); err != nil {
+			return responses, err
+		}
+	}
+}
+
+// requestMessage returns the only message frame of a request body
+func requestMessage(body []byte) ([]byte, error) {
+	var messages [][]byte
+	for len(body) > 0 {
+		if len(body) < frameHeaderSize {
+			return nil, status.Error(codes.InvalidArgument, "truncated grpc-web frame")
+		}
+		flag, length := body[0], binary.BigEndian.Uint32(body[1:frameHeaderSize])
+		if uint64(len(body)-frameHeaderSize) < uint64(length) {
+			return nil, status.Error(codes.InvalidArgument, "truncated grpc-web frame")
+		}
+		if flag&compressedFlag != 0 {
+			return nil, status.Error(codes.Unimplemented, "compressed grpc-web messages are not supported")
+		}
+		messages = append(messages, body[frameHeaderSize:frameHeaderSize+int(length)])
+		body = body[frameHeaderSize+int(length):]
+	}
+	if len(messages) != 1 {
+		return nil, status.Errorf(codes.Unimplemented, "grpc-web calls carry a single request, got %d", len(messages))
+	}
+	return messages[0], nil
+}
+
+// callMetadata returns the headers of a browser request that are metadata
+func callMetadata(h http.Header) metadata.MD {
+	md := metadata.MD{}
+	for name, values := range h {
+		key := strings.ToLower(name)
+		if slices.Contains(requestHeaders, key) || strings.HasPrefix(key, "sec-") || strings.HasPrefix(key, "access-control-") {
+			continue
+		}
+		for _, value := range values {
+			if strings.HasSuffix(key, "-bin") {
+				decoded, err := decodeBinaryHeader(value)
+				if err != nil {
+					continue
+				}
+				value = string(decoded)
+			}
+			md.Append(key, value)
+		}
+	}
+	return md
+}
+
+// grpcWebResponse writes the frames of a gRPC-Web response
+type grpcWebResponse struct {
+	w           http.ResponseWriter
+	contentType string
+	text        bool
+	started     bool
+	trailer     metadata.MD
+}
+
+// writeHeader sends the response metadata as HTTP headers
+func (r *grpcWebResponse) writeHeader(md metadata.MD) {
+	if r.started {
+		return
+	}
+	r.started = true
+	exposed := []string{"grpc-status", "grpc-message"}
+	for key, values := range responseMetadata(md) {
+		for _, value := range values {
+			r.w.Header().Add(key, encodeHeaderValue(key, value))
+		}
+		exposed = append(exposed, key)
+	}
+	if r.w.Header().Get("Access-Control-Allow-Origin") != "" {
+		r.w.Header().Set("Access-Control-Expose-Headers", strings.Join(exposed, ", "))
+	}
+	r.w.Header().Set("Content-Type", r.contentType)
+	r.w.WriteHeader(http.StatusOK)
+}
+
+// writeFrame sends one frame, base64 encoded on its own in the text encoding
+func (r *grpcWebResponse) writeFrame(flag byte, data []byte) error {
+	frame := make([]byte, frameHeaderSize+len(data))
+	frame[0] = flag
+	binary.BigEndian.PutUint32(frame[1:frameHeaderSize], uint32(len(data)))
+	copy(frame[frameHeaderSize:], data)
+	if r.text {
+		frame = []byte(base64.StdEncoding.EncodeToString(frame))
+	}
+	if _, err := r.w.Write(frame); err != nil {
+		return err
+	}
+	http.NewResponseController(r.w).Flush()
+	return nil
+}
+
+// writeTrailer ends the response with the status and trailers of the call
+func (r *grpcWebResponse) writeTrailer(s *status.Status) {
+	r.writeHeader(nil)
+	var trailer bytes.Buffer
+	fmt.Fprintf(&trailer, "grpc-status: %d\r\n", s.Code())
+	if s.Message() != "" {
+		fmt.Fprintf(&trailer, "grpc-message: %s\r\n", encodeGRPCMessage(s.Message()))
+	}
+	for key, values := range responseMetadata(r.trailer) {
+		for _, value := range values {
+			fmt.Fprintf(&trailer, "%s: %s\r\n", key, encodeHeaderValue(key, value))
+		}
+	}
+	r.writeFrame(trailerFlag, trailer.Bytes())
+}
+
+// decodeBase64Chunks decodes a grpc-web-text body, which may be several
+// padded base64 strings written one after another
+func decodeBase64Chunks(data []byte) ([]byte, error) {
+	data = bytes.TrimSpace(data)
+	var out []byte
+	for len(data) > 0 {
+		end := bytes.IndexByte(data, '=')
+		if end < 0 {
+			end = len(data)
+		}
+		for end < len(data) && data[end] == '=' {
+			end++
+		}
+		chunk, err := base64.StdEncoding.DecodeString(string(data[:end]))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, chunk...)
+		data = data[end:]
+	}
+	return out, nil
+}
+
+// maxTimeoutDigits is the longest amount a grpc-timeout header may carry
+const maxTimeoutDigits = 8
+
+// parseTimeout parses a grpc-timeout header such as "10S" or "500m". Amounts
+// too large for a time.Duration, which hours can reach, are capped to the
+// longest duration.
+func parseTimeout(value string) (time.Duration, error) {
+	if len(value) < 2 {
+		return 0, errors.New("too short")
+	}
+	if len(value)-1 > maxTimeoutDigits {
+		return 0, fmt.Errorf("amount longer than %d digits", maxTimeoutDigits)
+	}
+	units := map[byte]time.Duration{
+		'H': time.Hour, 'M': time.Minute, 'S': time.Second,
+		'm': time.Millisecond, 'u': time.Microsecond, 'n': time.Nanosecond,
+	}
+	unit, ok := units[value[len(value)-1]]
+	if !ok {
+		return 0, fmt.Errorf("unknown unit %q", value[len(value)-1])
+	}
+	amount := value[:len(value)-1]
+	n, err := strconv.ParseInt(amount, 10, 64)
+	if err != nil || strings.TrimLeft(amount, "0123456789") != "" {
+		return 0, fmt.Errorf("invalid amount %q", amount)
+	}
+	if time.Duration(n) > math.MaxInt64/unit {
+		return math.MaxInt64, nil
+	}
+	return time.Duration(n) * unit, nil
+}
+
+// encodeHeaderValue base64 encodes the values of binary metadata keys
+func encodeHeaderValue(key, value string) string {
+	if strings.HasSuffix(key, "-bin") {
+		return base64.StdEncoding.EncodeToString([]byte(value))
+	}
+	return value
+}
+
+// decodeBinaryHeader decodes a binary metadata value, padded or not
+func decodeBinaryHeader(value string) ([]byte, error) {
+	if len(value)%4 == 0 {
+		return base64.StdEncoding.DecodeString(value)
+	}
+	return base64.RawStdEncoding.DecodeString(value)
+}
+
+// encodeGRPCMessage percent-encodes a status message as gRPC requires
+func encodeGRPCMessage(message string) string {
+	var b strings.Builder
+	for i := 0; i < len(message); i++ {
+		c := message[i]
+		if c >= ' ' && c <= '~' && c != '%' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
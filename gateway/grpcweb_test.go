@@ -0,0 +1,168 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestAllowCORS(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		allowed     []string
+		origin      string
+		host        string
+		wantAllowed bool
+		wantOrigin  string
+		wantCreds   string
+	}{
+		{name: "no origin", allowed: nil, origin: "", wantAllowed: true},
+		{name: "same origin", allowed: nil, origin: "https://devices.example", host: "devices.example", wantAllowed: true},
+		{name: "listed origin", allowed: []string{"https://app.example"}, origin: "https://app.example", wantAllowed: true, wantOrigin: "https://app.example", wantCreds: "true"},
+		{name: "unlisted origin", allowed: []string{"https://app.example"}, origin: "https://evil.example", wantAllowed: false},
+		{name: "wildcard", allowed: []string{"*"}, origin: "https://evil.example", wantAllowed: true, wantOrigin: "*"},
+		{name: "listed beside wildcard", allowed: []string{"*", "https://app.example"}, origin: "https://app.example", wantAllowed: true, wantOrigin: "https://app.example", wantCreds: "true"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &GRPCWebProxy{AllowedOrigins: tc.allowed}
+			r := httptest.NewRequest(http.MethodOptions, "/pkg.Service/Method", nil)
+			if tc.origin != "" {
+				r.Header.Set("Origin", tc.origin)
+			}
+			if tc.host != "" {
+				r.Host = tc.host
+			}
+			w := httptest.NewRecorder()
+			if got := p.allowCORS(w, r); got != tc.wantAllowed {
+				t.Fatalf("allowCORS = %v, want %v", got, tc.wantAllowed)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tc.wantOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tc.wantOrigin)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Credentials"); got != tc.wantCreds {
+				t.Errorf("Access-Control-Allow-Credentials = %q, want %q", got, tc.wantCreds)
+			}
+		})
+	}
+}
+
+func TestPreflight(t *testing.T) {
+	p := &GRPCWebProxy{AllowedOrigins: []string{"https://app.example"}}
+	for origin, want := range map[string]int{
+		"https://app.example":  http.StatusNoContent,
+		"https://evil.example": http.StatusForbidden,
+	} {
+		r := httptest.NewRequest(http.MethodOptions, "/pkg.Service/Method", nil)
+		r.Header.Set("Origin", origin)
+		r.Header.Set("Access-Control-Request-Headers", "x-grpc-web, content-type")
+		w := httptest.NewRecorder()
+		p.Handler().ServeHTTP(w, r)
+		if w.Code != want {
+			t.Errorf("preflight from %s = %d, want %d", origin, w.Code, want)
+		}
+	}
+}
+
+func frame(flag byte, data string) []byte {
+	b := make([]byte, frameHeaderSize+len(data))
+	b[0] = flag
+	binary.BigEndian.PutUint32(b[1:frameHeaderSize], uint32(len(data)))
+	copy(b[frameHeaderSize:], data)
+	return b
+}
+
+func TestDecodeBase64Chunks(t *testing.T) {
+	first, second := frame(0, "a"), frame(0, "hello")
+	for _, tc := range []struct {
+		name    string
+		body    string
+		want    []byte
+		wantErr bool
+	}{
+		{name: "empty", body: "", want: nil},
+		{name: "single", body: base64.StdEncoding.EncodeToString(second), want: second},
+		{name: "padded chunks", body: base64.StdEncoding.EncodeToString(first) + base64.StdEncoding.EncodeToString(second), want: append(append([]byte(nil), first...), second...)},
+		{name: "surrounding space", body: "\n" + base64.StdEncoding.EncodeToString(second) + "\r\n", want: second},
+		{name: "invalid", body: "not base64!", wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := decodeBase64Chunks([]byte(tc.body))
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("decodeBase64Chunks(%q) error = %v, want error %v", tc.body, err, tc.wantErr)
+			}
+			if !bytes.Equal(got, tc.want) {
+				t.Errorf("decodeBase64Chunks(%q) = %x, want %x", tc.body, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRequestMessage(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		body []byte
+		want string
+		code codes.Code
+	}{
+		{name: "single", body: frame(0, "request"), want: "request"},
+		{name: "empty message", body: frame(0, ""), want: ""},
+		{name: "no frame", body: nil, code: codes.Unimplemented},
+		{name: "two frames", body: append(frame(0, "a"), frame(0, "b")...), code: codes.Unimplemented},
+		{name: "short header", body: []byte{0, 0, 0}, code: codes.InvalidArgument},
+		{name: "short message", body: frame(0, "request")[:8], code: codes.InvalidArgument},
+		{name: "compressed", body: frame(compressedFlag, "request"), code: codes.Unimplemented},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := requestMessage(tc.body)
+			if code := status.Code(err); code != tc.code {
+				t.Fatalf("requestMessage code = %s (%v), want %s", code, err, tc.code)
+			}
+			if err == nil && string(got) != tc.want {
+				t.Errorf("requestMessage = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseTimeout(t *testing.T) {
+	for _, tc := range []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{value: "1H", want: time.Hour},
+		{value: "2M", want: 2 * time.Minute},
+		{value: "10S", want: 10 * time.Second},
+		{value: "500m", want: 500 * time.Millisecond},
+		{value: "250u", want: 250 * time.Microsecond},
+		{value: "100n", want: 100 * time.Nanosecond},
+		{value: "0S", want: 0},
+		{value: "99999999n", want: 99999999 * time.Nanosecond},
+		{value: "99999999H", want: math.MaxInt64},
+		{value: "999999999S", wantErr: true},
+		{value: "+1S", wantErr: true},
+		{value: "S", wantErr: true},
+		{value: "", wantErr: true},
+		{value: "10", wantErr: true},
+		{value: "10s", wantErr: true},
+		{value: "-1S", wantErr: true},
+		{value: "xS", wantErr: true},
+	} {
+		got, err := parseTimeout(tc.value)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("parseTimeout(%q) error = %v, want error %v", tc.value, err, tc.wantErr)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("parseTimeout(%q) = %s, want %s", tc.value, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package gateway
+
+import (
+	"fmt"
+	"net/http"
+
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// responseWriter remembers whether the response started, so that an error
+// after the first streamed message is written as part of the stream
+type responseWriter struct {
+	http.ResponseWriter
+	status int
+	stream *streamWriter
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responseWriter) writeJSON(m proto.Message) error {
+	data, err := protojson.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("failed to encode response: %w", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// writeError writes s as the response, or as the last message of a stream
+// that already started
+func (w *responseWriter) writeError(s *status.Status) {
+	if w.stream != nil && w.stream.started {
+		w.stream.writeError(s)
+		return
+	}
+	if w.status != 0 {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(HTTPStatusFromCode(s.Code()))
+	w.Write(append(statusJSON(s), '\n'))
+}
+
+// streamWriter writes server-streaming responses as NDJSON or Server-Sent Events
+type streamWriter struct {
+	w       *responseWriter
+	sse     bool
+	started bool
+}
+
+func newStreamWriter(w *responseWriter, sse bool) *streamWriter {
+	stream := &streamWriter{w: w, sse: sse}
+	w.stream = stream
+	return stream
+}
+
+// start sends the headers with the first message, so that errors raised
+// before it still get a matching HTTP status
+func (s *streamWriter) start() {
+	if s.started {
+		return
+	}
+	s.started = true
+	if s.sse {
+		s.w.Header().Set("Content-Type", "text/event-stream")
+		s.w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		s.w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	s.w.WriteHeader(http.StatusOK)
+}
+
+func (s *streamWriter) write(m proto.Message) error {
+	data, err := protojson.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("failed to encode response: %w", err)
+	}
+	s.start()
+	if s.sse {
+		_, err = fmt.Fprintf(s.w, "data: %s\n\n", data)
+	} else {
+		_, err = fmt.Fprintf(s.w, "%s\n", data)
+	}
+	if err != nil {
+		return err
+	}
+	http.NewResponseController(s.w.ResponseWriter).Flush()
+	return nil
+}
+
+// writeError ends the stream with s: an "error" event, or an NDJSON line
+// holding the status under "error"
+func (s *streamWriter) writeError(st *status.Status) {
+	if s.sse {
+		fmt.Fprintf(s.w, "event: error\ndata: %s\n\n", statusJSON(st))
+	} else {
+		fmt.Fprintf(s.w, "{\"error\":%s}\n", statusJSON(st))
+	}
+	http.NewResponseController(s.w.ResponseWriter).Flush()
+}
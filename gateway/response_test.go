@@ -0,0 +1,135 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	client "github.com/vedantkulkarni/reflect-poc/client"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestToStatus(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want codes.Code
+	}{
+		{status.Error(codes.NotFound, "no such device"), codes.NotFound},
+		{&http.MaxBytesError{Limit: 10}, codes.ResourceExhausted},
+		{fmt.Errorf("failed to read body: %w", &http.MaxBytesError{Limit: 10}), codes.ResourceExhausted},
+		{fmt.Errorf("%w: bad field", client.ErrInvalidRequest), codes.InvalidArgument},
+		{fmt.Errorf("%w: short frame", ErrInvalidFrame), codes.InvalidArgument},
+		{errors.New("something else"), codes.Unknown},
+	} {
+		if got := toStatus(tc.err).Code(); got != tc.want {
+			t.Errorf("toStatus(%v) = %s, want %s", tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestStreamWriter(t *testing.T) {
+	failed := status.New(codes.Unavailable, "device went away")
+	for _, tc := range []struct {
+		name        string
+		sse         bool
+		messages    []string
+		err         *status.Status
+		wantStatus  int
+		contentType string
+		body        string
+	}{
+		{
+			name:        "NDJSON",
+			messages:    []string{"a", "b"},
+			wantStatus:  http.StatusOK,
+			contentType: "application/x-ndjson",
+			body:        "\"a\"\n\"b\"\n",
+		},
+		{
+			name:        "NDJSON error after the first message",
+			messages:    []string{"a"},
+			err:         failed,
+			wantStatus:  http.StatusOK,
+			contentType: "application/x-ndjson",
+			body:        "\"a\"\n{\"error\":{\"code\":14,\"message\":\"device went away\",\"details\":[]}}\n",
+		},
+		{
+			name:        "SSE",
+			sse:         true,
+			messages:    []string{"a", "b"},
+			wantStatus:  http.StatusOK,
+			contentType: "text/event-stream",
+			body:        "data: \"a\"\n\ndata: \"b\"\n\n",
+		},
+		{
+			name:        "SSE error after the first message",
+			sse:         true,
+			messages:    []string{"a"},
+			err:         failed,
+			wantStatus:  http.StatusOK,
+			contentType: "text/event-stream",
+			body:        "data: \"a\"\n\nevent: error\ndata: {\"code\":14,\"message\":\"device went away\",\"details\":[]}\n\n",
+		},
+		{
+			name:        "error before the first message",
+			sse:         true,
+			err:         failed,
+			wantStatus:  http.StatusServiceUnavailable,
+			contentType: "application/json",
+			body:        "{\"code\":14,\"message\":\"device went away\",\"details\":[]}\n",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			w := &responseWriter{ResponseWriter: recorder}
+			stream := newStreamWriter(w, tc.sse)
+			for _, message := range tc.messages {
+				if err := stream.write(wrapperspb.String(message)); err != nil {
+					t.Fatalf("failed to write message: %v", err)
+				}
+			}
+			if tc.err != nil {
+				w.writeError(tc.err)
+			}
+
+			if recorder.Code != tc.wantStatus {
+				t.Errorf("status %d, want %d", recorder.Code, tc.wantStatus)
+			}
+			if got := recorder.Header().Get("Content-Type"); got != tc.contentType {
+				t.Errorf("Content-Type %q, want %q", got, tc.contentType)
+			}
+			if got := compact(recorder.Body.String()); got != compact(tc.body) {
+				t.Errorf("body %q, want %q", recorder.Body.String(), tc.body)
+			}
+		})
+	}
+}
+
+func TestWriteErrorAfterResponse(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	w := &responseWriter{ResponseWriter: recorder}
+	if err := w.writeJSON(wrapperspb.String("done")); err != nil {
+		t.Fatalf("failed to write response: %v", err)
+	}
+	// A response that was already sent is not followed by an error
+	w.writeError(status.New(codes.Internal, "too late"))
+	if recorder.Code != http.StatusOK || compact(recorder.Body.String()) != "\"done\"\n" {
+		t.Errorf("response %d %q", recorder.Code, recorder.Body.String())
+	}
+}
+
+// compact drops the spaces protojson randomly adds after separators
+func compact(s string) string {
+	out := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] != ' ' {
+			out = append(out, s[i])
+		}
+	}
+	return string(out)
+}
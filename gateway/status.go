@@ -0,0 +1,80 @@
+package gateway
+
+import (
+	"errors"
+	"net/http"
+
+	client "github.com/vedantkulkarni/reflect-poc/client"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// HTTPStatusFromCode maps a gRPC status code to the HTTP status returned for
+// it, following the mapping of google.rpc.Code
+func HTTPStatusFromCode(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		// 499 Client Closed Request, as used by nginx and grpc-gateway
+		return 499
+	case codes.Unknown:
+		return http.StatusInternalServerError
+	case codes.InvalidArgument:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.FailedPrecondition:
+		return http.StatusBadRequest
+	case codes.Aborted:
+		return http.StatusConflict
+	case codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Internal:
+		return http.StatusInternalServerError
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	case codes.DataLoss:
+		return http.StatusInternalServerError
+	}
+	return http.StatusInternalServerError
+}
+
+// toStatus converts a call error to a gRPC status. Errors raised before the
+// call reaches the device, such as a malformed body, get a matching code.
+func toStatus(err error) *status.Status {
+	if s, ok := status.FromError(err); ok {
+		return s
+	}
+	var maxBytes *http.MaxBytesError
+	if errors.As(err, &maxBytes) {
+		return status.New(codes.ResourceExhausted, err.Error())
+	}
+	if errors.Is(err, client.ErrInvalidRequest) || errors.Is(err, ErrInvalidFrame) {
+		return status.New(codes.InvalidArgument, err.Error())
+	}
+	return status.New(codes.Unknown, err.Error())
+}
+
+// statusJSON renders s as a google.rpc.Status JSON object
+func statusJSON(s *status.Status) []byte {
+	// The code is always present, so that OK is explicit
+	data, err := protojson.MarshalOptions{EmitUnpopulated: true}.Marshal(s.Proto())
+	if err != nil {
+		return []byte(`{"code":2,"message":"failed to encode status"}`)
+	}
+	return data
+}
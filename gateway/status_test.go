@@ -0,0 +1,40 @@
+package gateway_test
+
+import (
+	"net/http"
+	"testing"
+
+	gateway "github.com/vedantkulkarni/reflect-poc/gateway"
+
+	"google.golang.org/grpc/codes"
+)
+
+func TestHTTPStatusFromCode(t *testing.T) {
+	for _, tc := range []struct {
+		code codes.Code
+		want int
+	}{
+		{codes.OK, http.StatusOK},
+		{codes.Canceled, 499},
+		{codes.Unknown, http.StatusInternalServerError},
+		{codes.InvalidArgument, http.StatusBadRequest},
+		{codes.DeadlineExceeded, http.StatusGatewayTimeout},
+		{codes.NotFound, http.StatusNotFound},
+		{codes.AlreadyExists, http.StatusConflict},
+		{codes.PermissionDenied, http.StatusForbidden},
+		{codes.ResourceExhausted, http.StatusTooManyRequests},
+		{codes.FailedPrecondition, http.StatusBadRequest},
+		{codes.Aborted, http.StatusConflict},
+		{codes.OutOfRange, http.StatusBadRequest},
+		{codes.Unimplemented, http.StatusNotImplemented},
+		{codes.Internal, http.StatusInternalServerError},
+		{codes.Unavailable, http.StatusServiceUnavailable},
+		{codes.DataLoss, http.StatusInternalServerError},
+		{codes.Unauthenticated, http.StatusUnauthorized},
+		{codes.Code(99), http.StatusInternalServerError},
+	} {
+		if got := gateway.HTTPStatusFromCode(tc.code); got != tc.want {
+			t.Errorf("HTTPStatusFromCode(%s) = %d, want %d", tc.code, got, tc.want)
+		}
+	}
+}
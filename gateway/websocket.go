@@ -0,0 +1,298 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	client "github.com/vedantkulkarni/reflect-poc/client"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// ErrInvalidFrame reports a WebSocket frame that is not a valid request
+var ErrInvalidFrame = errors.New("invalid frame")
+
+// clientFrame is a text frame sent by the browser: a request, or the end of
+// the requests
+type clientFrame struct {
+	Message   json.RawMessage `json:"message,omitempty"`
+	HalfClose bool            `json:"halfClose,omitempty"`
+}
+
+// serverFrame is a text frame sent to the browser: the response headers, a
+// response, or the final status with the trailers
+type serverFrame struct {
+	Header  metadata.MD     `json:"header,omitempty"`
+	Message json.RawMessage `json:"message,omitempty"`
+	Status  json.RawMessage `json:"status,omitempty"`
+	Trailer metadata.MD     `json:"trailer,omitempty"`
+}
+
+// handleStream serves a WebSocket upgrade of GET /{bridgeId}/{pkg.Service}/{Method}
+// as one call of the method.
+//
+// The browser sends {"message": {...}} frames with the requests and
+// {"halfClose": true} once it has no more; unary and server-streaming methods
+// are half-closed after their request. The gateway sends a {"header": {...}}
+// frame, a {"message": {...}} frame per response and a final
+// {"status": {...}, "trailer": {...}} frame before closing the socket.
+// Closing the socket cancels the call.
+//
+// The status frame is authoritative; the close code only summarizes it:
+// 1000 after an OK status, 1008 after a malformed frame and 1011 after any
+// other error, with the gRPC code name as the reason.
+func (g *Gateway) handleStream(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	bridgeID, fullMethod := r.PathValue("bridge"), r.PathValue("service")+"/"+r.PathValue("method")
+	logger := g.logger().With(zap.String("bridge_id", bridgeID), zap.String("method", fullMethod), zap.String("remote_addr", r.RemoteAddr))
+
+	// Errors before the upgrade are plain HTTP errors. Upgrades from other
+	// origins are refused before any device client is opened.
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	var drs *client.ReflectionClient
+	var method protoreflect.MethodDescriptor
+	err := status.Errorf(codes.PermissionDenied, "origin %q is not allowed", r.Header.Get("Origin"))
+	if g.checkOrigin(r) {
+		ctx, drs, method, err = g.resolve(ctx, r.Header, bridgeID, fullMethod)
+	}
+	if err != nil {
+		s := toStatus(err)
+		writer := &responseWriter{ResponseWriter: w}
+		writer.writeError(s)
+		logger.Info("Handled WebSocket call", zap.Stringer("code", s.Code()), zap.Int("http_status", writer.status), zap.Error(err))
+		return
+	}
+
+	upgrader := websocket.Upgrader{CheckOrigin: g.checkOrigin}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader already replied with an HTTP error
+		logger.Info("Rejected WebSocket upgrade", zap.Error(err))
+		return
+	}
+	defer conn.Close()
+	conn.SetReadLimit(g.maxBodyBytes())
+
+	session := &wsSession{conn: conn, method: method, cancel: cancel}
+	err = session.run(ctx, drs.NewStream)
+	logger.Info("Handled WebSocket call",
+		zap.Stringer("code", toStatus(err).Code()),
+		zap.Int("requests", session.requests),
+		zap.Int("responses", session.responses),
+		zap.Duration("duration", time.Since(start)),
+		zap.Error(err))
+}
+
+// checkOrigin accepts same-origin upgrades and those from AllowedOrigins
+func (g *Gateway) checkOrigin(r *http.Request) bool {
+	return checkOrigin(r, g.AllowedOrigins)
+}
+
+// checkOrigin reports whether r comes from its own origin, has none, or comes
+// from one of allowed, where "*" allows any
+func checkOrigin(r *http.Request, allowed []string) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err == nil && u.Host == r.Host {
+		return true
+	}
+	return slices.Contains(allowed, "*") || slices.Contains(allowed, origin)
+}
+
+// wsSession relays one call over a WebSocket
+type wsSession struct {
+	conn   *websocket.Conn
+	method protoreflect.MethodDescriptor
+	cancel context.CancelFunc
+
+	// writeMu serializes frames, which gorilla/websocket requires
+	writeMu sync.Mutex
+
+	mu sync.Mutex
+	// frameErr is a malformed frame from the browser, which fails the call
+	frameErr  error
+	requests  int
+	responses int
+}
+
+func (s *wsSession) run(ctx context.Context, newStream func(context.Context, protoreflect.MethodDescriptor, ...grpc.CallOption) (grpc.ClientStream, error)) error {
+	stream, err := newStream(ctx, s.method)
+	if err != nil {
+		s.finish(err, nil)
+		return err
+	}
+
+	sent := make(chan struct{})
+	go func() {
+		defer close(sent)
+		s.readRequests(stream)
+	}()
+
+	err = s.relayResponses(stream)
+	s.cancel()
+	s.mu.Lock()
+	if s.frameErr != nil {
+		err = s.frameErr
+	}
+	s.mu.Unlock()
+	s.finish(err, stream.Trailer())
+
+	// Closing the socket stops the reader
+	s.conn.Close()
+	<-sent
+	return err
+}
+
+// readRequests sends the requests of the browser until it half-closes; a
+// malformed frame or a closed socket cancels the call
+func (s *wsSession) readRequests(stream grpc.ClientStream) {
+	for {
+		_, data, err := s.conn.ReadMessage()
+		if err != nil {
+			s.cancel()
+			return
+		}
+
+		var frame clientFrame
+		if err := json.Unmarshal(data, &frame); err != nil {
+			s.fail(fmt.Errorf("%w: %w", ErrInvalidFrame, err))
+			return
+		}
+		if frame.Message == nil && !frame.HalfClose {
+			s.fail(fmt.Errorf("%w: expected \"message\" or \"halfClose\"", ErrInvalidFrame))
+			return
+		}
+
+		// Like the call command, a method without client streaming that is
+		// half-closed before any request gets an empty one
+		if frame.Message != nil || !s.method.IsStreamingClient() {
+			request := dynamicpb.NewMessage(s.method.Input())
+			if frame.Message != nil {
+				if err := protojson.Unmarshal(frame.Message, request); err != nil {
+					s.fail(fmt.Errorf("%w for %s: %w", ErrInvalidFrame, s.method.FullName(), err))
+					return
+				}
+			}
+			if err := stream.SendMsg(request); err != nil {
+				// The call ended; its status comes from RecvMsg
+				return
+			}
+			s.mu.Lock()
+			s.requests++
+			s.mu.Unlock()
+		}
+		if frame.HalfClose || !s.method.IsStreamingClient() {
+			stream.CloseSend()
+			s.drain()
+			return
+		}
+	}
+}
+
+// drain reads frames after the half-close so that a closed socket still
+// cancels the call
+func (s *wsSession) drain() {
+	for {
+		if _, _, err := s.conn.ReadMessage(); err != nil {
+			s.cancel()
+			return
+		}
+	}
+}
+
+func (s *wsSession) fail(err error) {
+	s.mu.Lock()
+	s.frameErr = err
+	s.mu.Unlock()
+	s.cancel()
+}
+
+// relayResponses sends the headers and every response to the browser
+func (s *wsSession) relayResponses(stream grpc.ClientStream) error {
+	header, err := stream.Header()
+	if header = responseMetadata(header); err == nil && len(header) > 0 {
+		if err := s.write(serverFrame{Header: header}); err != nil {
+			return err
+		}
+	}
+
+	for {
+		response := dynamicpb.NewMessage(s.method.Output())
+		if err := stream.RecvMsg(response); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		s.responses++
+		data, err := protojson.Marshal(response)
+		if err != nil {
+			return fmt.Errorf("failed to encode response: %w", err)
+		}
+		if err := s.write(serverFrame{Message: data}); err != nil {
+			return err
+		}
+	}
+}
+
+// finish sends the final status and trailers and closes the socket with a
+// code that reflects the status
+func (s *wsSession) finish(err error, trailer metadata.MD) {
+	st := toStatus(err)
+	s.write(serverFrame{Status: statusJSON(st), Trailer: trailer})
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(closeCode(err), closeReason(st)),
+		time.Now().Add(time.Second))
+}
+
+// closeCode returns the WebSocket close code of a call that ended with err
+func closeCode(err error) int {
+	switch {
+	case err == nil:
+		return websocket.CloseNormalClosure
+	case errors.Is(err, ErrInvalidFrame):
+		return websocket.ClosePolicyViolation
+	default:
+		return websocket.CloseInternalServerErr
+	}
+}
+
+// closeReason is the gRPC code name of a failed call; close reasons are
+// limited to 123 bytes, so the message stays in the status frame
+func closeReason(s *status.Status) string {
+	if s.Code() == codes.OK {
+		return ""
+	}
+	return s.Code().String()
+}
+
+func (s *wsSession) write(frame serverFrame) error {
+	data, err := json.Marshal(frame)
+	if err != nil {
+		return err
+	}
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return s.conn.WriteMessage(websocket.TextMessage, data)
+}
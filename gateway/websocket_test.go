@@ -0,0 +1,204 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	server "github.com/vedantkulkarni/reflect-poc/server"
+	service_proto "github.com/vedantkulkarni/reflect-poc/service-proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// relayServer serves the test service over bufconn and returns the URL of an
+// endpoint that relays GET /{method} upgrades to it like handleStream does
+func relayServer(t *testing.T) string {
+	t.Helper()
+	listener := bufconn.Listen(1 << 20)
+	grpcServer := grpc.NewServer()
+	service_proto.RegisterTestServiceServer(grpcServer, &server.MyTestService{})
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	cc, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	t.Cleanup(func() { cc.Close() })
+	newStream := func(ctx context.Context, method protoreflect.MethodDescriptor, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		desc := &grpc.StreamDesc{ClientStreams: method.IsStreamingClient(), ServerStreams: method.IsStreamingServer()}
+		return cc.NewStream(ctx, desc, "/"+string(method.Parent().FullName())+"/"+string(method.Name()), opts...)
+	}
+
+	service := service_proto.File_reflect_proto.Services().ByName("TestService")
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method := service.Methods().ByName(protoreflect.Name(strings.TrimPrefix(r.URL.Path, "/")))
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		session := &wsSession{conn: conn, method: method, cancel: cancel}
+		session.run(ctx, newStream)
+	}))
+	t.Cleanup(ts.Close)
+	return "ws" + strings.TrimPrefix(ts.URL, "http")
+}
+
+// wsCall is a WebSocket call from the point of view of the browser
+type wsCall struct {
+	t    *testing.T
+	conn *websocket.Conn
+}
+
+func dialCall(t *testing.T, url string) *wsCall {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("failed to open WebSocket: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	return &wsCall{t: t, conn: conn}
+}
+
+func (c *wsCall) send(frame string) {
+	c.t.Helper()
+	if err := c.conn.WriteMessage(websocket.TextMessage, []byte(frame)); err != nil {
+		c.t.Fatalf("failed to send %s: %v", frame, err)
+	}
+}
+
+// next returns the next frame other than the response headers, or nil once
+// the socket is closed along with its close code
+func (c *wsCall) next() (*serverFrame, int) {
+	c.t.Helper()
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			var closeErr *websocket.CloseError
+			if !errors.As(err, &closeErr) {
+				c.t.Fatalf("failed to read frame: %v", err)
+			}
+			return nil, closeErr.Code
+		}
+		var frame serverFrame
+		if err := json.Unmarshal(data, &frame); err != nil {
+			c.t.Fatalf("failed to decode frame %s: %v", data, err)
+		}
+		if frame.Header == nil {
+			return &frame, 0
+		}
+	}
+}
+
+// message returns the "message" field of the next response
+func (c *wsCall) message() string {
+	c.t.Helper()
+	frame, _ := c.next()
+	if frame == nil || frame.Message == nil {
+		c.t.Fatalf("got %+v, want a response", frame)
+	}
+	var response struct{ Message string }
+	if err := json.Unmarshal(frame.Message, &response); err != nil {
+		c.t.Fatalf("failed to decode response: %v", err)
+	}
+	return response.Message
+}
+
+// end returns the code of the final status frame and the close code
+func (c *wsCall) end() (codes.Code, int) {
+	c.t.Helper()
+	frame, _ := c.next()
+	if frame == nil || frame.Status == nil {
+		c.t.Fatalf("got %+v, want the status", frame)
+	}
+	var s struct{ Code codes.Code }
+	if err := json.Unmarshal(frame.Status, &s); err != nil {
+		c.t.Fatalf("failed to decode status %s: %v", frame.Status, err)
+	}
+	frame, closeCode := c.next()
+	if frame != nil {
+		c.t.Fatalf("got %+v after the status, want the socket closed", frame)
+	}
+	return s.Code, closeCode
+}
+
+func TestWebSocketClientStreaming(t *testing.T) {
+	call := dialCall(t, relayServer(t)+"/TestClientStream")
+	call.send(`{"message": {"message": "a"}}`)
+	call.send(`{"message": {"message": "b"}}`)
+	call.send(`{"halfClose": true}`)
+
+	if got, want := call.message(), "Received messages: a, b"; got != want {
+		t.Errorf("response %q, want %q", got, want)
+	}
+	if code, closeCode := call.end(); code != codes.OK || closeCode != websocket.CloseNormalClosure {
+		t.Errorf("ended with %v and close code %d, want OK and %d", code, closeCode, websocket.CloseNormalClosure)
+	}
+}
+
+func TestWebSocketBidiStreaming(t *testing.T) {
+	call := dialCall(t, relayServer(t)+"/TestBidiStream")
+	// Each response arrives before the next request is sent
+	for _, message := range []string{"a", "b"} {
+		call.send(`{"message": {"message": "` + message + `"}}`)
+		if got, want := call.message(), "Received: "+message; got != want {
+			t.Errorf("response %q, want %q", got, want)
+		}
+	}
+	call.send(`{"halfClose": true}`)
+	if code, closeCode := call.end(); code != codes.OK || closeCode != websocket.CloseNormalClosure {
+		t.Errorf("ended with %v and close code %d, want OK and %d", code, closeCode, websocket.CloseNormalClosure)
+	}
+}
+
+func TestWebSocketMalformedFrame(t *testing.T) {
+	for _, frame := range []string{
+		`not json`,
+		`{}`,
+		`{"message": {"unknown": 1}}`,
+	} {
+		call := dialCall(t, relayServer(t)+"/TestClientStream")
+		call.send(frame)
+		if code, closeCode := call.end(); code != codes.InvalidArgument || closeCode != websocket.ClosePolicyViolation {
+			t.Errorf("%s: ended with %v and close code %d, want InvalidArgument and %d", frame, code, closeCode, websocket.ClosePolicyViolation)
+		}
+	}
+}
+
+// TestWebSocketRejectsCrossOriginUpgrades checks that a page on another site
+// cannot open a call, and that no device client is opened for it
+func TestWebSocketRejectsCrossOriginUpgrades(t *testing.T) {
+	// The gateway has no pool, so resolving the device would panic
+	g := &Gateway{AllowedOrigins: []string{"https://console.example"}}
+	ts := httptest.NewServer(g.Handler())
+	defer ts.Close()
+
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/echo-service1/reflect.TestService/TestBidiStream"
+	_, resp, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {"https://evil.example"}})
+	if err == nil {
+		t.Fatal("accepted an upgrade from another origin")
+	}
+	if resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("got %v, want status %d", err, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	client "github.com/vedantkulkarni/reflect-poc/client"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// errStopWatch ends a health check after the first status
+var errStopWatch = errors.New("stop watching")
+
+// runHealth prints the health of a device and fails unless it is SERVING.
+// With watch set it keeps printing every change until the device becomes
+// unhealthy, the stream breaks or the command is interrupted.
+func runHealth(args []string, watch bool) error {
+	command := "health"
+	if watch {
+		command = "watch"
+	}
+	fs := flag.NewFlagSet(command, flag.ExitOnError)
+	var opts clientOptions
+	opts.register(fs)
+	service := fs.String("service", "", "service to check; empty checks the device as a whole")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s %s [flags] [bridge-id]\n", os.Args[0], command)
+		if watch {
+			fmt.Fprintln(fs.Output(), "Prints every status change and exits non-zero once the device is not SERVING.")
+		} else {
+			fmt.Fprintln(fs.Output(), "Prints the status of the device and exits non-zero unless it is SERVING.")
+		}
+		fs.PrintDefaults()
+	}
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return errUsage
+	}
+	bridgeID := opts.bridgeID
+	if fs.NArg() == 1 {
+		bridgeID = fs.Arg(0)
+	}
+
+	target := bridgeID
+	if *service != "" {
+		target += " " + *service
+	}
+
+	var reflectionClient *client.ReflectionClient
+	var ctx context.Context
+	var err error
+	if watch {
+		// A watch has no overall timeout; it runs until interrupted
+		reflectionClient, err = opts.dial(bridgeID)
+		if err != nil {
+			return err
+		}
+		defer opts.close()
+		defer reflectionClient.Close()
+		var stop context.CancelFunc
+		ctx, stop = signal.NotifyContext(opts.withHeaders(context.Background()), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+	} else {
+		var closeClient context.CancelFunc
+		reflectionClient, ctx, closeClient, err = opts.connect(bridgeID)
+		if err != nil {
+			return err
+		}
+		defer closeClient()
+	}
+
+	var last healthpb.HealthCheckResponse_ServingStatus
+	err = reflectionClient.WatchHealth(ctx, *service, func(status healthpb.HealthCheckResponse_ServingStatus) error {
+		last = status
+		if watch {
+			fmt.Printf("%s %s %s\n", time.Now().Format(time.RFC3339), target, status)
+		} else {
+			fmt.Printf("%s %s\n", target, status)
+		}
+		if status != healthpb.HealthCheckResponse_SERVING {
+			return fmt.Errorf("%s is %s", target, status)
+		}
+		if !watch {
+			return errStopWatch
+		}
+		return nil
+	})
+	switch {
+	case errors.Is(err, errStopWatch):
+		return nil
+	case watch && ctx.Err() != nil && last == healthpb.HealthCheckResponse_SERVING:
+		// Interrupted while healthy
+		return nil
+	case err == nil:
+		return fmt.Errorf("%s: health stream ended", target)
+	}
+	return err
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/vedantkulkarni/reflect-poc/reflection"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// runList prints the services of a device, or the methods of one service
+func runList(args []string) error {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	var opts clientOptions
+	opts.register(fs)
+	opts.registerOfflineFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s list [flags] [bridge-id] [service]\n", os.Args[0])
+		fmt.Fprintln(fs.Output(), "The bridge ID defaults to -bridge-id. A lone argument containing '.' is a service.")
+		fs.PrintDefaults()
+	}
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	bridgeID, serviceName, err := listArgs(fs, opts.bridgeID)
+	if err != nil {
+		return err
+	}
+
+	source, ctx, closeSource, err := opts.schema(bridgeID)
+	if err != nil {
+		return err
+	}
+	defer closeSource()
+
+	if serviceName == "" {
+		services, err := source.ListServices(ctx)
+		if err != nil {
+			return err
+		}
+		for _, service := range services {
+			fmt.Println(service)
+		}
+		return nil
+	}
+
+	desc, err := source.FindSymbol(ctx, serviceName)
+	if err != nil {
+		return err
+	}
+	service, ok := desc.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return fmt.Errorf("%s is not a service", desc.FullName())
+	}
+	for i := 0; i < service.Methods().Len(); i++ {
+		fmt.Println(service.Methods().Get(i).FullName())
+	}
+	return nil
+}
+
+// listArgs returns the [bridge-id] [service] arguments of list. Services are
+// qualified by their package, so a lone argument containing '.' is a service
+// and any other a bridge ID; bridge IDs containing '.' come with a service or
+// through -bridge-id.
+func listArgs(fs *flag.FlagSet, defaultBridgeID string) (string, string, error) {
+	switch fs.NArg() {
+	case 0:
+		return defaultBridgeID, "", nil
+	case 1:
+		if strings.Contains(fs.Arg(0), ".") {
+			return defaultBridgeID, fs.Arg(0), nil
+		}
+		return fs.Arg(0), "", nil
+	case 2:
+		return fs.Arg(0), fs.Arg(1), nil
+	}
+	fs.Usage()
+	return "", "", errUsage
+}
+
+// runDescribe prints the definition of a service, method, message or enum
+func runDescribe(args []string) error {
+	fs := flag.NewFlagSet("describe", flag.ExitOnError)
+	var opts clientOptions
+	opts.register(fs)
+	opts.registerOfflineFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s describe [flags] [bridge-id] <symbol>\n", os.Args[0])
+		fmt.Fprintln(fs.Output(), "The bridge ID defaults to -bridge-id.")
+		fs.PrintDefaults()
+	}
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	bridgeID, symbol, err := methodArgs(fs, opts.bridgeID)
+	if err != nil {
+		return err
+	}
+
+	source, ctx, closeSource, err := opts.schema(bridgeID)
+	if err != nil {
+		return err
+	}
+	defer closeSource()
+
+	desc, err := source.FindSymbol(ctx, symbol)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s is a %s:\n", desc.FullName(), kindOf(desc))
+	fmt.Print(reflection.Describe(desc))
+	return nil
+}
+
+func kindOf(desc protoreflect.Descriptor) string {
+	switch desc.(type) {
+	case protoreflect.ServiceDescriptor:
+		return "service"
+	case protoreflect.MethodDescriptor:
+		return "method"
+	case protoreflect.MessageDescriptor:
+		return "message"
+	case protoreflect.EnumDescriptor:
+		return "enum"
+	case protoreflect.FieldDescriptor:
+		return "field"
+	}
+	return "symbol"
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// logOptions are the flags that configure the logger; logs always go to stderr
+type logOptions struct {
+	level  string
+	format string
+}
+
+func (o *logOptions) register(fs *flag.FlagSet, defaultLevel string) {
+	fs.StringVar(&o.level, "log-level", defaultLevel, "minimum log level: debug, info, warn, error")
+	fs.StringVar(&o.format, "log-format", "json", "log format: json or console")
+}
+
+func (o *logOptions) logger() (*zap.Logger, error) {
+	level, err := zapcore.ParseLevel(o.level)
+	if err != nil {
+		return nil, fmt.Errorf("invalid log level: %w", err)
+	}
+	if o.format != "json" && o.format != "console" {
+		return nil, fmt.Errorf("invalid log format %q", o.format)
+	}
+
+	cfg := zap.NewProductionConfig()
+	cfg.Level = zap.NewAtomicLevelAt(level)
+	cfg.Encoding = o.format
+	if o.format == "console" {
+		cfg.EncoderConfig = zap.NewDevelopmentEncoderConfig()
+	}
+	cfg.OutputPaths = []string{"stderr"}
+	cfg.ErrorOutputPaths = []string{"stderr"}
+	return cfg.Build()
+}
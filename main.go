@@ -1,84 +1,96 @@
 package main
 
 import (
-	"context"
+	"errors"
 	"fmt"
-	"log"
 	"os"
-	"os/signal"
-	"syscall"
-	"time"
-
-	mqtt "github.com/eclipse/paho.mqtt.golang"
-	bridge "github.com/golain-io/mqtt-bridge"
-	client "github.com/vedantkulkarni/reflect-poc/client"
-	server "github.com/vedantkulkarni/reflect-poc/server"
-	service_proto "github.com/vedantkulkarni/reflect-poc/service-proto"
-	"go.uber.org/zap"
-
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/reflection"
 )
 
-func main() {
-
-	// Create MQTT client
-	opts := mqtt.NewClientOptions().
-		AddBroker("tcp://localhost:1883").
-		SetClientID("echo-net-service")
+// errUsage is returned by commands after they printed their usage
+var errUsage = errors.New("invalid usage")
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `usage: %s <command> [flags] [args]
+
+Commands:
+  serve      run the gRPC-over-MQTT server
+  call       call a method of a device with JSON requests
+  list       list the services of a device, or the methods of a service
+  describe   describe a service, method, message or enum of a device
+  encode     encode JSON requests of a method as protobuf messages
+  decode     decode protobuf messages of a method as JSON
+  shell      open an interactive prompt connected to a device
+  health     check the health of a device
+  watch      monitor the health of a device until it becomes unhealthy
+  discover   list the devices announced on the broker
+  gateway    serve HTTP/JSON calls to devices
+  grpc-web   serve gRPC-Web calls to devices
+  ui         serve a web page to browse and call devices
+  export     save the descriptors of a device as a protoset
+  mock       serve a mock device from a protoset or another device
+  proxy      forward calls to downstream devices by metadata or method prefix
+  broker     run an MQTT broker for devices and clients on this machine
+
+Run '%s <command> -h' for the flags of a command.
+`, os.Args[0], os.Args[0])
+}
 
-	mqttClient := mqtt.NewClient(opts)
-	token := mqttClient.Connect()
-	if token.Wait() && token.Error() != nil {
-		log.Fatal("Failed to connect to MQTT broker:", token.Error())
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
 	}
-	defer mqttClient.Disconnect(0)
-
-	logger, _ := zap.NewProduction()
-	netBridge := bridge.NewMQTTNetBridge(mqttClient, logger, "echo-service1")
-
-	grpcServer := grpc.NewServer()
-	testService := &server.MyTestService{}
-	service_proto.RegisterTestServiceServer(grpcServer, testService)
-	syncService := &server.MySyncService{}
-	service_proto.RegisterSyncServiceServer(grpcServer, syncService)
-
-	reflection.RegisterV1(grpcServer)
 
-	go createClient(os.Args)
-
-	// Add error handling for Serve
-	if err := grpcServer.Serve(netBridge); err != nil {
-		log.Fatalf("failed to serve: %v", err)
+	var err error
+	switch command, args := os.Args[1], os.Args[2:]; command {
+	case "serve":
+		err = runServe(args)
+	case "call":
+		err = runCall(args)
+	case "list":
+		err = runList(args)
+	case "describe":
+		err = runDescribe(args)
+	case "encode":
+		err = runEncode(args)
+	case "decode":
+		err = runDecode(args)
+	case "shell":
+		err = runShell(args)
+	case "health":
+		err = runHealth(args, false)
+	case "watch":
+		err = runHealth(args, true)
+	case "discover":
+		err = runDiscover(args)
+	case "gateway":
+		err = runGateway(args)
+	case "grpc-web":
+		err = runGRPCWeb(args)
+	case "ui":
+		err = runUI(args)
+	case "export":
+		err = runExport(args)
+	case "mock":
+		err = runMock(args)
+	case "proxy":
+		err = runProxy(args)
+	case "broker":
+		err = runBroker(args)
+	case "help", "-h", "-help", "--help":
+		usage()
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", command)
+		usage()
+		os.Exit(2)
 	}
 
-	// Wait for interrupt signal
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	<-sigChan
-
-	logger.Info("Shutting down server...")
-	netBridge.Close()
-}
-
-// create a new grpc client to fetch server methods based on grpc reflection
-func createClient(args []string) {
-	time.Sleep(5 * time.Second)
-
-	fmt.Println("Creating client")
-
-	// GetNewGRPCMQTTClient returns a new grpc client connection and a new mqtt bridge
-
-	reflectionClient := client.NewReflectionClient()
-	switch args[1] {
-	case "unary":
-		reflectionClient.TestUnaryRPC(context.Background())
-	case "server_stream":
-		reflectionClient.TestServerStreamRPC(context.Background())
-	case "client_stream":
-		reflectionClient.TestClientStreamRPC(context.Background())
-	case "bidi_stream":
-		reflectionClient.TestBidiStreamRPC(context.Background())
+	if errors.Is(err, errUsage) {
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
 	}
-
 }
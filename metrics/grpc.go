@@ -0,0 +1,146 @@
+package metrics
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryServerInterceptor records unary calls handled by the server
+func (m *Metrics) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		m.server.observe(info.FullMethod, start, err)
+		return resp, err
+	}
+}
+
+// UnknownMethod is the method label of calls handled by an unknown-service
+// handler, such as those of the mock and the proxy, whose method names come
+// from callers and would make the number of series unbounded
+const UnknownMethod = "unknown"
+
+// StreamServerInterceptor records streaming calls handled by the server
+func (m *Metrics) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		// gRPC passes no service to unknown-service handlers
+		method := info.FullMethod
+		if srv == nil {
+			method = UnknownMethod
+		}
+		active := m.server.activeStreams.WithLabelValues(method)
+		active.Inc()
+		defer active.Dec()
+
+		err := handler(srv, &serverStream{ServerStream: ss, rpc: m.server, method: method})
+		m.server.observe(method, start, err)
+		return err
+	}
+}
+
+// UnaryClientInterceptor records unary calls made by the client
+func (m *Metrics) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		start := time.Now()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		m.client.observe(method, start, err)
+		return err
+	}
+}
+
+// StreamClientInterceptor records streaming calls made by the client. A
+// stream is complete once RecvMsg returns an error, io.EOF included, or with
+// the single response of a client-streaming call. A stream abandoned before
+// then is complete when its context ends.
+func (m *Metrics) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		start := time.Now()
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			m.client.observe(method, start, err)
+			return nil, err
+		}
+		m.client.activeStreams.WithLabelValues(method).Inc()
+		s := &clientStream{ClientStream: cs, rpc: m.client, method: method, start: start, serverStreams: desc.ServerStreams}
+		s.stop = context.AfterFunc(ctx, func() {
+			s.finish(status.FromContextError(ctx.Err()).Err())
+		})
+		return s, nil
+	}
+}
+
+func (r rpcMetrics) observe(method string, start time.Time, err error) {
+	code := status.Code(err).String()
+	r.handled.WithLabelValues(method, code).Inc()
+	r.duration.WithLabelValues(method, code).Observe(time.Since(start).Seconds())
+}
+
+// serverStream counts the messages of a server stream
+type serverStream struct {
+	grpc.ServerStream
+	rpc    rpcMetrics
+	method string
+}
+
+func (s *serverStream) RecvMsg(m any) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		s.rpc.msgReceived.WithLabelValues(s.method).Inc()
+	}
+	return err
+}
+
+func (s *serverStream) SendMsg(m any) error {
+	err := s.ServerStream.SendMsg(m)
+	if err == nil {
+		s.rpc.msgSent.WithLabelValues(s.method).Inc()
+	}
+	return err
+}
+
+// clientStream counts the messages of a client stream and records the call
+// when the stream ends
+type clientStream struct {
+	grpc.ClientStream
+	rpc           rpcMetrics
+	method        string
+	start         time.Time
+	serverStreams bool
+	// stop cancels the context.AfterFunc that finishes abandoned streams
+	stop func() bool
+	once sync.Once
+}
+
+func (s *clientStream) SendMsg(m any) error {
+	err := s.ClientStream.SendMsg(m)
+	if err == nil {
+		s.rpc.msgSent.WithLabelValues(s.method).Inc()
+	}
+	return err
+}
+
+func (s *clientStream) RecvMsg(m any) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err == nil {
+		s.rpc.msgReceived.WithLabelValues(s.method).Inc()
+		if s.serverStreams {
+			return nil
+		}
+	}
+	s.stop()
+	s.finish(ignoreEOF(err))
+	return err
+}
+
+// finish records the call once, when it ends or its context does
+func (s *clientStream) finish(err error) {
+	s.once.Do(func() {
+		s.rpc.activeStreams.WithLabelValues(s.method).Dec()
+		s.rpc.observe(s.method, s.start, err)
+	})
+}
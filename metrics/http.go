@@ -0,0 +1,70 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
+)
+
+// Handler returns the HTTP handler exposing the metrics in the Prometheus text format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// Server serves /metrics on a local listener
+type Server struct {
+	listener net.Listener
+	http     *http.Server
+}
+
+// Serve starts serving /metrics on addr, for example 127.0.0.1:9090. A nil
+// logger disables logging.
+func (m *Metrics) Serve(addr string, logger *zap.Logger) (*Server, error) {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for metrics: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m.Handler())
+	s := &Server{
+		listener: listener,
+		http:     &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second},
+	}
+	go func() {
+		if err := s.http.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("Metrics listener stopped", zap.Error(err))
+		}
+	}()
+	logger.Info("Serving metrics", zap.String("addr", listener.Addr().String()))
+	return s, nil
+}
+
+// Addr returns the address the metrics are served on
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Close stops the listener, waiting briefly for in-flight scrapes
+func (s *Server) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	return s.http.Shutdown(ctx)
+}
+
+func ignoreEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	return err
+}
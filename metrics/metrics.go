@@ -0,0 +1,165 @@
+package metrics
+
+import (
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// namespace prefixes every metric name
+const namespace = "reflect"
+
+// Metrics holds the Prometheus collectors for gRPC calls over the bridge,
+// MQTT traffic and the reflection cache, in a registry of its own
+type Metrics struct {
+	registry *prometheus.Registry
+
+	server rpcMetrics
+	client rpcMetrics
+
+	mqttPublished      *prometheus.CounterVec
+	mqttPublishedBytes *prometheus.CounterVec
+	mqttReceived       *prometheus.CounterVec
+	mqttReceivedBytes  *prometheus.CounterVec
+	mqttSubscriptions  *prometheus.CounterVec
+	mqttReconnects     prometheus.Counter
+	reflectionLookups  *prometheus.CounterVec
+}
+
+// rpcMetrics are the call metrics of one side of a connection
+type rpcMetrics struct {
+	handled       *prometheus.CounterVec
+	duration      *prometheus.HistogramVec
+	msgReceived   *prometheus.CounterVec
+	msgSent       *prometheus.CounterVec
+	activeStreams *prometheus.GaugeVec
+}
+
+func newRPCMetrics(side string) rpcMetrics {
+	subsystem := "grpc_" + side
+	return rpcMetrics{
+		handled: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: subsystem, Name: "handled_total",
+			Help: "Completed RPCs by method and status code.",
+		}, []string{"method", "code"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace, Subsystem: subsystem, Name: "handling_seconds",
+			Help: "RPC latency by method and status code.",
+			// Calls cross the broker twice, so latency starts in the milliseconds
+			Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+		}, []string{"method", "code"}),
+		msgReceived: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: subsystem, Name: "msg_received_total",
+			Help: "Stream messages received by method.",
+		}, []string{"method"}),
+		msgSent: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: subsystem, Name: "msg_sent_total",
+			Help: "Stream messages sent by method.",
+		}, []string{"method"}),
+		activeStreams: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace, Subsystem: subsystem, Name: "active_streams",
+			Help: "Streams currently open by method.",
+		}, []string{"method"}),
+	}
+}
+
+func (r rpcMetrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{r.handled, r.duration, r.msgReceived, r.msgSent, r.activeStreams}
+}
+
+// New creates the collectors in a new registry, together with the Go runtime
+// and process collectors
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		server:   newRPCMetrics("server"),
+		client:   newRPCMetrics("client"),
+		mqttPublished: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: "mqtt", Name: "published_total",
+			Help: "MQTT messages published by topic kind.",
+		}, []string{"kind"}),
+		mqttPublishedBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: "mqtt", Name: "published_bytes_total",
+			Help: "MQTT payload bytes published by topic kind.",
+		}, []string{"kind"}),
+		mqttReceived: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: "mqtt", Name: "received_total",
+			Help: "MQTT messages received by topic kind.",
+		}, []string{"kind"}),
+		mqttReceivedBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: "mqtt", Name: "received_bytes_total",
+			Help: "MQTT payload bytes received by topic kind.",
+		}, []string{"kind"}),
+		mqttSubscriptions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: "mqtt", Name: "subscriptions_total",
+			Help: "MQTT subscriptions made by topic kind, including those replayed after a reconnect.",
+		}, []string{"kind"}),
+		mqttReconnects: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: "mqtt", Name: "reconnects_total",
+			Help: "Times the broker connection was restored after it dropped.",
+		}),
+		reflectionLookups: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: "reflection", Name: "cache_lookups_total",
+			Help: "Reflection symbol lookups by result (hit or miss) of the descriptor cache.",
+		}, []string{"result"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.mqttPublished, m.mqttPublishedBytes, m.mqttReceived, m.mqttReceivedBytes,
+		m.mqttSubscriptions, m.mqttReconnects, m.reflectionLookups,
+	)
+	m.registry.MustRegister(m.server.collectors()...)
+	m.registry.MustRegister(m.client.collectors()...)
+	return m
+}
+
+// Registry returns the registry holding the collectors, for registering more
+func (m *Metrics) Registry() *prometheus.Registry {
+	return m.registry
+}
+
+// Published records an MQTT publish
+func (m *Metrics) Published(topic string, bytes int) {
+	kind := topicKind(topic)
+	m.mqttPublished.WithLabelValues(kind).Inc()
+	m.mqttPublishedBytes.WithLabelValues(kind).Add(float64(bytes))
+}
+
+// Received records an MQTT message delivered to a subscription
+func (m *Metrics) Received(topic string, bytes int) {
+	kind := topicKind(topic)
+	m.mqttReceived.WithLabelValues(kind).Inc()
+	m.mqttReceivedBytes.WithLabelValues(kind).Add(float64(bytes))
+}
+
+// Subscribed records an MQTT subscription
+func (m *Metrics) Subscribed(topic string) {
+	m.mqttSubscriptions.WithLabelValues(topicKind(topic)).Inc()
+}
+
+// Reconnected records a restored broker connection
+func (m *Metrics) Reconnected() {
+	m.mqttReconnects.Inc()
+}
+
+// CacheLookup records a reflection cache lookup
+func (m *Metrics) CacheLookup(hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	m.reflectionLookups.WithLabelValues(result).Inc()
+}
+
+// topicKind reduces a topic to a label of bounded cardinality: bridge topics
+// such as /bridge/session/<bridge>/<session>/up become "session"
+func topicKind(topic string) string {
+	parts := strings.Split(strings.TrimPrefix(topic, "/"), "/")
+	if len(parts) >= 2 && parts[0] == "bridge" {
+		return parts[1]
+	}
+	return "other"
+}
@@ -0,0 +1,228 @@
+package metrics_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	metrics "github.com/vedantkulkarni/reflect-poc/metrics"
+	reflection "github.com/vedantkulkarni/reflect-poc/reflection"
+	server "github.com/vedantkulkarni/reflect-poc/server"
+	service_proto "github.com/vedantkulkarni/reflect-poc/service-proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	grpcreflection "google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// dial serves the test services with server metrics, and opts, on an
+// in-memory listener and returns a connection instrumented with client metrics
+func dial(t *testing.T, m *metrics.Metrics, opts ...grpc.ServerOption) *grpc.ClientConn {
+	t.Helper()
+	listener := bufconn.Listen(1 << 20)
+	grpcServer := grpc.NewServer(append(server.Interceptors{Metrics: m}.ServerOptions(), opts...)...)
+	service_proto.RegisterTestServiceServer(grpcServer, &server.MyTestService{})
+	grpcreflection.RegisterV1(grpcServer)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithChainUnaryInterceptor(m.UnaryClientInterceptor()),
+		grpc.WithChainStreamInterceptor(m.StreamClientInterceptor()),
+	)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func scrape(t *testing.T, addr string) string {
+	t.Helper()
+	resp, err := http.Get("http://" + addr + "/metrics")
+	if err != nil {
+		t.Fatalf("failed to scrape metrics: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("scrape returned %s", resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read metrics: %v", err)
+	}
+	return string(body)
+}
+
+func TestScrape(t *testing.T) {
+	m := metrics.New()
+	conn := dial(t, m)
+	ctx := context.Background()
+	client := service_proto.NewTestServiceClient(conn)
+
+	if _, err := client.Test(ctx, &service_proto.TestMessageRequest{Message: "hi"}); err != nil {
+		t.Fatalf("Test failed: %v", err)
+	}
+	stream, err := client.TestServerStream(ctx, &service_proto.TestMessageRequest{})
+	if err != nil {
+		t.Fatalf("TestServerStream failed: %v", err)
+	}
+	for {
+		if _, err := stream.Recv(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("TestServerStream failed: %v", err)
+		}
+	}
+
+	// A client-streaming call ends with its single response, without a further RecvMsg
+	clientStream, err := client.TestClientStream(ctx)
+	if err != nil {
+		t.Fatalf("TestClientStream failed: %v", err)
+	}
+	for _, message := range []string{"a", "b", "c"} {
+		if err := clientStream.Send(&service_proto.TestMessageRequest{Message: message}); err != nil {
+			t.Fatalf("TestClientStream failed: %v", err)
+		}
+	}
+	if _, err := clientStream.CloseAndRecv(); err != nil {
+		t.Fatalf("TestClientStream failed: %v", err)
+	}
+
+	// The first lookup fetches the file through reflection, the second hits the cache
+	helper := reflection.NewGRPCReflectionHelper(conn, nil)
+	helper.OnCacheLookup(m.CacheLookup)
+	for i := 0; i < 2; i++ {
+		if _, err := helper.FindSymbol(ctx, "reflect.TestService/Test"); err != nil {
+			t.Fatalf("FindSymbol failed: %v", err)
+		}
+	}
+
+	m.Subscribed("/bridge/session/echo-service1/+/up")
+	m.Published("/bridge/session/echo-service1/abc/down", 12)
+	m.Received("/bridge/session/echo-service1/abc/up", 30)
+	m.Reconnected()
+
+	metricsServer, err := m.Serve("127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatalf("failed to serve metrics: %v", err)
+	}
+	defer metricsServer.Close()
+	body := scrape(t, metricsServer.Addr())
+
+	for _, want := range []string{
+		`reflect_grpc_server_handled_total{code="OK",method="/reflect.TestService/Test"} 1`,
+		`reflect_grpc_client_handled_total{code="OK",method="/reflect.TestService/Test"} 1`,
+		`reflect_grpc_server_handled_total{code="OK",method="/reflect.TestService/TestServerStream"} 1`,
+		`reflect_grpc_client_handled_total{code="OK",method="/reflect.TestService/TestServerStream"} 1`,
+		`reflect_grpc_server_handling_seconds_count{code="OK",method="/reflect.TestService/Test"} 1`,
+		`reflect_grpc_server_msg_sent_total{method="/reflect.TestService/TestServerStream"} 5`,
+		`reflect_grpc_client_msg_received_total{method="/reflect.TestService/TestServerStream"} 5`,
+		`reflect_grpc_server_active_streams{method="/reflect.TestService/TestServerStream"} 0`,
+		`reflect_grpc_client_active_streams{method="/reflect.TestService/TestServerStream"} 0`,
+		`reflect_grpc_server_handled_total{code="OK",method="/reflect.TestService/TestClientStream"} 1`,
+		`reflect_grpc_client_handled_total{code="OK",method="/reflect.TestService/TestClientStream"} 1`,
+		`reflect_grpc_server_msg_received_total{method="/reflect.TestService/TestClientStream"} 3`,
+		`reflect_grpc_client_msg_sent_total{method="/reflect.TestService/TestClientStream"} 3`,
+		`reflect_grpc_client_msg_received_total{method="/reflect.TestService/TestClientStream"} 1`,
+		`reflect_grpc_server_active_streams{method="/reflect.TestService/TestClientStream"} 0`,
+		`reflect_grpc_client_active_streams{method="/reflect.TestService/TestClientStream"} 0`,
+		`reflect_reflection_cache_lookups_total{result="hit"} 1`,
+		`reflect_reflection_cache_lookups_total{result="miss"} 1`,
+		`reflect_mqtt_subscriptions_total{kind="session"} 1`,
+		`reflect_mqtt_published_total{kind="session"} 1`,
+		`reflect_mqtt_published_bytes_total{kind="session"} 12`,
+		`reflect_mqtt_received_total{kind="session"} 1`,
+		`reflect_mqtt_received_bytes_total{kind="session"} 30`,
+		`reflect_mqtt_reconnects_total 1`,
+	} {
+		if !strings.Contains(body, want+"\n") {
+			t.Errorf("scrape is missing %s", want)
+		}
+	}
+}
+
+// scrapeUntil scrapes m until the scrape contains every line of want
+func scrapeUntil(t *testing.T, m *metrics.Metrics, want ...string) string {
+	t.Helper()
+	metricsServer, err := m.Serve("127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatalf("failed to serve metrics: %v", err)
+	}
+	defer metricsServer.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		body := scrape(t, metricsServer.Addr())
+		missing := ""
+		for _, line := range want {
+			if !strings.Contains(body, line+"\n") {
+				missing = line
+				break
+			}
+		}
+		if missing == "" {
+			return body
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("scrape is missing %s", missing)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestAbandonedClientStream checks that a stream the caller stops reading is
+// recorded once its context ends
+func TestAbandonedClientStream(t *testing.T) {
+	m := metrics.New()
+	client := service_proto.NewTestServiceClient(dial(t, m))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := client.TestServerStream(ctx, &service_proto.TestMessageRequest{})
+	if err != nil {
+		t.Fatalf("TestServerStream failed: %v", err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		t.Fatalf("TestServerStream failed: %v", err)
+	}
+	scrapeUntil(t, m, `reflect_grpc_client_active_streams{method="/reflect.TestService/TestServerStream"} 1`)
+
+	cancel()
+	scrapeUntil(t, m,
+		`reflect_grpc_client_active_streams{method="/reflect.TestService/TestServerStream"} 0`,
+		`reflect_grpc_client_handled_total{code="Canceled",method="/reflect.TestService/TestServerStream"} 1`)
+}
+
+// TestUnknownMethodsShareALabel checks that the method names callers send to
+// an unknown-service handler do not become label values
+func TestUnknownMethodsShareALabel(t *testing.T) {
+	m := metrics.New()
+	conn := dial(t, m, grpc.UnknownServiceHandler(func(any, grpc.ServerStream) error {
+		return status.Error(codes.Unimplemented, "no such method")
+	}))
+	for _, method := range []string{"/made.Up/One", "/made.Up/Two"} {
+		err := conn.Invoke(context.Background(), method, &service_proto.TestMessageRequest{}, &service_proto.TestMessageResponse{})
+		if status.Code(err) != codes.Unimplemented {
+			t.Fatalf("%s returned %v, want Unimplemented", method, err)
+		}
+	}
+
+	body := scrapeUntil(t, m,
+		`reflect_grpc_server_handled_total{code="Unimplemented",method="unknown"} 2`,
+		`reflect_grpc_server_active_streams{method="unknown"} 0`)
+	for _, line := range strings.Split(body, "\n") {
+		if strings.HasPrefix(line, "reflect_grpc_server_") && strings.Contains(line, `method="/made.Up`) {
+			t.Errorf("scrape has a server series for a method name sent by a caller: %s", line)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	bridge "github.com/golain-io/mqtt-bridge"
+	client "github.com/vedantkulkarni/reflect-poc/client"
+	mock "github.com/vedantkulkarni/reflect-poc/mock"
+	server "github.com/vedantkulkarni/reflect-poc/server"
+	transport "github.com/vedantkulkarni/reflect-poc/transport"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// exportTimeout bounds reading the descriptors of the device -from-bridge mocks
+const exportTimeout = 30 * time.Second
+
+// runMock serves mocked services from descriptors until SIGINT or SIGTERM
+func runMock(args []string) error {
+	fs := flag.NewFlagSet("mock", flag.ExitOnError)
+	broker := transport.BrokerConfig{ClientID: "reflect-mock"}
+	registerBrokerFlags(fs, &broker)
+	bridgeID := fs.String("bridge-id", "mock-device", "bridge ID the mock device listens on")
+	listen := fs.String("listen", "", "serve over TCP on this address instead of MQTT, e.g. 127.0.0.1:50051")
+	protoset := fs.String("protoset", "", "FileDescriptorSet defining the mocked services, see the export command")
+	fromBridge := fs.String("from-bridge", "", "mock the services of this device, read through its reflection service")
+	rulesFile := fs.String("rules", "", "YAML or JSON file of the responses to serve")
+	synthesize := fs.Bool("synthesize", true, "answer calls no rule matches with responses generated from the output type")
+	streamResponses := fs.Int("stream-responses", mock.DefaultStreamResponses, "number of responses generated for server-streaming calls")
+	embeddedBroker := registerEmbeddedBrokerFlag(fs)
+	drainTimeout := fs.Duration("drain-timeout", server.DefaultDrainTimeout, "how long in-flight calls get to finish on shutdown")
+	var logOpts logOptions
+	logOpts.register(fs, "info")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if (*protoset == "") == (*fromBridge == "") {
+		fmt.Fprintln(os.Stderr, "exactly one of -protoset and -from-bridge is required")
+		fs.Usage()
+		return errUsage
+	}
+
+	logger, err := logOpts.logger()
+	if err != nil {
+		return err
+	}
+	defer logger.Sync()
+	broker.Logger = logger
+	if *embeddedBroker {
+		b, err := startEmbeddedBroker(&broker, logger)
+		if err != nil {
+			return err
+		}
+		defer b.Close()
+	}
+
+	var set *descriptorpb.FileDescriptorSet
+	if *protoset != "" {
+		set, err = readProtoset(*protoset)
+	} else {
+		set, err = exportFromDevice(broker, *fromBridge)
+	}
+	if err != nil {
+		return err
+	}
+
+	cfg := mock.Config{
+		Descriptors:     set,
+		Synthesize:      *synthesize,
+		StreamResponses: *streamResponses,
+		Logger:          logger,
+	}
+	if *rulesFile != "" {
+		if cfg.Rules, err = mock.LoadRules(*rulesFile); err != nil {
+			return err
+		}
+	}
+	mocked, err := mock.New(cfg)
+	if err != nil {
+		return err
+	}
+
+	interceptors := server.Interceptors{Logger: logger}
+	grpcServer := grpc.NewServer(append(interceptors.ServerOptions(), mocked.ServerOption())...)
+	mocked.RegisterReflection(grpcServer)
+	health := server.NewHealth(logger)
+	health.Register(grpcServer)
+	for _, service := range mocked.Services() {
+		health.SetServingStatus(service, healthpb.HealthCheckResponse_SERVING)
+	}
+
+	lifecycle := &server.Lifecycle{
+		GRPCServer:   grpcServer,
+		BridgeID:     *bridgeID,
+		DrainTimeout: *drainTimeout,
+		Health:       health,
+		Logger:       logger,
+	}
+	if *listen != "" {
+		if lifecycle.Listener, err = net.Listen("tcp", *listen); err != nil {
+			return fmt.Errorf("failed to listen on %s: %w", *listen, err)
+		}
+		logger.Info("Serving mock over TCP", zap.String("addr", lifecycle.Listener.Addr().String()), zap.Strings("services", mocked.Services()))
+	} else {
+		// The mock announces itself like the device it stands in for
+		services := server.ServiceNames(grpcServer)
+		for _, service := range mocked.Services() {
+			if _, ok := grpcServer.GetServiceInfo()[service]; !ok {
+				services = append(services, service)
+			}
+		}
+		lifecycle.Descriptors, err = server.DescriptorSet(mocked.Resolver(), services)
+		if err != nil {
+			return fmt.Errorf("failed to describe services: %w", err)
+		}
+		hash, err := server.DescriptorHash(lifecycle.Descriptors)
+		if err != nil {
+			return err
+		}
+		lifecycle.Presence = server.Presence{
+			BridgeID:       *bridgeID,
+			Online:         true,
+			Services:       services,
+			Version:        buildVersion() + "-mock",
+			DescriptorHash: hash,
+		}
+		if broker.Will, err = server.PresenceWill(lifecycle.Presence); err != nil {
+			return err
+		}
+
+		mqttClient, err := transport.Connect(broker)
+		if err != nil {
+			return err
+		}
+		netBridge := bridge.NewMQTTNetBridge(mqttClient, logger, *bridgeID)
+		lifecycle.MQTTClient = mqttClient
+		lifecycle.Listener = transport.BufferListener(mqttClient.TrackListener(netBridge))
+		logger.Info("Serving mock over MQTT", zap.String("bridge_id", *bridgeID), zap.Strings("services", mocked.Services()))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	if err := lifecycle.Run(ctx); err != nil {
+		return fmt.Errorf("failed to serve: %w", err)
+	}
+	return nil
+}
+
+// readProtoset reads a binary FileDescriptorSet
+func readProtoset(path string) (*descriptorpb.FileDescriptorSet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read protoset: %w", err)
+	}
+	set := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(data, set); err != nil {
+		return nil, fmt.Errorf("failed to parse protoset %s: %w", path, err)
+	}
+	return set, nil
+}
+
+// exportFromDevice reads the descriptors of bridgeID through its reflection
+// service, over a broker connection of its own
+func exportFromDevice(broker transport.BrokerConfig, bridgeID string) (*descriptorpb.FileDescriptorSet, error) {
+	broker.ClientID += "-export"
+	broker.Will = nil
+	reflectionClient, err := client.NewReflectionClient(client.Config{Broker: broker, BridgeID: bridgeID, Logger: broker.Logger})
+	if err != nil {
+		return nil, err
+	}
+	defer reflectionClient.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+	set, err := client.ExportDescriptors(ctx, reflectionClient)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export descriptors of %s: %w", bridgeID, err)
+	}
+	return set, nil
+}
+
+// runExport saves the descriptors of a device as a protoset
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	var opts clientOptions
+	opts.register(fs)
+	opts.registerOfflineFlag(fs)
+	output := fs.String("o", "", "file to write the FileDescriptorSet to (default stdout)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	source, ctx, done, err := opts.schema(opts.bridgeID)
+	if err != nil {
+		return err
+	}
+	defer done()
+	set, err := client.ExportDescriptors(ctx, source)
+	if err != nil {
+		return fmt.Errorf("failed to export descriptors of %s: %w", opts.bridgeID, err)
+	}
+	if len(set.File) == 0 {
+		return errors.New(opts.bridgeID + " serves no services to export")
+	}
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(set)
+	if err != nil {
+		return fmt.Errorf("failed to marshal descriptors: %w", err)
+	}
+
+	if *output == "" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	if err := os.WriteFile(*output, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", *output, err)
+	}
+	fmt.Fprintf(os.Stderr, "Wrote %d files to %s\n", len(set.File), *output)
+	return nil
+}
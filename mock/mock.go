@@ -0,0 +1,285 @@
+// Package mock serves devices that do not exist: every service of a
+// FileDescriptorSet answers from rules or with responses synthesized from the
+// output types
+package mock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	server "github.com/vedantkulkarni/reflect-poc/server"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+)
+
+// DefaultStreamResponses is the number of responses synthesized for a
+// server-streaming call
+const DefaultStreamResponses = 3
+
+// Config describes the mocked services
+type Config struct {
+	// Descriptors define the mocked services, with their dependencies
+	Descriptors *descriptorpb.FileDescriptorSet
+	// Rules answer the calls they match, in order
+	Rules *Rules
+	// Synthesize answers calls no rule matches with responses generated from
+	// the output type; without it they fail with Unimplemented
+	Synthesize bool
+	// StreamResponses is the number of responses synthesized for a
+	// server-streaming call; zero means DefaultStreamResponses
+	StreamResponses int
+	// Logger records the calls and the rule answering them; nil disables logging
+	Logger *zap.Logger
+}
+
+// Mock answers the calls of every service of its descriptors through
+// grpc.UnknownServiceHandler, so that any gRPC server serving it, over MQTT or
+// TCP, behaves like the device those descriptors were taken from
+type Mock struct {
+	files    *protoregistry.Files
+	services []string
+	rules    []*Rule
+	cfg      Config
+	logger   *zap.Logger
+}
+
+// New builds a mock from cfg, checking the rules against the descriptors
+func New(cfg Config) (*Mock, error) {
+	files, err := protodesc.NewFiles(cfg.Descriptors)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build descriptors: %w", err)
+	}
+	if cfg.StreamResponses == 0 {
+		cfg.StreamResponses = DefaultStreamResponses
+	}
+	m := &Mock{files: files, cfg: cfg, logger: cfg.Logger}
+	if m.logger == nil {
+		m.logger = zap.NewNop()
+	}
+
+	files.RangeFiles(func(file protoreflect.FileDescriptor) bool {
+		for i := 0; i < file.Services().Len(); i++ {
+			m.services = append(m.services, string(file.Services().Get(i).FullName()))
+		}
+		return true
+	})
+	sort.Strings(m.services)
+
+	if cfg.Rules != nil {
+		for i, rule := range cfg.Rules.Rules {
+			if err := rule.compile(files.FindDescriptorByName); err != nil {
+				return nil, fmt.Errorf("rule %d: %w", i+1, err)
+			}
+		}
+		m.rules = cfg.Rules.Rules
+	}
+	return m, nil
+}
+
+// ServerOption routes every call to a service the server does not register
+// to the mock
+func (m *Mock) ServerOption() grpc.ServerOption {
+	return grpc.UnknownServiceHandler(m.handle)
+}
+
+// Services returns the sorted names of the services of the descriptors,
+// including those the server registers itself such as health
+func (m *Mock) Services() []string {
+	return m.services
+}
+
+// RegisterReflection registers a reflection service on s that describes the
+// services of s and the mocked services together
+func (m *Mock) RegisterReflection(s *grpc.Server) {
+	reflectionpb.RegisterServerReflectionServer(s, reflection.NewServerV1(reflection.ServerOptions{
+		Services:           serviceInfo{server: s, mock: m},
+		DescriptorResolver: m.Resolver(),
+	}))
+}
+
+// Resolver finds the descriptors of the mocked services first and of the
+// services compiled into the binary next
+func (m *Mock) Resolver() protodesc.Resolver {
+	return server.Resolvers{m.files, protoregistry.GlobalFiles}
+}
+
+// serviceInfo lists the registered and the mocked services for reflection
+type serviceInfo struct {
+	server *grpc.Server
+	mock   *Mock
+}
+
+func (s serviceInfo) GetServiceInfo() map[string]grpc.ServiceInfo {
+	info := s.server.GetServiceInfo()
+	for _, name := range s.mock.services {
+		if _, ok := info[name]; !ok {
+			info[name] = grpc.ServiceInfo{}
+		}
+	}
+	return info
+}
+
+// handle serves a call of a mocked method
+func (m *Mock) handle(_ any, stream grpc.ServerStream) error {
+	fullMethod, _ := grpc.MethodFromServerStream(stream)
+	method, err := m.findMethod(fullMethod)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case !method.IsStreamingClient():
+		request := dynamicpb.NewMessage(method.Input())
+		if err := stream.RecvMsg(request); err != nil {
+			return err
+		}
+		return m.answer(stream, method, request)
+	case !method.IsStreamingServer():
+		// Client streams are matched on their last request
+		request := dynamicpb.NewMessage(method.Input())
+		for {
+			next := dynamicpb.NewMessage(method.Input())
+			err := stream.RecvMsg(next)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			request = next
+		}
+		return m.answer(stream, method, request)
+	default:
+		// Bidi streams answer each request in turn
+		for {
+			request := dynamicpb.NewMessage(method.Input())
+			err := stream.RecvMsg(request)
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err := m.answer(stream, method, request); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (m *Mock) findMethod(fullMethod string) (protoreflect.MethodDescriptor, error) {
+	name := protoreflect.FullName(fullMethod)
+	if len(fullMethod) > 0 && fullMethod[0] == '/' {
+		name = protoreflect.FullName(fullMethod[1:])
+	}
+	// /pkg.Service/Method names pkg.Service.Method
+	for i := len(name) - 1; i >= 0; i-- {
+		if name[i] == '/' {
+			name = name[:i] + "." + name[i+1:]
+			break
+		}
+	}
+	desc, err := m.files.FindDescriptorByName(name)
+	if err != nil {
+		return nil, status.Errorf(codes.Unimplemented, "unknown method %s", fullMethod)
+	}
+	method, ok := desc.(protoreflect.MethodDescriptor)
+	if !ok {
+		return nil, status.Errorf(codes.Unimplemented, "unknown method %s", fullMethod)
+	}
+	return method, nil
+}
+
+// answer sends the responses of the first rule matching request, or
+// synthesized ones
+func (m *Mock) answer(stream grpc.ServerStream, method protoreflect.MethodDescriptor, request proto.Message) error {
+	rule := m.match(method, request)
+	if rule == nil {
+		if !m.cfg.Synthesize {
+			return status.Errorf(codes.Unimplemented, "no mock rule matches the request to %s", method.FullName())
+		}
+		count := 1
+		if method.IsStreamingServer() && !method.IsStreamingClient() {
+			count = m.cfg.StreamResponses
+		}
+		m.logger.Debug("Synthesizing responses", zap.String("method", string(method.FullName())), zap.Int("responses", count))
+		for i := 1; i <= count; i++ {
+			if err := stream.SendMsg(synthesize(method.Output(), i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	m.logger.Debug("Answering from rule", zap.String("method", string(method.FullName())), zap.String("rule", rule.Method))
+	// Metadata can only be set once per call; later rules of a bidi stream
+	// keep the first
+	stream.SetHeader(metadata.New(rule.Header))
+	stream.SetTrailer(metadata.New(rule.Trailer))
+
+	responses, err := rule.responses(method)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	if !method.IsStreamingServer() && len(responses) > 1 {
+		responses = responses[:1]
+	}
+	for _, response := range responses {
+		if err := wait(stream.Context(), rule.delay); err != nil {
+			return err
+		}
+		if err := stream.SendMsg(response); err != nil {
+			return err
+		}
+	}
+	if rule.Error != nil {
+		if err := wait(stream.Context(), rule.delay); err != nil {
+			return err
+		}
+		return status.Error(rule.Error.Code, rule.Error.Message)
+	}
+	if len(responses) == 0 && !method.IsStreamingServer() {
+		return stream.SendMsg(dynamicpb.NewMessage(method.Output()))
+	}
+	return nil
+}
+
+func (m *Mock) match(method protoreflect.MethodDescriptor, request proto.Message) *Rule {
+	for _, rule := range m.rules {
+		if rule.appliesTo(method) && rule.matches(request) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// wait sleeps for d unless the call ends first
+func wait(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return status.FromContextError(ctx.Err()).Err()
+	}
+}
@@ -0,0 +1,128 @@
+package mock_test
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+
+	mock "github.com/vedantkulkarni/reflect-poc/mock"
+	server "github.com/vedantkulkarni/reflect-poc/server"
+	service_proto "github.com/vedantkulkarni/reflect-poc/service-proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// serve builds a mock of reflect.TestService from cfg and returns a client of it
+func serve(t *testing.T, cfg mock.Config) service_proto.TestServiceClient {
+	t.Helper()
+	set, err := server.FileDescriptorSet([]string{"reflect.TestService"})
+	if err != nil {
+		t.Fatalf("failed to build descriptors: %v", err)
+	}
+	cfg.Descriptors = set
+	m, err := mock.New(cfg)
+	if err != nil {
+		t.Fatalf("failed to build mock: %v", err)
+	}
+
+	listener := bufconn.Listen(1 << 20)
+	grpcServer := grpc.NewServer(m.ServerOption())
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return service_proto.NewTestServiceClient(conn)
+}
+
+func rule(method string, match map[string]any, response string) *mock.Rule {
+	return &mock.Rule{Method: method, Match: match, Response: json.RawMessage(response)}
+}
+
+func TestMockRules(t *testing.T) {
+	rules := &mock.Rules{Rules: []*mock.Rule{
+		rule("reflect.TestService/Test", map[string]any{"message": "ping"}, `{"message": "pong"}`),
+		rule("reflect.TestService/Test", map[string]any{"message": "ping"}, `{"message": "shadowed"}`),
+		rule("reflect.TestService.Test", map[string]any{"message": "hello"}, `{"message": "world"}`),
+		{Method: "reflect.TestService/*", Match: map[string]any{"message": "fail"}, Error: &mock.RuleError{Code: codes.NotFound, Message: "no pump"}},
+	}}
+
+	for _, synthesize := range []bool{false, true} {
+		client := serve(t, mock.Config{Rules: rules, Synthesize: synthesize})
+		for request, want := range map[string]string{"ping": "pong", "hello": "world"} {
+			response, err := client.Test(context.Background(), &service_proto.TestMessageRequest{Message: request})
+			if err != nil {
+				t.Fatalf("Test(%s) failed: %v", request, err)
+			}
+			if response.Message != want {
+				t.Errorf("Test(%s) = %q, want %q", request, response.Message, want)
+			}
+		}
+
+		_, err := client.Run(context.Background(), &service_proto.RunMessageRequest{Message: "fail"})
+		if s := status.Convert(err); s.Code() != codes.NotFound || s.Message() != "no pump" {
+			t.Errorf("the wildcard error rule returned %v", err)
+		}
+
+		// Requests no rule matches are synthesized or fail
+		response, err := client.Test(context.Background(), &service_proto.TestMessageRequest{Message: "other"})
+		if synthesize {
+			if err != nil || response.Message != "message 1" {
+				t.Errorf("synthesized %v, %v, want message 1", response, err)
+			}
+		} else if status.Code(err) != codes.Unimplemented {
+			t.Errorf("unmatched call returned %v, want Unimplemented", err)
+		}
+	}
+}
+
+func TestMockSynthesizedStream(t *testing.T) {
+	client := serve(t, mock.Config{Synthesize: true, StreamResponses: 2})
+	stream, err := client.TestServerStream(context.Background(), &service_proto.TestMessageRequest{})
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+	var got []string
+	for {
+		response, err := stream.Recv()
+		if err != nil {
+			break
+		}
+		got = append(got, response.Message)
+	}
+	if len(got) != 2 || got[0] != "message 1" || got[1] != "message 2" {
+		t.Errorf("stream responses = %v, want message 1 and message 2", got)
+	}
+}
+
+func TestNewRuleErrors(t *testing.T) {
+	set, err := server.FileDescriptorSet([]string{"reflect.TestService"})
+	if err != nil {
+		t.Fatalf("failed to build descriptors: %v", err)
+	}
+	for name, r := range map[string]*mock.Rule{
+		"not a method":     {Method: "Test"},
+		"unknown service":  {Method: "reflect.PumpService/Test"},
+		"not a service":    {Method: "reflect.TestMessageRequest/Test"},
+		"unknown method":   {Method: "reflect.TestService/Pump"},
+		"invalid response": rule("reflect.TestService/Test", nil, `{"flow": 3}`),
+		"invalid delay":    {Method: "reflect.TestService/Test", Delay: "soon"},
+	} {
+		if _, err := mock.New(mock.Config{Descriptors: set, Rules: &mock.Rules{Rules: []*mock.Rule{r}}}); err == nil {
+			t.Errorf("%s: built a mock with rule %+v", name, r)
+		}
+	}
+}
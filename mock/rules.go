@@ -0,0 +1,238 @@
+package mock
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"gopkg.in/yaml.v3"
+)
+
+// Rules is a rules file. It is YAML or JSON:
+//
+//	rules:
+//	  - method: reflect.TestService/Test
+//	    match: {message: ping}
+//	    response: {message: pong}
+//	  - method: reflect.TestService/TestServerStream
+//	    delay: 200ms
+//	    responses:
+//	      - {message: one}
+//	      - {message: two}
+//	  - method: reflect.SyncService/*
+//	    error: {code: UNAVAILABLE, message: maintenance}
+//
+// The first rule whose method and match fields fit a request answers it.
+type Rules struct {
+	Rules []*Rule `json:"rules"`
+}
+
+// Rule answers the calls of a method whose request contains Match
+type Rule struct {
+	// Method is pkg.Service/Method, pkg.Service.Method or pkg.Service/* for
+	// every method of a service
+	Method string `json:"method"`
+	// Match holds request fields, by JSON or proto name, that must be equal;
+	// nested messages match when they contain the given fields
+	Match map[string]any `json:"match,omitempty"`
+	// Response and Responses are JSON messages of the output type; streams
+	// get every response, other methods the first
+	Response  json.RawMessage   `json:"response,omitempty"`
+	Responses []json.RawMessage `json:"responses,omitempty"`
+	// Error, if set, fails the call after the responses
+	Error *RuleError `json:"error,omitempty"`
+	// Delay, such as "200ms", is waited before each response or the error
+	Delay string `json:"delay,omitempty"`
+	// Header and Trailer are sent as response metadata
+	Header  map[string]string `json:"header,omitempty"`
+	Trailer map[string]string `json:"trailer,omitempty"`
+
+	service string
+	method  string
+	delay   time.Duration
+}
+
+// RuleError is the status a rule fails calls with; Code is a name such as
+// "NOT_FOUND" or a number
+type RuleError struct {
+	Code    codes.Code `json:"code"`
+	Message string     `json:"message"`
+}
+
+// LoadRules reads a YAML or JSON rules file
+func LoadRules(path string) (*Rules, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rules: %w", err)
+	}
+	// YAML is a superset of JSON; going through JSON gives both the same
+	// field names and value types
+	var doc any
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse rules %s: %w", path, err)
+	}
+	data, err = json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse rules %s: %w", path, err)
+	}
+	rules := &Rules{}
+	if err := decodeJSON(data, rules); err != nil {
+		return nil, fmt.Errorf("failed to parse rules %s: %w", path, err)
+	}
+	return rules, nil
+}
+
+// compile checks a rule against the descriptors of the mocked services
+func (r *Rule) compile(resolve func(protoreflect.FullName) (protoreflect.Descriptor, error)) error {
+	service, method, ok := strings.Cut(r.Method, "/")
+	if !ok {
+		i := strings.LastIndex(r.Method, ".")
+		if i < 0 {
+			return fmt.Errorf("method %q is not pkg.Service/Method", r.Method)
+		}
+		service, method = r.Method[:i], r.Method[i+1:]
+	}
+	r.service, r.method = service, method
+
+	desc, err := resolve(protoreflect.FullName(service))
+	if err != nil {
+		return fmt.Errorf("unknown service %s: %w", service, err)
+	}
+	serviceDesc, ok := desc.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return fmt.Errorf("%s is not a service", service)
+	}
+	if method != "*" {
+		methodDesc := serviceDesc.Methods().ByName(protoreflect.Name(method))
+		if methodDesc == nil {
+			return fmt.Errorf("unknown method %s in service %s", method, service)
+		}
+		if _, err := r.responses(methodDesc); err != nil {
+			return err
+		}
+	}
+
+	if r.Delay != "" {
+		if r.delay, err = time.ParseDuration(r.Delay); err != nil {
+			return fmt.Errorf("invalid delay %q: %w", r.Delay, err)
+		}
+	}
+	return nil
+}
+
+// appliesTo reports whether the rule covers method
+func (r *Rule) appliesTo(method protoreflect.MethodDescriptor) bool {
+	return string(method.Parent().FullName()) == r.service && (r.method == "*" || string(method.Name()) == r.method)
+}
+
+// matches reports whether request contains the Match fields
+func (r *Rule) matches(request proto.Message) bool {
+	if len(r.Match) == 0 {
+		return true
+	}
+	for _, opts := range []protojson.MarshalOptions{
+		{EmitUnpopulated: true},
+		{EmitUnpopulated: true, UseProtoNames: true},
+	} {
+		data, err := opts.Marshal(request)
+		if err != nil {
+			return false
+		}
+		var fields map[string]any
+		if err := decodeJSON(data, &fields); err != nil {
+			return false
+		}
+		if contains(fields, r.Match) {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeJSON unmarshals data into v, keeping numbers as json.Number so that
+// 64-bit integers keep every digit
+func decodeJSON(data []byte, v any) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// contains reports whether got holds every field of want, comparing
+// scalars by their text so that 64-bit integers, which JSON carries as
+// strings, equal numbers
+func contains(got, want any) bool {
+	switch want := want.(type) {
+	case map[string]any:
+		got, ok := got.(map[string]any)
+		if !ok {
+			return false
+		}
+		for key, value := range want {
+			if !contains(got[key], value) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		got, ok := got.([]any)
+		if !ok || len(got) != len(want) {
+			return false
+		}
+		for i := range want {
+			if !contains(got[i], want[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return scalarText(got) == scalarText(want)
+	}
+}
+
+// scalarText returns numbers in one notation, so that 1e+06 and 1000000
+// are equal, and any other scalar as fmt.Sprint does
+func scalarText(v any) string {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return strconv.FormatInt(i, 10)
+		}
+		if u, err := strconv.ParseUint(v.String(), 10, 64); err == nil {
+			return strconv.FormatUint(u, 10)
+		}
+		if f, err := v.Float64(); err == nil {
+			return strconv.FormatFloat(f, 'f', -1, 64)
+		}
+		return v.String()
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// responses decodes the responses of the rule as messages of the output of method
+func (r *Rule) responses(method protoreflect.MethodDescriptor) ([]proto.Message, error) {
+	raw := r.Responses
+	if r.Response != nil {
+		raw = append([]json.RawMessage{r.Response}, raw...)
+	}
+	messages := make([]proto.Message, 0, len(raw))
+	for _, data := range raw {
+		message := dynamicpb.NewMessage(method.Output())
+		if err := protojson.Unmarshal(data, message); err != nil {
+			return nil, fmt.Errorf("invalid response of %s for %s: %w", r.Method, method.FullName(), err)
+		}
+		messages = append(messages, message)
+	}
+	return messages, nil
+}
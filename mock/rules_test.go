@@ -0,0 +1,172 @@
+package mock
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func TestContains(t *testing.T) {
+	got := map[string]any{
+		"message": "ping",
+		"id":      float64(7),
+		"big":     "9007199254740993",
+		"enabled": true,
+		"inner":   map[string]any{"name": "pump", "depth": map[string]any{"level": float64(2)}},
+		"tags":    []any{"a", "b"},
+		"empty":   nil,
+	}
+	for _, tc := range []struct {
+		name string
+		want any
+		ok   bool
+	}{
+		{"empty match", map[string]any{}, true},
+		{"equal string", map[string]any{"message": "ping"}, true},
+		{"different string", map[string]any{"message": "pong"}, false},
+		{"number", map[string]any{"id": 7}, true},
+		{"64-bit string equals number", map[string]any{"big": 9007199254740993}, true},
+		{"64-bit string equals JSON number", map[string]any{"big": json.Number("9007199254740993")}, true},
+		{"number in exponent notation", map[string]any{"id": json.Number("7e0")}, true},
+		{"bool", map[string]any{"enabled": true}, true},
+		{"several fields", map[string]any{"message": "ping", "id": 7}, true},
+		{"one field differs", map[string]any{"message": "ping", "id": 8}, false},
+		{"missing field", map[string]any{"other": "x"}, false},
+		{"nested subset", map[string]any{"inner": map[string]any{"name": "pump"}}, true},
+		{"deeply nested", map[string]any{"inner": map[string]any{"depth": map[string]any{"level": 2}}}, true},
+		{"nested differs", map[string]any{"inner": map[string]any{"name": "valve"}}, false},
+		{"nested against scalar", map[string]any{"message": map[string]any{"x": 1}}, false},
+		{"nested against null", map[string]any{"empty": map[string]any{"x": 1}}, false},
+		{"equal list", map[string]any{"tags": []any{"a", "b"}}, true},
+		{"list order", map[string]any{"tags": []any{"b", "a"}}, false},
+		{"list length", map[string]any{"tags": []any{"a"}}, false},
+	} {
+		if ok := contains(got, tc.want); ok != tc.ok {
+			t.Errorf("%s: contains(%v) = %v, want %v", tc.name, tc.want, ok, tc.ok)
+		}
+	}
+}
+
+func TestRuleMatches(t *testing.T) {
+	request := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("pump.proto"),
+		Package: proto.String("pumps"),
+		Options: &descriptorpb.FileOptions{GoPackage: proto.String("example.com/pumps")},
+		MessageType: []*descriptorpb.DescriptorProto{
+			{Name: proto.String("Reading")},
+		},
+	}
+	for _, tc := range []struct {
+		name  string
+		match map[string]any
+		ok    bool
+	}{
+		{"no match fields", nil, true},
+		{"scalar", map[string]any{"name": "pump.proto"}, true},
+		{"scalar differs", map[string]any{"name": "valve.proto"}, false},
+		{"nested by JSON name", map[string]any{"options": map[string]any{"goPackage": "example.com/pumps"}}, true},
+		{"nested by proto name", map[string]any{"options": map[string]any{"go_package": "example.com/pumps"}}, true},
+		{"top level by proto name", map[string]any{"message_type": []any{map[string]any{"name": "Reading"}}}, true},
+		{"nested differs", map[string]any{"options": map[string]any{"goPackage": "example.com/valves"}}, false},
+		{"unset message", map[string]any{"sourceCodeInfo": map[string]any{"location": []any{}}}, false},
+		{"unknown field", map[string]any{"vendor": "acme"}, false},
+	} {
+		rule := &Rule{Match: tc.match}
+		if ok := rule.matches(request); ok != tc.ok {
+			t.Errorf("%s: matches(%v) = %v, want %v", tc.name, tc.match, ok, tc.ok)
+		}
+	}
+}
+
+// TestRuleMatchesNumbers checks numbers of a rules file against requests,
+// where protojson writes 64-bit integers as strings and doubles as 1e+06
+func TestRuleMatchesNumbers(t *testing.T) {
+	request := &descriptorpb.UninterpretedOption{
+		PositiveIntValue: proto.Uint64(18446744073709551615),
+		NegativeIntValue: proto.Int64(-1000000),
+		DoubleValue:      proto.Float64(1e6),
+	}
+	dir := t.TempDir()
+	for _, tc := range []struct {
+		match string
+		ok    bool
+	}{
+		{"{doubleValue: 1000000}", true},
+		{"{doubleValue: 1e6}", true},
+		{"{doubleValue: 1000000.0}", true},
+		{"{doubleValue: 1000001}", false},
+		{"{negativeIntValue: -1000000}", true},
+		{"{negativeIntValue: -1e6}", true},
+		{"{negativeIntValue: -1000001}", false},
+		{"{positiveIntValue: 18446744073709551615}", true},
+		{"{positiveIntValue: 18446744073709551614}", false},
+	} {
+		path := filepath.Join(dir, "rules.yaml")
+		content := "rules:\n  - method: pkg.Service/Method\n    match: " + tc.match + "\n"
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		rules, err := LoadRules(path)
+		if err != nil {
+			t.Fatalf("failed to load rules: %v", err)
+		}
+		if ok := rules.Rules[0].matches(request); ok != tc.ok {
+			t.Errorf("matches(%s) = %v, want %v", tc.match, ok, tc.ok)
+		}
+	}
+}
+
+func TestLoadRules(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "rules.yaml")
+	yaml := `rules:
+  - method: reflect.TestService/Test
+    match: {message: ping}
+    response: {message: pong}
+    delay: 10ms
+  - method: reflect.SyncService/*
+    error: {code: UNAVAILABLE, message: maintenance}
+`
+	if err := os.WriteFile(path, []byte(yaml), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	rules, err := LoadRules(path)
+	if err != nil {
+		t.Fatalf("failed to load rules: %v", err)
+	}
+	if len(rules.Rules) != 2 {
+		t.Fatalf("loaded %d rules, want 2", len(rules.Rules))
+	}
+	if got := rules.Rules[0]; got.Method != "reflect.TestService/Test" || got.Match["message"] != "ping" || string(got.Response) != `{"message":"pong"}` || got.Delay != "10ms" {
+		t.Errorf("first rule = %+v", got)
+	}
+	if got := rules.Rules[1].Error; got == nil || got.Code.String() != "Unavailable" || got.Message != "maintenance" {
+		t.Errorf("second rule error = %+v", got)
+	}
+}
+
+func TestLoadRulesErrors(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"invalid YAML":     "rules: [\n",
+		"rules not a list": "rules: {method: x}\n",
+		"unknown code":     "rules:\n  - method: reflect.TestService/Test\n    error: {code: SOMETIMES}\n",
+		"method not text":  "rules:\n  - method: [reflect.TestService]\n",
+		"match not a map":  "rules:\n  - method: reflect.TestService/Test\n    match: ping\n",
+	} {
+		path := filepath.Join(dir, "rules.yaml")
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadRules(path); err == nil {
+			t.Errorf("%s: loaded rules from %q", name, content)
+		}
+	}
+	if _, err := LoadRules(filepath.Join(dir, "missing.yaml")); err == nil {
+		t.Error("loaded a missing rules file")
+	}
+}
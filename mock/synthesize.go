@@ -0,0 +1,80 @@
+package mock
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// maxSynthesizeDepth bounds the nesting of synthesized messages, which may be recursive
+const maxSynthesizeDepth = 3
+
+// synthesize returns a message of type desc with every field set to a
+// plausible value; n numbers the responses of a stream
+func synthesize(desc protoreflect.MessageDescriptor, n int) *dynamicpb.Message {
+	return synthesizeMessage(desc, n, 0)
+}
+
+func synthesizeMessage(desc protoreflect.MessageDescriptor, n, depth int) *dynamicpb.Message {
+	message := dynamicpb.NewMessage(desc)
+	// Well-known types have a special JSON form; their zero value is valid
+	if desc.ParentFile().Package() == "google.protobuf" || depth >= maxSynthesizeDepth {
+		return message
+	}
+
+	fields := desc.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		// Only the first member of a oneof can be set
+		if oneof := fd.ContainingOneof(); oneof != nil && !oneof.IsSynthetic() && oneof.Fields().Get(0) != fd {
+			continue
+		}
+		switch {
+		case fd.IsMap():
+			entries := message.Mutable(fd).Map()
+			entries.Set(synthesizeValue(fd.MapKey(), n).MapKey(), synthesizeField(fd.MapValue(), n, depth))
+		case fd.IsList():
+			message.Mutable(fd).List().Append(synthesizeField(fd, n, depth))
+		default:
+			message.Set(fd, synthesizeField(fd, n, depth))
+		}
+	}
+	return message
+}
+
+// synthesizeField returns a value of fd, or of an element of fd if it is a list
+func synthesizeField(fd protoreflect.FieldDescriptor, n, depth int) protoreflect.Value {
+	if fd.Message() != nil {
+		return protoreflect.ValueOfMessage(synthesizeMessage(fd.Message(), n, depth+1))
+	}
+	return synthesizeValue(fd, n)
+}
+
+// synthesizeValue returns a scalar value of fd: its name for strings and n
+// for numbers
+func synthesizeValue(fd protoreflect.FieldDescriptor, n int) protoreflect.Value {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return protoreflect.ValueOfBool(true)
+	case protoreflect.EnumKind:
+		values := fd.Enum().Values()
+		return protoreflect.ValueOfEnum(values.Get(n % values.Len()).Number())
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		return protoreflect.ValueOfInt32(int32(n))
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return protoreflect.ValueOfInt64(int64(n))
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return protoreflect.ValueOfUint32(uint32(n))
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return protoreflect.ValueOfUint64(uint64(n))
+	case protoreflect.FloatKind:
+		return protoreflect.ValueOfFloat32(float32(n) + 0.5)
+	case protoreflect.DoubleKind:
+		return protoreflect.ValueOfFloat64(float64(n) + 0.5)
+	case protoreflect.BytesKind:
+		return protoreflect.ValueOfBytes([]byte(fmt.Sprintf("%s %d", fd.Name(), n)))
+	default:
+		return protoreflect.ValueOfString(fmt.Sprintf("%s %d", fd.Name(), n))
+	}
+}
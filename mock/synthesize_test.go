@@ -0,0 +1,176 @@
+package mock
+
+import (
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func scalarField(name string, number int32, kind descriptorpb.FieldDescriptorProto_Type) *descriptorpb.FieldDescriptorProto {
+	return &descriptorpb.FieldDescriptorProto{
+		Name:   proto.String(name),
+		Number: proto.Int32(number),
+		Type:   kind.Enum(),
+		Label:  descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+	}
+}
+
+func typedField(name string, number int32, kind descriptorpb.FieldDescriptorProto_Type, typeName string, repeated bool) *descriptorpb.FieldDescriptorProto {
+	fd := scalarField(name, number, kind)
+	fd.TypeName = proto.String(typeName)
+	if repeated {
+		fd.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	}
+	return fd
+}
+
+// sample builds a message with a field of every kind, a list, a map, an enum,
+// a oneof, a well-known type and a recursive field
+func sample(t *testing.T) protoreflect.MessageDescriptor {
+	t.Helper()
+	kinds := map[string]descriptorpb.FieldDescriptorProto_Type{
+		"b":    descriptorpb.FieldDescriptorProto_TYPE_BOOL,
+		"i32":  descriptorpb.FieldDescriptorProto_TYPE_INT32,
+		"s32":  descriptorpb.FieldDescriptorProto_TYPE_SINT32,
+		"sf32": descriptorpb.FieldDescriptorProto_TYPE_SFIXED32,
+		"i64":  descriptorpb.FieldDescriptorProto_TYPE_INT64,
+		"s64":  descriptorpb.FieldDescriptorProto_TYPE_SINT64,
+		"sf64": descriptorpb.FieldDescriptorProto_TYPE_SFIXED64,
+		"u32":  descriptorpb.FieldDescriptorProto_TYPE_UINT32,
+		"f32":  descriptorpb.FieldDescriptorProto_TYPE_FIXED32,
+		"u64":  descriptorpb.FieldDescriptorProto_TYPE_UINT64,
+		"f64":  descriptorpb.FieldDescriptorProto_TYPE_FIXED64,
+		"fl":   descriptorpb.FieldDescriptorProto_TYPE_FLOAT,
+		"db":   descriptorpb.FieldDescriptorProto_TYPE_DOUBLE,
+		"str":  descriptorpb.FieldDescriptorProto_TYPE_STRING,
+		"byt":  descriptorpb.FieldDescriptorProto_TYPE_BYTES,
+	}
+	var fields []*descriptorpb.FieldDescriptorProto
+	number := int32(1)
+	for _, name := range []string{"b", "i32", "s32", "sf32", "i64", "s64", "sf64", "u32", "f32", "u64", "f64", "fl", "db", "str", "byt"} {
+		fields = append(fields, scalarField(name, number, kinds[name]))
+		number++
+	}
+	first := scalarField("first", 20, descriptorpb.FieldDescriptorProto_TYPE_STRING)
+	first.OneofIndex = proto.Int32(0)
+	second := scalarField("second", 21, descriptorpb.FieldDescriptorProto_TYPE_STRING)
+	second.OneofIndex = proto.Int32(0)
+	names := scalarField("names", 17, descriptorpb.FieldDescriptorProto_TYPE_STRING)
+	names.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	fields = append(fields,
+		typedField("state", 16, descriptorpb.FieldDescriptorProto_TYPE_ENUM, ".sample.State", false),
+		names,
+		typedField("counts", 18, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".sample.Sample.CountsEntry", true),
+		typedField("child", 19, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".sample.Sample", false),
+		first, second,
+		typedField("at", 22, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".google.protobuf.Timestamp", false),
+	)
+
+	file := &descriptorpb.FileDescriptorProto{
+		Name:       proto.String("sample.proto"),
+		Package:    proto.String("sample"),
+		Syntax:     proto.String("proto3"),
+		Dependency: []string{"google/protobuf/timestamp.proto"},
+		EnumType: []*descriptorpb.EnumDescriptorProto{{
+			Name: proto.String("State"),
+			Value: []*descriptorpb.EnumValueDescriptorProto{
+				{Name: proto.String("STATE_UNSPECIFIED"), Number: proto.Int32(0)},
+				{Name: proto.String("STATE_ON"), Number: proto.Int32(1)},
+			},
+		}},
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name:  proto.String("Sample"),
+			Field: fields,
+			NestedType: []*descriptorpb.DescriptorProto{{
+				Name: proto.String("CountsEntry"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					scalarField("key", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+					scalarField("value", 2, descriptorpb.FieldDescriptorProto_TYPE_INT32),
+				},
+				Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+			}},
+			OneofDecl: []*descriptorpb.OneofDescriptorProto{{Name: proto.String("choice")}},
+		}},
+	}
+	files := &protoregistry.Files{}
+	if err := files.RegisterFile(timestamppb.File_google_protobuf_timestamp_proto); err != nil {
+		t.Fatal(err)
+	}
+	fd, err := protodesc.NewFile(file, files)
+	if err != nil {
+		t.Fatalf("failed to build descriptors: %v", err)
+	}
+	return fd.Messages().ByName("Sample")
+}
+
+func TestSynthesize(t *testing.T) {
+	desc := sample(t)
+	message := synthesize(desc, 2)
+	get := func(name string) protoreflect.Value {
+		return message.Get(desc.Fields().ByName(protoreflect.Name(name)))
+	}
+
+	for name, want := range map[string]any{
+		"b":    true,
+		"i32":  int32(2),
+		"s32":  int32(2),
+		"sf32": int32(2),
+		"i64":  int64(2),
+		"s64":  int64(2),
+		"sf64": int64(2),
+		"u32":  uint32(2),
+		"f32":  uint32(2),
+		"u64":  uint64(2),
+		"f64":  uint64(2),
+		"fl":   float32(2.5),
+		"db":   float64(2.5),
+		"str":  "str 2",
+		"byt":  "byt 2",
+	} {
+		got := get(name).Interface()
+		if b, ok := got.([]byte); ok {
+			got = string(b)
+		}
+		if got != want {
+			t.Errorf("%s = %v (%T), want %v (%T)", name, got, got, want, want)
+		}
+	}
+
+	// Enum values cycle with n
+	if got := get("state").Enum(); got != 0 {
+		t.Errorf("state = %d, want the value at index 2 %% 2", got)
+	}
+	if got := synthesize(desc, 1).Get(desc.Fields().ByName("state")).Enum(); got != 1 {
+		t.Errorf("state of the first response = %d, want 1", got)
+	}
+
+	if names := get("names").List(); names.Len() != 1 || names.Get(0).String() != "names 2" {
+		t.Errorf("names has %d elements, want one", names.Len())
+	}
+	counts := get("counts").Map()
+	if counts.Len() != 1 || counts.Get(protoreflect.ValueOfString("key 2").MapKey()).Int() != 2 {
+		t.Errorf("counts has %d entries, want key 2: 2", counts.Len())
+	}
+
+	fields := desc.Fields()
+	if !message.Has(fields.ByName("first")) || message.Has(fields.ByName("second")) {
+		t.Error("synthesized a oneof other than with its first member")
+	}
+	if at := get("at").Message(); !message.Has(fields.ByName("at")) || at.Has(at.Descriptor().Fields().ByName("seconds")) {
+		t.Error("synthesized a well-known type other than as its zero value")
+	}
+
+	// Recursion stops at maxSynthesizeDepth
+	depth := 0
+	for m := message.ProtoReflect(); m.Has(fields.ByName("child")); m = m.Get(fields.ByName("child")).Message() {
+		depth++
+	}
+	if depth != maxSynthesizeDepth {
+		t.Errorf("nested %d children, want %d", depth, maxSynthesizeDepth)
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+)
+
+// outputFormats are the values accepted by -format
+var outputFormats = []string{"json", "compact", "text"}
+
+// newFormatter returns a function that renders responses in the given format
+func newFormatter(format string, emitDefaults bool) (func(proto.Message) ([]byte, error), error) {
+	switch format {
+	case "json":
+		return protojson.MarshalOptions{Multiline: true, Indent: "  ", EmitUnpopulated: emitDefaults}.Marshal, nil
+	case "compact":
+		return protojson.MarshalOptions{EmitUnpopulated: emitDefaults}.Marshal, nil
+	case "text":
+		return prototext.MarshalOptions{Multiline: true, Indent: "  ", EmitUnknown: true}.Marshal, nil
+	}
+	return nil, fmt.Errorf("unknown output format %q", format)
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/vedantkulkarni/reflect-poc/config"
+)
+
+// envPrefix prefixes the environment variable of every flag: -bridge-id is
+// read from REFLECT_BRIDGE_ID
+const envPrefix = "REFLECT_"
+
+// legacyEnv are environment variables read before profiles existed
+var legacyEnv = map[string]string{
+	"password":        "MQTT_PASSWORD",
+	"token":           "AUTH_TOKEN",
+	"hmac-secret":     "AUTH_HMAC_SECRET",
+	"auth-jwt-secret": "AUTH_JWT_SECRET",
+}
+
+// parseFlags parses args after applying the selected config profile and the
+// environment, so settings come from, in order of precedence: flags,
+// REFLECT_* environment variables, the profile, and built-in defaults
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	configPath := fs.String("config", "", "config file with named profiles (default "+config.DefaultPath()+", or $REFLECT_CONFIG)")
+	profileName := fs.String("profile", "", "profile of the config file to use (default: the file's default profile, or $REFLECT_PROFILE)")
+
+	// The profile provides the defaults of every other flag, so it is selected first
+	*configPath = firstNonEmpty(argValue(fs, args, "config"), os.Getenv(envPrefix+"CONFIG"))
+	*profileName = firstNonEmpty(argValue(fs, args, "profile"), os.Getenv(envPrefix+"PROFILE"))
+	profile, err := loadProfile(*configPath, *profileName)
+	if err != nil {
+		return err
+	}
+	if profile != nil {
+		if err := applyProfile(fs, profile); err != nil {
+			return fmt.Errorf("profile %s: %w", firstNonEmpty(*profileName, "default"), err)
+		}
+	}
+	if err := applyEnv(fs); err != nil {
+		return err
+	}
+	return fs.Parse(args)
+}
+
+func loadProfile(path, name string) (*config.Profile, error) {
+	var file *config.File
+	var err error
+	if path != "" {
+		file, err = config.Load(path)
+	} else {
+		file, err = config.LoadDefault()
+		if errors.Is(err, config.ErrNotFound) {
+			if name != "" {
+				return nil, fmt.Errorf("profile %q requested but there is no config file at %s", name, config.DefaultPath())
+			}
+			return nil, nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return file.Profile(name)
+}
+
+// applyProfile sets the flags of fs that the profile defines
+func applyProfile(fs *flag.FlagSet, p *config.Profile) error {
+	grpcTLS := p.GRPCClientTLS
+	if fs.Name() == "serve" {
+		grpcTLS = p.GRPCServerTLS
+	}
+	settings := map[string]string{
+		"broker":                 p.Broker,
+		"client-id":              p.ClientID,
+		"username":               p.Username,
+		"password":               p.Password,
+		"ca-file":                p.BrokerTLS.CAFile,
+		"cert-file":              p.BrokerTLS.CertFile,
+		"key-file":               p.BrokerTLS.KeyFile,
+		"server-name":            p.BrokerTLS.ServerName,
+		"insecure-skip-verify":   boolSetting(p.BrokerTLS.InsecureSkipVerify),
+		"max-reconnect-interval": p.MaxReconnectInterval,
+		"bridge-id":              p.BridgeID,
+		"grpc-tls-ca":            grpcTLS.CAFile,
+		"grpc-tls-cert":          grpcTLS.CertFile,
+		"grpc-tls-key":           grpcTLS.KeyFile,
+		"grpc-tls-server-name":   grpcTLS.ServerName,
+		"token":                  p.Auth.Token,
+		"jwt-secret":             p.Auth.JWTSecret,
+		"jwt-subject":            p.Auth.JWTSubject,
+		"jwt-roles":              strings.Join(p.Auth.JWTRoles, ","),
+		"hmac-key-id":            p.Auth.HMACKeyID,
+		"hmac-secret":            p.Auth.HMACSecret,
+		"timeout":                p.Timeout,
+		"connect-timeout":        p.ConnectTimeout,
+		"drain-timeout":          p.DrainTimeout,
+	}
+	for name, value := range settings {
+		if value == "" || fs.Lookup(name) == nil {
+			continue
+		}
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("invalid %s: %w", strings.ReplaceAll(name, "-", "_"), err)
+		}
+	}
+
+	// -H adds to the profile headers, replacing those of the same name
+	if f := fs.Lookup("H"); f != nil {
+		headers := f.Value.(*headerFlags)
+		names := make([]string, 0, len(p.Headers))
+		for name := range p.Headers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if err := headers.setProfile(name + ": " + p.Headers[name]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// applyEnv sets every flag of fs that has its environment variable set
+func applyEnv(fs *flag.FlagSet) error {
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || f.Name == "H" || f.Name == "config" || f.Name == "profile" {
+			return
+		}
+		names := []string{envPrefix + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))}
+		if legacy, ok := legacyEnv[f.Name]; ok {
+			names = append(names, legacy)
+		}
+		for _, name := range names {
+			if value := os.Getenv(name); value != "" {
+				if setErr := fs.Set(f.Name, value); setErr != nil {
+					err = fmt.Errorf("invalid $%s: %w", name, setErr)
+				}
+				return
+			}
+		}
+	})
+	return err
+}
+
+// argValue returns the value of the named flag in args without parsing them
+func argValue(fs *flag.FlagSet, args []string, name string) string {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" || arg == "-" || !strings.HasPrefix(arg, "-") {
+			return ""
+		}
+		key, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if key == name {
+			if hasValue {
+				return value
+			}
+			if i+1 < len(args) {
+				return args[i+1]
+			}
+			return ""
+		}
+		if !hasValue && !isBoolFlag(fs, key) {
+			i++
+		}
+	}
+	return ""
+}
+
+func isBoolFlag(fs *flag.FlagSet, name string) bool {
+	f := fs.Lookup(name)
+	if f == nil {
+		return false
+	}
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+func boolSetting(v bool) string {
+	if v {
+		return "true"
+	}
+	return ""
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
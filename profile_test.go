@@ -0,0 +1,187 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testConfig = `{
+  "default": "bench",
+  "profiles": {
+    "bench": {
+      "broker": "tcp://profile:1883",
+      "bridge_id": "profile-bridge",
+      "password": "profile-password",
+      "grpc_client_tls": {"ca_file": "devices-ca.pem", "server_name": "device"},
+      "grpc_server_tls": {"ca_file": "callers-ca.pem", "cert_file": "device.pem", "key_file": "device-key.pem"},
+      "timeout": "3s",
+      "headers": {"authorization": "Bearer profile", "x-operator": "oncall"}
+    },
+    "other": {"broker": "tcp://other:1883"}
+  }
+}`
+
+func writeConfig(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(testConfig), 0o600); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	return path
+}
+
+// testFlags registers the flags the tests read, as the client commands do
+func testFlags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.String("broker", "tcp://localhost:1883", "")
+	fs.String("bridge-id", "default-bridge", "")
+	fs.String("password", "", "")
+	fs.String("timeout", "", "")
+	fs.String("grpc-tls-ca", "", "")
+	fs.String("grpc-tls-cert", "", "")
+	fs.String("grpc-tls-key", "", "")
+	if name != "serve" {
+		fs.String("grpc-tls-server-name", "", "")
+		fs.Var(&headerFlags{}, "H", "")
+	}
+	return fs
+}
+
+func TestParseFlagsPrecedence(t *testing.T) {
+	config := writeConfig(t)
+
+	for _, tc := range []struct {
+		name string
+		env  map[string]string
+		args []string
+		want map[string]string
+	}{
+		{
+			name: "built-in defaults without a profile",
+			want: map[string]string{"broker": "tcp://localhost:1883", "bridge-id": "default-bridge"},
+		},
+		{
+			name: "profile over defaults",
+			args: []string{"-config", config},
+			want: map[string]string{"broker": "tcp://profile:1883", "bridge-id": "profile-bridge", "timeout": "3s"},
+		},
+		{
+			name: "environment over profile",
+			env:  map[string]string{"REFLECT_BROKER": "tcp://env:1883"},
+			args: []string{"-config", config},
+			want: map[string]string{"broker": "tcp://env:1883", "bridge-id": "profile-bridge"},
+		},
+		{
+			name: "flag over environment and profile",
+			env:  map[string]string{"REFLECT_BROKER": "tcp://env:1883", "REFLECT_BRIDGE_ID": "env-bridge"},
+			args: []string{"-config", config, "-broker", "tcp://flag:1883"},
+			want: map[string]string{"broker": "tcp://flag:1883", "bridge-id": "env-bridge"},
+		},
+		{
+			name: "profile headers",
+			args: []string{"-config", config, "-H", "x-trace: 1"},
+			want: map[string]string{"H": "authorization: Bearer profile, x-operator: oncall, x-trace: 1"},
+		},
+		{
+			name: "header flag replaces profile header of the same name",
+			args: []string{"-config", config, "-H", "Authorization: Bearer flag", "-H", "authorization: Bearer second"},
+			want: map[string]string{"H": "x-operator: oncall, Authorization: Bearer flag, authorization: Bearer second"},
+		},
+		{
+			name: "legacy environment variable over profile",
+			env:  map[string]string{"MQTT_PASSWORD": "legacy-password"},
+			args: []string{"-config", config},
+			want: map[string]string{"password": "legacy-password"},
+		},
+		{
+			name: "prefixed environment variable over legacy one",
+			env:  map[string]string{"MQTT_PASSWORD": "legacy-password", "REFLECT_PASSWORD": "env-password"},
+			args: []string{"-config", config},
+			want: map[string]string{"password": "env-password"},
+		},
+		{
+			name: "profile selected by flag",
+			env:  map[string]string{"REFLECT_PROFILE": "bench"},
+			args: []string{"-config", config, "-profile", "other"},
+			want: map[string]string{"broker": "tcp://other:1883", "bridge-id": "default-bridge"},
+		},
+		{
+			name: "profile selected by environment",
+			env:  map[string]string{"REFLECT_CONFIG": config, "REFLECT_PROFILE": "other"},
+			want: map[string]string{"broker": "tcp://other:1883"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// Keep the user's config file and environment out of the test
+			t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+			for _, name := range []string{"REFLECT_CONFIG", "REFLECT_PROFILE", "REFLECT_BROKER", "REFLECT_BRIDGE_ID", "REFLECT_PASSWORD", "MQTT_PASSWORD"} {
+				t.Setenv(name, "")
+			}
+			for name, value := range tc.env {
+				t.Setenv(name, value)
+			}
+			fs := testFlags("call")
+			if err := parseFlags(fs, tc.args); err != nil {
+				t.Fatalf("failed to parse flags: %v", err)
+			}
+			for name, want := range tc.want {
+				if got := fs.Lookup(name).Value.String(); got != want {
+					t.Errorf("-%s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseFlagsGRPCTLS(t *testing.T) {
+	config := writeConfig(t)
+	dir := filepath.Dir(config)
+
+	for _, tc := range []struct {
+		command string
+		want    map[string]string
+	}{
+		{"call", map[string]string{
+			"grpc-tls-ca":          filepath.Join(dir, "devices-ca.pem"),
+			"grpc-tls-cert":        "",
+			"grpc-tls-server-name": "device",
+		}},
+		{"serve", map[string]string{
+			"grpc-tls-ca":   filepath.Join(dir, "callers-ca.pem"),
+			"grpc-tls-cert": filepath.Join(dir, "device.pem"),
+			"grpc-tls-key":  filepath.Join(dir, "device-key.pem"),
+		}},
+	} {
+		t.Run(tc.command, func(t *testing.T) {
+			fs := testFlags(tc.command)
+			if err := parseFlags(fs, []string{"-config", config}); err != nil {
+				t.Fatalf("failed to parse flags: %v", err)
+			}
+			for name, want := range tc.want {
+				if got := fs.Lookup(name).Value.String(); got != want {
+					t.Errorf("-%s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseFlagsErrors(t *testing.T) {
+	config := writeConfig(t)
+	for name, args := range map[string][]string{
+		"unknown profile":     {"-config", config, "-profile", "missing"},
+		"missing config file": {"-config", filepath.Join(t.TempDir(), "missing.json")},
+		"invalid flag value":  {"-config", config, "-verbose"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			fs := testFlags("call")
+			fs.SetOutput(io.Discard)
+			if err := parseFlags(fs, args); err == nil {
+				t.Error("flags parsed")
+			}
+		})
+	}
+}
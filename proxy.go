@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	bridge "github.com/golain-io/mqtt-bridge"
+	auth "github.com/vedantkulkarni/reflect-poc/auth"
+	client "github.com/vedantkulkarni/reflect-poc/client"
+	gateway "github.com/vedantkulkarni/reflect-poc/gateway"
+	proxy "github.com/vedantkulkarni/reflect-poc/proxy"
+	server "github.com/vedantkulkarni/reflect-poc/server"
+	transport "github.com/vedantkulkarni/reflect-poc/transport"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+)
+
+// routeFlags collects repeated -route prefix=bridge-id flags
+type routeFlags []proxy.Route
+
+func (r *routeFlags) String() string {
+	routes := make([]string, 0, len(*r))
+	for _, route := range *r {
+		routes = append(routes, route.Prefix+"="+route.BridgeID)
+	}
+	return strings.Join(routes, ", ")
+}
+
+func (r *routeFlags) Set(value string) error {
+	route, err := proxy.ParseRoute(value)
+	if err != nil {
+		return err
+	}
+	*r = append(*r, route)
+	return nil
+}
+
+// registerDownstreamFlags adds the flags that secure the calls the proxy
+// makes to downstream devices, like the TLS and credential flags of clients
+func registerDownstreamFlags(fs *flag.FlagSet, cfg *client.Config, roles *string) {
+	fs.StringVar(&cfg.TLS.CAFile, "downstream-tls-ca", "", "CA bundle used to verify the certificates of downstream devices")
+	fs.StringVar(&cfg.TLS.CertFile, "downstream-tls-cert", "", "client certificate presented to downstream devices")
+	fs.StringVar(&cfg.TLS.KeyFile, "downstream-tls-key", "", "private key for -downstream-tls-cert")
+	fs.StringVar(&cfg.TLS.ServerName, "downstream-tls-server-name", "", "name expected in downstream certificates instead of their bridge ID")
+
+	fs.StringVar(&cfg.Auth.Token, "downstream-token", "", "bearer token sent with every downstream call")
+	fs.StringVar(&cfg.Auth.JWTSecret, "downstream-jwt-secret", "", "sign a JWT for every downstream call with this HS256 secret")
+	fs.StringVar(&cfg.Auth.Subject, "downstream-jwt-subject", "", "subject of the signed JWT")
+	fs.StringVar(roles, "downstream-jwt-roles", "", "comma separated roles of the signed JWT")
+	fs.StringVar(&cfg.Auth.HMACKeyID, "downstream-hmac-key-id", "", "key ID used to sign every downstream call")
+	fs.StringVar(&cfg.Auth.HMACSecret, "downstream-hmac-secret", "", "secret used to sign every downstream call")
+	fs.BoolVar(&cfg.Auth.AllowInsecure, "downstream-allow-insecure-credentials", false, "send tokens to downstream devices without end-to-end TLS")
+}
+
+// runProxy forwards calls to downstream devices until SIGINT or SIGTERM
+func runProxy(args []string) error {
+	fs := flag.NewFlagSet("proxy", flag.ExitOnError)
+	broker := transport.BrokerConfig{ClientID: "reflect-proxy"}
+	registerBrokerFlags(fs, &broker)
+	bridgeID := fs.String("bridge-id", "edge-proxy", "bridge ID the proxy listens on")
+	listen := fs.String("listen", "", "serve over TCP on this address instead of MQTT, e.g. 127.0.0.1:50051")
+	var routes routeFlags
+	fs.Var(&routes, "route", "route methods starting with a prefix to a device, as 'pkg.Service=bridge-id'; an empty prefix routes every method (repeatable)")
+	bridgeIDKey := fs.String("bridge-id-key", proxy.DefaultBridgeIDKey, "metadata key that names the device of a call, overriding -route")
+	allowedBridgeIDs := fs.String("allowed-bridge-ids", "", "comma separated devices, besides those of -route, that calls may name with -bridge-id-key")
+	var downstreamConfig client.Config
+	var downstreamRoles string
+	registerDownstreamFlags(fs, &downstreamConfig, &downstreamRoles)
+	// Callers of the proxy act with its downstream credentials, so they are
+	// authenticated like the callers of a device
+	var serverAuth auth.ServerConfig
+	var policyFile string
+	registerServerAuthFlags(fs, &serverAuth, &policyFile)
+	embeddedBroker := registerEmbeddedBrokerFlag(fs)
+	drainTimeout := fs.Duration("drain-timeout", server.DefaultDrainTimeout, "how long in-flight calls get to finish on shutdown")
+	var logOpts logOptions
+	logOpts.register(fs, "info")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	logger, err := logOpts.logger()
+	if err != nil {
+		return err
+	}
+	defer logger.Sync()
+	broker.Logger = logger
+	if *embeddedBroker {
+		b, err := startEmbeddedBroker(&broker, logger)
+		if err != nil {
+			return err
+		}
+		defer b.Close()
+	}
+
+	allowed := splitList(*allowedBridgeIDs)
+	for _, id := range allowed {
+		if err := gateway.ValidateBridgeID(id); err != nil {
+			return err
+		}
+	}
+
+	interceptors := server.Interceptors{Logger: logger}
+	serverAuth.JWTAudience = *bridgeID
+	authenticated, err := installServerAuth(serverAuth, policyFile, &interceptors)
+	if err != nil {
+		return err
+	}
+	if downstreamConfig.Auth.Enabled() && !authenticated {
+		return errors.New("downstream credentials require the callers of the proxy to authenticate, set one of the -auth-* flags")
+	}
+
+	// Downstream calls use a broker connection of their own
+	downstreamConfig.Broker = broker
+	downstreamConfig.Broker.ClientID += "-downstream"
+	downstreamConfig.Logger = logger
+	if downstreamRoles != "" {
+		downstreamConfig.Auth.Roles = strings.Split(downstreamRoles, ",")
+	}
+	pool, err := client.NewPool(downstreamConfig)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+
+	p := proxy.New(proxy.Config{
+		Pool:             pool,
+		Routes:           routes,
+		BridgeIDKey:      *bridgeIDKey,
+		AllowedBridgeIDs: allowed,
+		DownstreamTLS:    downstreamConfig.TLS.Enabled(),
+		Logger:           logger,
+	})
+	grpcServer := grpc.NewServer(append(interceptors.ServerOptions(), p.ServerOptions()...)...)
+	p.RegisterReflection(grpcServer)
+	health := server.NewHealth(logger)
+	health.Register(grpcServer)
+
+	// Read the downstream schemas up front so that the presence lists them;
+	// devices that are offline now are described once they answer
+	refreshCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	p.Refresh(refreshCtx)
+	cancel()
+
+	lifecycle := &server.Lifecycle{
+		GRPCServer:   grpcServer,
+		BridgeID:     *bridgeID,
+		DrainTimeout: *drainTimeout,
+		Health:       health,
+		Logger:       logger,
+	}
+	if *listen != "" {
+		if lifecycle.Listener, err = net.Listen("tcp", *listen); err != nil {
+			return fmt.Errorf("failed to listen on %s: %w", *listen, err)
+		}
+		logger.Info("Serving proxy over TCP", zap.String("addr", lifecycle.Listener.Addr().String()), zap.Stringer("routes", &routes))
+	} else {
+		services := server.ServiceNames(grpcServer)
+		for _, service := range p.Services() {
+			if _, ok := grpcServer.GetServiceInfo()[service]; !ok {
+				services = append(services, service)
+			}
+		}
+		lifecycle.Descriptors, err = server.DescriptorSet(p.Resolver(), services)
+		if err != nil {
+			return fmt.Errorf("failed to describe services: %w", err)
+		}
+		hash, err := server.DescriptorHash(lifecycle.Descriptors)
+		if err != nil {
+			return err
+		}
+		lifecycle.Presence = server.Presence{
+			BridgeID:       *bridgeID,
+			Online:         true,
+			Services:       services,
+			Version:        buildVersion(),
+			DescriptorHash: hash,
+		}
+		if broker.Will, err = server.PresenceWill(lifecycle.Presence); err != nil {
+			return err
+		}
+
+		mqttClient, err := transport.Connect(broker)
+		if err != nil {
+			return err
+		}
+		netBridge := bridge.NewMQTTNetBridge(mqttClient, logger, *bridgeID)
+		lifecycle.MQTTClient = mqttClient
+		lifecycle.Listener = transport.BufferListener(mqttClient.TrackListener(netBridge))
+		logger.Info("Serving proxy over MQTT", zap.String("bridge_id", *bridgeID), zap.Stringer("routes", &routes))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	if err := lifecycle.Run(ctx); err != nil {
+		return fmt.Errorf("failed to serve: %w", err)
+	}
+	return nil
+}
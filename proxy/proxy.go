@@ -0,0 +1,249 @@
+// Package proxy forwards calls for services it does not serve itself to
+// downstream devices, frame by frame, and merges their reflection
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+
+	client "github.com/vedantkulkarni/reflect-poc/client"
+	gateway "github.com/vedantkulkarni/reflect-poc/gateway"
+	transport "github.com/vedantkulkarni/reflect-poc/transport"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultBridgeIDKey is the metadata key that selects the downstream device of a call
+const DefaultBridgeIDKey = "x-bridge-id"
+
+// Route sends the calls whose method starts with Prefix to BridgeID. Prefixes
+// are matched against pkg.Service/Method; an empty prefix routes every call.
+type Route struct {
+	Prefix   string
+	BridgeID string
+}
+
+// ParseRoute parses a route written as prefix=bridge-id
+func ParseRoute(value string) (Route, error) {
+	prefix, bridgeID, ok := strings.Cut(value, "=")
+	if !ok {
+		return Route{}, fmt.Errorf("route %q is not in the form prefix=bridge-id", value)
+	}
+	route := Route{Prefix: strings.TrimPrefix(strings.TrimSpace(prefix), "/"), BridgeID: strings.TrimSpace(bridgeID)}
+	if err := gateway.ValidateBridgeID(route.BridgeID); err != nil {
+		return Route{}, err
+	}
+	return route, nil
+}
+
+// Config describes where the proxy sends calls
+type Config struct {
+	// Pool provides the connection to each downstream device
+	Pool *client.Pool
+	// Routes map method prefixes to devices; the longest matching prefix wins
+	Routes []Route
+	// BridgeIDKey is the metadata key that names the device of a call,
+	// overriding Routes; empty means DefaultBridgeIDKey
+	BridgeIDKey string
+	// AllowedBridgeIDs are the devices, besides those of Routes, that callers
+	// may name with BridgeIDKey; any other device is refused
+	AllowedBridgeIDs []string
+	// DownstreamTLS reports that the Pool secures its calls with end-to-end
+	// TLS. Without it the credentials of callers, such as their authorization
+	// metadata, are not forwarded, since anyone on the broker could read them.
+	DownstreamTLS bool
+	// Logger records routing decisions; nil disables logging
+	Logger *zap.Logger
+}
+
+// Proxy forwards every call to a service its server does not register, via
+// grpc.UnknownServiceHandler, without decoding the messages
+type Proxy struct {
+	cfg    Config
+	routes []Route
+	logger *zap.Logger
+	schema *mergedSchema
+}
+
+// New returns a proxy for cfg
+func New(cfg Config) *Proxy {
+	if cfg.BridgeIDKey == "" {
+		cfg.BridgeIDKey = DefaultBridgeIDKey
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = zap.NewNop()
+	}
+	routes := append([]Route(nil), cfg.Routes...)
+	sort.SliceStable(routes, func(i, j int) bool { return len(routes[i].Prefix) > len(routes[j].Prefix) })
+	p := &Proxy{cfg: cfg, routes: routes, logger: cfg.Logger}
+	p.schema = newMergedSchema(p)
+	return p
+}
+
+// ServerOptions forward unknown services to the downstream devices. The
+// codec lets the proxy relay raw frames while the services it registers
+// itself, such as reflection and health, keep working.
+func (p *Proxy) ServerOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.UnknownServiceHandler(p.handle),
+		grpc.ForceServerCodec(transport.FrameCodec{}),
+	}
+}
+
+// Downstreams returns the devices of the routing table
+func (p *Proxy) Downstreams() []string {
+	seen := make(map[string]bool)
+	var bridgeIDs []string
+	for _, route := range p.cfg.Routes {
+		if !seen[route.BridgeID] {
+			seen[route.BridgeID] = true
+			bridgeIDs = append(bridgeIDs, route.BridgeID)
+		}
+	}
+	return bridgeIDs
+}
+
+// route returns the device of a call: the one its metadata names, if it is
+// a downstream or allowed device, or the one of the longest matching route
+func (p *Proxy) route(ctx context.Context, fullMethod string) (string, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(p.cfg.BridgeIDKey); len(values) > 0 {
+		if err := gateway.ValidateBridgeID(values[0]); err != nil {
+			return "", status.Error(codes.InvalidArgument, err.Error())
+		}
+		if !slices.Contains(p.Downstreams(), values[0]) && !slices.Contains(p.cfg.AllowedBridgeIDs, values[0]) {
+			return "", status.Errorf(codes.PermissionDenied, "device %s is not reachable through this proxy", values[0])
+		}
+		return values[0], nil
+	}
+	method := strings.TrimPrefix(fullMethod, "/")
+	for _, route := range p.routes {
+		if strings.HasPrefix(method, route.Prefix) {
+			return route.BridgeID, nil
+		}
+	}
+	return "", status.Errorf(codes.Unimplemented, "no route for %s; set %s metadata to choose a device", fullMethod, p.cfg.BridgeIDKey)
+}
+
+// credentialKeys are the metadata keys that carry caller credentials
+var credentialKeys = []string{"authorization", "proxy-authorization", "cookie", "x-api-key"}
+
+// forwardedMetadata drops the headers the downstream connection sets itself
+// and, unless the downstream connection uses TLS, the caller's credentials
+func (p *Proxy) forwardedMetadata(ctx context.Context) metadata.MD {
+	md, _ := metadata.FromIncomingContext(ctx)
+	md = md.Copy()
+	for _, key := range []string{":authority", "content-type", "user-agent", p.cfg.BridgeIDKey} {
+		delete(md, key)
+	}
+	if !p.cfg.DownstreamTLS {
+		for _, key := range credentialKeys {
+			delete(md, key)
+		}
+	}
+	return md
+}
+
+// handle relays a call to its downstream device
+func (p *Proxy) handle(_ any, serverStream grpc.ServerStream) error {
+	fullMethod, _ := grpc.MethodFromServerStream(serverStream)
+	ctx := serverStream.Context()
+	bridgeID, err := p.route(ctx, fullMethod)
+	if err != nil {
+		return err
+	}
+	drs, err := p.cfg.Pool.Client(bridgeID)
+	if err != nil {
+		return status.Errorf(codes.Unavailable, "failed to open client for %s: %v", bridgeID, err)
+	}
+	p.logger.Debug("Routing call", zap.String("method", fullMethod), zap.String("bridge_id", bridgeID))
+
+	ctx, cancel := context.WithCancel(metadata.NewOutgoingContext(ctx, p.forwardedMetadata(ctx)))
+	defer cancel()
+	clientStream, err := drs.Conn().NewStream(ctx, &grpc.StreamDesc{ServerStreams: true, ClientStreams: true},
+		fullMethod, grpc.ForceCodec(transport.FrameCodec{}))
+	if err != nil {
+		return err
+	}
+
+	// Requests flow up while responses flow down; the call ends with the
+	// status of the downstream device
+	upErr := make(chan error, 1)
+	go func() {
+		upErr <- forwardRequests(serverStream, clientStream)
+	}()
+
+	responsesDone := make(chan error, 1)
+	go func() {
+		responsesDone <- forwardResponses(clientStream, serverStream)
+	}()
+
+	for {
+		select {
+		case err := <-upErr:
+			if err != nil {
+				// The caller went away or sent a broken frame
+				cancel()
+				return err
+			}
+			upErr = nil
+		case err := <-responsesDone:
+			serverStream.SetTrailer(responseMetadata(clientStream.Trailer()))
+			return err
+		}
+	}
+}
+
+// forwardRequests relays the requests of the caller and half-closes the
+// downstream call after the last one
+func forwardRequests(from grpc.ServerStream, to grpc.ClientStream) error {
+	for {
+		var frame []byte
+		if err := from.RecvMsg(&frame); err != nil {
+			if errors.Is(err, io.EOF) {
+				return to.CloseSend()
+			}
+			return err
+		}
+		if err := to.SendMsg(&frame); err != nil {
+			// The downstream call ended; its status comes with the responses
+			return nil
+		}
+	}
+}
+
+// forwardResponses relays the headers and responses of the device
+func forwardResponses(from grpc.ClientStream, to grpc.ServerStream) error {
+	if header, err := from.Header(); err == nil {
+		if err := to.SendHeader(responseMetadata(header)); err != nil {
+			return err
+		}
+	}
+	for {
+		var frame []byte
+		if err := from.RecvMsg(&frame); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if err := to.SendMsg(&frame); err != nil {
+			return err
+		}
+	}
+}
+
+// responseMetadata drops the content type, which the proxy's own server sets
+func responseMetadata(md metadata.MD) metadata.MD {
+	md = md.Copy()
+	delete(md, "content-type")
+	return md
+}
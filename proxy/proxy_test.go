@@ -0,0 +1,168 @@
+package proxy
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	server "github.com/vedantkulkarni/reflect-poc/server"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	_ "github.com/vedantkulkarni/reflect-poc/service-proto"
+)
+
+func TestParseRoute(t *testing.T) {
+	route, err := ParseRoute(" /reflect.TestService = pump-1 ")
+	if err != nil {
+		t.Fatalf("failed to parse route: %v", err)
+	}
+	if route != (Route{Prefix: "reflect.TestService", BridgeID: "pump-1"}) {
+		t.Errorf("route = %+v", route)
+	}
+	for _, value := range []string{"reflect.TestService", "reflect.TestService=", "reflect.TestService=pumps/1"} {
+		if _, err := ParseRoute(value); err == nil {
+			t.Errorf("parsed route %q", value)
+		}
+	}
+}
+
+func TestRoute(t *testing.T) {
+	p := New(Config{
+		Routes: []Route{
+			{Prefix: "", BridgeID: "default"},
+			{Prefix: "reflect.", BridgeID: "reflect"},
+			{Prefix: "reflect.SyncService/", BridgeID: "sync"},
+		},
+		AllowedBridgeIDs: []string{"spare"},
+	})
+	for _, tc := range []struct {
+		name     string
+		method   string
+		bridgeID string
+		want     string
+		code     codes.Code
+	}{
+		{name: "longest prefix", method: "/reflect.SyncService/Sync", want: "sync"},
+		{name: "shorter prefix", method: "/reflect.TestService/Test", want: "reflect"},
+		{name: "empty prefix", method: "/other.Service/Call", want: "default"},
+		{name: "metadata names a route device", method: "/reflect.SyncService/Sync", bridgeID: "default", want: "default"},
+		{name: "metadata names an allowed device", method: "/reflect.TestService/Test", bridgeID: "spare", want: "spare"},
+		{name: "metadata names another device", method: "/reflect.TestService/Test", bridgeID: "billing", code: codes.PermissionDenied},
+		{name: "metadata names an invalid device", method: "/reflect.TestService/Test", bridgeID: "pumps/#", code: codes.InvalidArgument},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tc.bridgeID != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(DefaultBridgeIDKey, tc.bridgeID))
+			}
+			got, err := p.route(ctx, tc.method)
+			if code := status.Code(err); code != tc.code {
+				t.Fatalf("route code = %s (%v), want %s", code, err, tc.code)
+			}
+			if got != tc.want {
+				t.Errorf("route = %q, want %q", got, tc.want)
+			}
+		})
+	}
+
+	unrouted := New(Config{Routes: []Route{{Prefix: "reflect.SyncService/", BridgeID: "sync"}}})
+	if _, err := unrouted.route(context.Background(), "/reflect.TestService/Test"); status.Code(err) != codes.Unimplemented {
+		t.Errorf("unrouted call returned %v, want Unimplemented", err)
+	}
+}
+
+func TestForwardedMetadata(t *testing.T) {
+	incoming := metadata.Pairs(
+		":authority", "edge-proxy",
+		"content-type", "application/grpc",
+		"user-agent", "grpc-go",
+		DefaultBridgeIDKey, "pump-1",
+		"authorization", "Bearer secret",
+		"proxy-authorization", "Basic secret",
+		"cookie", "session=secret",
+		"x-api-key", "secret",
+		"x-request-id", "42",
+		"trace-bin", "\x01\x02",
+	)
+	ctx := metadata.NewIncomingContext(context.Background(), incoming)
+	for _, tc := range []struct {
+		downstreamTLS bool
+		want          []string
+	}{
+		{false, []string{"trace-bin", "x-request-id"}},
+		{true, []string{"authorization", "cookie", "proxy-authorization", "trace-bin", "x-api-key", "x-request-id"}},
+	} {
+		p := New(Config{DownstreamTLS: tc.downstreamTLS})
+		md := p.forwardedMetadata(ctx)
+		var keys []string
+		for key := range md {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		if !slices.Equal(keys, tc.want) {
+			t.Errorf("with downstream TLS %v forwarded %v, want %v", tc.downstreamTLS, keys, tc.want)
+		}
+	}
+	if len(incoming.Get("authorization")) != 1 {
+		t.Error("forwardedMetadata changed the incoming metadata")
+	}
+}
+
+func descriptors(t *testing.T, services ...string) *descriptorpb.FileDescriptorSet {
+	t.Helper()
+	set, err := server.FileDescriptorSet(services)
+	if err != nil {
+		t.Fatalf("failed to build descriptors: %v", err)
+	}
+	return set
+}
+
+func TestSchemaMerge(t *testing.T) {
+	p := New(Config{Routes: []Route{
+		{Prefix: "reflect.", BridgeID: "pump-1"},
+		{Prefix: "grpc.health.", BridgeID: "pump-2"},
+	}})
+	schema := p.schema
+
+	if err := schema.add("pump-1", descriptors(t, "reflect.TestService")); err != nil {
+		t.Fatalf("failed to add pump-1: %v", err)
+	}
+	// A device serving the same files adds no services twice
+	if err := schema.add("pump-3", descriptors(t, "reflect.TestService")); err != nil {
+		t.Fatalf("failed to add pump-3: %v", err)
+	}
+	if err := schema.add("pump-2", descriptors(t, "grpc.health.v1.Health")); err != nil {
+		t.Fatalf("failed to add pump-2: %v", err)
+	}
+	want := []string{"grpc.health.v1.Health", "reflect.SyncService", "reflect.TestService"}
+	if services := p.Services(); !slices.Equal(services, want) {
+		t.Errorf("services = %v, want %v", services, want)
+	}
+	if _, err := p.Resolver().FindDescriptorByName("reflect.TestService.Test"); err != nil {
+		t.Errorf("merged schema does not resolve a downstream method: %v", err)
+	}
+
+	// Another file defining the same types does not merge and leaves the
+	// schema as it was
+	conflicting := descriptors(t, "reflect.TestService")
+	renamed := proto.Clone(conflicting.File[len(conflicting.File)-1]).(*descriptorpb.FileDescriptorProto)
+	renamed.Name = proto.String("copy/reflect.proto")
+	conflicting.File = append(conflicting.File, renamed)
+	if err := schema.add("pump-4", conflicting); err == nil {
+		t.Fatal("merged descriptors defining the same types twice")
+	}
+	if services := p.Services(); !slices.Equal(services, want) {
+		t.Errorf("services after a failed merge = %v, want %v", services, want)
+	}
+	schema.mu.Lock()
+	_, added := schema.sets["pump-4"]
+	schema.mu.Unlock()
+	if added {
+		t.Error("kept the descriptors of a device that failed to merge")
+	}
+}
@@ -0,0 +1,232 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	client "github.com/vedantkulkarni/reflect-poc/client"
+	server "github.com/vedantkulkarni/reflect-poc/server"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+)
+
+const (
+	// schemaTimeout bounds reading the descriptors of the downstream devices
+	schemaTimeout = 5 * time.Second
+	// schemaRetryInterval spaces the retries for devices that did not answer
+	schemaRetryInterval = 30 * time.Second
+)
+
+// mergedSchema holds the descriptors of every downstream device of the
+// routing table, merged into one registry
+type mergedSchema struct {
+	proxy *Proxy
+
+	// refreshMu serializes refreshes, which read from the network; mu only
+	// guards the state, so lookups never wait for a device
+	refreshMu sync.Mutex
+
+	mu          sync.Mutex
+	sets        map[string]*descriptorpb.FileDescriptorSet
+	files       *protoregistry.Files
+	services    []string
+	lastAttempt time.Time
+}
+
+func newMergedSchema(p *Proxy) *mergedSchema {
+	return &mergedSchema{
+		proxy: p,
+		sets:  make(map[string]*descriptorpb.FileDescriptorSet),
+		files: new(protoregistry.Files),
+	}
+}
+
+// Refresh reads the descriptors of the downstream devices not read yet. The
+// devices that fail are retried by later reflection requests.
+func (p *Proxy) Refresh(ctx context.Context) error {
+	return p.schema.refresh(ctx)
+}
+
+// Services returns the services of the downstream devices read so far
+func (p *Proxy) Services() []string {
+	p.schema.mu.Lock()
+	defer p.schema.mu.Unlock()
+	return p.schema.services
+}
+
+// Resolver finds the descriptors of the downstream devices first and of the
+// services compiled into the binary next
+func (p *Proxy) Resolver() protodesc.Resolver {
+	return schemaResolver{p.schema}
+}
+
+// RegisterReflection registers a reflection service on s that describes the
+// services of s together with those of every downstream device
+func (p *Proxy) RegisterReflection(s *grpc.Server) {
+	reflectionpb.RegisterServerReflectionServer(s, reflection.NewServerV1(reflection.ServerOptions{
+		Services:           serviceInfo{server: s, proxy: p},
+		DescriptorResolver: p.Resolver(),
+	}))
+}
+
+func (m *mergedSchema) refresh(ctx context.Context) error {
+	m.refreshMu.Lock()
+	defer m.refreshMu.Unlock()
+	return m.refreshLocked(ctx)
+}
+
+// refreshLocked reads the descriptors of the devices not read yet, without
+// holding mu, and adds them; refreshMu must be held
+func (m *mergedSchema) refreshLocked(ctx context.Context) error {
+	m.mu.Lock()
+	m.lastAttempt = time.Now()
+	var pending []string
+	for _, bridgeID := range m.proxy.Downstreams() {
+		if _, ok := m.sets[bridgeID]; !ok {
+			pending = append(pending, bridgeID)
+		}
+	}
+	m.mu.Unlock()
+
+	var firstErr error
+	for _, bridgeID := range pending {
+		set, err := m.export(ctx, bridgeID)
+		if err == nil {
+			err = m.add(bridgeID, set)
+		}
+		if err != nil {
+			m.proxy.logger.Warn("Failed to read descriptors of downstream device", zap.String("bridge_id", bridgeID), zap.Error(err))
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// add merges the descriptors of a newly read device into the registry. The
+// registry is only replaced once the merge succeeds, so a device whose
+// descriptors conflict with the others leaves it as it was.
+func (m *mergedSchema) add(bridgeID string, set *descriptorpb.FileDescriptorSet) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sets := make(map[string]*descriptorpb.FileDescriptorSet, len(m.sets)+1)
+	for id, existing := range m.sets {
+		sets[id] = existing
+	}
+	sets[bridgeID] = set
+	files, services, err := merge(sets)
+	if err != nil {
+		return fmt.Errorf("failed to merge descriptors: %w", err)
+	}
+	m.sets, m.files, m.services = sets, files, services
+	return nil
+}
+
+func (m *mergedSchema) export(ctx context.Context, bridgeID string) (*descriptorpb.FileDescriptorSet, error) {
+	drs, err := m.proxy.cfg.Pool.Client(bridgeID)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, schemaTimeout)
+	defer cancel()
+	return client.ExportDescriptors(ctx, drs)
+}
+
+// merge builds a registry from the sets of every device and lists their
+// services. Files are shared by path, so devices serving the same API add
+// their services once.
+func merge(sets map[string]*descriptorpb.FileDescriptorSet) (*protoregistry.Files, []string, error) {
+	union := &descriptorpb.FileDescriptorSet{}
+	seen := make(map[string]bool)
+	bridgeIDs := make([]string, 0, len(sets))
+	for bridgeID := range sets {
+		bridgeIDs = append(bridgeIDs, bridgeID)
+	}
+	sort.Strings(bridgeIDs)
+	for _, bridgeID := range bridgeIDs {
+		for _, file := range sets[bridgeID].File {
+			if !seen[file.GetName()] {
+				seen[file.GetName()] = true
+				union.File = append(union.File, file)
+			}
+		}
+	}
+
+	files, err := protodesc.NewFiles(union)
+	if err != nil {
+		return nil, nil, err
+	}
+	var services []string
+	files.RangeFiles(func(file protoreflect.FileDescriptor) bool {
+		for i := 0; i < file.Services().Len(); i++ {
+			services = append(services, string(file.Services().Get(i).FullName()))
+		}
+		return true
+	})
+	sort.Strings(services)
+	return files, services, nil
+}
+
+// current returns the merged registry, first retrying the devices that did
+// not answer if the last attempt is old enough. Lookups made while another
+// refresh is running get the registry as it is.
+func (m *mergedSchema) current() (*protoregistry.Files, []string) {
+	m.mu.Lock()
+	stale := len(m.sets) < len(m.proxy.Downstreams()) && time.Since(m.lastAttempt) > schemaRetryInterval
+	m.mu.Unlock()
+	if stale && m.refreshMu.TryLock() {
+		m.refreshLocked(context.Background())
+		m.refreshMu.Unlock()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.files, m.services
+}
+
+// serviceInfo lists the registered and the downstream services for reflection
+type serviceInfo struct {
+	server *grpc.Server
+	proxy  *Proxy
+}
+
+func (s serviceInfo) GetServiceInfo() map[string]grpc.ServiceInfo {
+	info := s.server.GetServiceInfo()
+	_, services := s.proxy.schema.current()
+	for _, name := range services {
+		if _, ok := info[name]; !ok {
+			info[name] = grpc.ServiceInfo{}
+		}
+	}
+	return info
+}
+
+// schemaResolver resolves descriptors from the merged registry as it is at
+// the time of each lookup
+type schemaResolver struct {
+	schema *mergedSchema
+}
+
+func (r schemaResolver) resolvers() server.Resolvers {
+	files, _ := r.schema.current()
+	return server.Resolvers{files, protoregistry.GlobalFiles}
+}
+
+func (r schemaResolver) FindFileByPath(path string) (protoreflect.FileDescriptor, error) {
+	return r.resolvers().FindFileByPath(path)
+}
+
+func (r schemaResolver) FindDescriptorByName(name protoreflect.FullName) (protoreflect.Descriptor, error) {
+	return r.resolvers().FindDescriptorByName(name)
+}
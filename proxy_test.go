@@ -0,0 +1,21 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestProxyRequiresAuthForDownstreamCredentials checks that the proxy does
+// not lend its downstream identity to callers it does not authenticate
+func TestProxyRequiresAuthForDownstreamCredentials(t *testing.T) {
+	for _, args := range [][]string{
+		{"-downstream-token", "t0ken"},
+		{"-downstream-jwt-secret", "s3cret"},
+		{"-downstream-hmac-key-id", "proxy", "-downstream-hmac-secret", "s3cret"},
+	} {
+		err := runProxy(append(args, "-log-level", "error"))
+		if err == nil || !strings.Contains(err.Error(), "authenticate") {
+			t.Errorf("runProxy(%q) returned %v, want an error asking for caller authentication", args, err)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package reflection
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Describe renders a descriptor in protobuf source syntax
+func Describe(desc protoreflect.Descriptor) string {
+	var b strings.Builder
+	switch d := desc.(type) {
+	case protoreflect.ServiceDescriptor:
+		fmt.Fprintf(&b, "service %s {\n", d.Name())
+		for i := 0; i < d.Methods().Len(); i++ {
+			fmt.Fprintf(&b, "  %s\n", describeMethod(d.Methods().Get(i)))
+		}
+		b.WriteString("}\n")
+	case protoreflect.MethodDescriptor:
+		fmt.Fprintf(&b, "%s\n", describeMethod(d))
+	case protoreflect.MessageDescriptor:
+		describeMessage(&b, d, "")
+	case protoreflect.EnumDescriptor:
+		describeEnum(&b, d, "")
+	case protoreflect.FieldDescriptor:
+		fmt.Fprintf(&b, "%s\n", describeField(d))
+	default:
+		fmt.Fprintf(&b, "%s\n", d.FullName())
+	}
+	return b.String()
+}
+
+func describeMethod(m protoreflect.MethodDescriptor) string {
+	input, output := string(m.Input().FullName()), string(m.Output().FullName())
+	if m.IsStreamingClient() {
+		input = "stream " + input
+	}
+	if m.IsStreamingServer() {
+		output = "stream " + output
+	}
+	return fmt.Sprintf("rpc %s(%s) returns (%s);", m.Name(), input, output)
+}
+
+func describeMessage(b *strings.Builder, m protoreflect.MessageDescriptor, indent string) {
+	fmt.Fprintf(b, "%smessage %s {\n", indent, m.Name())
+	for i := 0; i < m.Fields().Len(); i++ {
+		fmt.Fprintf(b, "%s  %s\n", indent, describeField(m.Fields().Get(i)))
+	}
+	for i := 0; i < m.Messages().Len(); i++ {
+		if nested := m.Messages().Get(i); !nested.IsMapEntry() {
+			describeMessage(b, nested, indent+"  ")
+		}
+	}
+	for i := 0; i < m.Enums().Len(); i++ {
+		describeEnum(b, m.Enums().Get(i), indent+"  ")
+	}
+	fmt.Fprintf(b, "%s}\n", indent)
+}
+
+func describeEnum(b *strings.Builder, e protoreflect.EnumDescriptor, indent string) {
+	fmt.Fprintf(b, "%senum %s {\n", indent, e.Name())
+	for i := 0; i < e.Values().Len(); i++ {
+		v := e.Values().Get(i)
+		fmt.Fprintf(b, "%s  %s = %d;\n", indent, v.Name(), v.Number())
+	}
+	fmt.Fprintf(b, "%s}\n", indent)
+}
+
+func describeField(f protoreflect.FieldDescriptor) string {
+	label := ""
+	if f.IsList() {
+		label = "repeated "
+	} else if f.HasOptionalKeyword() {
+		label = "optional "
+	}
+	return fmt.Sprintf("%s%s %s = %d;", label, fieldType(f), f.Name(), f.Number())
+}
+
+func fieldType(f protoreflect.FieldDescriptor) string {
+	if f.IsMap() {
+		return fmt.Sprintf("map<%s, %s>", fieldType(f.MapKey()), fieldType(f.MapValue()))
+	}
+	switch f.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return string(f.Message().FullName())
+	case protoreflect.EnumKind:
+		return string(f.Enum().FullName())
+	}
+	return f.Kind().String()
+}
@@ -4,8 +4,11 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"sync"
 
+	"go.uber.org/zap"
 	"google.golang.org/grpc"
 	v1 "google.golang.org/grpc/reflection/grpc_reflection_v1"
 	"google.golang.org/protobuf/encoding/protojson"
@@ -20,45 +23,58 @@ import (
 type GRPCReflectionHelper struct {
 	conn   *grpc.ClientConn
 	client v1.ServerReflectionClient
+	logger *zap.Logger
+
+	// files caches the descriptors resolved by FindSymbol
+	mu    sync.RWMutex
+	files *protoregistry.Files
+
+	onCacheLookup func(hit bool)
 }
 
-// NewGRPCReflectionHelper creates a new helper instance
-func NewGRPCReflectionHelper(conn *grpc.ClientConn) *GRPCReflectionHelper {
+// NewGRPCReflectionHelper creates a new helper instance. A nil logger disables logging.
+func NewGRPCReflectionHelper(conn *grpc.ClientConn, logger *zap.Logger) *GRPCReflectionHelper {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
 	return &GRPCReflectionHelper{
 		conn:   conn,
 		client: v1.NewServerReflectionClient(conn),
+		logger: logger,
+		files:  &protoregistry.Files{},
 	}
 }
 
+// OnCacheLookup registers fn to be called with the outcome of every cache
+// lookup made by FindSymbol. It must be called before the helper is used.
+func (g *GRPCReflectionHelper) OnCacheLookup(fn func(hit bool)) {
+	g.onCacheLookup = fn
+}
+
 // GetFileDescriptor retrieves the full file descriptor from a gRPC connection
 func (g *GRPCReflectionHelper) GetFileDescriptor(ctx context.Context, filename string) (*descriptorpb.FileDescriptorProto, error) {
-	stream, err := g.client.ServerReflectionInfo(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create reflection stream: %w", err)
-	}
-
 	request := &v1.ServerReflectionRequest{
 		MessageRequest: &v1.ServerReflectionRequest_FileByFilename{
 			FileByFilename: filename,
 		},
 	}
-
-	if err := stream.Send(request); err != nil {
-		return nil, fmt.Errorf("failed to send reflection request: %w", err)
-	}
-
-	response, err := stream.Recv()
+	response, err := g.request(ctx, request)
 	if err != nil {
-		return nil, fmt.Errorf("failed to receive reflection response: %w", err)
+		return nil, err
 	}
+	return firstFileDescriptor(response)
+}
 
-	fileDescBytes := response.GetFileDescriptorResponse().GetFileDescriptorProto()[0]
+// firstFileDescriptor returns the first file of a file descriptor response
+func firstFileDescriptor(response *v1.ServerReflectionResponse) (*descriptorpb.FileDescriptorProto, error) {
+	files := response.GetFileDescriptorResponse().GetFileDescriptorProto()
+	if len(files) == 0 {
+		return nil, errors.New("reflection response has no file descriptors")
+	}
 	fileDesc := &descriptorpb.FileDescriptorProto{}
-	
-	if err := proto.Unmarshal(fileDescBytes, fileDesc); err != nil {
+	if err := proto.Unmarshal(files[0], fileDesc); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal file descriptor: %w", err)
 	}
-
 	return fileDesc, nil
 }
 
@@ -77,34 +93,41 @@ func (g *GRPCReflectionHelper) formatJSON(msg proto.Message) (string, error) {
 	return prettyJSON.String(), nil
 }
 
-// GetFileDescriptorBySymbol retrieves file descriptor using service and method name
-func (g *GRPCReflectionHelper) GetFileDescriptorBySymbol(ctx context.Context, serviceName, methodName string) (*descriptorpb.FileDescriptorProto, error) {
-	stream, err := g.client.ServerReflectionInfo(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create reflection stream: %w", err)
-	}
+// legacyPackage is the package service names were once implied to be in;
+// names that are not found as given are looked up in it, so that callers
+// passing a bare service name keep working
+const legacyPackage = "reflect"
 
-	query := fmt.Sprintf("reflect.%s.%s", serviceName, methodName)
-	request := &v1.ServerReflectionRequest{
-		MessageRequest: &v1.ServerReflectionRequest_FileContainingSymbol{
-			FileContainingSymbol: query,
-		},
-	}
+// serviceNames returns the names a service given to the symbol helpers is
+// looked up as, in order
+func serviceNames(serviceName string) []string {
+	return []string{serviceName, legacyPackage + "." + serviceName}
+}
 
-	if err := stream.Send(request); err != nil {
-		return nil, fmt.Errorf("failed to send reflection request: %w", err)
+// GetFileDescriptorBySymbol retrieves file descriptor using the fully-qualified
+// service name and a method name. A service that is not found is looked up
+// again in the reflect package.
+func (g *GRPCReflectionHelper) GetFileDescriptorBySymbol(ctx context.Context, serviceName, methodName string) (*descriptorpb.FileDescriptorProto, error) {
+	var response *v1.ServerReflectionResponse
+	var err error
+	for _, name := range serviceNames(serviceName) {
+		request := &v1.ServerReflectionRequest{
+			MessageRequest: &v1.ServerReflectionRequest_FileContainingSymbol{
+				FileContainingSymbol: fmt.Sprintf("%s.%s", name, methodName),
+			},
+		}
+		response, err = g.request(ctx, request)
+		if !isNotFound(err) {
+			break
+		}
 	}
-
-	response, err := stream.Recv()
 	if err != nil {
-		return nil, fmt.Errorf("failed to receive reflection response: %w", err)
+		return nil, err
 	}
 
-	fileDescBytes := response.GetFileDescriptorResponse().GetFileDescriptorProto()[0]
-	fileDesc := &descriptorpb.FileDescriptorProto{}
-	
-	if err := proto.Unmarshal(fileDescBytes, fileDesc); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal file descriptor: %w", err)
+	fileDesc, err := firstFileDescriptor(response)
+	if err != nil {
+		return nil, err
 	}
 
 	_, err = g.formatJSON(fileDesc.ProtoReflect().Interface())
@@ -112,19 +135,26 @@ func (g *GRPCReflectionHelper) GetFileDescriptorBySymbol(ctx context.Context, se
 		return nil, fmt.Errorf("failed to format JSON: %w", err)
 	}
 
-
 	return fileDesc, nil
 }
 
-// GetMethodDescriptor retrieves the method descriptor for a specific service and method
+// GetMethodDescriptor retrieves the method descriptor for a fully-qualified
+// service and a method, falling back to the reflect package like
+// GetFileDescriptorBySymbol
 func (g *GRPCReflectionHelper) GetMethodDescriptor(ctx context.Context, serviceName, methodName string) (protoreflect.MethodDescriptor, error) {
 	fileDesc, err := g.GetFileDescriptorReflect(ctx, serviceName, methodName)
 	if err != nil {
 		return nil, err
 	}
 
-	services := fileDesc.Services()
-	service := services.ByName(protoreflect.Name(serviceName))
+	var service protoreflect.ServiceDescriptor
+	for _, name := range serviceNames(serviceName) {
+		fullName := protoreflect.FullName(name)
+		if found := fileDesc.Services().ByName(fullName.Name()); found != nil && found.FullName() == fullName {
+			service = found
+			break
+		}
+	}
 	if service == nil {
 		return nil, fmt.Errorf("service %s not found", serviceName)
 	}
@@ -139,37 +169,16 @@ func (g *GRPCReflectionHelper) GetMethodDescriptor(ctx context.Context, serviceN
 
 // GetInputOutputTypes retrieves the input and output message descriptors for a method
 func (g *GRPCReflectionHelper) GetInputOutputTypes(ctx context.Context, serviceName, methodName string) (protoreflect.MessageDescriptor, protoreflect.MessageDescriptor, error) {
-	fileDesc, err := g.GetFileDescriptorReflect(ctx, serviceName, methodName)
-	if err != nil {
-		return nil, nil, err
-	}
-
 	methodDesc, err := g.GetMethodDescriptor(ctx, serviceName, methodName)
 	if err != nil {
 		return nil, nil, err
 	}
 
 	return methodDesc.Input(), methodDesc.Output(), nil
-
-
-	inputDesc := fileDesc.Messages().ByName(protoreflect.Name("TestMessageRequest"))
-	outputDesc := fileDesc.Messages().ByName(protoreflect.Name("TestMessageResponse"))
-
-	fmt.Println("found inputDesc: ", inputDesc.Name())
-	fmt.Println("found outputDesc: ", outputDesc.Name())
-
-	if inputDesc == nil || outputDesc == nil {
-		return nil, nil, fmt.Errorf("failed to find input or output message descriptors")
-	}
-
-	return inputDesc, outputDesc, nil
 }
 
 // PopulateMessageFromJSON populates a dynamic protobuf message with JSON data
 func (g *GRPCReflectionHelper) PopulateMessageFromJSON(msg *dynamicpb.Message, jsonData []byte) error {
-	// Log input JSON and validate it's properly formatted
-	fmt.Printf("Input JSON: %s\n", string(jsonData))
-	
 	// Create UnmarshalOptions with more lenient settings
 	unmarshaler := protojson.UnmarshalOptions{
 		DiscardUnknown: true,
@@ -177,42 +186,19 @@ func (g *GRPCReflectionHelper) PopulateMessageFromJSON(msg *dynamicpb.Message, j
 	}
 
 	if err := unmarshaler.Unmarshal(jsonData, msg); err != nil {
-		// Add more detailed error information
-		fmt.Printf("Error details - Message type: %s\n", msg.Descriptor().FullName())
-		fmt.Printf("Expected fields:\n")
-		fields := msg.Descriptor().Fields()
-		for i := 0; i < fields.Len(); i++ {
-			fd := fields.Get(i)
-			fmt.Printf("  - Name: %s, JSONName: %s, Number: %d, Type: %s\n",
-				fd.Name(), fd.JSONName(), fd.Number(), fd.Kind())
-		}
+		g.logger.Debug("Failed to populate message from JSON",
+			zap.String("message_type", string(msg.Descriptor().FullName())),
+			zap.Int("bytes", len(jsonData)),
+			zap.Error(err))
 		return fmt.Errorf("failed to unmarshal JSON into dynamic message: %w", err)
 	}
 
-	// Log the successfully populated message
-	marshaler := protojson.MarshalOptions{Indent: "  "}
-	if debugJSON, err := marshaler.Marshal(msg); err == nil {
-		fmt.Printf("Successfully populated message:\n%s\n", string(debugJSON))
-	}
-
+	g.logger.Debug("Populated message from JSON",
+		zap.String("message_type", string(msg.Descriptor().FullName())),
+		zap.Int("bytes", len(jsonData)))
 	return nil
 }
 
-// Helper function to print message fields recursively
-func printMessageFields(desc protoreflect.MessageDescriptor, indent string) {
-	fields := desc.Fields()
-	for i := 0; i < fields.Len(); i++ {
-		fd := fields.Get(i)
-		fmt.Printf("%s- Field: %s (json: %s)\n", indent, fd.Name(), fd.JSONName())
-		
-		// If the field is a message type, recursively print its fields
-		if fd.Kind() == protoreflect.MessageKind && !fd.IsMap() && !fd.IsList() {
-			fmt.Printf("%s  Message fields:\n", indent)
-			printMessageFields(fd.Message(), indent+"    ")
-		}
-	}
-} 
-
 // ConvertMessageToJSON converts a dynamic protobuf message to JSON
 func (g *GRPCReflectionHelper) ConvertMessageToJSON(msg *dynamicpb.Message) (string, error) {
 	marshaler := protojson.MarshalOptions{
@@ -0,0 +1,210 @@
+package reflection_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	reflection "github.com/vedantkulkarni/reflect-poc/reflection"
+	server "github.com/vedantkulkarni/reflect-poc/server"
+	service_proto "github.com/vedantkulkarni/reflect-poc/service-proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	grpcreflection "google.golang.org/grpc/reflection"
+	v1 "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// dial serves register on an in-memory listener and returns a reflection
+// helper connected to it
+func dial(t *testing.T, register func(*grpc.Server)) *reflection.GRPCReflectionHelper {
+	t.Helper()
+	listener := bufconn.Listen(1 << 20)
+	grpcServer := grpc.NewServer()
+	register(grpcServer)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return reflection.NewGRPCReflectionHelper(conn, nil)
+}
+
+func registerTestService(s *grpc.Server) {
+	service_proto.RegisterTestServiceServer(s, &server.MyTestService{})
+	grpcreflection.RegisterV1(s)
+}
+
+func TestGetMethodDescriptor(t *testing.T) {
+	helper := dial(t, registerTestService)
+
+	method, err := helper.GetMethodDescriptor(context.Background(), "reflect.TestService", "Test")
+	if err != nil {
+		t.Fatalf("failed to get method: %v", err)
+	}
+	if got := method.FullName(); got != "reflect.TestService.Test" {
+		t.Errorf("method is %s, want reflect.TestService.Test", got)
+	}
+
+	// Bare service names are still looked up in the reflect package
+	method, err = helper.GetMethodDescriptor(context.Background(), "TestService", "Test")
+	if err != nil {
+		t.Fatalf("failed to get method of a bare service name: %v", err)
+	}
+	if got := method.FullName(); got != "reflect.TestService.Test" {
+		t.Errorf("method is %s, want reflect.TestService.Test", got)
+	}
+
+	if _, err := helper.GetMethodDescriptor(context.Background(), "other.TestService", "Test"); err == nil {
+		t.Error("found a method of a service in another package")
+	}
+}
+
+// emptyReflection answers every request with a file descriptor response
+// holding no files
+type emptyReflection struct {
+	v1.UnimplementedServerReflectionServer
+}
+
+func (emptyReflection) ServerReflectionInfo(stream v1.ServerReflection_ServerReflectionInfoServer) error {
+	if _, err := stream.Recv(); err != nil {
+		return err
+	}
+	return stream.Send(&v1.ServerReflectionResponse{
+		MessageResponse: &v1.ServerReflectionResponse_FileDescriptorResponse{
+			FileDescriptorResponse: &v1.FileDescriptorResponse{},
+		},
+	})
+}
+
+func TestGetFileDescriptorEmptyResponse(t *testing.T) {
+	helper := dial(t, func(s *grpc.Server) {
+		v1.RegisterServerReflectionServer(s, emptyReflection{})
+	})
+
+	if _, err := helper.GetFileDescriptor(context.Background(), "reflect.proto"); err == nil {
+		t.Error("GetFileDescriptor accepted a response without files")
+	}
+	if _, err := helper.GetFileDescriptorBySymbol(context.Background(), "reflect.TestService", "Test"); err == nil {
+		t.Error("GetFileDescriptorBySymbol accepted a response without files")
+	}
+}
+
+func TestGetFileDescriptorUnknownFile(t *testing.T) {
+	helper := dial(t, registerTestService)
+
+	if _, err := helper.GetFileDescriptor(context.Background(), "missing.proto"); err == nil {
+		t.Error("found a file the server does not have")
+	}
+}
+
+// slowDependencyReflection answers symbol requests with the file holding the
+// symbol only, and holds back requests for files by name until release
+// closes; fetching closes once such a request arrives
+type slowDependencyReflection struct {
+	v1.UnimplementedServerReflectionServer
+	files    map[string]*descriptorpb.FileDescriptorProto
+	symbols  map[string]string
+	fetching chan struct{}
+	release  chan struct{}
+}
+
+func (s *slowDependencyReflection) ServerReflectionInfo(stream v1.ServerReflection_ServerReflectionInfoServer) error {
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	name := s.symbols[req.GetFileContainingSymbol()]
+	if filename := req.GetFileByFilename(); filename != "" {
+		close(s.fetching)
+		<-s.release
+		name = filename
+	}
+	data, err := proto.Marshal(s.files[name])
+	if err != nil {
+		return err
+	}
+	return stream.Send(&v1.ServerReflectionResponse{
+		MessageResponse: &v1.ServerReflectionResponse_FileDescriptorResponse{
+			FileDescriptorResponse: &v1.FileDescriptorResponse{FileDescriptorProto: [][]byte{data}},
+		},
+	})
+}
+
+// TestFindSymbolFetchesWithoutLock checks that cached symbols resolve while
+// the dependency of another file is still being fetched
+func TestFindSymbolFetchesWithoutLock(t *testing.T) {
+	file := func(name, pkg, message string, deps ...string) *descriptorpb.FileDescriptorProto {
+		return &descriptorpb.FileDescriptorProto{
+			Name:        proto.String(name),
+			Package:     proto.String(pkg),
+			Dependency:  deps,
+			Syntax:      proto.String("proto3"),
+			MessageType: []*descriptorpb.DescriptorProto{{Name: proto.String(message)}},
+		}
+	}
+	slow := &slowDependencyReflection{
+		files: map[string]*descriptorpb.FileDescriptorProto{
+			"cached.proto": file("cached.proto", "cached", "C"),
+			"dep.proto":    file("dep.proto", "dep", "B"),
+			"svc.proto":    file("svc.proto", "svc", "A", "dep.proto"),
+		},
+		symbols:  map[string]string{"cached.C": "cached.proto", "svc.A": "svc.proto"},
+		fetching: make(chan struct{}),
+		release:  make(chan struct{}),
+	}
+	helper := dial(t, func(s *grpc.Server) {
+		v1.RegisterServerReflectionServer(s, slow)
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := helper.FindSymbol(ctx, "cached.C"); err != nil {
+		t.Fatalf("failed to resolve cached.C: %v", err)
+	}
+	resolved := make(chan error, 1)
+	go func() {
+		_, err := helper.FindSymbol(ctx, "svc.A")
+		resolved <- err
+	}()
+
+	// svc.A waits for dep.proto, which the server holds back
+	select {
+	case <-slow.fetching:
+	case err := <-resolved:
+		t.Fatalf("svc.A resolved without fetching its dependency: %v", err)
+	}
+	lookup := make(chan error, 1)
+	go func() {
+		_, err := helper.FindSymbol(ctx, "cached.C")
+		lookup <- err
+	}()
+	select {
+	case err := <-lookup:
+		if err != nil {
+			t.Errorf("failed to resolve cached.C from the cache: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("a cache lookup waited for another symbol's dependency fetch")
+	}
+
+	close(slow.release)
+	if err := <-resolved; err != nil {
+		t.Fatalf("failed to resolve svc.A: %v", err)
+	}
+	if _, err := helper.FindSymbol(ctx, "dep.B"); err != nil {
+		t.Errorf("the fetched dependency was not cached: %v", err)
+	}
+}
@@ -0,0 +1,254 @@
+package reflection
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	v1 "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// request sends a single reflection request on a new stream and returns the response
+func (g *GRPCReflectionHelper) request(ctx context.Context, req *v1.ServerReflectionRequest) (*v1.ServerReflectionResponse, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := g.client.ServerReflectionInfo(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create reflection stream: %w", err)
+	}
+	if err := stream.Send(req); err != nil {
+		return nil, fmt.Errorf("failed to send reflection request: %w", err)
+	}
+	response, err := stream.Recv()
+	if err != nil {
+		return nil, fmt.Errorf("failed to receive reflection response: %w", err)
+	}
+	stream.CloseSend()
+
+	if errResp := response.GetErrorResponse(); errResp != nil {
+		return nil, &errorResponse{code: codes.Code(errResp.GetErrorCode()), message: errResp.GetErrorMessage()}
+	}
+	return response, nil
+}
+
+// errorResponse is an error answered by the reflection service
+type errorResponse struct {
+	code    codes.Code
+	message string
+}
+
+func (e *errorResponse) Error() string {
+	return fmt.Sprintf("reflection error %d: %s", e.code, e.message)
+}
+
+// isNotFound reports whether the reflection service did not know a symbol or file
+func isNotFound(err error) bool {
+	var errResp *errorResponse
+	return errors.As(err, &errResp) && errResp.code == codes.NotFound
+}
+
+// ListServices returns the fully-qualified names of the services exposed by the server
+func (g *GRPCReflectionHelper) ListServices(ctx context.Context) ([]string, error) {
+	response, err := g.request(ctx, &v1.ServerReflectionRequest{
+		MessageRequest: &v1.ServerReflectionRequest_ListServices{},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var services []string
+	for _, service := range response.GetListServicesResponse().GetService() {
+		services = append(services, service.GetName())
+	}
+	sort.Strings(services)
+	return services, nil
+}
+
+// FindSymbol resolves a fully-qualified service, method, message, enum or field
+// name. Methods may also be written as pkg.Service/Method. Files fetched from
+// the server are cached together with their dependencies.
+func (g *GRPCReflectionHelper) FindSymbol(ctx context.Context, name string) (protoreflect.Descriptor, error) {
+	fullName, err := symbolName(name)
+	if err != nil {
+		return nil, err
+	}
+	name = string(fullName)
+
+	desc, err := g.findCached(fullName)
+	if g.onCacheLookup != nil {
+		g.onCacheLookup(err == nil)
+	}
+	if err == nil {
+		g.logger.Debug("Resolved symbol from cache", zap.String("symbol", name))
+		return desc, nil
+	}
+	g.logger.Debug("Resolving symbol through reflection", zap.String("symbol", name))
+
+	response, err := g.request(ctx, &v1.ServerReflectionRequest{
+		MessageRequest: &v1.ServerReflectionRequest_FileContainingSymbol{
+			FileContainingSymbol: name,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", name, err)
+	}
+	if err := g.registerFiles(ctx, response.GetFileDescriptorResponse().GetFileDescriptorProto()); err != nil {
+		return nil, err
+	}
+
+	desc, err = g.findCached(fullName)
+	if err != nil {
+		return nil, fmt.Errorf("symbol %s not found: %w", name, err)
+	}
+	return desc, nil
+}
+
+// symbolName normalizes a symbol written as pkg.Service/Method or with a leading slash
+func symbolName(name string) (protoreflect.FullName, error) {
+	name = strings.TrimPrefix(strings.ReplaceAll(name, "/", "."), ".")
+	fullName := protoreflect.FullName(name)
+	if !fullName.IsValid() {
+		return "", fmt.Errorf("invalid symbol name %q", name)
+	}
+	return fullName, nil
+}
+
+func (g *GRPCReflectionHelper) findCached(name protoreflect.FullName) (protoreflect.Descriptor, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.files.FindDescriptorByName(name)
+}
+
+// FindMethod resolves a method given as /pkg.Service/Method, pkg.Service/Method or pkg.Service.Method
+func (g *GRPCReflectionHelper) FindMethod(ctx context.Context, name string) (protoreflect.MethodDescriptor, error) {
+	return FindMethod(ctx, g, name)
+}
+
+// FindMethod resolves name through source and checks that it is a method
+func FindMethod(ctx context.Context, source DescriptorSource, name string) (protoreflect.MethodDescriptor, error) {
+	desc, err := source.FindSymbol(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	method, ok := desc.(protoreflect.MethodDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a method", desc.FullName())
+	}
+	return method, nil
+}
+
+// registerFiles adds serialized file descriptors to the cache, fetching any
+// dependencies the server did not include. Fetches happen without g.mu held,
+// so that a slow device does not block readers of the cache.
+func (g *GRPCReflectionHelper) registerFiles(ctx context.Context, serialized [][]byte) error {
+	pending := make(map[string]*descriptorpb.FileDescriptorProto)
+	var queue []*descriptorpb.FileDescriptorProto
+	for _, data := range serialized {
+		file := &descriptorpb.FileDescriptorProto{}
+		if err := proto.Unmarshal(data, file); err != nil {
+			return fmt.Errorf("failed to unmarshal file descriptor: %w", err)
+		}
+		pending[file.GetName()] = file
+		queue = append(queue, file)
+	}
+
+	for len(queue) > 0 {
+		file := queue[0]
+		queue = queue[1:]
+		for _, dep := range file.GetDependency() {
+			if _, ok := pending[dep]; ok || g.hasFile(dep) {
+				continue
+			}
+			fetched, err := g.fetchFile(ctx, dep)
+			if err != nil {
+				return err
+			}
+			pending[dep] = fetched
+			queue = append(queue, fetched)
+		}
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, file := range pending {
+		if err := g.registerFile(file, pending); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *GRPCReflectionHelper) hasFile(path string) bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	_, err := g.files.FindFileByPath(path)
+	return err == nil
+}
+
+// registerFile registers file after its dependencies, skipping files that are
+// already registered, for example by another lookup. g.mu must be held.
+func (g *GRPCReflectionHelper) registerFile(file *descriptorpb.FileDescriptorProto, pending map[string]*descriptorpb.FileDescriptorProto) error {
+	if _, err := g.files.FindFileByPath(file.GetName()); err == nil {
+		return nil
+	}
+
+	for _, dep := range file.GetDependency() {
+		if _, err := g.files.FindFileByPath(dep); err == nil {
+			continue
+		}
+		depFile, ok := pending[dep]
+		if !ok {
+			return fmt.Errorf("missing dependency %s of %s", dep, file.GetName())
+		}
+		if err := g.registerFile(depFile, pending); err != nil {
+			return err
+		}
+	}
+
+	desc, err := protodesc.NewFile(file, g.files)
+	if err != nil {
+		return fmt.Errorf("failed to create file descriptor %s: %w", file.GetName(), err)
+	}
+	if err := g.files.RegisterFile(desc); err != nil {
+		return fmt.Errorf("failed to register file descriptor %s: %w", file.GetName(), err)
+	}
+	return nil
+}
+
+// fetchFile requests a single file by name. Well-known types compiled into the
+// binary are used directly.
+func (g *GRPCReflectionHelper) fetchFile(ctx context.Context, filename string) (*descriptorpb.FileDescriptorProto, error) {
+	if desc, err := protoregistry.GlobalFiles.FindFileByPath(filename); err == nil {
+		return protodesc.ToFileDescriptorProto(desc), nil
+	}
+
+	response, err := g.request(ctx, &v1.ServerReflectionRequest{
+		MessageRequest: &v1.ServerReflectionRequest_FileByFilename{
+			FileByFilename: filename,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", filename, err)
+	}
+
+	for _, data := range response.GetFileDescriptorResponse().GetFileDescriptorProto() {
+		file := &descriptorpb.FileDescriptorProto{}
+		if err := proto.Unmarshal(data, file); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal file descriptor: %w", err)
+		}
+		if file.GetName() == filename {
+			return file, nil
+		}
+	}
+	return nil, errors.New("server did not return " + filename)
+}
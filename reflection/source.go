@@ -0,0 +1,101 @@
+package reflection
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	transport "github.com/vedantkulkarni/reflect-poc/transport"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// DescriptorSource lists services and resolves symbols, either through the
+// reflection service of a live device or from descriptors obtained earlier
+type DescriptorSource interface {
+	// ListServices returns the fully-qualified names of the services
+	ListServices(ctx context.Context) ([]string, error)
+	// FindSymbol resolves a fully-qualified service, method, message, enum or
+	// field name; methods may also be written as pkg.Service/Method
+	FindSymbol(ctx context.Context, name string) (protoreflect.Descriptor, error)
+}
+
+var (
+	_ DescriptorSource = (*GRPCReflectionHelper)(nil)
+	_ DescriptorSource = (*FileSource)(nil)
+)
+
+// FileSource is a DescriptorSource backed by a FileDescriptorSet. It needs no
+// round trip to the device, so it also works while the device is offline.
+type FileSource struct {
+	files    *protoregistry.Files
+	services []string
+}
+
+// NewFileSource builds a source from set, which must include the dependencies of its files
+func NewFileSource(set *descriptorpb.FileDescriptorSet) (*FileSource, error) {
+	files, err := protodesc.NewFiles(set)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build descriptors: %w", err)
+	}
+
+	var services []string
+	files.RangeFiles(func(file protoreflect.FileDescriptor) bool {
+		for i := 0; i < file.Services().Len(); i++ {
+			services = append(services, string(file.Services().Get(i).FullName()))
+		}
+		return true
+	})
+	sort.Strings(services)
+	return &FileSource{files: files, services: services}, nil
+}
+
+// ReadDescriptorSet reads the FileDescriptorSet retained on topic, waiting
+// until the broker delivers it or ctx is done
+func ReadDescriptorSet(ctx context.Context, mqttClient mqtt.Client, topic string) (*descriptorpb.FileDescriptorSet, error) {
+	payload, err := transport.ReadRetained(ctx, mqttClient, topic)
+	if err != nil {
+		return nil, err
+	}
+	set := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(payload, set); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal descriptors from %s: %w", topic, err)
+	}
+	return set, nil
+}
+
+// NewMQTTSource builds a source from the FileDescriptorSet retained on topic
+func NewMQTTSource(ctx context.Context, mqttClient mqtt.Client, topic string) (*FileSource, error) {
+	set, err := ReadDescriptorSet(ctx, mqttClient, topic)
+	if err != nil {
+		return nil, err
+	}
+	return NewFileSource(set)
+}
+
+// ListServices returns the services defined in the descriptors
+func (s *FileSource) ListServices(context.Context) ([]string, error) {
+	return s.services, nil
+}
+
+// FindSymbol resolves a symbol defined in the descriptors
+func (s *FileSource) FindSymbol(_ context.Context, name string) (protoreflect.Descriptor, error) {
+	fullName, err := symbolName(name)
+	if err != nil {
+		return nil, err
+	}
+	desc, err := s.files.FindDescriptorByName(fullName)
+	if err != nil {
+		return nil, fmt.Errorf("symbol %s not found: %w", fullName, err)
+	}
+	return desc, nil
+}
+
+// FindMethod resolves a method given as /pkg.Service/Method, pkg.Service/Method or pkg.Service.Method
+func (s *FileSource) FindMethod(ctx context.Context, name string) (protoreflect.MethodDescriptor, error) {
+	return FindMethod(ctx, s, name)
+}
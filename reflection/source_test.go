@@ -0,0 +1,101 @@
+package reflection_test
+
+import (
+	"context"
+	"testing"
+
+	reflection "github.com/vedantkulkarni/reflect-poc/reflection"
+	server "github.com/vedantkulkarni/reflect-poc/server"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func testFileSource(t *testing.T) *reflection.FileSource {
+	t.Helper()
+	set, err := server.FileDescriptorSet([]string{"reflect.TestService", "reflect.SyncService"})
+	if err != nil {
+		t.Fatalf("failed to build descriptors: %v", err)
+	}
+	source, err := reflection.NewFileSource(set)
+	if err != nil {
+		t.Fatalf("failed to build source: %v", err)
+	}
+	return source
+}
+
+func TestFileSourceListServices(t *testing.T) {
+	services, err := testFileSource(t).ListServices(context.Background())
+	if err != nil {
+		t.Fatalf("ListServices failed: %v", err)
+	}
+	want := []string{"reflect.SyncService", "reflect.TestService"}
+	if len(services) != len(want) || services[0] != want[0] || services[1] != want[1] {
+		t.Errorf("ListServices returned %v, want %v", services, want)
+	}
+}
+
+func TestFileSourceFindSymbol(t *testing.T) {
+	source := testFileSource(t)
+	for _, tc := range []struct {
+		name string
+		want protoreflect.FullName
+	}{
+		{"reflect.TestService", "reflect.TestService"},
+		{"reflect.TestService.Test", "reflect.TestService.Test"},
+		{"reflect.TestService/Test", "reflect.TestService.Test"},
+		{"/reflect.TestService/TestBidiStream", "reflect.TestService.TestBidiStream"},
+		{"reflect.TestMessageRequest", "reflect.TestMessageRequest"},
+		{"reflect.TestMessageRequest.message", "reflect.TestMessageRequest.message"},
+	} {
+		desc, err := source.FindSymbol(context.Background(), tc.name)
+		if err != nil {
+			t.Errorf("FindSymbol(%q) failed: %v", tc.name, err)
+			continue
+		}
+		if desc.FullName() != tc.want {
+			t.Errorf("FindSymbol(%q) = %s, want %s", tc.name, desc.FullName(), tc.want)
+		}
+	}
+
+	for _, name := range []string{"reflect.NoSuchService", "reflect.TestService/NoSuchMethod", "not a symbol", ""} {
+		if desc, err := source.FindSymbol(context.Background(), name); err == nil {
+			t.Errorf("FindSymbol(%q) = %s, want an error", name, desc.FullName())
+		}
+	}
+}
+
+func TestFileSourceFindMethod(t *testing.T) {
+	source := testFileSource(t)
+	method, err := source.FindMethod(context.Background(), "reflect.TestService/TestClientStream")
+	if err != nil {
+		t.Fatalf("FindMethod failed: %v", err)
+	}
+	if !method.IsStreamingClient() || method.IsStreamingServer() || method.Input().FullName() != "reflect.TestMessageRequest" {
+		t.Errorf("FindMethod returned %s with input %s", method.FullName(), method.Input().FullName())
+	}
+	if _, err := source.FindMethod(context.Background(), "reflect.TestMessageRequest"); err == nil {
+		t.Error("FindMethod resolved a message")
+	}
+}
+
+func TestNewFileSourceMissingDependency(t *testing.T) {
+	set := &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{{
+		Name:       proto.String("device.proto"),
+		Package:    proto.String("device"),
+		Dependency: []string{"missing.proto"},
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Reading"),
+			Field: []*descriptorpb.FieldDescriptorProto{{
+				Name:     proto.String("unit"),
+				Number:   proto.Int32(1),
+				Type:     descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(),
+				TypeName: proto.String(".missing.Unit"),
+			}},
+		}},
+	}}}
+	if _, err := reflection.NewFileSource(set); err == nil {
+		t.Error("source built without the dependencies of its files")
+	}
+}
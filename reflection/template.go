@@ -0,0 +1,106 @@
+package reflection
+
+import (
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Template renders a single-line JSON request with every field of desc set to
+// a placeholder value, ready to be edited. Only the first member of each oneof
+// is set, and recursive messages are left empty after their first level.
+func Template(desc protoreflect.MessageDescriptor) string {
+	var b strings.Builder
+	writeMessageTemplate(&b, desc, map[protoreflect.FullName]bool{})
+	return b.String()
+}
+
+func writeMessageTemplate(b *strings.Builder, desc protoreflect.MessageDescriptor, visiting map[protoreflect.FullName]bool) {
+	if wkt, ok := wellKnownTemplate(desc); ok {
+		b.WriteString(wkt)
+		return
+	}
+	if visiting[desc.FullName()] {
+		b.WriteString("{}")
+		return
+	}
+	visiting[desc.FullName()] = true
+	defer delete(visiting, desc.FullName())
+
+	b.WriteString("{")
+	fields := desc.Fields()
+	first := true
+	for i := 0; i < fields.Len(); i++ {
+		field := fields.Get(i)
+		// Only one member of a oneof may be set
+		if oneof := field.ContainingOneof(); oneof != nil && !oneof.IsSynthetic() && oneof.Fields().Get(0) != field {
+			continue
+		}
+		if !first {
+			b.WriteString(", ")
+		}
+		first = false
+		b.WriteString(strconv.Quote(field.JSONName()))
+		b.WriteString(": ")
+		switch {
+		case field.IsMap():
+			b.WriteString("{}")
+		case field.IsList():
+			b.WriteString("[")
+			writeValueTemplate(b, field, visiting)
+			b.WriteString("]")
+		default:
+			writeValueTemplate(b, field, visiting)
+		}
+	}
+	b.WriteString("}")
+}
+
+func writeValueTemplate(b *strings.Builder, field protoreflect.FieldDescriptor, visiting map[protoreflect.FullName]bool) {
+	switch field.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		writeMessageTemplate(b, field.Message(), visiting)
+	case protoreflect.EnumKind:
+		if values := field.Enum().Values(); values.Len() > 0 {
+			b.WriteString(strconv.Quote(string(values.Get(0).Name())))
+		} else {
+			b.WriteString("0")
+		}
+	case protoreflect.BoolKind:
+		b.WriteString("false")
+	case protoreflect.StringKind, protoreflect.BytesKind:
+		b.WriteString(`""`)
+	default:
+		b.WriteString("0")
+	}
+}
+
+// wellKnownTemplate returns the JSON placeholder of well-known types that
+// protojson does not encode as objects
+func wellKnownTemplate(desc protoreflect.MessageDescriptor) (string, bool) {
+	switch desc.FullName() {
+	case "google.protobuf.Timestamp":
+		return `"1970-01-01T00:00:00Z"`, true
+	case "google.protobuf.Duration":
+		return `"0s"`, true
+	case "google.protobuf.FieldMask":
+		return `""`, true
+	case "google.protobuf.Struct", "google.protobuf.Empty", "google.protobuf.Any":
+		// An Any needs the type URL of a message the caller picks
+		return "{}", true
+	case "google.protobuf.ListValue":
+		return "[]", true
+	case "google.protobuf.Value":
+		return "null", true
+	case "google.protobuf.DoubleValue", "google.protobuf.FloatValue",
+		"google.protobuf.Int64Value", "google.protobuf.UInt64Value",
+		"google.protobuf.Int32Value", "google.protobuf.UInt32Value",
+		"google.protobuf.BoolValue", "google.protobuf.StringValue",
+		"google.protobuf.BytesValue":
+		var b strings.Builder
+		writeValueTemplate(&b, desc.Fields().ByName("value"), nil)
+		return b.String(), true
+	}
+	return "", false
+}
@@ -0,0 +1,129 @@
+package reflection_test
+
+import (
+	"testing"
+
+	reflection "github.com/vedantkulkarni/reflect-poc/reflection"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	_ "google.golang.org/protobuf/types/known/anypb"
+)
+
+// testFile builds a file with a message of every shape Template and the
+// completer handle specially: oneofs, Any, recursion, maps and enums
+func testFile(t *testing.T) protoreflect.FileDescriptor {
+	t.Helper()
+	optional := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
+	repeated := descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+	field := func(name string, number int32, kind descriptorpb.FieldDescriptorProto_Type, label descriptorpb.FieldDescriptorProto_Label, typeName string) *descriptorpb.FieldDescriptorProto {
+		f := &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			JsonName: proto.String(name),
+			Number:   proto.Int32(number),
+			Type:     kind.Enum(),
+			Label:    label.Enum(),
+		}
+		if typeName != "" {
+			f.TypeName = proto.String(typeName)
+		}
+		return f
+	}
+	inOneof := func(f *descriptorpb.FieldDescriptorProto, index int32) *descriptorpb.FieldDescriptorProto {
+		f.OneofIndex = proto.Int32(index)
+		return f
+	}
+	message := descriptorpb.FieldDescriptorProto_TYPE_MESSAGE
+	str := descriptorpb.FieldDescriptorProto_TYPE_STRING
+
+	note := inOneof(field("note", 3, str, optional, ""), 1)
+	note.Proto3Optional = proto.Bool(true)
+	file := &descriptorpb.FileDescriptorProto{
+		Name:       proto.String("template.proto"),
+		Package:    proto.String("tmpl"),
+		Syntax:     proto.String("proto3"),
+		Dependency: []string{"google/protobuf/any.proto"},
+		EnumType: []*descriptorpb.EnumDescriptorProto{{
+			Name: proto.String("Kind"),
+			Value: []*descriptorpb.EnumValueDescriptorProto{
+				{Name: proto.String("KIND_UNSPECIFIED"), Number: proto.Int32(0)},
+				{Name: proto.String("KIND_A"), Number: proto.Int32(1)},
+			},
+		}},
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name: proto.String("Choice"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					inOneof(field("host", 1, str, optional, ""), 0),
+					inOneof(field("port", 2, descriptorpb.FieldDescriptorProto_TYPE_INT32, optional, ""), 0),
+					note,
+				},
+				OneofDecl: []*descriptorpb.OneofDescriptorProto{
+					{Name: proto.String("target")},
+					{Name: proto.String("_note")},
+				},
+			},
+			{
+				Name:  proto.String("Wrapper"),
+				Field: []*descriptorpb.FieldDescriptorProto{field("detail", 1, message, optional, ".google.protobuf.Any")},
+			},
+			{
+				Name: proto.String("Node"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("name", 1, str, optional, ""),
+					field("child", 2, message, optional, ".tmpl.Node"),
+					field("children", 3, message, repeated, ".tmpl.Node"),
+					field("kind", 4, descriptorpb.FieldDescriptorProto_TYPE_ENUM, optional, ".tmpl.Kind"),
+					field("flag", 5, descriptorpb.FieldDescriptorProto_TYPE_BOOL, optional, ""),
+				},
+			},
+			{
+				Name:  proto.String("Labels"),
+				Field: []*descriptorpb.FieldDescriptorProto{field("labels", 1, message, repeated, ".tmpl.Labels.LabelsEntry")},
+				NestedType: []*descriptorpb.DescriptorProto{{
+					Name: proto.String("LabelsEntry"),
+					Field: []*descriptorpb.FieldDescriptorProto{
+						field("key", 1, str, optional, ""),
+						field("value", 2, descriptorpb.FieldDescriptorProto_TYPE_INT32, optional, ""),
+					},
+					Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+				}},
+			},
+		},
+	}
+	desc, err := protodesc.NewFile(file, protoregistry.GlobalFiles)
+	if err != nil {
+		t.Fatalf("failed to build test file: %v", err)
+	}
+	return desc
+}
+
+func TestTemplate(t *testing.T) {
+	file := testFile(t)
+	for _, tc := range []struct {
+		message string
+		want    string
+	}{
+		// Only the first member of a oneof; proto3 optional fields are kept
+		{"Choice", `{"host": "", "note": ""}`},
+		// An Any needs a type URL the operator picks
+		{"Wrapper", `{"detail": {}}`},
+		// Recursive messages stop after their first level
+		{"Node", `{"name": "", "child": {}, "children": [{}], "kind": "KIND_UNSPECIFIED", "flag": false}`},
+		{"Labels", `{"labels": {}}`},
+	} {
+		desc := file.Messages().ByName(protoreflect.Name(tc.message))
+		got := reflection.Template(desc)
+		if got != tc.want {
+			t.Errorf("Template(%s) = %s, want %s", tc.message, got, tc.want)
+		}
+		if err := protojson.Unmarshal([]byte(got), dynamicpb.NewMessage(desc)); err != nil {
+			t.Errorf("template of %s does not unmarshal: %v", tc.message, err)
+		}
+	}
+}
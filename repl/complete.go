@@ -0,0 +1,298 @@
+package repl
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	client "github.com/vedantkulkarni/reflect-poc/client"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// commands are completed with a trailing space, ready for their argument
+var commands = []string{"call ", "describe ", "exit", "header ", "headers", "help", "list ", "template "}
+
+// schema holds the names offered by tab completion, resolved once at startup
+type schema struct {
+	services  []string
+	methodsOf map[string][]string
+	// methods is keyed by pkg.Service/Method
+	methods map[string]protoreflect.MethodDescriptor
+	// symbols are every service, method, message and enum name
+	symbols []string
+}
+
+func loadSchema(ctx context.Context, c *client.ReflectionClient) (*schema, error) {
+	services, err := c.ListServices(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &schema{
+		services:  services,
+		methodsOf: make(map[string][]string),
+		methods:   make(map[string]protoreflect.MethodDescriptor),
+	}
+	seen := make(map[protoreflect.FullName]bool)
+	for _, name := range services {
+		desc, err := c.FindSymbol(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		service, ok := desc.(protoreflect.ServiceDescriptor)
+		if !ok {
+			return nil, fmt.Errorf("%s is not a service", name)
+		}
+		s.addSymbol(seen, service.FullName())
+		for i := 0; i < service.Methods().Len(); i++ {
+			method := service.Methods().Get(i)
+			path := fmt.Sprintf("%s/%s", service.FullName(), method.Name())
+			s.methodsOf[name] = append(s.methodsOf[name], path)
+			s.methods[path] = method
+			s.addSymbol(seen, method.FullName())
+			s.addMessage(seen, method.Input())
+			s.addMessage(seen, method.Output())
+		}
+	}
+	sort.Strings(s.symbols)
+	return s, nil
+}
+
+func (s *schema) addSymbol(seen map[protoreflect.FullName]bool, name protoreflect.FullName) bool {
+	if seen[name] {
+		return false
+	}
+	seen[name] = true
+	s.symbols = append(s.symbols, string(name))
+	return true
+}
+
+func (s *schema) addMessage(seen map[protoreflect.FullName]bool, desc protoreflect.MessageDescriptor) {
+	if desc.IsMapEntry() || !s.addSymbol(seen, desc.FullName()) {
+		return
+	}
+	for i := 0; i < desc.Fields().Len(); i++ {
+		field := desc.Fields().Get(i)
+		if field.Enum() != nil {
+			s.addSymbol(seen, field.Enum().FullName())
+		}
+		if field.Message() != nil {
+			s.addMessage(seen, field.Message())
+		}
+	}
+}
+
+func (s *schema) methodNames() []string {
+	names := make([]string, 0, len(s.methods))
+	for name := range s.methods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// completer implements readline.AutoCompleter
+type completer struct {
+	shell *Shell
+}
+
+func (c *completer) Do(line []rune, pos int) ([][]rune, int) {
+	text := string(line[:pos])
+	if input := c.shell.streamInput(); input != nil {
+		return suffixes(jsonCompletions(input, text))
+	}
+
+	command, args, ok := strings.Cut(text, " ")
+	if !ok {
+		return suffixes(command, commands)
+	}
+	args = strings.TrimLeft(args, " ")
+	schema := c.shell.schema
+	switch command {
+	case "list", "ls":
+		return suffixes(args, schema.services)
+	case "describe", "desc":
+		return suffixes(args, schema.symbols)
+	case "template":
+		return suffixes(args, schema.methodNames())
+	case "call":
+		name, body, ok := strings.Cut(args, " ")
+		if !ok {
+			return suffixes(name, withSpace(schema.methodNames()))
+		}
+		if method, found := schema.methods[name]; found {
+			return suffixes(jsonCompletions(method.Input(), strings.TrimLeft(body, " ")))
+		}
+	}
+	return nil, 0
+}
+
+func withSpace(names []string) []string {
+	out := make([]string, len(names))
+	for i, name := range names {
+		out[i] = name + " "
+	}
+	return out
+}
+
+// suffixes returns the remainder of every candidate that starts with word
+func suffixes(word string, candidates []string) ([][]rune, int) {
+	var out [][]rune
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, word) {
+			out = append(out, []rune(candidate[len(word):]))
+		}
+	}
+	return out, len([]rune(word))
+}
+
+// jsonFrame is an open JSON object or list while scanning a request
+type jsonFrame struct {
+	// msg is the message being filled, nil inside maps and unknown fields
+	msg protoreflect.MessageDescriptor
+	// mapValue is the value type when the object is a map
+	mapValue protoreflect.FieldDescriptor
+	// field is the field whose value comes next
+	field protoreflect.FieldDescriptor
+	key   bool
+	list  bool
+}
+
+func (f *jsonFrame) valueField() protoreflect.FieldDescriptor {
+	if f.mapValue != nil {
+		return f.mapValue
+	}
+	return f.field
+}
+
+// jsonCompletions scans the partial JSON request text of type desc and returns
+// the word under the cursor with the field names or values that may follow
+func jsonCompletions(desc protoreflect.MessageDescriptor, text string) (string, []string) {
+	var stack []*jsonFrame
+	top := func() *jsonFrame {
+		if len(stack) == 0 {
+			return &jsonFrame{}
+		}
+		return stack[len(stack)-1]
+	}
+
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; c {
+		case '{':
+			frame := &jsonFrame{key: true}
+			if len(stack) == 0 {
+				frame.msg = desc
+			} else if field := top().valueField(); field != nil {
+				if field.IsMap() {
+					frame.mapValue = field.MapValue()
+				} else {
+					frame.msg = field.Message()
+				}
+			}
+			stack = append(stack, frame)
+		case '}':
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		case '[':
+			top().list = true
+		case ']':
+			top().list = false
+		case ':':
+			top().key = false
+		case ',':
+			if frame := top(); !frame.list {
+				frame.key = true
+				frame.field = nil
+			}
+		case '"':
+			end := closingQuote(text, i)
+			if end < 0 {
+				return text[i:], candidatesAt(top(), len(stack) == 0)
+			}
+			if frame := top(); frame.key && frame.msg != nil {
+				name, err := strconv.Unquote(text[i : end+1])
+				if err == nil {
+					frame.field = lookupField(frame.msg, name)
+				}
+			}
+			i = end
+		case ' ', '\t', '\n', '\r':
+		default:
+			// Bare values: numbers, true, false, null
+			start := i
+			for i < len(text) && !strings.ContainsRune(",:{}[] \t\n\r\"", rune(text[i])) {
+				i++
+			}
+			if i == len(text) {
+				return text[start:], candidatesAt(top(), len(stack) == 0)
+			}
+			i--
+		}
+	}
+	return "", candidatesAt(top(), len(stack) == 0)
+}
+
+// closingQuote returns the index of the quote ending the string opened at start, or -1
+func closingQuote(text string, start int) int {
+	for i := start + 1; i < len(text); i++ {
+		switch text[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+
+func lookupField(msg protoreflect.MessageDescriptor, name string) protoreflect.FieldDescriptor {
+	if field := msg.Fields().ByJSONName(name); field != nil {
+		return field
+	}
+	return msg.Fields().ByName(protoreflect.Name(name))
+}
+
+func candidatesAt(frame *jsonFrame, outside bool) []string {
+	if outside {
+		return []string{"{"}
+	}
+	if frame.key {
+		if frame.msg == nil {
+			return nil
+		}
+		fields := frame.msg.Fields()
+		names := make([]string, 0, fields.Len())
+		for i := 0; i < fields.Len(); i++ {
+			names = append(names, strconv.Quote(fields.Get(i).JSONName())+": ")
+		}
+		return names
+	}
+
+	field := frame.valueField()
+	if field == nil {
+		return nil
+	}
+	switch {
+	case field.IsMap():
+		return []string{"{"}
+	case field.IsList() && !frame.list:
+		return []string{"["}
+	}
+	switch field.Kind() {
+	case protoreflect.EnumKind:
+		values := field.Enum().Values()
+		names := make([]string, 0, values.Len())
+		for i := 0; i < values.Len(); i++ {
+			names = append(names, strconv.Quote(string(values.Get(i).Name())))
+		}
+		return names
+	case protoreflect.BoolKind:
+		return []string{"true", "false"}
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return []string{"{"}
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package repl
+
+import (
+	"slices"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	_ "google.golang.org/protobuf/types/known/anypb"
+)
+
+// testFile builds a file with a message of every shape the completer handles
+// specially: oneofs, Any, recursion, maps and enums
+func testFile(t *testing.T) protoreflect.FileDescriptor {
+	t.Helper()
+	optional := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
+	repeated := descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+	field := func(name string, number int32, kind descriptorpb.FieldDescriptorProto_Type, label descriptorpb.FieldDescriptorProto_Label, typeName string) *descriptorpb.FieldDescriptorProto {
+		f := &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			JsonName: proto.String(name),
+			Number:   proto.Int32(number),
+			Type:     kind.Enum(),
+			Label:    label.Enum(),
+		}
+		if typeName != "" {
+			f.TypeName = proto.String(typeName)
+		}
+		return f
+	}
+	inOneof := func(f *descriptorpb.FieldDescriptorProto, index int32) *descriptorpb.FieldDescriptorProto {
+		f.OneofIndex = proto.Int32(index)
+		return f
+	}
+	message := descriptorpb.FieldDescriptorProto_TYPE_MESSAGE
+	str := descriptorpb.FieldDescriptorProto_TYPE_STRING
+
+	note := inOneof(field("note", 3, str, optional, ""), 1)
+	note.Proto3Optional = proto.Bool(true)
+	file := &descriptorpb.FileDescriptorProto{
+		Name:       proto.String("template.proto"),
+		Package:    proto.String("tmpl"),
+		Syntax:     proto.String("proto3"),
+		Dependency: []string{"google/protobuf/any.proto"},
+		EnumType: []*descriptorpb.EnumDescriptorProto{{
+			Name: proto.String("Kind"),
+			Value: []*descriptorpb.EnumValueDescriptorProto{
+				{Name: proto.String("KIND_UNSPECIFIED"), Number: proto.Int32(0)},
+				{Name: proto.String("KIND_A"), Number: proto.Int32(1)},
+			},
+		}},
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name: proto.String("Choice"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					inOneof(field("host", 1, str, optional, ""), 0),
+					inOneof(field("port", 2, descriptorpb.FieldDescriptorProto_TYPE_INT32, optional, ""), 0),
+					note,
+				},
+				OneofDecl: []*descriptorpb.OneofDescriptorProto{
+					{Name: proto.String("target")},
+					{Name: proto.String("_note")},
+				},
+			},
+			{
+				Name:  proto.String("Wrapper"),
+				Field: []*descriptorpb.FieldDescriptorProto{field("detail", 1, message, optional, ".google.protobuf.Any")},
+			},
+			{
+				Name: proto.String("Node"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("name", 1, str, optional, ""),
+					field("child", 2, message, optional, ".tmpl.Node"),
+					field("children", 3, message, repeated, ".tmpl.Node"),
+					field("kind", 4, descriptorpb.FieldDescriptorProto_TYPE_ENUM, optional, ".tmpl.Kind"),
+					field("flag", 5, descriptorpb.FieldDescriptorProto_TYPE_BOOL, optional, ""),
+				},
+			},
+			{
+				Name:  proto.String("Labels"),
+				Field: []*descriptorpb.FieldDescriptorProto{field("labels", 1, message, repeated, ".tmpl.Labels.LabelsEntry")},
+				NestedType: []*descriptorpb.DescriptorProto{{
+					Name: proto.String("LabelsEntry"),
+					Field: []*descriptorpb.FieldDescriptorProto{
+						field("key", 1, str, optional, ""),
+						field("value", 2, descriptorpb.FieldDescriptorProto_TYPE_INT32, optional, ""),
+					},
+					Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+				}},
+			},
+		},
+	}
+	desc, err := protodesc.NewFile(file, protoregistry.GlobalFiles)
+	if err != nil {
+		t.Fatalf("failed to build test file: %v", err)
+	}
+	return desc
+}
+
+func TestJSONCompletions(t *testing.T) {
+	file := testFile(t)
+	nodeFields := []string{`"name": `, `"child": `, `"children": `, `"kind": `, `"flag": `}
+	kinds := []string{`"KIND_UNSPECIFIED"`, `"KIND_A"`}
+	for _, tc := range []struct {
+		name       string
+		message    string
+		text       string
+		word       string
+		candidates []string
+	}{
+		{"empty request", "Node", ``, ``, []string{"{"}},
+		{"field name", "Node", `{`, ``, nodeFields},
+		{"partial field name", "Node", `{"na`, `"na`, nodeFields},
+		{"after a value", "Node", `{"name": "x", `, ``, nodeFields},
+		{"enum value", "Node", `{"kind": `, ``, kinds},
+		{"partial enum value", "Node", `{"kind": "KIND_`, `"KIND_`, kinds},
+		{"bool value", "Node", `{"flag": t`, `t`, []string{"true", "false"}},
+		{"nested message", "Node", `{"child": `, ``, []string{"{"}},
+		{"recursive message fields", "Node", `{"child": {"child": {`, ``, nodeFields},
+		{"repeated field", "Node", `{"children": `, ``, []string{"["}},
+		{"repeated element", "Node", `{"children": [`, ``, []string{"{"}},
+		{"after a nested message", "Node", `{"child": {"name": "x"}, `, ``, nodeFields},
+		{"oneof members", "Choice", `{`, ``, []string{`"host": `, `"port": `, `"note": `}},
+		{"map", "Labels", `{"labels": `, ``, []string{"{"}},
+		{"map keys", "Labels", `{"labels": {`, ``, nil},
+		{"unknown field", "Node", `{"missing": `, ``, nil},
+	} {
+		word, candidates := jsonCompletions(file.Messages().ByName(protoreflect.Name(tc.message)), tc.text)
+		if word != tc.word || !slices.Equal(candidates, tc.candidates) {
+			t.Errorf("%s: jsonCompletions(%q) = %q, %q, want %q, %q", tc.name, tc.text, word, candidates, tc.word, tc.candidates)
+		}
+	}
+}
+
+// TestCompleterStreamInput checks that an open interactive stream completes
+// request fields instead of commands
+func TestCompleterStreamInput(t *testing.T) {
+	shell := &Shell{}
+	c := &completer{shell: shell}
+	shell.setInput(testFile(t).Messages().ByName("Node"))
+	line := []rune(`{"fl`)
+	got, length := c.Do(line, len(line))
+	if length != 3 || len(got) != 1 || string(got[0]) != `ag": ` {
+		t.Errorf("Do(%q) = %q, %d, want the rest of \"flag\"", string(line), got, length)
+	}
+}
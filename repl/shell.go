@@ -0,0 +1,355 @@
+package repl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chzyer/readline"
+	client "github.com/vedantkulkarni/reflect-poc/client"
+	reflection "github.com/vedantkulkarni/reflect-poc/reflection"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const help = `Commands:
+  list [service]            list services, or the methods of a service
+  describe <symbol>         show a service, method, message or enum
+  call <method> [json]      call a method; streaming methods without a body
+                            open an interactive stream
+  template <method>         prefill a call with a request template
+  header <name: value>      send a header with every call
+  headers                   show the headers sent with every call
+  help                      show this help
+  exit                      leave the shell
+
+In an interactive stream every line is sent as one request message.
+  .end      half-close the stream and wait for the remaining responses
+  .cancel   cancel the stream (also Ctrl-C)
+`
+
+// Config configures a Shell
+type Config struct {
+	Client   *client.ReflectionClient
+	BridgeID string
+	// HistoryFile keeps command history across sessions; empty disables it
+	HistoryFile string
+	// Format renders responses
+	Format func(proto.Message) ([]byte, error)
+	// CallTimeout bounds each call except interactive streams, and describe
+	// lookups; 0 means no limit for calls and 10s for describe
+	CallTimeout time.Duration
+}
+
+// Shell is an interactive prompt connected to one device, with tab completion
+// of services, methods, JSON field names and enum values
+type Shell struct {
+	cfg     Config
+	schema  *schema
+	rl      *readline.Instance
+	headers metadata.MD
+	prompt  string
+	// interactive is false when stdin is not a terminal, which disables prefilling
+	interactive bool
+
+	// input is the message type of the interactive stream, nil at the command
+	// prompt; the completer reads it from the readline goroutine
+	inputMu sync.Mutex
+	input   protoreflect.MessageDescriptor
+}
+
+// defaultDescribeTimeout bounds describe when CallTimeout is not set
+const defaultDescribeTimeout = 10 * time.Second
+
+// New loads the schema of the device and prepares the prompt
+func New(ctx context.Context, cfg Config) (*Shell, error) {
+	schema, err := loadSchema(ctx, cfg.Client)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Shell{
+		cfg:     cfg,
+		schema:  schema,
+		headers: metadata.MD{},
+		prompt:  cfg.BridgeID + "> ",
+
+		interactive: readline.DefaultIsTerminal(),
+	}
+	s.rl, err = readline.NewEx(&readline.Config{
+		Prompt:            s.prompt,
+		HistoryFile:       cfg.HistoryFile,
+		HistorySearchFold: true,
+		AutoComplete:      &completer{shell: s},
+		InterruptPrompt:   "^C",
+		EOFPrompt:         "exit",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open terminal: %w", err)
+	}
+	return s, nil
+}
+
+// Run reads and executes commands until exit, Ctrl-D or ctx is done
+func (s *Shell) Run(ctx context.Context) error {
+	defer s.rl.Close()
+	fmt.Fprintf(s.rl.Stdout(), "Connected to %s. Type 'help' for commands, Tab to complete.\n", s.cfg.BridgeID)
+
+	prefill := ""
+	for ctx.Err() == nil {
+		if prefill != "" && !s.interactive {
+			fmt.Fprintln(s.rl.Stdout(), prefill)
+		}
+		line, err := s.readLine(prefill)
+		prefill = ""
+		if errors.Is(err, readline.ErrInterrupt) {
+			continue
+		}
+		if err != nil {
+			return nil
+		}
+
+		command, args, _ := strings.Cut(strings.TrimSpace(line), " ")
+		args = strings.TrimSpace(args)
+		switch command {
+		case "":
+		case "exit", "quit":
+			return nil
+		case "help":
+			fmt.Fprint(s.rl.Stdout(), help)
+		case "list", "ls":
+			err = s.list(args)
+		case "describe", "desc":
+			err = s.describe(ctx, args)
+		case "call":
+			prefill, err = s.call(ctx, args)
+		case "template":
+			prefill, err = s.template(args)
+		case "header":
+			err = s.addHeader(args)
+		case "headers":
+			s.printHeaders()
+		default:
+			err = fmt.Errorf("unknown command %q, type 'help' for commands", command)
+		}
+		if err != nil {
+			fmt.Fprintln(s.rl.Stderr(), "ERROR:", err)
+		}
+	}
+	return ctx.Err()
+}
+
+func (s *Shell) list(service string) error {
+	if service == "" {
+		for _, name := range s.schema.services {
+			fmt.Fprintln(s.rl.Stdout(), name)
+		}
+		return nil
+	}
+	methods, ok := s.schema.methodsOf[service]
+	if !ok {
+		return fmt.Errorf("unknown service %s", service)
+	}
+	for _, method := range methods {
+		fmt.Fprintln(s.rl.Stdout(), method)
+	}
+	return nil
+}
+
+func (s *Shell) describe(ctx context.Context, symbol string) error {
+	if symbol == "" {
+		return errors.New("usage: describe <symbol>")
+	}
+	timeout := s.cfg.CallTimeout
+	if timeout <= 0 {
+		timeout = defaultDescribeTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	desc, err := s.cfg.Client.FindSymbol(ctx, symbol)
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(s.rl.Stdout(), reflection.Describe(desc))
+	return nil
+}
+
+// call runs a call and returns the text to prefill the next prompt with
+func (s *Shell) call(ctx context.Context, args string) (string, error) {
+	name, body, _ := strings.Cut(args, " ")
+	method, ok := s.schema.methods[name]
+	if !ok {
+		return "", fmt.Errorf("unknown method %q", name)
+	}
+
+	body = strings.TrimSpace(body)
+	if body == "" {
+		if method.IsStreamingClient() {
+			return "", s.stream(ctx, name, method)
+		}
+		// Nothing to send yet: offer a request to edit instead
+		return fmt.Sprintf("call %s %s", name, reflection.Template(method.Input())), nil
+	}
+
+	ctx, stop := signal.NotifyContext(s.callContext(ctx), os.Interrupt)
+	defer stop()
+	if s.cfg.CallTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.cfg.CallTimeout)
+		defer cancel()
+	}
+	return "", s.cfg.Client.Call(ctx, name, strings.NewReader(body), s.printResponse)
+}
+
+func (s *Shell) template(name string) (string, error) {
+	method, ok := s.schema.methods[name]
+	if !ok {
+		return "", fmt.Errorf("unknown method %q", name)
+	}
+	return fmt.Sprintf("call %s %s", name, reflection.Template(method.Input())), nil
+}
+
+// stream keeps a client or bidi-streaming call open, sending every line the
+// operator enters while responses are printed as they arrive
+func (s *Shell) stream(ctx context.Context, name string, method protoreflect.MethodDescriptor) error {
+	ctx, cancel := context.WithCancel(s.callContext(ctx))
+	defer cancel()
+
+	requests, requestWriter := io.Pipe()
+	done := make(chan struct{})
+	// closing is closed once the operator ended the stream and nothing is read anymore
+	closing := make(chan struct{})
+	go func() {
+		defer close(done)
+		err := s.cfg.Client.Call(ctx, name, requests, s.printResponse)
+		// Unblock a pending write once the call is over
+		requests.Close()
+
+		hint := " (press Enter)"
+		select {
+		case <-closing:
+			hint = ""
+		default:
+		}
+		if err != nil {
+			fmt.Fprintf(s.rl.Stderr(), "Stream closed: %v%s\n", err, hint)
+		} else {
+			fmt.Fprintf(s.rl.Stdout(), "Stream closed%s\n", hint)
+		}
+	}()
+
+	s.setInput(method.Input())
+	s.rl.SetPrompt(string(method.Name()) + "> ")
+	defer func() {
+		s.setInput(nil)
+		s.rl.SetPrompt(s.prompt)
+	}()
+	fmt.Fprintln(s.rl.Stdout(), "Stream open: one JSON message per line, .end to half-close, .cancel to cancel")
+
+	prefill := reflection.Template(method.Input())
+	for {
+		line, err := s.readLine(prefill)
+		select {
+		case <-done:
+			return nil
+		default:
+		}
+
+		line = strings.TrimSpace(line)
+		switch {
+		case errors.Is(err, readline.ErrInterrupt), line == ".cancel":
+			close(closing)
+			cancel()
+			<-done
+			return nil
+		case err != nil, line == ".end":
+			close(closing)
+			requestWriter.Close()
+			<-done
+			return nil
+		case line == "":
+			continue
+		}
+
+		// Check each message here so a typo does not end the stream
+		if err := protojson.Unmarshal([]byte(line), dynamicpb.NewMessage(method.Input())); err != nil {
+			fmt.Fprintln(s.rl.Stderr(), "ERROR: invalid message:", err)
+			prefill = line
+			continue
+		}
+		if _, err := io.WriteString(requestWriter, line+"\n"); err != nil {
+			<-done
+			return nil
+		}
+		prefill = line
+	}
+}
+
+func (s *Shell) setInput(input protoreflect.MessageDescriptor) {
+	s.inputMu.Lock()
+	defer s.inputMu.Unlock()
+	s.input = input
+}
+
+// streamInput returns the message type of the interactive stream, if one is open
+func (s *Shell) streamInput() protoreflect.MessageDescriptor {
+	s.inputMu.Lock()
+	defer s.inputMu.Unlock()
+	return s.input
+}
+
+// readLine reads a line, prefilled with text the operator can edit
+func (s *Shell) readLine(prefill string) (string, error) {
+	if !s.interactive {
+		return s.rl.Readline()
+	}
+	return s.rl.ReadlineWithDefault(prefill)
+}
+
+// callContext attaches the shell headers to ctx
+func (s *Shell) callContext(ctx context.Context) context.Context {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	return metadata.NewOutgoingContext(ctx, metadata.Join(md, s.headers))
+}
+
+func (s *Shell) printResponse(response proto.Message) error {
+	out, err := s.cfg.Format(response)
+	if err != nil {
+		return fmt.Errorf("failed to format response: %w", err)
+	}
+	fmt.Fprintln(s.rl.Stdout(), strings.TrimSpace(string(out)))
+	return nil
+}
+
+func (s *Shell) addHeader(header string) error {
+	name, value, ok := strings.Cut(header, ":")
+	if !ok || strings.TrimSpace(name) == "" {
+		return errors.New("usage: header <name: value>")
+	}
+	s.headers.Append(strings.ToLower(strings.TrimSpace(name)), strings.TrimSpace(value))
+	return nil
+}
+
+func (s *Shell) printHeaders() {
+	names := make([]string, 0, len(s.headers))
+	for name := range s.headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range s.headers[name] {
+			fmt.Fprintf(s.rl.Stdout(), "%s: %s\n", name, value)
+		}
+	}
+}
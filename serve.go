@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os/signal"
+	"syscall"
+	"time"
+
+	bridge "github.com/golain-io/mqtt-bridge"
+	auth "github.com/vedantkulkarni/reflect-poc/auth"
+	metrics "github.com/vedantkulkarni/reflect-poc/metrics"
+	server "github.com/vedantkulkarni/reflect-poc/server"
+	service_proto "github.com/vedantkulkarni/reflect-poc/service-proto"
+	transport "github.com/vedantkulkarni/reflect-poc/transport"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/reflection"
+)
+
+// runServe runs the gRPC-over-MQTT server until SIGINT or SIGTERM
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	broker := transport.BrokerConfig{ClientID: "echo-net-service"}
+	registerBrokerFlags(fs, &broker)
+	bridgeID := fs.String("bridge-id", defaultBridgeID, "bridge ID the server listens on")
+	embeddedBroker := registerEmbeddedBrokerFlag(fs)
+	drainTimeout := fs.Duration("drain-timeout", server.DefaultDrainTimeout, "how long in-flight calls get to finish on shutdown")
+	healthInterval := fs.Duration("health-interval", server.DefaultHealthInterval, "how often the broker connection is checked for the health service")
+	var logOpts logOptions
+	logOpts.register(fs, "info")
+	metricsAddr := fs.String("metrics-addr", "", "serve Prometheus metrics on this address, e.g. 127.0.0.1:9090")
+	var traceOpts traceOptions
+	traceOpts.register(fs)
+
+	// End-to-end TLS between the gRPC client and the device, inside the MQTT session
+	var serverTLS transport.TLSConfig
+	fs.StringVar(&serverTLS.CertFile, "grpc-tls-cert", "", "certificate served by the device, issued for its bridge ID")
+	fs.StringVar(&serverTLS.KeyFile, "grpc-tls-key", "", "private key for -grpc-tls-cert")
+	fs.StringVar(&serverTLS.CAFile, "grpc-tls-ca", "", "CA bundle used to verify callers; client certificates are required when set")
+
+	// Per-RPC credentials checked by the device
+	var serverAuth auth.ServerConfig
+	var policyFile string
+	registerServerAuthFlags(fs, &serverAuth, &policyFile)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	logger, err := logOpts.logger()
+	if err != nil {
+		return err
+	}
+	defer logger.Sync()
+	broker.Logger = logger
+	if *embeddedBroker {
+		b, err := startEmbeddedBroker(&broker, logger)
+		if err != nil {
+			return err
+		}
+		defer b.Close()
+	}
+
+	var serverMetrics *metrics.Metrics
+	if *metricsAddr != "" {
+		serverMetrics = metrics.New()
+		broker.Observer = serverMetrics
+		metricsServer, err := serverMetrics.Serve(*metricsAddr, logger)
+		if err != nil {
+			return err
+		}
+		defer metricsServer.Close()
+	}
+
+	serverTracing, flushTraces, err := traceOpts.start("reflect-server", logger)
+	if err != nil {
+		return err
+	}
+	defer flushTraces()
+
+	serverCreds, err := transport.ServerCredentials(serverTLS)
+	if err != nil {
+		return fmt.Errorf("failed to build transport credentials: %w", err)
+	}
+
+	serverOpts := []grpc.ServerOption{
+		grpc.Creds(serverCreds),
+		// Clients ping to detect bridge sessions closed by a forced shutdown
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             10 * time.Second,
+			PermitWithoutStream: true,
+		}),
+	}
+	interceptors := server.Interceptors{Logger: logger, Metrics: serverMetrics, Tracing: serverTracing}
+	serverAuth.JWTAudience = *bridgeID
+	if _, err := installServerAuth(serverAuth, policyFile, &interceptors); err != nil {
+		return err
+	}
+
+	grpcServer := grpc.NewServer(append(serverOpts, interceptors.ServerOptions()...)...)
+	testService := &server.MyTestService{Logger: logger}
+	service_proto.RegisterTestServiceServer(grpcServer, testService)
+	syncService := &server.MySyncService{Logger: logger}
+	service_proto.RegisterSyncServiceServer(grpcServer, syncService)
+
+	reflection.RegisterV1(grpcServer)
+	health := server.NewHealth(logger)
+	health.Register(grpcServer)
+
+	// The presence announces what the device serves; its Last Will version
+	// marks the device offline if it disappears without shutting down
+	presence, descriptors, err := server.NewPresence(grpcServer, *bridgeID, buildVersion())
+	if err != nil {
+		return fmt.Errorf("failed to describe services: %w", err)
+	}
+	broker.Will, err = server.PresenceWill(presence)
+	if err != nil {
+		return err
+	}
+
+	// Create MQTT client
+	mqttClient, err := transport.Connect(broker)
+	if err != nil {
+		return err
+	}
+
+	netBridge := bridge.NewMQTTNetBridge(mqttClient, logger, *bridgeID)
+
+	// SIGINT and SIGTERM drain in-flight calls before the broker connection closes
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	lifecycle := &server.Lifecycle{
+		GRPCServer:     grpcServer,
+		Listener:       transport.BufferListener(mqttClient.TrackListener(netBridge)),
+		MQTTClient:     mqttClient,
+		BridgeID:       *bridgeID,
+		DrainTimeout:   *drainTimeout,
+		Health:         health,
+		HealthInterval: *healthInterval,
+		Presence:       presence,
+		Descriptors:    descriptors,
+		Logger:         logger,
+	}
+	if err := lifecycle.Run(ctx); err != nil {
+		return fmt.Errorf("failed to serve: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// ServiceNames returns the sorted names of the services registered on s
+func ServiceNames(s *grpc.Server) []string {
+	info := s.GetServiceInfo()
+	names := make([]string, 0, len(info))
+	for name := range info {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// FileDescriptorSet returns the files that define services, with their
+// dependencies, sorted by path so that the same services give the same set
+func FileDescriptorSet(services []string) (*descriptorpb.FileDescriptorSet, error) {
+	return DescriptorSet(protoregistry.GlobalFiles, services)
+}
+
+// DescriptorResolver finds descriptors by name, like protoregistry.Files
+type DescriptorResolver interface {
+	FindDescriptorByName(protoreflect.FullName) (protoreflect.Descriptor, error)
+}
+
+// DescriptorSet is FileDescriptorSet with the services resolved by resolver
+// instead of the global registry
+func DescriptorSet(resolver DescriptorResolver, services []string) (*descriptorpb.FileDescriptorSet, error) {
+	files := make(map[string]protoreflect.FileDescriptor)
+	var add func(protoreflect.FileDescriptor)
+	add = func(file protoreflect.FileDescriptor) {
+		if _, ok := files[file.Path()]; ok {
+			return
+		}
+		files[file.Path()] = file
+		imports := file.Imports()
+		for i := 0; i < imports.Len(); i++ {
+			add(imports.Get(i).FileDescriptor)
+		}
+	}
+	for _, service := range services {
+		desc, err := resolver.FindDescriptorByName(protoreflect.FullName(service))
+		if err != nil {
+			return nil, fmt.Errorf("failed to find descriptor of %s: %w", service, err)
+		}
+		add(desc.ParentFile())
+	}
+
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	set := &descriptorpb.FileDescriptorSet{}
+	for _, path := range paths {
+		set.File = append(set.File, protodesc.ToFileDescriptorProto(files[path]))
+	}
+	return set, nil
+}
+
+// DescriptorHash returns the hex SHA-256 of the deterministic encoding of set.
+// Devices serving the same API announce the same hash.
+func DescriptorHash(set *descriptorpb.FileDescriptorSet) (string, error) {
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(set)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal descriptors: %w", err)
+	}
+	return hashPayload(data), nil
+}
+
+func hashPayload(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Resolvers search several registries in order, such as the descriptors of
+// services served dynamically and then protoregistry.GlobalFiles
+type Resolvers []*protoregistry.Files
+
+// FindFileByPath returns the first file found at path
+func (r Resolvers) FindFileByPath(path string) (protoreflect.FileDescriptor, error) {
+	for _, files := range r {
+		if file, err := files.FindFileByPath(path); err == nil {
+			return file, nil
+		}
+	}
+	return nil, protoregistry.NotFound
+}
+
+// FindDescriptorByName returns the first descriptor found with name
+func (r Resolvers) FindDescriptorByName(name protoreflect.FullName) (protoreflect.Descriptor, error) {
+	for _, files := range r {
+		if desc, err := files.FindDescriptorByName(name); err == nil {
+			return desc, nil
+		}
+	}
+	return nil, protoregistry.NotFound
+}
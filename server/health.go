@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// Health is the grpc.health.v1.Health service of a device. Statuses are kept
+// per service, with the empty name standing for the device as a whole, and
+// every change is pushed to the callers watching that service.
+type Health struct {
+	*health.Server
+	logger *zap.Logger
+
+	shutdown chan struct{}
+	once     sync.Once
+}
+
+// NewHealth creates a health service; nil disables logging
+func NewHealth(logger *zap.Logger) *Health {
+	return &Health{
+		Server:   health.NewServer(),
+		logger:   serviceLogger(logger),
+		shutdown: make(chan struct{}),
+	}
+}
+
+// Register adds the health service to s and marks the device and every service
+// registered on s so far as SERVING
+func (h *Health) Register(s *grpc.Server) {
+	healthpb.RegisterHealthServer(s, h)
+	h.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	for service := range s.GetServiceInfo() {
+		if service != healthpb.Health_ServiceDesc.ServiceName {
+			h.SetServingStatus(service, healthpb.HealthCheckResponse_SERVING)
+		}
+	}
+}
+
+// SetServingStatus sets the status of service, "" for the whole device
+func (h *Health) SetServingStatus(service string, status healthpb.HealthCheckResponse_ServingStatus) {
+	h.logger.Debug("Health status set", zap.String("service", service), zap.Stringer("status", status))
+	h.Server.SetServingStatus(service, status)
+}
+
+// Shutdown marks every service NOT_SERVING, ignores later updates and ends
+// the Watch streams, which would otherwise hold up a graceful stop. Watchers
+// see NOT_SERVING or an Unavailable error.
+func (h *Health) Shutdown() {
+	h.Server.Shutdown()
+	h.once.Do(func() { close(h.shutdown) })
+}
+
+// Watch streams the status of a service until the caller leaves or the device shuts down
+func (h *Health) Watch(req *healthpb.HealthCheckRequest, stream healthpb.Health_WatchServer) error {
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+	go func() {
+		select {
+		case <-h.shutdown:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	err := h.Server.Watch(req, &watchStream{Health_WatchServer: stream, ctx: ctx})
+	if stream.Context().Err() == nil && ctx.Err() != nil {
+		return status.Error(codes.Unavailable, "device is shutting down")
+	}
+	return err
+}
+
+// watchStream ends a Watch stream with its own context
+type watchStream struct {
+	healthpb.Health_WatchServer
+	ctx context.Context
+}
+
+func (s *watchStream) Context() context.Context {
+	return s.ctx
+}
+
+// Watchdog runs check every interval until ctx is done and marks service
+// SERVING while it succeeds and NOT_SERVING while it fails
+func (h *Health) Watchdog(ctx context.Context, service string, interval time.Duration, check func(context.Context) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := healthpb.HealthCheckResponse_UNKNOWN
+	for {
+		status := healthpb.HealthCheckResponse_SERVING
+		checkCtx, cancel := context.WithTimeout(ctx, interval)
+		err := check(checkCtx)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			status = healthpb.HealthCheckResponse_NOT_SERVING
+		}
+		if status != last {
+			if err != nil {
+				h.logger.Warn("Health check failed", zap.String("service", service), zap.Error(err))
+			}
+			h.SetServingStatus(service, status)
+			last = status
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
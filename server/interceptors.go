@@ -0,0 +1,256 @@
+package server
+
+import (
+	"context"
+	"runtime/debug"
+	"time"
+
+	"github.com/google/uuid"
+	metrics "github.com/vedantkulkarni/reflect-poc/metrics"
+	tracing "github.com/vedantkulkarni/reflect-poc/tracing"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// RequestIDHeader carries the request ID in both directions. A caller-provided
+// ID is kept, otherwise one is generated, and it is returned as a response header.
+const RequestIDHeader = "x-request-id"
+
+// Interceptors configures the interceptor chain of the device server. Every
+// call goes through, in order: tracing, request ID, access logging, metrics,
+// panic recovery and then the Unary or Stream interceptors, so custom
+// interceptors, such as authentication, are traced, logged, measured and
+// recovered like handlers.
+type Interceptors struct {
+	// Logger receives access logs and recovered panics; nil disables logging
+	Logger *zap.Logger
+	// Metrics records every call, including those rejected by Unary and
+	// Stream; nil disables metrics
+	Metrics *metrics.Metrics
+	// Tracing continues the caller's trace in a span per call; nil disables tracing
+	Tracing *tracing.Tracing
+	// Unary and Stream are appended to the built-in chain
+	Unary  []grpc.UnaryServerInterceptor
+	Stream []grpc.StreamServerInterceptor
+}
+
+// ServerOptions returns the server options that install the chain
+func (i Interceptors) ServerOptions() []grpc.ServerOption {
+	logger := serviceLogger(i.Logger)
+	var unary []grpc.UnaryServerInterceptor
+	var stream []grpc.StreamServerInterceptor
+	if i.Tracing != nil {
+		unary = append(unary, i.Tracing.UnaryServerInterceptor())
+		stream = append(stream, i.Tracing.StreamServerInterceptor())
+	}
+	unary = append(unary, UnaryRequestIDInterceptor(), UnaryAccessLogInterceptor(logger))
+	stream = append(stream, StreamRequestIDInterceptor(), StreamAccessLogInterceptor(logger))
+	// Metrics sit outside recovery so that panics count as Internal
+	if i.Metrics != nil {
+		unary = append(unary, i.Metrics.UnaryServerInterceptor())
+		stream = append(stream, i.Metrics.StreamServerInterceptor())
+	}
+	unary = append(unary, UnaryRecoveryInterceptor(logger))
+	unary = append(append(unary, i.Unary...), unaryRecordCaller)
+	stream = append(stream, StreamRecoveryInterceptor(logger))
+	stream = append(append(stream, i.Stream...), streamRecordCaller)
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(unary...),
+		grpc.ChainStreamInterceptor(stream...),
+	}
+}
+
+type requestIDKey struct{}
+
+// RequestIDFromContext returns the request ID of the call handled with ctx
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// withRequestID stores the caller's request ID, or a new one, on ctx
+func withRequestID(ctx context.Context) (context.Context, string) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	var id string
+	if values := md.Get(RequestIDHeader); len(values) > 0 && values[0] != "" {
+		id = values[0]
+	} else {
+		id = uuid.NewString()
+	}
+	return context.WithValue(ctx, requestIDKey{}, id), id
+}
+
+// UnaryRequestIDInterceptor assigns a request ID to unary calls
+func UnaryRequestIDInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, id := withRequestID(ctx)
+		grpc.SetHeader(ctx, metadata.Pairs(RequestIDHeader, id))
+		return handler(ctx, req)
+	}
+}
+
+// StreamRequestIDInterceptor assigns a request ID to streaming calls
+func StreamRequestIDInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, id := withRequestID(ss.Context())
+		ss.SetHeader(metadata.Pairs(RequestIDHeader, id))
+		return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// UnaryRecoveryInterceptor turns a panic in a unary handler into an Internal error
+func UnaryRecoveryInterceptor(logger *zap.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = recovered(ctx, logger, info.FullMethod, r)
+			}
+		}()
+		return handler(ctx, req)
+	}
+}
+
+// StreamRecoveryInterceptor turns a panic in a streaming handler into an Internal error
+func StreamRecoveryInterceptor(logger *zap.Logger) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = recovered(ss.Context(), logger, info.FullMethod, r)
+			}
+		}()
+		return handler(srv, ss)
+	}
+}
+
+func recovered(ctx context.Context, logger *zap.Logger, method string, r any) error {
+	logger.Error("Recovered from panic in handler",
+		zap.String("method", method),
+		zap.String("request_id", RequestIDFromContext(ctx)),
+		zap.Any("panic", r),
+		zap.ByteString("stack", debug.Stack()))
+	return status.Error(codes.Internal, "internal error")
+}
+
+// callRecord collects what the access log learns from inner interceptors
+type callRecord struct {
+	caller string
+}
+
+type callRecordKey struct{}
+
+// recordCaller saves the authenticated caller for the access log, which runs
+// before authentication
+func recordCaller(ctx context.Context) {
+	if record, ok := ctx.Value(callRecordKey{}).(*callRecord); ok {
+		record.caller = caller(ctx)
+	}
+}
+
+func unaryRecordCaller(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	recordCaller(ctx)
+	return handler(ctx, req)
+}
+
+func streamRecordCaller(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	recordCaller(ss.Context())
+	return handler(srv, ss)
+}
+
+// UnaryAccessLogInterceptor logs every unary call with its status and duration
+func UnaryAccessLogInterceptor(logger *zap.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+		record := &callRecord{}
+		resp, err := handler(context.WithValue(ctx, callRecordKey{}, record), req)
+		logCall(ctx, logger, info.FullMethod, start, record, err)
+		return resp, err
+	}
+}
+
+// StreamAccessLogInterceptor logs every streaming call with its status,
+// duration and message counts
+func StreamAccessLogInterceptor(logger *zap.Logger) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		record := &callRecord{}
+		counted := &countingStream{
+			ServerStream: &contextStream{ServerStream: ss, ctx: context.WithValue(ss.Context(), callRecordKey{}, record)},
+		}
+		err := handler(srv, counted)
+		logCall(ss.Context(), logger, info.FullMethod, start, record, err,
+			zap.Int("messages_received", counted.received),
+			zap.Int("messages_sent", counted.sent))
+		return err
+	}
+}
+
+func logCall(ctx context.Context, logger *zap.Logger, method string, start time.Time, record *callRecord, err error, extra ...zap.Field) {
+	code := status.Code(err)
+	if record.caller == "" {
+		record.caller = caller(ctx)
+	}
+	fields := append([]zap.Field{
+		zap.String("method", method),
+		zap.Stringer("code", code),
+		zap.Duration("duration", time.Since(start)),
+		zap.String("request_id", RequestIDFromContext(ctx)),
+		zap.String("caller", record.caller),
+	}, extra...)
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		fields = append(fields, zap.String("peer", p.Addr.String()))
+	}
+	if err != nil {
+		fields = append(fields, zap.Error(err))
+	}
+	logger.Check(codeLevel(code), "Handled call").Write(fields...)
+}
+
+// codeLevel maps a status code to the level its access log is written at
+func codeLevel(code codes.Code) zapcore.Level {
+	switch code {
+	case codes.OK, codes.Canceled, codes.InvalidArgument, codes.NotFound,
+		codes.AlreadyExists, codes.Unauthenticated:
+		return zapcore.InfoLevel
+	case codes.Unknown, codes.Unimplemented, codes.Internal, codes.DataLoss:
+		return zapcore.ErrorLevel
+	}
+	return zapcore.WarnLevel
+}
+
+// contextStream replaces the context of a server stream
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
+
+// countingStream counts the messages of a server stream
+type countingStream struct {
+	grpc.ServerStream
+	received int
+	sent     int
+}
+
+func (s *countingStream) RecvMsg(m any) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		s.received++
+	}
+	return err
+}
+
+func (s *countingStream) SendMsg(m any) error {
+	err := s.ServerStream.SendMsg(m)
+	if err == nil {
+		s.sent++
+	}
+	return err
+}
@@ -0,0 +1,126 @@
+package server_test
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	server "github.com/vedantkulkarni/reflect-poc/server"
+	service_proto "github.com/vedantkulkarni/reflect-poc/service-proto"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// panickingService panics in every handler when asked to
+type panickingService struct {
+	server.MyTestService
+}
+
+func (s *panickingService) Test(ctx context.Context, req *service_proto.TestMessageRequest) (*service_proto.TestMessageResponse, error) {
+	if req.GetMessage() == "panic" {
+		panic("unary handler failed")
+	}
+	return s.MyTestService.Test(ctx, req)
+}
+
+func (s *panickingService) TestServerStream(req *service_proto.TestMessageRequest, stream service_proto.TestService_TestServerStreamServer) error {
+	if req.GetMessage() == "panic" {
+		if err := stream.Send(&service_proto.TestMessageResponse{Message: "before panic"}); err != nil {
+			return err
+		}
+		panic("stream handler failed")
+	}
+	return s.MyTestService.TestServerStream(req, stream)
+}
+
+// serveInterceptors runs the panicking service behind the interceptor chain
+func serveInterceptors(t *testing.T, interceptors server.Interceptors) service_proto.TestServiceClient {
+	t.Helper()
+	listener := bufconn.Listen(1 << 20)
+	grpcServer := grpc.NewServer(interceptors.ServerOptions()...)
+	service_proto.RegisterTestServiceServer(grpcServer, &panickingService{})
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return service_proto.NewTestServiceClient(conn)
+}
+
+func TestRecoveryUnary(t *testing.T) {
+	core, logs := observer.New(zapcore.ErrorLevel)
+	client := serveInterceptors(t, server.Interceptors{Logger: zap.New(core)})
+	ctx := context.Background()
+
+	_, err := client.Test(ctx, &service_proto.TestMessageRequest{Message: "panic"})
+	if status.Code(err) != codes.Internal {
+		t.Fatalf("panicking call returned %v, want Internal", err)
+	}
+	if panics := logs.FilterMessage("Recovered from panic in handler").FilterField(zap.String("method", "/reflect.TestService/Test")); panics.Len() != 1 {
+		t.Errorf("logged %d recovered panics, want 1", panics.Len())
+	}
+
+	// The server keeps serving after the panic
+	if _, err := client.Test(ctx, &service_proto.TestMessageRequest{Message: "hi"}); err != nil {
+		t.Errorf("call after panic failed: %v", err)
+	}
+}
+
+func TestRecoveryStream(t *testing.T) {
+	core, logs := observer.New(zapcore.ErrorLevel)
+	client := serveInterceptors(t, server.Interceptors{Logger: zap.New(core)})
+	ctx := context.Background()
+
+	stream, err := client.TestServerStream(ctx, &service_proto.TestMessageRequest{Message: "panic"})
+	if err != nil {
+		t.Fatalf("failed to start stream: %v", err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		t.Fatalf("failed to receive the message sent before the panic: %v", err)
+	}
+	if _, err := stream.Recv(); status.Code(err) != codes.Internal {
+		t.Fatalf("panicking stream returned %v, want Internal", err)
+	}
+	if panics := logs.FilterMessage("Recovered from panic in handler"); panics.Len() != 1 {
+		t.Errorf("logged %d recovered panics, want 1", panics.Len())
+	}
+
+	// Both unary and streaming calls keep working after the panic
+	if _, err := client.Test(ctx, &service_proto.TestMessageRequest{Message: "hi"}); err != nil {
+		t.Errorf("unary call after panic failed: %v", err)
+	}
+	stream, err = client.TestServerStream(ctx, &service_proto.TestMessageRequest{Message: "hi"})
+	if err != nil {
+		t.Fatalf("failed to start stream after panic: %v", err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		t.Errorf("stream after panic failed: %v", err)
+	}
+}
+
+func TestRecoveryCoversCustomInterceptors(t *testing.T) {
+	failing := func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		panic("interceptor failed")
+	}
+	client := serveInterceptors(t, server.Interceptors{Unary: []grpc.UnaryServerInterceptor{failing}})
+
+	_, err := client.Test(context.Background(), &service_proto.TestMessageRequest{Message: "hi"})
+	if status.Code(err) != codes.Internal {
+		t.Errorf("panicking interceptor returned %v, want Internal", err)
+	}
+}
@@ -0,0 +1,254 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// DefaultDrainTimeout is how long in-flight calls get to finish on shutdown
+const DefaultDrainTimeout = 10 * time.Second
+
+// DefaultHealthInterval is how often the broker connection is checked while serving
+const DefaultHealthInterval = 5 * time.Second
+
+// disconnectQuiesce is how long the MQTT client gets to flush outgoing messages
+const disconnectQuiesce = 250 // milliseconds
+
+// Lifecycle serves a gRPC server over an MQTT bridge and shuts everything down
+// in order: report NOT_SERVING to health watchers, stop accepting calls, drain
+// in-flight calls up to DrainTimeout, close the bridge, publish an offline
+// presence and disconnect from the broker. While serving, the device announces
+// itself online with its services, again after every reconnect when the
+// client supports OnReconnect, and Health reports the device NOT_SERVING
+// while the broker connection is down.
+type Lifecycle struct {
+	GRPCServer *grpc.Server
+	// Listener is the MQTT bridge, possibly wrapped; closing it closes every bridge session
+	Listener net.Listener
+	// MQTTClient carries the bridge; nil serves Listener, such as a TCP
+	// listener, without presence
+	MQTTClient   mqtt.Client
+	BridgeID     string
+	DrainTimeout time.Duration
+	// Health, if set, is shut down first so that watchers learn about the shutdown
+	Health *Health
+	// HealthInterval is how often Health checks the broker connection
+	HealthInterval time.Duration
+	// Presence is announced while serving, see NewPresence; its BridgeID
+	// defaults to BridgeID
+	Presence Presence
+	// Descriptors, if set, are published before the presence that refers to them
+	Descriptors *descriptorpb.FileDescriptorSet
+	Logger      *zap.Logger
+
+	stopping atomic.Bool
+}
+
+// reconnectNotifier is implemented by MQTT clients that report reconnects,
+// such as transport.Client
+type reconnectNotifier interface {
+	OnReconnect(func())
+}
+
+// Run serves until ctx is cancelled or serving fails, then shuts down
+func (l *Lifecycle) Run(ctx context.Context) error {
+	if l.DrainTimeout <= 0 {
+		l.DrainTimeout = DefaultDrainTimeout
+	}
+	if l.HealthInterval <= 0 {
+		l.HealthInterval = DefaultHealthInterval
+	}
+	if l.Logger == nil {
+		l.Logger = zap.NewNop()
+	}
+
+	if l.Presence.BridgeID == "" {
+		l.Presence.BridgeID = l.BridgeID
+	}
+
+	listener := newDrainListener(l.Listener)
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- l.GRPCServer.Serve(listener)
+	}()
+
+	l.announce()
+	if notifier, ok := l.MQTTClient.(reconnectNotifier); ok {
+		notifier.OnReconnect(l.announce)
+	}
+	stopWatchdog := l.startWatchdog()
+
+	var err error
+	select {
+	case err = <-serveErr:
+		l.Logger.Error("gRPC server stopped serving", zap.Error(err))
+	case <-ctx.Done():
+		l.Logger.Info("Shutting down server", zap.Duration("drain_timeout", l.DrainTimeout))
+	}
+
+	stopWatchdog()
+	l.shutdown()
+	return err
+}
+
+// startWatchdog marks the device NOT_SERVING while the broker connection is
+// down, and returns a function that stops the watchdog and waits for it
+func (l *Lifecycle) startWatchdog() func() {
+	if l.Health == nil || l.MQTTClient == nil {
+		return func() {}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		l.Health.Watchdog(ctx, "", l.HealthInterval, func(context.Context) error {
+			if !l.MQTTClient.IsConnectionOpen() {
+				return errBrokerDisconnected
+			}
+			return nil
+		})
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+var errBrokerDisconnected = errors.New("broker connection lost")
+
+// announce publishes the descriptors and then the online presence, which
+// replaces a delivered Last Will. Clients that see the presence can read the
+// descriptors it refers to, even once the device is offline.
+func (l *Lifecycle) announce() {
+	if l.stopping.Load() || l.MQTTClient == nil {
+		return
+	}
+	if l.Descriptors != nil {
+		if _, err := PublishDescriptors(l.MQTTClient, l.Descriptors); err != nil {
+			l.Logger.Warn("Failed to publish descriptors", zap.Error(err))
+		}
+	}
+	presence := l.Presence
+	presence.Online = true
+	presence.Timestamp = time.Now()
+	if err := PublishPresence(l.MQTTClient, presence); err != nil {
+		l.Logger.Warn("Failed to publish online presence", zap.Error(err))
+		return
+	}
+	l.Logger.Info("Announced presence", zap.Strings("services", presence.Services), zap.String("descriptor_hash", presence.DescriptorHash))
+}
+
+func (l *Lifecycle) shutdown() {
+	l.stopping.Store(true)
+	if l.Health != nil {
+		l.Health.Shutdown()
+	}
+
+	// GracefulStop closes the drain listener, which stops accepting sessions
+	// but leaves the bridge, and the sessions of in-flight calls, open
+	stopped := make(chan struct{})
+	go func() {
+		l.GRPCServer.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		l.Logger.Info("In-flight calls drained")
+	case <-time.After(l.DrainTimeout):
+		l.Logger.Warn("Drain timeout reached, cancelling remaining calls")
+		l.GRPCServer.Stop()
+		<-stopped
+	}
+
+	if err := l.Listener.Close(); err != nil {
+		l.Logger.Warn("Failed to close bridge", zap.Error(err))
+	}
+
+	if l.MQTTClient == nil {
+		l.Logger.Info("Server stopped")
+		return
+	}
+
+	// The offline presence keeps the descriptor hash for clients that
+	// describe the device while it is away
+	offline := l.Presence
+	offline.Online = false
+	offline.Timestamp = time.Now()
+	if err := PublishPresence(l.MQTTClient, offline); err != nil {
+		l.Logger.Warn("Failed to publish offline presence", zap.Error(err))
+	}
+
+	l.MQTTClient.Disconnect(disconnectQuiesce)
+	l.Logger.Info("Server stopped")
+}
+
+var errListenerClosed = errors.New("listener closed")
+
+// drainListener lets the gRPC server stop accepting connections without
+// closing the bridge, whose Close tears down every open session at once
+type drainListener struct {
+	net.Listener
+
+	accepted chan acceptResult
+	done     chan struct{}
+	once     sync.Once
+}
+
+type acceptResult struct {
+	conn net.Conn
+	err  error
+}
+
+func newDrainListener(l net.Listener) *drainListener {
+	d := &drainListener{
+		Listener: l,
+		accepted: make(chan acceptResult),
+		done:     make(chan struct{}),
+	}
+	go d.acceptLoop()
+	return d
+}
+
+func (d *drainListener) acceptLoop() {
+	for {
+		conn, err := d.Listener.Accept()
+		select {
+		case d.accepted <- acceptResult{conn: conn, err: err}:
+			if err != nil {
+				return
+			}
+		case <-d.done:
+			if conn != nil {
+				conn.Close()
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (d *drainListener) Accept() (net.Conn, error) {
+	select {
+	case result := <-d.accepted:
+		return result.conn, result.err
+	case <-d.done:
+		return nil, errListenerClosed
+	}
+}
+
+// Close stops Accept; the wrapped listener stays open
+func (d *drainListener) Close() error {
+	d.once.Do(func() { close(d.done) })
+	return nil
+}
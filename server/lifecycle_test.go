@@ -0,0 +1,230 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	server "github.com/vedantkulkarni/reflect-poc/server"
+	service_proto "github.com/vedantkulkarni/reflect-poc/service-proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// fakeMQTT is a broker connection that records retained publishes and whose
+// connection state is set by the test
+type fakeMQTT struct {
+	mqtt.Client
+	connected atomic.Bool
+
+	mu        sync.Mutex
+	presences []server.Presence
+}
+
+func (f *fakeMQTT) IsConnectionOpen() bool {
+	return f.connected.Load()
+}
+
+func (f *fakeMQTT) Publish(topic string, qos byte, retained bool, payload any) mqtt.Token {
+	var presence server.Presence
+	if err := json.Unmarshal(payload.([]byte), &presence); err == nil {
+		f.mu.Lock()
+		f.presences = append(f.presences, presence)
+		f.mu.Unlock()
+	}
+	return &mqtt.DummyToken{}
+}
+
+func (f *fakeMQTT) Disconnect(quiesce uint) {
+	f.connected.Store(false)
+}
+
+func TestLifecycleHealthFollowsBroker(t *testing.T) {
+	listener := bufconn.Listen(1 << 20)
+	grpcServer := grpc.NewServer()
+	service_proto.RegisterTestServiceServer(grpcServer, &server.MyTestService{})
+	health := server.NewHealth(nil)
+	health.Register(grpcServer)
+
+	mqttClient := &fakeMQTT{}
+	mqttClient.connected.Store(true)
+	lifecycle := &server.Lifecycle{
+		GRPCServer:     grpcServer,
+		Listener:       listener,
+		MQTTClient:     mqttClient,
+		BridgeID:       "device-1",
+		DrainTimeout:   time.Second,
+		Health:         health,
+		HealthInterval: 10 * time.Millisecond,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- lifecycle.Run(ctx) }()
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	watchCtx, stopWatch := context.WithTimeout(context.Background(), 10*time.Second)
+	defer stopWatch()
+	watch, err := healthpb.NewHealthClient(conn).Watch(watchCtx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatalf("failed to watch health: %v", err)
+	}
+	expect := func(want healthpb.HealthCheckResponse_ServingStatus) {
+		t.Helper()
+		resp, err := watch.Recv()
+		if err != nil {
+			t.Fatalf("failed to receive health status: %v", err)
+		}
+		if resp.GetStatus() != want {
+			t.Fatalf("health status is %s, want %s", resp.GetStatus(), want)
+		}
+	}
+
+	expect(healthpb.HealthCheckResponse_SERVING)
+	mqttClient.connected.Store(false)
+	expect(healthpb.HealthCheckResponse_NOT_SERVING)
+	mqttClient.connected.Store(true)
+	expect(healthpb.HealthCheckResponse_SERVING)
+
+	// Shutting down reports NOT_SERVING even though the broker is connected
+	cancel()
+	expect(healthpb.HealthCheckResponse_NOT_SERVING)
+	if err := <-done; err != nil {
+		t.Fatalf("lifecycle failed: %v", err)
+	}
+
+	mqttClient.mu.Lock()
+	defer mqttClient.mu.Unlock()
+	if n := len(mqttClient.presences); n != 2 || !mqttClient.presences[0].Online || mqttClient.presences[n-1].Online {
+		t.Errorf("published presences %+v, want online then offline", mqttClient.presences)
+	}
+}
+
+// blockingService holds Test calls until release is closed or the call ends
+type blockingService struct {
+	service_proto.UnimplementedTestServiceServer
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingService) Test(ctx context.Context, req *service_proto.TestMessageRequest) (*service_proto.TestMessageResponse, error) {
+	s.started <- struct{}{}
+	select {
+	case <-s.release:
+		return &service_proto.TestMessageResponse{Message: "done"}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// shutdownDuringCall starts a Test call on a lifecycle with drainTimeout,
+// shuts the lifecycle down while the call is in flight and calls during
+// the drain. Once Run has returned, it returns the presences published and
+// the result of the call.
+func shutdownDuringCall(t *testing.T, drainTimeout time.Duration, during func(*blockingService)) ([]server.Presence, error) {
+	t.Helper()
+	listener := bufconn.Listen(1 << 20)
+	grpcServer := grpc.NewServer()
+	service := &blockingService{started: make(chan struct{}, 1), release: make(chan struct{})}
+	service_proto.RegisterTestServiceServer(grpcServer, service)
+
+	mqttClient := &fakeMQTT{}
+	mqttClient.connected.Store(true)
+	lifecycle := &server.Lifecycle{
+		GRPCServer:   grpcServer,
+		Listener:     listener,
+		MQTTClient:   mqttClient,
+		BridgeID:     "device-1",
+		DrainTimeout: drainTimeout,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- lifecycle.Run(ctx) }()
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	callCtx, cancelCall := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelCall()
+	callErr := make(chan error, 1)
+	go func() {
+		_, err := service_proto.NewTestServiceClient(conn).Test(callCtx, &service_proto.TestMessageRequest{})
+		callErr <- err
+	}()
+	select {
+	case <-service.started:
+	case <-callCtx.Done():
+		t.Fatal("call never reached the service")
+	}
+
+	cancel()
+	during(service)
+	err = <-callErr
+	select {
+	case runErr := <-done:
+		if runErr != nil {
+			t.Fatalf("lifecycle failed: %v", runErr)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("lifecycle did not stop")
+	}
+
+	mqttClient.mu.Lock()
+	defer mqttClient.mu.Unlock()
+	return mqttClient.presences, err
+}
+
+func TestLifecycleDrainsInFlightCalls(t *testing.T) {
+	presences, err := shutdownDuringCall(t, 10*time.Second, func(service *blockingService) {
+		// The call is still being served after the shutdown began
+		time.Sleep(50 * time.Millisecond)
+		close(service.release)
+	})
+	if err != nil {
+		t.Errorf("in-flight call failed: %v", err)
+	}
+	if n := len(presences); n == 0 || presences[n-1].Online {
+		t.Errorf("published presences %+v, want offline last", presences)
+	}
+}
+
+func TestLifecycleCutsOffCallsAfterDrainTimeout(t *testing.T) {
+	start := time.Now()
+	presences, err := shutdownDuringCall(t, 100*time.Millisecond, func(*blockingService) {})
+	if err == nil {
+		t.Error("call outlived the drain timeout")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("shutdown took %v with a drain timeout of 100ms", elapsed)
+	}
+	if n := len(presences); n == 0 || presences[n-1].Online {
+		t.Errorf("published presences %+v, want offline last", presences)
+	}
+}
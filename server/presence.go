@@ -0,0 +1,124 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	transport "github.com/vedantkulkarni/reflect-poc/transport"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// PresenceTopic returns the retained topic where a device announces whether it is online
+func PresenceTopic(bridgeID string) string {
+	return fmt.Sprintf("/bridge/presence/%s", bridgeID)
+}
+
+// DescriptorTopic returns the retained topic holding the FileDescriptorSet
+// whose DescriptorHash is hash. Devices serving the same API share it.
+func DescriptorTopic(hash string) string {
+	return fmt.Sprintf("/bridge/descriptors/%s", hash)
+}
+
+// PresenceTopicFilter matches the presence topics of every device
+const PresenceTopicFilter = "/bridge/presence/+"
+
+// Presence is the retained announcement published on PresenceTopic. A device
+// announces itself online with what it serves when it starts and after every
+// reconnect; its Last Will, or a clean shutdown, marks it offline.
+type Presence struct {
+	BridgeID string `json:"bridge_id"`
+	Online   bool   `json:"online"`
+	// Services are the fully-qualified names of the services served
+	Services []string `json:"services,omitempty"`
+	// Version is the version of the device software
+	Version string `json:"version,omitempty"`
+	// DescriptorHash identifies the descriptors of Services, see DescriptorHash
+	DescriptorHash string    `json:"descriptor_hash,omitempty"`
+	Timestamp      time.Time `json:"timestamp"`
+}
+
+// NewPresence returns the online presence of the device bridgeID serving the
+// services registered on s, with the descriptors its DescriptorHash refers to
+func NewPresence(s *grpc.Server, bridgeID, version string) (Presence, *descriptorpb.FileDescriptorSet, error) {
+	services := ServiceNames(s)
+	set, err := FileDescriptorSet(services)
+	if err != nil {
+		return Presence{}, nil, err
+	}
+	hash, err := DescriptorHash(set)
+	if err != nil {
+		return Presence{}, nil, err
+	}
+	return Presence{
+		BridgeID:       bridgeID,
+		Online:         true,
+		Services:       services,
+		Version:        version,
+		DescriptorHash: hash,
+	}, set, nil
+}
+
+// PresenceWill returns the Last Will that marks the device of p offline when
+// its broker connection drops without a clean shutdown. The will is stamped
+// again before every reconnect, so its timestamp is when the lost connection
+// was made rather than when the device started.
+func PresenceWill(p Presence) (*transport.Will, error) {
+	p.Online = false
+	p.Timestamp = time.Now()
+	payload, err := json.Marshal(p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal presence: %w", err)
+	}
+	refresh := func() []byte {
+		stamped := p
+		stamped.Timestamp = time.Now()
+		if refreshed, err := json.Marshal(stamped); err == nil {
+			return refreshed
+		}
+		return payload
+	}
+	return &transport.Will{Topic: PresenceTopic(p.BridgeID), Payload: payload, QoS: 1, Retained: true, Refresh: refresh}, nil
+}
+
+// publishTimeout bounds how long a presence publish waits for the broker
+const publishTimeout = 5 * time.Second
+
+// PublishPresence publishes p as a retained message on the device presence topic
+func PublishPresence(mqttClient mqtt.Client, p Presence) error {
+	payload, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("failed to marshal presence: %w", err)
+	}
+
+	if err := publishRetained(mqttClient, PresenceTopic(p.BridgeID), payload); err != nil {
+		return fmt.Errorf("failed to publish presence: %w", err)
+	}
+	return nil
+}
+
+// PublishDescriptors publishes set, serialized deterministically, as a
+// retained message on the DescriptorTopic of its hash and returns the hash
+func PublishDescriptors(mqttClient mqtt.Client, set *descriptorpb.FileDescriptorSet) (string, error) {
+	payload, err := proto.MarshalOptions{Deterministic: true}.Marshal(set)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal descriptors: %w", err)
+	}
+	hash := hashPayload(payload)
+	if err := publishRetained(mqttClient, DescriptorTopic(hash), payload); err != nil {
+		return "", fmt.Errorf("failed to publish descriptors: %w", err)
+	}
+	return hash, nil
+}
+
+func publishRetained(mqttClient mqtt.Client, topic string, payload []byte) error {
+	token := mqttClient.Publish(topic, 1, true, payload)
+	if !token.WaitTimeout(publishTimeout) {
+		return errors.New("timed out")
+	}
+	return token.Error()
+}
@@ -0,0 +1,37 @@
+package server_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	server "github.com/vedantkulkarni/reflect-poc/server"
+)
+
+func TestPresenceWillIsStampedPerConnection(t *testing.T) {
+	will, err := server.PresenceWill(server.Presence{BridgeID: "pump-1", Online: true, Services: []string{"reflect.TestService"}})
+	if err != nil {
+		t.Fatalf("failed to build will: %v", err)
+	}
+	if will.Topic != server.PresenceTopic("pump-1") || !will.Retained {
+		t.Errorf("will is published on %s, retained %v", will.Topic, will.Retained)
+	}
+
+	decode := func(payload []byte) server.Presence {
+		t.Helper()
+		var presence server.Presence
+		if err := json.Unmarshal(payload, &presence); err != nil {
+			t.Fatalf("invalid will: %v", err)
+		}
+		if presence.Online || presence.BridgeID != "pump-1" || len(presence.Services) != 1 {
+			t.Errorf("will announces %+v", presence)
+		}
+		return presence
+	}
+	first := decode(will.Payload)
+	time.Sleep(10 * time.Millisecond)
+	refreshed := decode(will.Refresh())
+	if !refreshed.Timestamp.After(first.Timestamp) {
+		t.Errorf("refreshed will is stamped %v, not after %v", refreshed.Timestamp, first.Timestamp)
+	}
+}
@@ -6,15 +6,39 @@ import (
 	"io"
 	"strings"
 
+	auth "github.com/vedantkulkarni/reflect-poc/auth"
 	service_proto "github.com/vedantkulkarni/reflect-poc/service-proto"
+	"go.uber.org/zap"
 )
 
+// caller returns the subject of the authenticated caller, if any
+func caller(ctx context.Context) string {
+	if id, ok := auth.FromContext(ctx); ok {
+		return id.Subject
+	}
+	return "anonymous"
+}
+
+// serviceLogger returns logger, or a no-op logger when it is nil
+func serviceLogger(logger *zap.Logger) *zap.Logger {
+	if logger == nil {
+		return zap.NewNop()
+	}
+	return logger
+}
+
 type MyTestService struct {
 	service_proto.UnimplementedTestServiceServer
+
+	// Logger records handled calls; nil disables logging
+	Logger *zap.Logger
 }
 
 func (s *MyTestService) Test(ctx context.Context, req *service_proto.TestMessageRequest) (*service_proto.TestMessageResponse, error) {
-	fmt.Println("Received: ", req.Message)
+	serviceLogger(s.Logger).Debug("Test called",
+		zap.String("method", "Test"),
+		zap.String("caller", caller(ctx)),
+		zap.Int("message_length", len(req.Message)))
 	return &service_proto.TestMessageResponse{
 		Message: "Response from Test method",
 	}, nil
@@ -34,7 +58,9 @@ func (s *MyTestService) TestServerStream(req *service_proto.TestMessageRequest,
 }
 
 func (s *MyTestService) TestClientStream(stream service_proto.TestService_TestClientStreamServer) error {
-	fmt.Println("Received client stream")
+	serviceLogger(s.Logger).Debug("Client stream opened",
+		zap.String("method", "TestClientStream"),
+		zap.String("caller", caller(stream.Context())))
 	var messages []string
 	for {
 		req, err := stream.Recv()
@@ -70,6 +96,9 @@ func (s *MyTestService) TestBidiStream(stream service_proto.TestService_TestBidi
 }
 
 func (s *MyTestService) Run(ctx context.Context, req *service_proto.RunMessageRequest) (*service_proto.RunMessageResponse, error) {
+	serviceLogger(s.Logger).Info("Run requested",
+		zap.String("method", "Run"),
+		zap.String("caller", caller(ctx)))
 	return &service_proto.RunMessageResponse{
 		Message: "Response from Run method",
 	}, nil
@@ -8,15 +8,21 @@ import (
 	"time"
 
 	service_proto "github.com/vedantkulkarni/reflect-poc/service-proto"
+	"go.uber.org/zap"
 )
 
-
 type MySyncService struct {
 	service_proto.UnimplementedSyncServiceServer
+
+	// Logger records handled calls; nil disables logging
+	Logger *zap.Logger
 }
 
 // Sync handles unary RPC calls
 func (s *MySyncService) Sync(ctx context.Context, req *service_proto.SyncMessageRequest) (*service_proto.SyncMessageResponse, error) {
+	serviceLogger(s.Logger).Info("Sync requested",
+		zap.String("method", "Sync"),
+		zap.String("caller", caller(ctx)))
 	return &service_proto.SyncMessageResponse{
 		Message: "Received: " + req.GetMessage(),
 	}, nil
@@ -63,7 +69,7 @@ func (s *MySyncService) SyncBidiStream(stream service_proto.SyncService_SyncBidi
 		if err != nil {
 			return err
 		}
-		
+
 		if err := stream.Send(&service_proto.SyncMessageResponse{
 			Message: "Echoing: " + req.GetMessage(),
 		}); err != nil {
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/vedantkulkarni/reflect-poc/repl"
+)
+
+// runShell opens an interactive prompt connected to one device
+func runShell(args []string) error {
+	fs := flag.NewFlagSet("shell", flag.ExitOnError)
+	var opts clientOptions
+	opts.register(fs)
+	history := fs.String("history", defaultHistoryFile(), "file that keeps command history; empty disables it")
+	format := fs.String("format", "json", "output format: "+strings.Join(outputFormats, ", "))
+	emitDefaults := fs.Bool("emit-defaults", false, "include fields with default values in JSON output")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s shell [flags] [bridge-id]\n", os.Args[0])
+		fmt.Fprintln(fs.Output(), "-timeout bounds each call; interactive streams stay open until closed.")
+		fs.PrintDefaults()
+	}
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return errUsage
+	}
+	bridgeID := opts.bridgeID
+	if fs.NArg() == 1 {
+		bridgeID = fs.Arg(0)
+	}
+
+	formatter, err := newFormatter(*format, *emitDefaults)
+	if err != nil {
+		return err
+	}
+
+	reflectionClient, err := opts.dial(bridgeID)
+	if err != nil {
+		return err
+	}
+	defer opts.close()
+	defer reflectionClient.Close()
+
+	ctx := opts.withHeaders(context.Background())
+	loadCtx, cancel := context.WithTimeout(ctx, opts.timeout)
+	defer cancel()
+	shell, err := repl.New(loadCtx, repl.Config{
+		Client:      reflectionClient,
+		BridgeID:    bridgeID,
+		HistoryFile: *history,
+		Format:      formatter,
+		CallTimeout: opts.timeout,
+	})
+	if err != nil {
+		return err
+	}
+	return shell.Run(ctx)
+}
+
+func defaultHistoryFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".reflect_history")
+}
@@ -0,0 +1,43 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// OTLPConfig describes the OTLP/gRPC collector spans are exported to
+type OTLPConfig struct {
+	// Endpoint of the collector, host:port
+	Endpoint string
+	// Insecure disables TLS to the collector
+	Insecure bool
+	// ServiceName identifies this process in the traces
+	ServiceName string
+}
+
+// NewOTLPProvider returns a tracer provider that batches spans to the
+// collector. Shutdown flushes the remaining spans.
+func NewOTLPProvider(ctx context.Context, cfg OTLPConfig) (*sdktrace.TracerProvider, error) {
+	opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(cfg.Endpoint)}
+	if cfg.Insecure {
+		opts = append(opts, otlptracegrpc.WithInsecure())
+	}
+	exporter, err := otlptracegrpc.New(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+	return NewProvider(exporter, cfg.ServiceName), nil
+}
+
+// NewProvider returns a tracer provider that batches spans to exporter
+func NewProvider(exporter sdktrace.SpanExporter, serviceName string) *sdktrace.TracerProvider {
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(serviceName))),
+	)
+}
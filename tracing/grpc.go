@@ -0,0 +1,134 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryServerInterceptor continues the caller's trace in a server span
+func (t *Tracing) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, span := t.startServer(ctx, info.FullMethod)
+		resp, err := handler(ctx, req)
+		end(span, err)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor continues the caller's trace in a server span
+func (t *Tracing) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, span := t.startServer(ss.Context(), info.FullMethod)
+		err := handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+		end(span, err)
+		return err
+	}
+}
+
+// startServer starts the span of a call received by the device. The bridge
+// reports the bridge ID as the local address and the session as the peer.
+func (t *Tracing) startServer(ctx context.Context, fullMethod string) (context.Context, trace.Span) {
+	attrs := rpcAttributes(fullMethod)
+	if p, ok := peer.FromContext(ctx); ok && p.LocalAddr != nil && p.Addr != nil {
+		attrs = append(attrs, sessionAttributes(p.LocalAddr.String(), p.Addr.String())...)
+	}
+	return t.tracer.Start(t.extract(ctx), spanName(fullMethod),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attrs...))
+}
+
+// UnaryClientInterceptor starts a client span and sends its context to the device
+func (t *Tracing) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx, span := t.startClient(ctx, method, cc)
+		err := invoker(t.inject(ctx), method, req, reply, cc, opts...)
+		end(span, err)
+		return err
+	}
+}
+
+// StreamClientInterceptor starts a client span and sends its context to the
+// device. The span ends once RecvMsg returns an error, io.EOF included, or
+// with the single response of a client-streaming call.
+func (t *Tracing) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		ctx, span := t.startClient(ctx, method, cc)
+		cs, err := streamer(t.inject(ctx), desc, cc, method, opts...)
+		if err != nil {
+			end(span, err)
+			return nil, err
+		}
+		s := &clientStream{ClientStream: cs, span: span, serverStreams: desc.ServerStreams}
+		// Callers may abandon a stream without reading it to the end
+		s.stop = context.AfterFunc(ctx, func() {
+			s.end(status.FromContextError(ctx.Err()).Err())
+		})
+		return s, nil
+	}
+}
+
+// startClient starts the span of a call to the device at cc. The session is
+// only known to the device, so the topics are the filters of every session.
+func (t *Tracing) startClient(ctx context.Context, fullMethod string, cc *grpc.ClientConn) (context.Context, trace.Span) {
+	attrs := rpcAttributes(fullMethod)
+	if bridgeID := bridgeIDOf(cc.Target()); bridgeID != "" {
+		attrs = append(attrs, sessionAttributes(bridgeID, "")...)
+	}
+	return t.tracer.Start(ctx, spanName(fullMethod),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...))
+}
+
+// bridgeIDOf returns the bridge ID of an mqtt://<bridge-id> target
+func bridgeIDOf(target string) string {
+	bridgeID, ok := strings.CutPrefix(target, "mqtt://")
+	if !ok {
+		return ""
+	}
+	return bridgeID
+}
+
+// serverStream carries the span context to stream handlers
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+// clientStream ends the span when the stream ends, or when its context does
+type clientStream struct {
+	grpc.ClientStream
+	span          trace.Span
+	serverStreams bool
+	// stop cancels the context.AfterFunc that ends the span of abandoned streams
+	stop func() bool
+	once sync.Once
+}
+
+func (s *clientStream) RecvMsg(m any) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil || !s.serverStreams {
+		s.stop()
+		spanErr := err
+		if errors.Is(spanErr, io.EOF) {
+			spanErr = nil
+		}
+		s.end(spanErr)
+	}
+	return err
+}
+
+func (s *clientStream) end(err error) {
+	s.once.Do(func() { end(s.span, err) })
+}
@@ -0,0 +1,134 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	grpccodes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// instrumentationName identifies the tracer that creates the spans
+const instrumentationName = "github.com/vedantkulkarni/reflect-poc/tracing"
+
+// Attributes set on spans in addition to the OpenTelemetry RPC conventions
+const (
+	// BridgeIDKey is the bridge ID of the device that handles the call
+	BridgeIDKey = attribute.Key("mqtt_bridge.bridge_id")
+	// SessionIDKey is the bridge session the call is carried by, known on the device only
+	SessionIDKey = attribute.Key("mqtt_bridge.session_id")
+	// UpTopicKey and DownTopicKey are the MQTT topics of the session; on the
+	// client, where the session is not known, they hold the topic filters
+	UpTopicKey   = attribute.Key("mqtt_bridge.topic.up")
+	DownTopicKey = attribute.Key("mqtt_bridge.topic.down")
+)
+
+// Topic patterns of the bridge sessions
+const (
+	sessionUpTopic   = "/bridge/session/%s/%s/up"
+	sessionDownTopic = "/bridge/session/%s/%s/down"
+)
+
+// Tracing creates spans for calls over the bridge and carries the W3C trace
+// context in gRPC metadata, so that a trace continues on the device
+type Tracing struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+// New creates spans with provider, typically an sdktrace.TracerProvider
+func New(provider trace.TracerProvider) *Tracing {
+	return &Tracing{
+		tracer:     provider.Tracer(instrumentationName),
+		propagator: propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}),
+	}
+}
+
+// inject adds the trace context of ctx to its outgoing metadata
+func (t *Tracing) inject(ctx context.Context) context.Context {
+	md, ok := metadata.FromOutgoingContext(ctx)
+	if ok {
+		md = md.Copy()
+	} else {
+		md = metadata.MD{}
+	}
+	t.propagator.Inject(ctx, metadataCarrier(md))
+	return metadata.NewOutgoingContext(ctx, md)
+}
+
+// extract returns ctx with the trace context found in its incoming metadata
+func (t *Tracing) extract(ctx context.Context) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	return t.propagator.Extract(ctx, metadataCarrier(md))
+}
+
+// metadataCarrier adapts gRPC metadata to a propagation.TextMapCarrier
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Get(key string) string {
+	values := metadata.MD(c).Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+func (c metadataCarrier) Set(key, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// rpcAttributes returns the attributes describing fullMethod, /pkg.Service/Method
+func rpcAttributes(fullMethod string) []attribute.KeyValue {
+	service, method, _ := strings.Cut(strings.TrimPrefix(fullMethod, "/"), "/")
+	return []attribute.KeyValue{
+		semconv.RPCSystemGRPC,
+		semconv.RPCService(service),
+		semconv.RPCMethod(method),
+		semconv.MessagingSystemKey.String("mqtt"),
+	}
+}
+
+// sessionAttributes describes the bridge session sessionID of bridgeID. An
+// empty sessionID describes every session of the bridge.
+func sessionAttributes(bridgeID, sessionID string) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{BridgeIDKey.String(bridgeID)}
+	topicSession := "+"
+	if sessionID != "" {
+		attrs = append(attrs, SessionIDKey.String(sessionID))
+		topicSession = sessionID
+	}
+	return append(attrs,
+		UpTopicKey.String(fmt.Sprintf(sessionUpTopic, bridgeID, topicSession)),
+		DownTopicKey.String(fmt.Sprintf(sessionDownTopic, bridgeID, topicSession)),
+	)
+}
+
+// spanName follows the RPC conventions: pkg.Service/Method
+func spanName(fullMethod string) string {
+	return strings.TrimPrefix(fullMethod, "/")
+}
+
+// end records the outcome of the call on span and ends it
+func end(span trace.Span, err error) {
+	s, _ := status.FromError(err)
+	span.SetAttributes(semconv.RPCGRPCStatusCodeKey.Int(int(s.Code())))
+	if s.Code() != grpccodes.OK {
+		span.SetStatus(codes.Error, s.Message())
+	}
+	span.End()
+}
@@ -0,0 +1,267 @@
+package tracing_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	server "github.com/vedantkulkarni/reflect-poc/server"
+	service_proto "github.com/vedantkulkarni/reflect-poc/service-proto"
+	tracing "github.com/vedantkulkarni/reflect-poc/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	grpccodes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const (
+	bridgeID  = "echo-service1"
+	sessionID = "session-1"
+)
+
+// bridgeAddr is the address the MQTT bridge gives its connections
+type bridgeAddr string
+
+func (a bridgeAddr) Network() string { return "mqtt" }
+func (a bridgeAddr) String() string  { return string(a) }
+
+// bridgeConn reports addresses like a bridge session on the device does: the
+// bridge ID locally and the session ID as the peer
+type bridgeConn struct {
+	net.Conn
+}
+
+func (c bridgeConn) LocalAddr() net.Addr  { return bridgeAddr(bridgeID) }
+func (c bridgeConn) RemoteAddr() net.Addr { return bridgeAddr(sessionID) }
+
+type bridgeListener struct {
+	net.Listener
+}
+
+func (l bridgeListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return bridgeConn{conn}, nil
+}
+
+// dial serves the test service traced by serverProvider and returns a client
+// traced by clientProvider that dials mqtt://<bridgeID> like the real client
+func dial(t *testing.T, clientProvider, serverProvider trace.TracerProvider) service_proto.TestServiceClient {
+	t.Helper()
+	listener := bufconn.Listen(1 << 20)
+	interceptors := server.Interceptors{Tracing: tracing.New(serverProvider)}
+	grpcServer := grpc.NewServer(interceptors.ServerOptions()...)
+	service_proto.RegisterTestServiceServer(grpcServer, &server.MyTestService{})
+	go grpcServer.Serve(bridgeListener{listener})
+	t.Cleanup(grpcServer.Stop)
+
+	builder := manual.NewBuilderWithScheme("mqtt")
+	builder.InitialState(resolver.State{Addresses: []resolver.Address{{Addr: bridgeID}}})
+	clientTracing := tracing.New(clientProvider)
+	conn, err := grpc.NewClient("mqtt://"+bridgeID,
+		grpc.WithResolvers(builder),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithChainUnaryInterceptor(clientTracing.UnaryClientInterceptor()),
+		grpc.WithChainStreamInterceptor(clientTracing.StreamClientInterceptor()),
+	)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return service_proto.NewTestServiceClient(conn)
+}
+
+func newProvider(exporter sdktrace.SpanExporter) *sdktrace.TracerProvider {
+	return sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+}
+
+// spans returns the ended spans of kind by name
+func spans(exporter *tracetest.InMemoryExporter, kind trace.SpanKind) map[string]tracetest.SpanStub {
+	byName := make(map[string]tracetest.SpanStub)
+	for _, span := range exporter.GetSpans() {
+		if span.SpanKind == kind {
+			byName[span.Name] = span
+		}
+	}
+	return byName
+}
+
+func attributes(span tracetest.SpanStub) map[attribute.Key]attribute.Value {
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, kv := range span.Attributes {
+		attrs[kv.Key] = kv.Value
+	}
+	return attrs
+}
+
+func TestPropagation(t *testing.T) {
+	clientExporter := tracetest.NewInMemoryExporter()
+	serverExporter := tracetest.NewInMemoryExporter()
+	client := dial(t, newProvider(clientExporter), newProvider(serverExporter))
+	ctx := context.Background()
+
+	if _, err := client.Test(ctx, &service_proto.TestMessageRequest{Message: "hi"}); err != nil {
+		t.Fatalf("Test failed: %v", err)
+	}
+	stream, err := client.TestClientStream(ctx)
+	if err != nil {
+		t.Fatalf("TestClientStream failed: %v", err)
+	}
+	if err := stream.Send(&service_proto.TestMessageRequest{Message: "a"}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if _, err := stream.CloseAndRecv(); err != nil {
+		t.Fatalf("CloseAndRecv failed: %v", err)
+	}
+
+	clientSpans := spans(clientExporter, trace.SpanKindClient)
+	serverSpans := spans(serverExporter, trace.SpanKindServer)
+	for _, name := range []string{"reflect.TestService/Test", "reflect.TestService/TestClientStream"} {
+		clientSpan, ok := clientSpans[name]
+		if !ok {
+			t.Fatalf("no client span %s", name)
+		}
+		serverSpan, ok := serverSpans[name]
+		if !ok {
+			t.Fatalf("no server span %s", name)
+		}
+
+		if serverSpan.SpanContext.TraceID() != clientSpan.SpanContext.TraceID() {
+			t.Errorf("%s: server trace %s, want the client trace %s", name, serverSpan.SpanContext.TraceID(), clientSpan.SpanContext.TraceID())
+		}
+		if serverSpan.Parent.SpanID() != clientSpan.SpanContext.SpanID() || !serverSpan.Parent.IsRemote() {
+			t.Errorf("%s: server span parent is %s, want the remote client span %s", name, serverSpan.Parent.SpanID(), clientSpan.SpanContext.SpanID())
+		}
+
+		clientAttrs := attributes(clientSpan)
+		for key, want := range map[attribute.Key]string{
+			tracing.BridgeIDKey:  bridgeID,
+			tracing.UpTopicKey:   "/bridge/session/echo-service1/+/up",
+			tracing.DownTopicKey: "/bridge/session/echo-service1/+/down",
+			"rpc.system":         "grpc",
+			"rpc.service":        "reflect.TestService",
+		} {
+			if got := clientAttrs[key].AsString(); got != want {
+				t.Errorf("%s: client %s = %q, want %q", name, key, got, want)
+			}
+		}
+
+		serverAttrs := attributes(serverSpan)
+		for key, want := range map[attribute.Key]string{
+			tracing.BridgeIDKey:  bridgeID,
+			tracing.SessionIDKey: sessionID,
+			tracing.UpTopicKey:   "/bridge/session/echo-service1/session-1/up",
+			tracing.DownTopicKey: "/bridge/session/echo-service1/session-1/down",
+			"messaging.system":   "mqtt",
+		} {
+			if got := serverAttrs[key].AsString(); got != want {
+				t.Errorf("%s: server %s = %q, want %q", name, key, got, want)
+			}
+		}
+	}
+}
+
+func TestErrorStatus(t *testing.T) {
+	clientExporter := tracetest.NewInMemoryExporter()
+	serverExporter := tracetest.NewInMemoryExporter()
+	client := dial(t, newProvider(clientExporter), newProvider(serverExporter))
+
+	// A cancelled context fails the call before it reaches the device
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := client.Test(ctx, &service_proto.TestMessageRequest{})
+	if status.Code(err) != grpccodes.Canceled {
+		t.Fatalf("Test returned %v, want Canceled", err)
+	}
+
+	span, ok := spans(clientExporter, trace.SpanKindClient)["reflect.TestService/Test"]
+	if !ok {
+		t.Fatal("no client span")
+	}
+	if span.Status.Code != codes.Error {
+		t.Errorf("span status is %v, want Error", span.Status.Code)
+	}
+	if got := attributes(span)["rpc.grpc.status_code"].AsInt64(); got != int64(grpccodes.Canceled) {
+		t.Errorf("rpc.grpc.status_code = %d, want %d", got, grpccodes.Canceled)
+	}
+}
+
+// TestServerStreamEOF checks that a traced server stream ends with io.EOF
+// right after the last message the device sent
+func TestServerStreamEOF(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	client := dial(t, newProvider(exporter), newProvider(tracetest.NewInMemoryExporter()))
+
+	stream, err := client.TestServerStream(context.Background(), &service_proto.TestMessageRequest{Message: "hi"})
+	if err != nil {
+		t.Fatalf("TestServerStream failed: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		if _, err := stream.Recv(); err != nil {
+			t.Fatalf("Recv %d failed: %v", i, err)
+		}
+	}
+	if resp, err := stream.Recv(); err != io.EOF {
+		t.Fatalf("Recv after the last message returned %v, %v, want io.EOF", resp, err)
+	}
+
+	span, ok := spans(exporter, trace.SpanKindClient)["reflect.TestService/TestServerStream"]
+	if !ok {
+		t.Fatal("no client span")
+	}
+	if span.Status.Code == codes.Error {
+		t.Errorf("span status is Error, want the end of stream to end it cleanly")
+	}
+}
+
+// TestAbandonedStreamEndsSpan checks that the span of a stream the caller
+// stops reading ends with its context
+func TestAbandonedStreamEndsSpan(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	client := dial(t, newProvider(exporter), newProvider(tracetest.NewInMemoryExporter()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := client.TestServerStream(ctx, &service_proto.TestMessageRequest{Message: "hi"})
+	if err != nil {
+		t.Fatalf("TestServerStream failed: %v", err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		t.Fatalf("Recv failed: %v", err)
+	}
+	if _, ok := spans(exporter, trace.SpanKindClient)["reflect.TestService/TestServerStream"]; ok {
+		t.Fatal("span ended while the stream was open")
+	}
+
+	cancel()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		span, ok := spans(exporter, trace.SpanKindClient)["reflect.TestService/TestServerStream"]
+		if ok {
+			if got := attributes(span)["rpc.grpc.status_code"].AsInt64(); got != int64(grpccodes.Canceled) {
+				t.Errorf("rpc.grpc.status_code = %d, want %d", got, grpccodes.Canceled)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("span of the abandoned stream never ended")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
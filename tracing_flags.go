@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"time"
+
+	tracing "github.com/vedantkulkarni/reflect-poc/tracing"
+	"go.uber.org/zap"
+)
+
+// traceFlushTimeout bounds how long exiting waits for spans to be exported
+const traceFlushTimeout = 5 * time.Second
+
+// traceOptions are the flags that export OpenTelemetry traces
+type traceOptions struct {
+	endpoint string
+	insecure bool
+}
+
+func (o *traceOptions) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.endpoint, "otlp-endpoint", "", "export traces to this OTLP/gRPC collector, host:port")
+	fs.BoolVar(&o.insecure, "otlp-insecure", false, "connect to the OTLP collector without TLS")
+}
+
+// start returns the tracing of the process, or nil when no collector is set,
+// and a function that flushes the remaining spans
+func (o *traceOptions) start(serviceName string, logger *zap.Logger) (*tracing.Tracing, func(), error) {
+	if o.endpoint == "" {
+		return nil, func() {}, nil
+	}
+	provider, err := tracing.NewOTLPProvider(context.Background(), tracing.OTLPConfig{
+		Endpoint:    o.endpoint,
+		Insecure:    o.insecure,
+		ServiceName: serviceName,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return tracing.New(provider), func() {
+		ctx, cancel := context.WithTimeout(context.Background(), traceFlushTimeout)
+		defer cancel()
+		if err := provider.Shutdown(ctx); err != nil {
+			logger.Warn("Failed to flush traces", zap.Error(err))
+		}
+	}, nil
+}
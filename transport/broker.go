@@ -0,0 +1,143 @@
+package transport
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"go.uber.org/zap"
+)
+
+// DefaultBrokerURL is the broker used when none is configured
+const DefaultBrokerURL = "tcp://localhost:1883"
+
+// BrokerConfig describes how to reach and authenticate with the MQTT broker
+type BrokerConfig struct {
+	// URL of the broker, e.g. tcp://host:1883 or ssl://host:8883
+	URL      string
+	ClientID string
+	Username string
+	Password string
+	TLS      TLSConfig
+	// ConnectTimeout bounds the initial connection attempt
+	ConnectTimeout time.Duration
+	Reconnect      ReconnectConfig
+	// OnConnectionEvent is called whenever the broker connection state changes
+	OnConnectionEvent func(ConnectionEvent)
+	// Logger records connection state changes; nil disables logging
+	Logger *zap.Logger
+	// Observer is told about publishes, deliveries, subscriptions and reconnects; nil disables it
+	Observer Observer
+	// Will is published by the broker when the connection drops without a disconnect
+	Will *Will
+	// Dial, if set, opens the connection to the broker instead of dialing
+	// URL, such as an in-process connection to an embedded broker
+	Dial func() (net.Conn, error)
+}
+
+// Will is the MQTT Last Will and Testament of a connection
+type Will struct {
+	Topic    string
+	Payload  []byte
+	QoS      byte
+	Retained bool
+	// Refresh, if set, replaces Payload before the first connection and every
+	// reconnect, so that the will can describe the connection it is sent with
+	Refresh func() []byte
+}
+
+// usesTLS reports whether the broker URL scheme requires a TLS connection
+func usesTLS(scheme string) bool {
+	switch scheme {
+	case "ssl", "tls", "mqtts", "mqtt+ssl", "tcps", "wss":
+		return true
+	}
+	return false
+}
+
+// NewClientOptions builds paho client options from the broker configuration
+func NewClientOptions(cfg BrokerConfig) (*mqtt.ClientOptions, error) {
+	brokerURL := cfg.URL
+	if brokerURL == "" {
+		brokerURL = DefaultBrokerURL
+	}
+
+	parsed, err := url.Parse(brokerURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid broker url %q: %w", brokerURL, err)
+	}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(brokerURL).
+		SetClientID(cfg.ClientID)
+
+	if cfg.Username != "" {
+		opts.SetUsername(cfg.Username)
+		opts.SetPassword(cfg.Password)
+	}
+
+	if cfg.ConnectTimeout > 0 {
+		opts.SetConnectTimeout(cfg.ConnectTimeout)
+	}
+
+	if cfg.Will != nil {
+		payload := cfg.Will.Payload
+		if cfg.Will.Refresh != nil {
+			payload = cfg.Will.Refresh()
+		}
+		opts.SetBinaryWill(cfg.Will.Topic, payload, cfg.Will.QoS, cfg.Will.Retained)
+	}
+
+	if usesTLS(parsed.Scheme) || cfg.TLS.Enabled() {
+		if !usesTLS(parsed.Scheme) {
+			return nil, fmt.Errorf("TLS options set but broker url %q does not use a TLS scheme", brokerURL)
+		}
+		// The broker certificate is checked against the host of its URL, which
+		// is not sent as SNI when it is an IP address
+		brokerTLS := cfg.TLS
+		if brokerTLS.ServerName == "" {
+			brokerTLS.ServerName = parsed.Hostname()
+		}
+		tlsConfig, err := brokerTLS.ClientConfig()
+		if err != nil {
+			return nil, fmt.Errorf("failed to build broker TLS config: %w", err)
+		}
+		opts.SetTLSConfig(tlsConfig)
+	}
+
+	if cfg.Dial != nil {
+		opts.SetCustomOpenConnectionFn(func(*url.URL, mqtt.ClientOptions) (net.Conn, error) {
+			return cfg.Dial()
+		})
+	}
+
+	return opts, nil
+}
+
+// Connect creates an MQTT client from the broker configuration and connects it.
+// The client reconnects with backoff and restores its subscriptions unless
+// reconnection is disabled.
+func Connect(cfg BrokerConfig) (*Client, error) {
+	opts, err := NewClientOptions(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	logger := cfg.Logger
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	logger = logger.With(zap.String("broker", opts.Servers[0].Redacted()), zap.String("client_id", opts.ClientID))
+
+	mqttClient := newClient(cfg.OnConnectionEvent, logger, cfg.Observer)
+	mqttClient.setHandlers(opts, cfg.Reconnect, cfg.Will)
+	mqttClient.Client = mqtt.NewClient(opts)
+
+	token := mqttClient.Connect()
+	if token.Wait() && token.Error() != nil {
+		return nil, fmt.Errorf("failed to connect to MQTT broker: %w", token.Error())
+	}
+	return mqttClient, nil
+}
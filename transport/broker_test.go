@@ -0,0 +1,96 @@
+package transport_test
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	embedded "github.com/vedantkulkarni/reflect-poc/embedded"
+	transport "github.com/vedantkulkarni/reflect-poc/transport"
+)
+
+func TestWillRefreshedOnReconnect(t *testing.T) {
+	b, err := embedded.Start(embedded.Config{})
+	if err != nil {
+		t.Fatalf("failed to start broker: %v", err)
+	}
+	defer b.Close()
+
+	// Record the broker connections so the test can drop them
+	var mu sync.Mutex
+	var conns []net.Conn
+	dial := func() (net.Conn, error) {
+		conn, err := b.Dial()
+		if err == nil {
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+		}
+		return conn, err
+	}
+	dropConnection := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		conns[len(conns)-1].Close()
+	}
+
+	wills := make(chan string, 10)
+	watcher, err := transport.Connect(transport.BrokerConfig{URL: embedded.InProcessURL, ClientID: "watcher", Dial: b.Dial})
+	if err != nil {
+		t.Fatalf("failed to connect watcher: %v", err)
+	}
+	defer watcher.Disconnect(0)
+	token := watcher.Subscribe("/test/will", 1, func(_ mqtt.Client, msg mqtt.Message) {
+		wills <- string(msg.Payload())
+	})
+	if token.Wait() && token.Error() != nil {
+		t.Fatalf("failed to subscribe: %v", token.Error())
+	}
+
+	var refreshes atomic.Int32
+	reconnected := make(chan struct{}, 10)
+	device, err := transport.Connect(transport.BrokerConfig{
+		URL:       embedded.InProcessURL,
+		ClientID:  "device",
+		Dial:      dial,
+		Reconnect: transport.ReconnectConfig{MaxInterval: 100 * time.Millisecond},
+		Will: &transport.Will{
+			Topic:   "/test/will",
+			Payload: []byte("stale"),
+			QoS:     1,
+			Refresh: func() []byte {
+				return []byte(fmt.Sprintf("connection-%d", refreshes.Add(1)))
+			},
+		},
+		OnConnectionEvent: func(event transport.ConnectionEvent) {
+			if event.State == transport.StateConnected && event.Reconnect {
+				reconnected <- struct{}{}
+			}
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to connect device: %v", err)
+	}
+	defer device.Disconnect(0)
+
+	for _, want := range []string{"connection-1", "connection-2"} {
+		dropConnection()
+		select {
+		case will := <-wills:
+			if will != want {
+				t.Fatalf("will is %q, want %q", will, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("will %q not delivered", want)
+		}
+		select {
+		case <-reconnected:
+		case <-time.After(5 * time.Second):
+			t.Fatal("device did not reconnect")
+		}
+	}
+}
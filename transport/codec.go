@@ -0,0 +1,41 @@
+package transport
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// FrameCodec passes *[]byte messages through untouched and encodes every
+// other message as protobuf. Proxies use it to forward calls without decoding
+// them, on connections and servers that also serve ordinary calls. Its name
+// keeps the application/grpc+proto content type.
+type FrameCodec struct{}
+
+// Marshal returns the bytes of a *[]byte frame or the encoding of a proto message
+func (FrameCodec) Marshal(v any) ([]byte, error) {
+	switch v := v.(type) {
+	case *[]byte:
+		return *v, nil
+	case proto.Message:
+		return proto.Marshal(v)
+	default:
+		return nil, fmt.Errorf("cannot marshal %T", v)
+	}
+}
+
+// Unmarshal copies data into a *[]byte frame or decodes it into a proto message
+func (FrameCodec) Unmarshal(data []byte, v any) error {
+	switch v := v.(type) {
+	case *[]byte:
+		*v = append([]byte(nil), data...)
+		return nil
+	case proto.Message:
+		return proto.Unmarshal(data, v)
+	default:
+		return fmt.Errorf("cannot unmarshal into %T", v)
+	}
+}
+
+// Name returns the content subtype of the codec
+func (FrameCodec) Name() string { return "proto" }
@@ -0,0 +1,57 @@
+package transport_test
+
+import (
+	"bytes"
+	"testing"
+
+	transport "github.com/vedantkulkarni/reflect-poc/transport"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestFrameCodec(t *testing.T) {
+	codec := transport.FrameCodec{}
+	if codec.Name() != "proto" {
+		t.Errorf("Name() = %q, want proto", codec.Name())
+	}
+
+	frame := []byte{0x0a, 0x02, 'h', 'i'}
+	data, err := codec.Marshal(&frame)
+	if err != nil {
+		t.Fatalf("failed to marshal frame: %v", err)
+	}
+	if !bytes.Equal(data, frame) {
+		t.Errorf("Marshal(frame) = %x, want %x", data, frame)
+	}
+
+	var copied []byte
+	if err := codec.Unmarshal(data, &copied); err != nil {
+		t.Fatalf("failed to unmarshal frame: %v", err)
+	}
+	want := bytes.Clone(frame)
+	data[0] = 0
+	if !bytes.Equal(copied, want) {
+		t.Errorf("Unmarshal kept a reference to its input: got %x, want %x", copied, want)
+	}
+
+	message := wrapperspb.String("hi")
+	data, err = codec.Marshal(message)
+	if err != nil {
+		t.Fatalf("failed to marshal message: %v", err)
+	}
+	decoded := &wrapperspb.StringValue{}
+	if err := codec.Unmarshal(data, decoded); err != nil {
+		t.Fatalf("failed to unmarshal message: %v", err)
+	}
+	if !proto.Equal(decoded, message) {
+		t.Errorf("round trip = %v, want %v", decoded, message)
+	}
+
+	if _, err := codec.Marshal("not a frame"); err == nil {
+		t.Error("marshaled a string")
+	}
+	var s string
+	if err := codec.Unmarshal(data, &s); err == nil {
+		t.Error("unmarshaled into a string")
+	}
+}
@@ -0,0 +1,120 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// maxPayloadSize is the largest MQTT payload written, and accepted, on a
+// bridge connection. gRPC flushes at most 32KiB per write and TLS records are
+// smaller than that; larger writes are split.
+const maxPayloadSize = 64 * 1024
+
+// ErrPayloadTooLarge is returned by reads of a payload larger than a
+// buffered connection accepts, whose remainder the bridge has dropped
+var ErrPayloadTooLarge = errors.New("bridge payload too large")
+
+// bufferedConn keeps the remainder of an MQTT payload between reads. The bridge
+// copies each payload into the caller's buffer once and drops what does not fit,
+// which corrupts the stream for readers such as crypto/tls that read in small
+// pieces. Payloads are therefore read whole into a buffer one byte larger than
+// maxPayloadSize, so that a truncated payload is reported instead of lost.
+type bufferedConn struct {
+	net.Conn
+	buf     []byte
+	pending []byte
+}
+
+// BufferConn wraps a bridge connection so that short reads do not lose data
+// and writes fit in the payload size peers accept
+func BufferConn(conn net.Conn) net.Conn {
+	return &bufferedConn{
+		Conn: conn,
+		buf:  make([]byte, maxPayloadSize+1),
+	}
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	if len(c.pending) == 0 {
+		n, err := c.Conn.Read(c.buf)
+		if n > maxPayloadSize {
+			return 0, fmt.Errorf("%w: more than %d bytes", ErrPayloadTooLarge, maxPayloadSize)
+		}
+		if n == 0 {
+			return 0, err
+		}
+		c.pending = c.buf[:n]
+	}
+	n := copy(b, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// Write sends b in payloads of at most maxPayloadSize bytes
+func (c *bufferedConn) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		chunk := b[:min(len(b), maxPayloadSize)]
+		n, err := c.Conn.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		b = b[len(chunk):]
+	}
+	return written, nil
+}
+
+type bufferedListener struct {
+	net.Listener
+}
+
+// BufferListener wraps every connection accepted from l with BufferConn
+func BufferListener(l net.Listener) net.Listener {
+	return &bufferedListener{Listener: l}
+}
+
+func (l *bufferedListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return BufferConn(conn), nil
+}
+
+// ClientCredentials returns the transport credentials used between a gRPC client
+// and the device behind bridgeID. The device certificate must be issued for the
+// bridge ID unless a server name override is configured. Without TLS material
+// the gRPC stream is carried in plaintext inside the MQTT session.
+func ClientCredentials(cfg TLSConfig, bridgeID string) (credentials.TransportCredentials, error) {
+	if !cfg.Enabled() {
+		return insecure.NewCredentials(), nil
+	}
+	if cfg.ServerName == "" {
+		cfg.ServerName = bridgeID
+	}
+
+	tlsConfig, err := cfg.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	return credentials.NewTLS(tlsConfig), nil
+}
+
+// ServerCredentials returns the transport credentials used by the device. When a
+// CA file is configured, callers must present a certificate signed by it.
+func ServerCredentials(cfg TLSConfig) (credentials.TransportCredentials, error) {
+	if !cfg.Enabled() {
+		return insecure.NewCredentials(), nil
+	}
+
+	tlsConfig, err := cfg.ServerConfig()
+	if err != nil {
+		return nil, err
+	}
+	return credentials.NewTLS(tlsConfig), nil
+}
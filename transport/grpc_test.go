@@ -0,0 +1,85 @@
+package transport_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	transport "github.com/vedantkulkarni/reflect-poc/transport"
+)
+
+// messageConn delivers each Write as one payload and, like the bridge, copies
+// a payload into the reader's buffer once, dropping what does not fit
+type messageConn struct {
+	net.Conn
+	in  <-chan []byte
+	out chan<- []byte
+}
+
+func messagePipe() (*messageConn, *messageConn) {
+	a, b := make(chan []byte, 64), make(chan []byte, 64)
+	return &messageConn{in: a, out: b}, &messageConn{in: b, out: a}
+}
+
+func (c *messageConn) Read(b []byte) (int, error) {
+	payload, ok := <-c.in
+	if !ok {
+		return 0, io.EOF
+	}
+	return copy(b, payload), nil
+}
+
+func (c *messageConn) Write(b []byte) (int, error) {
+	c.out <- bytes.Clone(b)
+	return len(b), nil
+}
+
+func (c *messageConn) Close() error {
+	close(c.out)
+	return nil
+}
+
+func TestBufferConnLargeWrite(t *testing.T) {
+	a, b := messagePipe()
+	writer, reader := transport.BufferConn(a), transport.BufferConn(b)
+
+	// Larger than a payload, so the write is split and read back in pieces
+	data := bytes.Repeat([]byte("0123456789abcdef"), 150*1024/16)
+	go func() {
+		if _, err := writer.Write(data); err != nil {
+			t.Errorf("write failed: %v", err)
+		}
+		writer.Close()
+	}()
+
+	var got bytes.Buffer
+	buf := make([]byte, 1000)
+	for {
+		n, err := reader.Read(buf)
+		got.Write(buf[:n])
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("read failed: %v", err)
+		}
+	}
+	if !bytes.Equal(got.Bytes(), data) {
+		t.Errorf("read %d bytes, want the %d written", got.Len(), len(data))
+	}
+}
+
+func TestBufferConnOversizePayload(t *testing.T) {
+	a, b := messagePipe()
+	reader := transport.BufferConn(b)
+
+	// A peer that does not split its writes sends more than a payload holds
+	if _, err := a.Write(make([]byte, 100*1024)); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	if _, err := io.ReadAll(reader); !errors.Is(err, transport.ErrPayloadTooLarge) {
+		t.Errorf("read returned %v, want ErrPayloadTooLarge", err)
+	}
+}
@@ -0,0 +1,389 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"go.uber.org/zap"
+)
+
+// ErrConnectionLost is returned by bridge connections that were open when the
+// broker connection dropped. gRPC reports it as Unavailable on in-flight calls;
+// new calls redial through the bridge once the broker is reachable again.
+var ErrConnectionLost = errors.New("mqtt broker connection lost")
+
+// ReconnectConfig controls how the broker connection is restored after it drops
+type ReconnectConfig struct {
+	// Disabled turns automatic reconnection off
+	Disabled bool
+	// MaxInterval caps the exponential backoff between reconnection attempts
+	MaxInterval time.Duration
+	// RetryInitialConnect keeps retrying the first connection instead of failing
+	RetryInitialConnect bool
+}
+
+// ConnectionState is the state of the broker connection
+type ConnectionState int
+
+const (
+	// StateConnected is reported when the connection is first made and after every reconnect
+	StateConnected ConnectionState = iota
+	// StateConnectionLost is reported when the connection drops
+	StateConnectionLost
+	// StateReconnecting is reported before every reconnection attempt
+	StateReconnecting
+)
+
+func (s ConnectionState) String() string {
+	switch s {
+	case StateConnected:
+		return "connected"
+	case StateConnectionLost:
+		return "connection_lost"
+	case StateReconnecting:
+		return "reconnecting"
+	}
+	return "unknown"
+}
+
+// ConnectionEvent describes a change of the broker connection state
+type ConnectionEvent struct {
+	State ConnectionState
+	// Err is the reason the connection was lost
+	Err error
+	// Reconnect is true for StateConnected events that follow a lost connection
+	Reconnect bool
+}
+
+// Observer receives the MQTT traffic of a Client, for example to export metrics
+type Observer interface {
+	// Published is called for every message published, with its payload size
+	Published(topic string, bytes int)
+	// Received is called for every message delivered to a subscription
+	Received(topic string, bytes int)
+	// Subscribed is called for every subscription, including replayed ones
+	Subscribed(topic string)
+	// Reconnected is called when the connection is restored after it dropped
+	Reconnected()
+}
+
+type subscription struct {
+	qos     byte
+	handler mqtt.MessageHandler
+}
+
+// Client is an mqtt.Client that restores its subscriptions after a reconnect
+// and fails the bridge connections that were open when the connection dropped.
+// Subscriptions made through it, including those made by the bridge, are
+// replayed on every reconnect because a clean session loses them at the broker.
+type Client struct {
+	mqtt.Client
+
+	onEvent  func(ConnectionEvent)
+	logger   *zap.Logger
+	observer Observer
+
+	mu            sync.Mutex
+	subscriptions map[string]subscription
+	gates         map[string]*deliveryGate
+	dials         map[string]*sessionDial
+	conns         map[*trackedConn]struct{}
+	onReconnect   []func()
+	wasConnected  bool
+}
+
+func newClient(onEvent func(ConnectionEvent), logger *zap.Logger, observer Observer) *Client {
+	return &Client{
+		onEvent:       onEvent,
+		logger:        logger,
+		observer:      observer,
+		subscriptions: make(map[string]subscription),
+		gates:         make(map[string]*deliveryGate),
+		dials:         make(map[string]*sessionDial),
+		conns:         make(map[*trackedConn]struct{}),
+	}
+}
+
+// setHandlers installs the reconnection handlers on the paho options
+func (c *Client) setHandlers(opts *mqtt.ClientOptions, cfg ReconnectConfig, will *Will) {
+	opts.SetAutoReconnect(!cfg.Disabled)
+	if cfg.MaxInterval > 0 {
+		opts.SetMaxReconnectInterval(cfg.MaxInterval)
+	}
+	if cfg.RetryInitialConnect {
+		opts.SetConnectRetry(true)
+		opts.SetConnectRetryInterval(time.Second)
+	}
+
+	opts.SetOnConnectHandler(c.handleConnect)
+	opts.SetConnectionLostHandler(c.handleConnectionLost)
+	opts.SetReconnectingHandler(func(_ mqtt.Client, opts *mqtt.ClientOptions) {
+		// The options are those of the client, read for the next CONNECT
+		if will != nil && will.Refresh != nil {
+			opts.WillPayload = will.Refresh()
+		}
+		c.emit(ConnectionEvent{State: StateReconnecting})
+	})
+}
+
+func (c *Client) emit(event ConnectionEvent) {
+	switch event.State {
+	case StateConnected:
+		c.logger.Info("Connected to MQTT broker", zap.Bool("reconnect", event.Reconnect))
+	case StateConnectionLost:
+		c.logger.Warn("MQTT broker connection lost", zap.Error(event.Err))
+	case StateReconnecting:
+		c.logger.Info("Reconnecting to MQTT broker")
+	}
+	if c.onEvent != nil {
+		c.onEvent(event)
+	}
+}
+
+func (c *Client) handleConnect(raw mqtt.Client) {
+	c.mu.Lock()
+	reconnect := c.wasConnected
+	c.wasConnected = true
+	subscriptions := make(map[string]subscription, len(c.subscriptions))
+	for topic, sub := range c.subscriptions {
+		subscriptions[topic] = sub
+	}
+	onReconnect := append([]func(){}, c.onReconnect...)
+	c.mu.Unlock()
+
+	if reconnect {
+		for topic, sub := range subscriptions {
+			token := raw.Subscribe(topic, sub.qos, sub.handler)
+			if token.Wait() && token.Error() != nil {
+				c.emit(ConnectionEvent{State: StateConnectionLost, Err: token.Error()})
+				return
+			}
+			c.subscribed(topic)
+		}
+		if c.observer != nil {
+			c.observer.Reconnected()
+		}
+		for _, fn := range onReconnect {
+			fn()
+		}
+	}
+	c.emit(ConnectionEvent{State: StateConnected, Reconnect: reconnect})
+}
+
+func (c *Client) handleConnectionLost(_ mqtt.Client, err error) {
+	c.mu.Lock()
+	conns := make([]*trackedConn, 0, len(c.conns))
+	for conn := range c.conns {
+		conns = append(conns, conn)
+	}
+	c.mu.Unlock()
+
+	for _, conn := range conns {
+		conn.fail()
+	}
+	c.emit(ConnectionEvent{State: StateConnectionLost, Err: err})
+}
+
+// OnReconnect registers fn to be called after every reconnect, once the
+// subscriptions are restored, for example to publish retained state again
+// after the broker delivered the Last Will
+func (c *Client) OnReconnect(fn func()) {
+	c.mu.Lock()
+	c.onReconnect = append(c.onReconnect, fn)
+	c.mu.Unlock()
+}
+
+// Publish publishes through the broker and reports the message to the observer
+func (c *Client) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	if c.observer != nil {
+		c.observer.Published(topic, payloadSize(payload))
+	}
+	return c.Client.Publish(topic, qos, retained, payload)
+}
+
+// Subscribe subscribes through the broker and remembers the subscription for
+// reconnects. Deliveries to bridge sessions stop when their connection closes.
+func (c *Client) Subscribe(topic string, qos byte, callback mqtt.MessageHandler) mqtt.Token {
+	callback = c.gated(topic, c.observe(callback))
+	c.mu.Lock()
+	c.subscriptions[topic] = subscription{qos: qos, handler: callback}
+	c.mu.Unlock()
+	c.subscribed(topic)
+	return c.Client.Subscribe(topic, qos, callback)
+}
+
+// SubscribeMultiple subscribes through the broker and remembers the subscriptions for reconnects
+func (c *Client) SubscribeMultiple(filters map[string]byte, callback mqtt.MessageHandler) mqtt.Token {
+	callback = c.observe(callback)
+	c.mu.Lock()
+	for topic, qos := range filters {
+		c.subscriptions[topic] = subscription{qos: qos, handler: callback}
+	}
+	c.mu.Unlock()
+	for topic := range filters {
+		c.subscribed(topic)
+	}
+	return c.Client.SubscribeMultiple(filters, callback)
+}
+
+// observe wraps callback so that deliveries are reported to the observer
+func (c *Client) observe(callback mqtt.MessageHandler) mqtt.MessageHandler {
+	if c.observer == nil || callback == nil {
+		return callback
+	}
+	return func(client mqtt.Client, msg mqtt.Message) {
+		c.observer.Received(msg.Topic(), len(msg.Payload()))
+		callback(client, msg)
+	}
+}
+
+func (c *Client) subscribed(topic string) {
+	if c.observer != nil {
+		c.observer.Subscribed(topic)
+	}
+}
+
+// payloadSize returns the size of a payload accepted by Publish
+func payloadSize(payload interface{}) int {
+	switch p := payload.(type) {
+	case []byte:
+		return len(p)
+	case string:
+		return len(p)
+	case bytes.Buffer:
+		return p.Len()
+	case *bytes.Buffer:
+		return p.Len()
+	}
+	return 0
+}
+
+// Unsubscribe unsubscribes through the broker and forgets the subscriptions
+func (c *Client) Unsubscribe(topics ...string) mqtt.Token {
+	c.mu.Lock()
+	for _, topic := range topics {
+		delete(c.subscriptions, topic)
+		delete(c.gates, topic)
+	}
+	c.mu.Unlock()
+	return c.Client.Unsubscribe(topics...)
+}
+
+// TrackConn returns conn wrapped so that it fails with ErrConnectionLost when
+// the broker connection drops
+func (c *Client) TrackConn(conn net.Conn) net.Conn {
+	return c.trackConn(conn, "")
+}
+
+// trackConn tracks conn, which receives on the session topic, if any, whose
+// deliveries are stopped before it closes
+func (c *Client) trackConn(conn net.Conn, topic string) *trackedConn {
+	tracked := &trackedConn{Conn: conn, client: c, topic: topic}
+
+	if !c.IsConnectionOpen() {
+		tracked.lost = true
+		tracked.Close()
+		return tracked
+	}
+
+	c.mu.Lock()
+	c.conns[tracked] = struct{}{}
+	c.mu.Unlock()
+	return tracked
+}
+
+// TrackDialer wraps dial, which opens bridge connections to a bridge ID, so
+// that every connection it returns is tracked like those of TrackConn
+func (c *Client) TrackDialer(dial func(ctx context.Context, bridgeID string) (net.Conn, error)) func(context.Context, string) (net.Conn, error) {
+	return func(ctx context.Context, bridgeID string) (net.Conn, error) {
+		session, err := c.beginDial(ctx, bridgeID)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := dial(ctx, bridgeID)
+		topic := c.endDial(bridgeID, session)
+		if err != nil {
+			return nil, err
+		}
+		return c.trackConn(conn, topic), nil
+	}
+}
+
+// TrackListener wraps every connection accepted from l with TrackConn
+func (c *Client) TrackListener(l net.Listener) net.Listener {
+	return &trackedListener{Listener: l, client: c}
+}
+
+func (c *Client) untrack(conn *trackedConn) {
+	c.mu.Lock()
+	delete(c.conns, conn)
+	c.mu.Unlock()
+}
+
+type trackedListener struct {
+	net.Listener
+	client *Client
+}
+
+func (l *trackedListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return l.client.trackConn(conn, sessionUpTopic(conn)), nil
+}
+
+// trackedConn is a bridge connection owned by a Client
+type trackedConn struct {
+	net.Conn
+	client *Client
+	// topic is the session topic the connection receives on, if known,
+	// whose deliveries stop before the bridge closes it
+	topic string
+
+	mu   sync.Mutex
+	lost bool
+}
+
+// fail closes the connection and marks it as lost with the broker connection
+func (c *trackedConn) fail() {
+	c.mu.Lock()
+	c.lost = true
+	c.mu.Unlock()
+	c.Close()
+}
+
+func (c *trackedConn) isLost() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lost
+}
+
+func (c *trackedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if err != nil && c.isLost() {
+		return n, ErrConnectionLost
+	}
+	return n, err
+}
+
+func (c *trackedConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if err != nil && c.isLost() {
+		return n, ErrConnectionLost
+	}
+	return n, err
+}
+
+func (c *trackedConn) Close() error {
+	c.client.untrack(c)
+	if c.topic != "" {
+		c.client.closeGate(c.topic)
+	}
+	return c.Conn.Close()
+}
@@ -0,0 +1,31 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// ReadRetained returns the retained message on topic. It waits until the
+// broker delivers it or ctx is done, which is how a missing message shows.
+func ReadRetained(ctx context.Context, mqttClient mqtt.Client, topic string) ([]byte, error) {
+	payloads := make(chan []byte, 1)
+	token := mqttClient.Subscribe(topic, 1, func(_ mqtt.Client, msg mqtt.Message) {
+		select {
+		case payloads <- msg.Payload():
+		default:
+		}
+	})
+	if token.Wait() && token.Error() != nil {
+		return nil, fmt.Errorf("failed to subscribe to %s: %w", topic, token.Error())
+	}
+	defer mqttClient.Unsubscribe(topic)
+
+	select {
+	case payload := <-payloads:
+		return payload, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("no retained message on %s: %w", topic, ctx.Err())
+	}
+}
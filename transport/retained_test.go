@@ -0,0 +1,46 @@
+package transport_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	embedded "github.com/vedantkulkarni/reflect-poc/embedded"
+	transport "github.com/vedantkulkarni/reflect-poc/transport"
+)
+
+func TestReadRetained(t *testing.T) {
+	b, err := embedded.Start(embedded.Config{})
+	if err != nil {
+		t.Fatalf("failed to start broker: %v", err)
+	}
+	defer b.Close()
+	mqttClient, err := transport.Connect(transport.BrokerConfig{URL: embedded.InProcessURL, ClientID: "reader", Dial: b.Dial})
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer mqttClient.Disconnect(0)
+
+	token := mqttClient.Publish("/test/retained", 1, true, []byte("state"))
+	if token.Wait() && token.Error() != nil {
+		t.Fatalf("failed to publish: %v", token.Error())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	payload, err := transport.ReadRetained(ctx, mqttClient, "/test/retained")
+	if err != nil {
+		t.Fatalf("ReadRetained failed: %v", err)
+	}
+	if string(payload) != "state" {
+		t.Errorf("ReadRetained returned %q, want %q", payload, "state")
+	}
+
+	// A topic without a retained message times out
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := transport.ReadRetained(ctx, mqttClient, "/test/missing"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("ReadRetained of a missing message returned %v, want a deadline error", err)
+	}
+}
@@ -0,0 +1,132 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// The bridge carries every session on two topics, named in its handshake:
+// /bridge/session/{server bridge ID}/{session ID}/up from the client to the
+// server and .../down back to the client
+const (
+	sessionTopicPrefix = "/bridge/session/"
+	sessionUp          = "up"
+	sessionDown        = "down"
+)
+
+// sessionTopic splits a bridge session topic into the server bridge ID and
+// the direction, up or down
+func sessionTopic(topic string) (server, direction string, ok bool) {
+	if !strings.HasPrefix(topic, sessionTopicPrefix) {
+		return "", "", false
+	}
+	parts := strings.Split(strings.TrimPrefix(topic, sessionTopicPrefix), "/")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+	if parts[2] != sessionUp && parts[2] != sessionDown {
+		return "", "", false
+	}
+	return parts[0], parts[2], true
+}
+
+// sessionUpTopic returns the topic an accepted connection receives on; the
+// bridge names accepted connections after the server and the session
+func sessionUpTopic(conn net.Conn) string {
+	return fmt.Sprintf("%s%s/%s/%s", sessionTopicPrefix, conn.LocalAddr(), conn.RemoteAddr(), sessionUp)
+}
+
+// deliveryGate serializes the deliveries to a session topic with the close of
+// its connection. The bridge reads the closed flag of a connection and sends
+// on its read channel from the delivery callbacks without a lock, and sets the
+// flag and closes the channel in Close; once the gate is closed the callback
+// is no longer called.
+type deliveryGate struct {
+	mu     sync.Mutex
+	closed bool
+}
+
+// sessionDial collects the down topic subscribed while a connection is dialed
+type sessionDial struct {
+	done  chan struct{}
+	topic string
+}
+
+// gated wraps callback so that deliveries to a session topic stop once its
+// connection closes; other topics are delivered as they are. The down topic
+// of a session is recorded for the dial in progress to its server.
+func (c *Client) gated(topic string, callback mqtt.MessageHandler) mqtt.MessageHandler {
+	server, direction, ok := sessionTopic(topic)
+	if !ok || callback == nil {
+		return callback
+	}
+	c.mu.Lock()
+	gate, ok := c.gates[topic]
+	if !ok {
+		gate = &deliveryGate{}
+		c.gates[topic] = gate
+	}
+	if dial, ok := c.dials[server]; ok && direction == sessionDown {
+		dial.topic = topic
+	}
+	c.mu.Unlock()
+
+	return func(client mqtt.Client, msg mqtt.Message) {
+		gate.mu.Lock()
+		defer gate.mu.Unlock()
+		if !gate.closed {
+			callback(client, msg)
+		}
+	}
+}
+
+// closeGate waits for the delivery in progress to topic, if any, and stops
+// later ones. The bridge callbacks never block, so neither does this.
+func (c *Client) closeGate(topic string) {
+	c.mu.Lock()
+	gate, ok := c.gates[topic]
+	c.mu.Unlock()
+	if !ok {
+		return
+	}
+	gate.mu.Lock()
+	gate.closed = true
+	gate.mu.Unlock()
+}
+
+// beginDial registers a dial to server. Dials to the same server wait for
+// each other, so that the down topic subscribed belongs to this one.
+func (c *Client) beginDial(ctx context.Context, server string) (*sessionDial, error) {
+	for {
+		c.mu.Lock()
+		pending, ok := c.dials[server]
+		if !ok {
+			dial := &sessionDial{done: make(chan struct{})}
+			c.dials[server] = dial
+			c.mu.Unlock()
+			return dial, nil
+		}
+		c.mu.Unlock()
+
+		select {
+		case <-pending.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// endDial unregisters a dial and returns the down topic it subscribed
+func (c *Client) endDial(server string, dial *sessionDial) string {
+	c.mu.Lock()
+	delete(c.dials, server)
+	topic := dial.topic
+	c.mu.Unlock()
+	close(dial.done)
+	return topic
+}
@@ -0,0 +1,122 @@
+package transport
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	bridge "github.com/golain-io/mqtt-bridge"
+	embedded "github.com/vedantkulkarni/reflect-poc/embedded"
+	"go.uber.org/zap"
+)
+
+func TestSessionTopic(t *testing.T) {
+	for _, tc := range []struct {
+		topic     string
+		server    string
+		direction string
+		ok        bool
+	}{
+		{"/bridge/session/pump-1/4f1c/up", "pump-1", "up", true},
+		{"/bridge/session/pump-1/4f1c/down", "pump-1", "down", true},
+		{"/bridge/session/pump-1/4f1c/sideways", "", "", false},
+		{"/bridge/session/pump-1/up", "", "", false},
+		{"/bridge/session//4f1c/up", "", "", false},
+		{"/bridge/handshake/pump-1/request/4f1c", "", "", false},
+	} {
+		server, direction, ok := sessionTopic(tc.topic)
+		if server != tc.server || direction != tc.direction || ok != tc.ok {
+			t.Errorf("sessionTopic(%q) = %q, %q, %v", tc.topic, server, direction, ok)
+		}
+	}
+}
+
+// TestSessionGates checks that both ends of a bridge session know the topic
+// they receive on, so that closing them stops the deliveries first
+func TestSessionGates(t *testing.T) {
+	b, err := embedded.Start(embedded.Config{})
+	if err != nil {
+		t.Fatalf("failed to start broker: %v", err)
+	}
+	defer b.Close()
+	connect := func(clientID string) *Client {
+		c, err := Connect(BrokerConfig{URL: embedded.InProcessURL, ClientID: clientID, Dial: b.Dial})
+		if err != nil {
+			t.Fatalf("failed to connect %s: %v", clientID, err)
+		}
+		t.Cleanup(func() { c.Disconnect(0) })
+		return c
+	}
+	serverClient, dialClient := connect("server"), connect("dialer")
+
+	listener := serverClient.TrackListener(bridge.NewMQTTNetBridge(serverClient, zap.NewNop(), "pump-1"))
+	defer listener.Close()
+	dial := dialClient.TrackDialer(bridge.NewMQTTNetBridge(dialClient, zap.NewNop(), "client-1").Dial)
+
+	accepted := make(chan *trackedConn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			close(accepted)
+			return
+		}
+		accepted <- conn.(*trackedConn)
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := dial(ctx, "pump-1")
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	dialed := conn.(*trackedConn)
+	server, ok := <-accepted
+	if !ok {
+		t.Fatal("failed to accept")
+	}
+
+	type end struct {
+		name      string
+		conn      *trackedConn
+		client    *Client
+		direction string
+		gate      *deliveryGate
+	}
+	ends := []*end{
+		{name: "accepted", conn: server, client: serverClient, direction: sessionUp},
+		{name: "dialed", conn: dialed, client: dialClient, direction: sessionDown},
+	}
+	for _, e := range ends {
+		if _, direction, ok := sessionTopic(e.conn.topic); !ok || direction != e.direction {
+			t.Fatalf("%s connection receives on %q, want a %s topic", e.name, e.conn.topic, e.direction)
+		}
+		e.client.mu.Lock()
+		e.gate = e.client.gates[e.conn.topic]
+		e.client.mu.Unlock()
+		if e.gate == nil {
+			t.Fatalf("%s connection has no gate on %q", e.name, e.conn.topic)
+		}
+	}
+
+	// The session still carries data both ways through the gates
+	for i, e := range ends {
+		peer := ends[1-i].conn
+		if _, err := peer.Write([]byte("ping")); err != nil {
+			t.Fatalf("failed to write to %s connection: %v", e.name, err)
+		}
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(e.conn, buf); err != nil || string(buf) != "ping" {
+			t.Fatalf("%s connection read %q, %v", e.name, buf, err)
+		}
+	}
+
+	for _, e := range ends {
+		e.conn.Close()
+		e.gate.mu.Lock()
+		closed := e.gate.closed
+		e.gate.mu.Unlock()
+		if !closed {
+			t.Errorf("closing the %s connection left its deliveries open", e.name)
+		}
+	}
+}
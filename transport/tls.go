@@ -0,0 +1,263 @@
+package transport
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// TLSConfig holds the certificate material used to secure a connection.
+// Files are re-read whenever their modification time changes, so rotated
+// certificates are picked up on the next handshake without a restart.
+type TLSConfig struct {
+	CAFile             string
+	CertFile           string
+	KeyFile            string
+	ServerName         string
+	InsecureSkipVerify bool
+}
+
+// Enabled reports whether any TLS material has been configured
+func (c TLSConfig) Enabled() bool {
+	return c.CAFile != "" || c.CertFile != "" || c.KeyFile != "" || c.ServerName != "" || c.InsecureSkipVerify
+}
+
+// ClientConfig builds a *tls.Config for the dialing side of a connection
+func (c TLSConfig) ClientConfig() (*tls.Config, error) {
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return nil, errors.New("both cert file and key file must be set for client certificates")
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+
+	if c.CertFile != "" {
+		keyPair, err := newKeyPairReloader(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return keyPair.Get()
+		}
+	}
+
+	if c.CAFile != "" && !c.InsecureSkipVerify {
+		roots, err := newCertPoolReloader(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		// Verification is done by hand so that a rotated CA bundle is used for
+		// the next handshake; RootCAs is fixed once the config is in use.
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+			pool, err := roots.Get()
+			if err != nil {
+				return err
+			}
+			name := serverNameOf(cs, c.ServerName)
+			if name == "" {
+				return errors.New("no server name to verify the peer certificate against")
+			}
+			return verifyPeer(cs, pool, name, x509.ExtKeyUsageServerAuth)
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// ServerConfig builds a *tls.Config for the accepting side of a connection.
+// When a CA file is set, clients must present a certificate signed by it.
+func (c TLSConfig) ServerConfig() (*tls.Config, error) {
+	if c.CertFile == "" || c.KeyFile == "" {
+		return nil, errors.New("cert file and key file are required for a TLS server")
+	}
+
+	keyPair, err := newKeyPairReloader(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return keyPair.Get()
+		},
+	}
+
+	if c.CAFile != "" {
+		roots, err := newCertPoolReloader(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.ClientAuth = tls.RequireAnyClientCert
+		tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+			pool, err := roots.Get()
+			if err != nil {
+				return err
+			}
+			return verifyPeer(cs, pool, "", x509.ExtKeyUsageClientAuth)
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+func serverNameOf(cs tls.ConnectionState, override string) string {
+	if override != "" {
+		return override
+	}
+	return cs.ServerName
+}
+
+// verifyPeer checks the peer chain against pool, and the leaf against name when it is set
+func verifyPeer(cs tls.ConnectionState, pool *x509.CertPool, name string, usage x509.ExtKeyUsage) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("peer did not present a certificate")
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range cs.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	_, err := cs.PeerCertificates[0].Verify(x509.VerifyOptions{
+		Roots:         pool,
+		Intermediates: intermediates,
+		DNSName:       name,
+		KeyUsages:     []x509.ExtKeyUsage{usage},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to verify peer certificate: %w", err)
+	}
+	return nil
+}
+
+// fileWatch remembers the modification times of a set of files
+type fileWatch struct {
+	paths   []string
+	modTime []time.Time
+}
+
+// changed stats the files and reports whether any of them changed since the last call
+func (w *fileWatch) changed() (bool, error) {
+	if w.modTime == nil {
+		w.modTime = make([]time.Time, len(w.paths))
+	}
+
+	changed := false
+	for i, path := range w.paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return false, fmt.Errorf("failed to stat %s: %w", path, err)
+		}
+		if !info.ModTime().Equal(w.modTime[i]) {
+			w.modTime[i] = info.ModTime()
+			changed = true
+		}
+	}
+	return changed, nil
+}
+
+// keyPairReloader serves a certificate and key pair, reloading it when the files change
+type keyPairReloader struct {
+	certFile, keyFile string
+
+	mu    sync.Mutex
+	watch fileWatch
+	cert  *tls.Certificate
+}
+
+func newKeyPairReloader(certFile, keyFile string) (*keyPairReloader, error) {
+	r := &keyPairReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		watch:    fileWatch{paths: []string{certFile, keyFile}},
+	}
+	if _, err := r.Get(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Get returns the current key pair. A failed reload keeps serving the previous pair.
+func (r *keyPairReloader) Get() (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	changed, err := r.watch.changed()
+	if err != nil || !changed {
+		if r.cert != nil {
+			return r.cert, nil
+		}
+		return nil, err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		if r.cert != nil {
+			return r.cert, nil
+		}
+		return nil, fmt.Errorf("failed to load key pair: %w", err)
+	}
+	r.cert = &cert
+	return r.cert, nil
+}
+
+// certPoolReloader serves a CA bundle, reloading it when the file changes
+type certPoolReloader struct {
+	caFile string
+
+	mu    sync.Mutex
+	watch fileWatch
+	pool  *x509.CertPool
+}
+
+func newCertPoolReloader(caFile string) (*certPoolReloader, error) {
+	r := &certPoolReloader{
+		caFile: caFile,
+		watch:  fileWatch{paths: []string{caFile}},
+	}
+	if _, err := r.Get(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Get returns the current pool. A failed reload keeps serving the previous pool.
+func (r *certPoolReloader) Get() (*x509.CertPool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	changed, err := r.watch.changed()
+	if err != nil || !changed {
+		if r.pool != nil {
+			return r.pool, nil
+		}
+		return nil, err
+	}
+
+	pem, err := os.ReadFile(r.caFile)
+	if err != nil {
+		if r.pool != nil {
+			return r.pool, nil
+		}
+		return nil, fmt.Errorf("failed to read CA file: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		if r.pool != nil {
+			return r.pool, nil
+		}
+		return nil, fmt.Errorf("no certificates found in %s", r.caFile)
+	}
+	r.pool = pool
+	return r.pool, nil
+}
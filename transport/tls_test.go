@@ -0,0 +1,111 @@
+package transport_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	transport "github.com/vedantkulkarni/reflect-poc/transport"
+)
+
+// issue returns a certificate for the names and IPs signed by ca, or a
+// self-signed CA certificate when ca is nil
+func issue(t *testing.T, ca *tls.Certificate, names []string, ips []net.IP) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     names,
+		IPAddresses:  ips,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	parent, signer := template, any(key)
+	if ca == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		parent, signer = ca.Leaf, ca.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// TestBrokerCertificateCheckedAgainstIP checks that a broker addressed by IP,
+// for which no SNI is sent, must present a certificate for that IP
+func TestBrokerCertificateCheckedAgainstIP(t *testing.T) {
+	ca := issue(t, nil, nil, nil)
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Certificate[0]}), 0o600); err != nil {
+		t.Fatalf("failed to write CA file: %v", err)
+	}
+
+	for name, tc := range map[string]struct {
+		cert tls.Certificate
+		ok   bool
+	}{
+		"certificate for the IP":     {issue(t, &ca, nil, []net.IP{net.IPv4(127, 0, 0, 1)}), true},
+		"certificate for other name": {issue(t, &ca, []string{"broker.example"}, nil), false},
+	} {
+		t.Run(name, func(t *testing.T) {
+			listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{tc.cert}})
+			if err != nil {
+				t.Fatalf("failed to listen: %v", err)
+			}
+			defer listener.Close()
+			go func() {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				conn.(*tls.Conn).Handshake()
+				conn.Close()
+			}()
+
+			opts, err := transport.NewClientOptions(transport.BrokerConfig{
+				URL: "ssl://" + listener.Addr().String(),
+				TLS: transport.TLSConfig{CAFile: caFile},
+			})
+			if err != nil {
+				t.Fatalf("failed to build client options: %v", err)
+			}
+			raw, err := net.Dial("tcp", listener.Addr().String())
+			if err != nil {
+				t.Fatalf("failed to dial: %v", err)
+			}
+			conn := tls.Client(raw, opts.TLSConfig)
+			defer conn.Close()
+			err = conn.Handshake()
+			if tc.ok && err != nil {
+				t.Errorf("handshake failed: %v", err)
+			}
+			if !tc.ok && err == nil {
+				t.Error("accepted a certificate that does not name the broker IP")
+			}
+		})
+	}
+}
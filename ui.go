@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"flag"
+
+	client "github.com/vedantkulkarni/reflect-poc/client"
+	gateway "github.com/vedantkulkarni/reflect-poc/gateway"
+	webui "github.com/vedantkulkarni/reflect-poc/webui"
+	"go.uber.org/zap"
+)
+
+// runUI serves the local web UI until SIGINT or SIGTERM
+func runUI(args []string) error {
+	fs := flag.NewFlagSet("ui", flag.ExitOnError)
+	var opts clientOptions
+	opts.register(fs)
+	listen := fs.String("listen", "127.0.0.1:8082", "address the web UI listens on")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if err := opts.prepare("reflect-ui"); err != nil {
+		return err
+	}
+	defer opts.close()
+	logger := opts.config.Logger
+
+	pool, err := client.NewPool(opts.config)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+
+	ui := &webui.UI{
+		Pool:          pool,
+		Gateway:       &gateway.Gateway{Pool: pool, Timeout: opts.timeout, Logger: logger},
+		BridgeID:      opts.bridgeID,
+		SchemaTimeout: opts.connectTimeout,
+		Addr:          *listen,
+		Logger:        logger,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		if err := ui.WatchDevices(ctx); err != nil {
+			logger.Warn("Failed to watch devices", zap.Error(err))
+		}
+	}()
+
+	return serveHTTP(*listen, ui.Handler(), "web UI", logger)
+}
@@ -0,0 +1,179 @@
+package webui
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// schema describes the services of a device and every message and enum their
+// requests refer to, for the browser to render request forms
+type schema struct {
+	Services []serviceInfo          `json:"services"`
+	Messages map[string]messageInfo `json:"messages"`
+	Enums    map[string]enumInfo    `json:"enums"`
+}
+
+type serviceInfo struct {
+	Name    string       `json:"name"`
+	Methods []methodInfo `json:"methods"`
+}
+
+type methodInfo struct {
+	Name            string `json:"name"`
+	FullName        string `json:"fullName"`
+	Input           string `json:"input"`
+	Output          string `json:"output"`
+	ClientStreaming bool   `json:"clientStreaming"`
+	ServerStreaming bool   `json:"serverStreaming"`
+}
+
+type messageInfo struct {
+	Name   string      `json:"name"`
+	Fields []fieldInfo `json:"fields"`
+	// Oneofs are the names of the oneofs of the message, in declaration order
+	Oneofs []string `json:"oneofs,omitempty"`
+	// WellKnown messages have a special JSON form and are edited as JSON
+	WellKnown bool `json:"wellKnown,omitempty"`
+}
+
+type fieldInfo struct {
+	Name     string `json:"name"`
+	JSONName string `json:"jsonName"`
+	Number   int32  `json:"number"`
+	// Kind is the protobuf type, such as "string", "int64", "enum" or "message"
+	Kind     string `json:"kind"`
+	Repeated bool   `json:"repeated,omitempty"`
+	// Message and Enum name the type of message and enum fields
+	Message string `json:"message,omitempty"`
+	Enum    string `json:"enum,omitempty"`
+	// Oneof is the oneof the field belongs to, if any
+	Oneof string `json:"oneof,omitempty"`
+	// MapKey and MapValue describe the entries of map fields
+	MapKey   *fieldInfo `json:"mapKey,omitempty"`
+	MapValue *fieldInfo `json:"mapValue,omitempty"`
+}
+
+type enumInfo struct {
+	Name   string   `json:"name"`
+	Values []string `json:"values"`
+}
+
+// symbolFinder resolves services by name, like the reflection client
+type symbolFinder interface {
+	ListServices(ctx context.Context) ([]string, error)
+	FindSymbol(ctx context.Context, name string) (protoreflect.Descriptor, error)
+}
+
+// loadSchema describes every service of a device except the reflection ones
+func loadSchema(ctx context.Context, source symbolFinder) (*schema, error) {
+	names, err := source.ListServices(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s := &schema{
+		Services: []serviceInfo{},
+		Messages: make(map[string]messageInfo),
+		Enums:    make(map[string]enumInfo),
+	}
+	for _, name := range names {
+		if strings.HasPrefix(name, "grpc.reflection.") {
+			continue
+		}
+		desc, err := source.FindSymbol(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		service, ok := desc.(protoreflect.ServiceDescriptor)
+		if !ok {
+			return nil, fmt.Errorf("%s is not a service", name)
+		}
+
+		info := serviceInfo{Name: name, Methods: []methodInfo{}}
+		methods := service.Methods()
+		for i := 0; i < methods.Len(); i++ {
+			method := methods.Get(i)
+			info.Methods = append(info.Methods, methodInfo{
+				Name:            string(method.Name()),
+				FullName:        string(method.FullName()),
+				Input:           string(method.Input().FullName()),
+				Output:          string(method.Output().FullName()),
+				ClientStreaming: method.IsStreamingClient(),
+				ServerStreaming: method.IsStreamingServer(),
+			})
+			s.addMessage(method.Input())
+		}
+		s.Services = append(s.Services, info)
+	}
+	return s, nil
+}
+
+// addMessage describes desc and the messages and enums its fields refer to
+func (s *schema) addMessage(desc protoreflect.MessageDescriptor) {
+	name := string(desc.FullName())
+	if _, ok := s.Messages[name]; ok {
+		return
+	}
+	info := messageInfo{Name: name, Fields: []fieldInfo{}}
+	if desc.ParentFile().Package() == "google.protobuf" {
+		info.WellKnown = true
+		s.Messages[name] = info
+		return
+	}
+	// Registered before the fields so that recursive messages terminate
+	s.Messages[name] = info
+
+	oneofs := desc.Oneofs()
+	for i := 0; i < oneofs.Len(); i++ {
+		if oneof := oneofs.Get(i); !oneof.IsSynthetic() {
+			info.Oneofs = append(info.Oneofs, string(oneof.Name()))
+		}
+	}
+	fields := desc.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		info.Fields = append(info.Fields, s.field(fields.Get(i)))
+	}
+	s.Messages[name] = info
+}
+
+func (s *schema) field(fd protoreflect.FieldDescriptor) fieldInfo {
+	info := fieldInfo{
+		Name:     string(fd.Name()),
+		JSONName: fd.JSONName(),
+		Number:   int32(fd.Number()),
+		Kind:     fd.Kind().String(),
+		Repeated: fd.IsList(),
+	}
+	if oneof := fd.ContainingOneof(); oneof != nil && !oneof.IsSynthetic() {
+		info.Oneof = string(oneof.Name())
+	}
+	if fd.IsMap() {
+		key, value := s.field(fd.MapKey()), s.field(fd.MapValue())
+		info.Kind, info.MapKey, info.MapValue = "map", &key, &value
+		return info
+	}
+	switch {
+	case fd.Enum() != nil:
+		info.Enum = string(fd.Enum().FullName())
+		s.addEnum(fd.Enum())
+	case fd.Message() != nil:
+		info.Message = string(fd.Message().FullName())
+		s.addMessage(fd.Message())
+	}
+	return info
+}
+
+func (s *schema) addEnum(desc protoreflect.EnumDescriptor) {
+	name := string(desc.FullName())
+	if _, ok := s.Enums[name]; ok {
+		return
+	}
+	info := enumInfo{Name: name}
+	values := desc.Values()
+	for i := 0; i < values.Len(); i++ {
+		info.Values = append(info.Values, string(values.Get(i).Name()))
+	}
+	s.Enums[name] = info
+}
@@ -0,0 +1,197 @@
+package webui
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// fakeFinder serves the symbols of files and fails on any other name
+type fakeFinder struct {
+	services []string
+	files    *protoregistry.Files
+}
+
+func (f *fakeFinder) ListServices(context.Context) ([]string, error) {
+	return f.services, nil
+}
+
+func (f *fakeFinder) FindSymbol(_ context.Context, name string) (protoreflect.Descriptor, error) {
+	return f.files.FindDescriptorByName(protoreflect.FullName(name))
+}
+
+func field(name string, number int32, kind descriptorpb.FieldDescriptorProto_Type, label descriptorpb.FieldDescriptorProto_Label) *descriptorpb.FieldDescriptorProto {
+	return &descriptorpb.FieldDescriptorProto{
+		Name:     proto.String(name),
+		JsonName: proto.String(name),
+		Number:   proto.Int32(number),
+		Type:     kind.Enum(),
+		Label:    label.Enum(),
+	}
+}
+
+// testFiles describes a service whose request has every kind of field the UI
+// renders: a recursive message, a map, an enum, a oneof, a proto3 optional
+// and a well-known type
+func testFiles(t *testing.T) *protoregistry.Files {
+	t.Helper()
+	const (
+		optional = descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
+		repeated = descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+	)
+	children := field("children", 2, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, repeated)
+	children.TypeName = proto.String(".devices.Node")
+	labels := field("labels", 3, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, repeated)
+	labels.TypeName = proto.String(".devices.Node.LabelsEntry")
+	mode := field("mode", 4, descriptorpb.FieldDescriptorProto_TYPE_ENUM, optional)
+	mode.TypeName = proto.String(".devices.Mode")
+	host := field("host", 5, descriptorpb.FieldDescriptorProto_TYPE_STRING, optional)
+	host.OneofIndex = proto.Int32(0)
+	port := field("port", 6, descriptorpb.FieldDescriptorProto_TYPE_INT32, optional)
+	port.OneofIndex = proto.Int32(0)
+	at := field("at", 7, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, optional)
+	at.TypeName = proto.String(".google.protobuf.Timestamp")
+	note := field("note", 8, descriptorpb.FieldDescriptorProto_TYPE_STRING, optional)
+	note.OneofIndex = proto.Int32(1)
+	note.Proto3Optional = proto.Bool(true)
+
+	file := &descriptorpb.FileDescriptorProto{
+		Name:       proto.String("devices.proto"),
+		Package:    proto.String("devices"),
+		Syntax:     proto.String("proto3"),
+		Dependency: []string{"google/protobuf/timestamp.proto"},
+		EnumType: []*descriptorpb.EnumDescriptorProto{{
+			Name: proto.String("Mode"),
+			Value: []*descriptorpb.EnumValueDescriptorProto{
+				{Name: proto.String("MODE_UNSPECIFIED"), Number: proto.Int32(0)},
+				{Name: proto.String("MODE_FAST"), Number: proto.Int32(1)},
+			},
+		}},
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Node"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				field("name", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, optional),
+				children, labels, mode, host, port, at, note,
+			},
+			NestedType: []*descriptorpb.DescriptorProto{{
+				Name: proto.String("LabelsEntry"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("key", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, optional),
+					field("value", 2, descriptorpb.FieldDescriptorProto_TYPE_INT64, optional),
+				},
+				Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+			}},
+			OneofDecl: []*descriptorpb.OneofDescriptorProto{
+				{Name: proto.String("target")},
+				{Name: proto.String("_note")},
+			},
+		}, {
+			Name: proto.String("Ack"),
+		}},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("Devices"),
+			Method: []*descriptorpb.MethodDescriptorProto{{
+				Name:            proto.String("Walk"),
+				InputType:       proto.String(".devices.Node"),
+				OutputType:      proto.String(".devices.Ack"),
+				ServerStreaming: proto.Bool(true),
+			}},
+		}},
+	}
+
+	files := &protoregistry.Files{}
+	if err := files.RegisterFile(timestamppb.File_google_protobuf_timestamp_proto); err != nil {
+		t.Fatal(err)
+	}
+	fd, err := protodesc.NewFile(file, files)
+	if err != nil {
+		t.Fatalf("failed to build descriptors: %v", err)
+	}
+	if err := files.RegisterFile(fd); err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestLoadSchema(t *testing.T) {
+	source := &fakeFinder{
+		services: []string{"grpc.reflection.v1.ServerReflection", "devices.Devices"},
+		files:    testFiles(t),
+	}
+	s, err := loadSchema(context.Background(), source)
+	if err != nil {
+		t.Fatalf("failed to load schema: %v", err)
+	}
+
+	if len(s.Services) != 1 || s.Services[0].Name != "devices.Devices" {
+		t.Fatalf("services = %+v, want only devices.Devices", s.Services)
+	}
+	want := methodInfo{
+		Name:            "Walk",
+		FullName:        "devices.Devices.Walk",
+		Input:           "devices.Node",
+		Output:          "devices.Ack",
+		ServerStreaming: true,
+	}
+	if methods := s.Services[0].Methods; len(methods) != 1 || methods[0] != want {
+		t.Errorf("methods = %+v, want %+v", methods, want)
+	}
+
+	node, ok := s.Messages["devices.Node"]
+	if !ok {
+		t.Fatalf("messages = %v, want devices.Node", s.Messages)
+	}
+	if !slices.Equal(node.Oneofs, []string{"target"}) {
+		t.Errorf("oneofs = %v, want only target", node.Oneofs)
+	}
+	fields := make(map[string]fieldInfo)
+	for _, f := range node.Fields {
+		fields[f.Name] = f
+	}
+	for name, check := range map[string]func(fieldInfo) bool{
+		"name":     func(f fieldInfo) bool { return f.Kind == "string" && !f.Repeated },
+		"children": func(f fieldInfo) bool { return f.Kind == "message" && f.Repeated && f.Message == "devices.Node" },
+		"labels": func(f fieldInfo) bool {
+			return f.Kind == "map" && f.MapKey != nil && f.MapKey.Kind == "string" && f.MapValue != nil && f.MapValue.Kind == "int64"
+		},
+		"mode": func(f fieldInfo) bool { return f.Kind == "enum" && f.Enum == "devices.Mode" },
+		"host": func(f fieldInfo) bool { return f.Oneof == "target" },
+		"port": func(f fieldInfo) bool { return f.Kind == "int32" && f.Oneof == "target" },
+		"at":   func(f fieldInfo) bool { return f.Message == "google.protobuf.Timestamp" },
+		"note": func(f fieldInfo) bool { return f.Oneof == "" },
+	} {
+		if f, ok := fields[name]; !ok || !check(f) {
+			t.Errorf("field %s = %+v", name, f)
+		}
+	}
+
+	if !s.Messages["google.protobuf.Timestamp"].WellKnown {
+		t.Error("google.protobuf.Timestamp is not marked well-known")
+	}
+	if _, ok := s.Messages["devices.Ack"]; ok {
+		t.Error("described the response message, which the UI never edits")
+	}
+	if values := s.Enums["devices.Mode"].Values; !slices.Equal(values, []string{"MODE_UNSPECIFIED", "MODE_FAST"}) {
+		t.Errorf("enum values = %v", values)
+	}
+}
+
+func TestLoadSchemaErrors(t *testing.T) {
+	files := testFiles(t)
+	for _, services := range [][]string{
+		{"devices.Missing"},
+		{"devices.Node"},
+	} {
+		source := &fakeFinder{services: services, files: files}
+		if _, err := loadSchema(context.Background(), source); err == nil {
+			t.Errorf("loaded a schema listing %v", services)
+		}
+	}
+}
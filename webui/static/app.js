@@ -0,0 +1,447 @@
+'use strict';
+
+// State of the page: the loaded device schema and the call in progress
+let schema = null;
+let bridgeID = '';
+let method = null;
+let form = null;
+let socket = null;
+
+const $ = (id) => document.getElementById(id);
+
+function el(tag, attrs = {}, ...children) {
+  const node = document.createElement(tag);
+  for (const [key, value] of Object.entries(attrs)) {
+    if (key === 'class') node.className = value;
+    else if (key.startsWith('on')) node.addEventListener(key.slice(2), value);
+    else node.setAttribute(key, value);
+  }
+  for (const child of children) {
+    node.append(child);
+  }
+  return node;
+}
+
+async function fetchJSON(url) {
+  const response = await fetch(url);
+  const body = await response.json();
+  if (!response.ok) {
+    throw new Error(body.message || response.statusText);
+  }
+  return body;
+}
+
+// Devices
+
+async function loadDevices() {
+  const { devices, default: defaultID } = await fetchJSON('api/devices');
+  const list = $('devices');
+  list.replaceChildren(...devices.map((d) => el('option', { value: d.bridge_id },
+    d.online ? `online ${d.version || ''}` : 'offline')));
+  if (!$('bridge').value) {
+    $('bridge').value = defaultID || (devices[0] && devices[0].bridge_id) || '';
+  }
+}
+
+async function loadSchema() {
+  bridgeID = $('bridge').value.trim();
+  if (!bridgeID) return;
+  $('device-status').textContent = 'loading…';
+  $('services').replaceChildren();
+  $('method').hidden = true;
+  try {
+    schema = await fetchJSON(`api/devices/${encodeURIComponent(bridgeID)}/schema`);
+    $('device-status').textContent = '';
+    renderServices();
+  } catch (err) {
+    $('device-status').textContent = err.message;
+  }
+}
+
+function renderServices() {
+  const nav = $('services');
+  nav.replaceChildren();
+  for (const service of schema.services) {
+    const items = service.methods.map((m) => {
+      const link = el('a', { onclick: () => selectMethod(service, m, link) }, m.name);
+      return el('li', {}, link);
+    });
+    nav.append(el('h3', {}, service.name), el('ul', {}, ...items));
+  }
+}
+
+function methodKind(m) {
+  if (m.clientStreaming && m.serverStreaming) return 'bidi streaming';
+  if (m.clientStreaming) return 'client streaming';
+  if (m.serverStreaming) return 'server streaming';
+  return 'unary';
+}
+
+function selectMethod(service, m, link) {
+  closeSocket();
+  document.querySelectorAll('nav a.selected').forEach((a) => a.classList.remove('selected'));
+  link.classList.add('selected');
+  method = { ...m, service: service.name };
+  $('method').hidden = false;
+  $('method-name').textContent = m.fullName;
+  $('method-kind').textContent = `${methodKind(m)} · ${m.input} → ${m.output}`;
+  form = buildMessage(m.input);
+  $('request').replaceChildren(form.node);
+  $('request-json').value = '{}';
+  clearResponse();
+  setCalling(false);
+}
+
+// Request forms built from the message descriptors
+
+const intKinds = ['int32', 'sint32', 'sfixed32', 'uint32', 'fixed32'];
+const longKinds = ['int64', 'sint64', 'sfixed64', 'uint64', 'fixed64'];
+const floatKinds = ['float', 'double'];
+
+// buildMessage returns an editor for a message: its node and a get function
+// returning the JSON value, or undefined when nothing is set
+function buildMessage(name) {
+  const message = schema.messages[name];
+  if (!message || message.wellKnown) {
+    return buildJSONValue(name);
+  }
+  const node = el('div');
+  const editors = [];
+  for (const field of message.fields.filter((f) => !f.oneof)) {
+    const editor = buildField(field);
+    node.append(row(field, editor.node));
+    editors.push([field.jsonName, editor]);
+  }
+  for (const oneof of message.oneofs || []) {
+    const members = message.fields.filter((f) => f.oneof === oneof);
+    const holder = el('div');
+    let chosen = null;
+    const select = el('select', {
+      onchange: () => {
+        const field = members.find((f) => f.name === select.value);
+        chosen = field ? [field.jsonName, buildField(field)] : null;
+        holder.replaceChildren(chosen ? row(field, chosen[1].node) : '');
+      },
+    }, el('option', { value: '' }, '(none)'), ...members.map((f) => el('option', { value: f.name }, f.name)));
+    node.append(el('div', { class: 'field' }, el('label', {}, `oneof ${oneof}`), select), holder);
+    editors.push([null, { get: () => chosen && { [chosen[0]]: chosen[1].get() } }]);
+  }
+  return {
+    node,
+    get() {
+      const value = {};
+      for (const [key, editor] of editors) {
+        const v = editor.get();
+        if (v === undefined || v === null) continue;
+        if (key === null) Object.assign(value, v);
+        else value[key] = v;
+      }
+      return Object.keys(value).length ? value : undefined;
+    },
+  };
+}
+
+function row(field, node) {
+  const type = field.kind === 'map'
+    ? `map<${field.mapKey.kind}, ${typeName(field.mapValue)}>`
+    : `${field.repeated ? 'repeated ' : ''}${typeName(field)}`;
+  return el('div', { class: 'field' },
+    el('label', {}, field.name, el('br'), el('span', { class: 'type' }, type)), node);
+}
+
+function typeName(field) {
+  return field.message || field.enum || field.kind;
+}
+
+function buildField(field) {
+  if (field.kind === 'map') return buildMap(field);
+  if (field.repeated) return buildRepeated(field);
+  return buildSingle(field);
+}
+
+function buildSingle(field) {
+  if (field.kind === 'message') return buildOptionalMessage(field.message);
+  if (field.kind === 'enum') return buildEnum(field.enum);
+  if (field.kind === 'bool') {
+    const input = el('input', { type: 'checkbox' });
+    return { node: input, get: () => (input.checked ? true : undefined) };
+  }
+  const input = el('input', { type: 'text', placeholder: field.kind === 'bytes' ? 'base64' : field.kind });
+  return {
+    node: input,
+    get() {
+      if (input.value === '') return undefined;
+      if (intKinds.includes(field.kind) || floatKinds.includes(field.kind)) {
+        const n = Number(input.value);
+        return Number.isNaN(n) ? input.value : n;
+      }
+      // 64-bit integers are strings in JSON to keep their precision
+      return input.value;
+    },
+  };
+}
+
+// buildOptionalMessage renders a nested message only once it is set, so that
+// recursive messages stay finite
+function buildOptionalMessage(name) {
+  const fieldset = el('fieldset');
+  let editor = null;
+  const toggle = el('input', {
+    type: 'checkbox',
+    onchange: () => {
+      editor = toggle.checked ? buildMessage(name) : null;
+      fieldset.replaceChildren(legend, ...(editor ? [editor.node] : []));
+    },
+  });
+  const legend = el('legend', {}, el('label', {}, toggle, ' set'));
+  fieldset.append(legend);
+  return { node: fieldset, get: () => (editor ? editor.get() || {} : undefined) };
+}
+
+function buildEnum(name) {
+  const values = (schema.enums[name] || { values: [] }).values;
+  const select = el('select', {}, ...values.map((v) => el('option', { value: v }, v)));
+  return { node: select, get: () => (select.selectedIndex > 0 ? select.value : undefined) };
+}
+
+function buildJSONValue(name) {
+  const area = el('textarea', { rows: 2, placeholder: `${name} as JSON` });
+  return {
+    node: area,
+    get() {
+      if (area.value.trim() === '') return undefined;
+      return JSON.parse(area.value);
+    },
+  };
+}
+
+function buildRepeated(field) {
+  const list = el('div');
+  const items = [];
+  const add = el('button', {
+    type: 'button',
+    onclick: () => {
+      const editor = field.kind === 'message' ? buildMessage(field.message) : buildSingle(field);
+      const item = el('div', { class: 'item' }, editor.node);
+      const remove = el('button', {
+        type: 'button',
+        onclick: () => {
+          items.splice(items.indexOf(editor), 1);
+          item.remove();
+        },
+      }, '×');
+      item.append(remove);
+      items.push(editor);
+      list.insertBefore(item, add);
+    },
+  }, 'Add');
+  list.append(add);
+  return {
+    node: list,
+    get() {
+      const values = items.map((e) => e.get()).map((v) => (v === undefined && field.kind === 'message' ? {} : v));
+      return values.length ? values : undefined;
+    },
+  };
+}
+
+function buildMap(field) {
+  const list = el('div');
+  const entries = [];
+  const add = el('button', {
+    type: 'button',
+    onclick: () => {
+      const key = el('input', { type: 'text', placeholder: `key (${field.mapKey.kind})` });
+      const value = buildSingle(field.mapValue);
+      const entry = { key, value };
+      const item = el('div', { class: 'item' }, key, value.node);
+      item.append(el('button', {
+        type: 'button',
+        onclick: () => {
+          entries.splice(entries.indexOf(entry), 1);
+          item.remove();
+        },
+      }, '×'));
+      entries.push(entry);
+      list.insertBefore(item, add);
+    },
+  }, 'Add entry');
+  list.append(add);
+  return {
+    node: list,
+    get() {
+      const value = {};
+      for (const { key, value: editor } of entries) {
+        const v = editor.get();
+        value[key.value] = v === undefined ? defaultValue(field.mapValue) : v;
+      }
+      return entries.length ? value : undefined;
+    },
+  };
+}
+
+function defaultValue(field) {
+  if (field.kind === 'message') return {};
+  if (field.kind === 'bool') return false;
+  if (field.kind === 'string' || field.kind === 'bytes' || field.kind === 'enum') return '';
+  return 0;
+}
+
+// Calls, made over the gateway WebSocket so that every kind of method shows
+// its headers, live messages and trailers
+
+function requestValue() {
+  if ($('request-json').hidden) {
+    return form.get() || {};
+  }
+  return JSON.parse($('request-json').value || '{}');
+}
+
+function metadataParams() {
+  const params = new URLSearchParams();
+  for (const row of $('metadata').querySelectorAll('.item')) {
+    const [name, value] = row.querySelectorAll('input');
+    if (name.value.trim()) params.append('metadata', `${name.value.trim()}: ${value.value}`);
+  }
+  return params.toString();
+}
+
+let started = 0;
+let firstResponse = 0;
+
+function invoke() {
+  let request;
+  try {
+    request = requestValue();
+  } catch (err) {
+    $('status').replaceChildren(el('span', { class: 'failed' }, `Invalid request: ${err.message}`));
+    return;
+  }
+  clearResponse();
+  closeSocket();
+
+  const scheme = location.protocol === 'https:' ? 'wss:' : 'ws:';
+  const path = [bridgeID, method.service, method.name].map(encodeURIComponent).join('/');
+  const query = metadataParams();
+  socket = new WebSocket(`${scheme}//${location.host}${location.pathname.replace(/[^/]*$/, '')}call/${path}${query ? '?' + query : ''}`);
+  started = performance.now();
+  firstResponse = 0;
+  setCalling(true);
+
+  socket.onopen = () => send(request);
+  socket.onmessage = (event) => onFrame(JSON.parse(event.data));
+  socket.onclose = (event) => {
+    setCalling(false);
+    if (!$('status').textContent) {
+      $('status').replaceChildren(el('span', { class: 'failed' }, event.reason || `connection closed (${event.code})`));
+    }
+    socket = null;
+  };
+}
+
+function send(request) {
+  if (!socket || socket.readyState !== WebSocket.OPEN) return;
+  socket.send(JSON.stringify({ message: request }));
+  logLine('sent', request);
+}
+
+function onFrame(frame) {
+  if (frame.header) {
+    $('headers').textContent = formatMetadata(frame.header);
+  }
+  if (frame.message) {
+    if (!firstResponse) firstResponse = performance.now();
+    logLine('received', frame.message);
+  }
+  if (frame.status) {
+    const elapsed = performance.now() - started;
+    const code = frame.status.code || 0;
+    $('status').replaceChildren(el('span', { class: code === 0 ? 'ok' : 'failed' },
+      code === 0 ? 'OK' : `Error ${code}: ${frame.status.message}`));
+    if (frame.status.details && frame.status.details.length) {
+      $('status').append(el('pre', {}, JSON.stringify(frame.status.details, null, 2)));
+    }
+    $('trailers').textContent = formatMetadata(frame.trailer || {});
+    $('timing').textContent = `Total ${elapsed.toFixed(1)} ms` +
+      (firstResponse ? ` · first response after ${(firstResponse - started).toFixed(1)} ms` : '');
+  }
+}
+
+function logLine(direction, message) {
+  const offset = ((performance.now() - started) / 1000).toFixed(3);
+  $('log').append(el('li', { class: direction }, `+${offset}s ${direction === 'sent' ? '→' : '←'} ${JSON.stringify(message, null, 2)}`));
+}
+
+function formatMetadata(md) {
+  return Object.entries(md).map(([key, values]) => values.map((v) => `${key}: ${v}`).join('\n')).join('\n');
+}
+
+function clearResponse() {
+  for (const id of ['status', 'headers', 'log', 'trailers', 'timing']) {
+    $(id).replaceChildren();
+  }
+}
+
+function setCalling(calling) {
+  const streaming = method && method.clientStreaming;
+  $('invoke').hidden = calling;
+  $('send').hidden = !(calling && streaming);
+  $('half-close').hidden = !(calling && streaming);
+  $('cancel').hidden = !calling;
+}
+
+function closeSocket() {
+  if (socket) {
+    socket.onclose = null;
+    socket.close();
+    socket = null;
+  }
+}
+
+// Wiring
+
+$('load').addEventListener('click', loadSchema);
+$('bridge').addEventListener('keydown', (event) => {
+  if (event.key === 'Enter') loadSchema();
+});
+$('invoke').addEventListener('click', invoke);
+$('send').addEventListener('click', () => {
+  try {
+    send(requestValue());
+  } catch (err) {
+    logLine('error', err.message);
+  }
+});
+$('half-close').addEventListener('click', () => {
+  if (socket) socket.send(JSON.stringify({ halfClose: true }));
+  $('send').hidden = true;
+  $('half-close').hidden = true;
+});
+$('cancel').addEventListener('click', () => {
+  closeSocket();
+  setCalling(false);
+  $('status').replaceChildren(el('span', { class: 'failed' }, 'Cancelled'));
+});
+$('add-metadata').addEventListener('click', () => {
+  const item = el('div', { class: 'item' }, el('input', { placeholder: 'name' }), el('input', { placeholder: 'value' }));
+  item.append(el('button', { type: 'button', onclick: () => item.remove() }, '×'));
+  $('metadata').append(item);
+});
+document.querySelectorAll('input[name=editor]').forEach((radio) => radio.addEventListener('change', () => {
+  const json = radio.value === 'json' && radio.checked;
+  if (json && form) {
+    try {
+      $('request-json').value = JSON.stringify(form.get() || {}, null, 2);
+    } catch (err) {
+      $('request-json').value = '{}';
+    }
+  }
+  $('request').hidden = json;
+  $('request-json').hidden = !json;
+}));
+$('request').addEventListener('submit', (event) => event.preventDefault());
+
+loadDevices().then(loadSchema).catch((err) => {
+  $('device-status').textContent = err.message;
+});
+setInterval(() => loadDevices().catch(() => {}), 10000);
@@ -0,0 +1,185 @@
+// Package webui serves a local web page for browsing the services of devices
+// and calling them, in the spirit of grpcui
+package webui
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"io/fs"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	client "github.com/vedantkulkarni/reflect-poc/client"
+	gateway "github.com/vedantkulkarni/reflect-poc/gateway"
+	server "github.com/vedantkulkarni/reflect-poc/server"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+//go:embed static
+var static embed.FS
+
+// UI serves the web page, the device and schema API it reads, and the calls
+// it makes through a Gateway mounted at /call/
+type UI struct {
+	// Pool provides the client of each device
+	Pool *client.Pool
+	// Gateway relays the calls of the page; its WebSocket endpoint carries
+	// every kind of call with live message logs
+	Gateway *gateway.Gateway
+	// BridgeID is the device selected when the page opens, listed even if it
+	// has not announced itself
+	BridgeID string
+	// SchemaTimeout bounds the reflection calls that load a device schema
+	SchemaTimeout time.Duration
+	// Addr is the address the UI listens on. Requests must name it or a
+	// loopback host, so that pages served under other names, for example
+	// through DNS rebinding, cannot call devices with the operator's
+	// credentials.
+	Addr string
+	// Logger records API errors; nil disables logging
+	Logger *zap.Logger
+
+	mu      sync.Mutex
+	devices map[string]server.Presence
+}
+
+// Handler returns the HTTP handler of the UI
+func (u *UI) Handler() http.Handler {
+	files, _ := fs.Sub(static, "static")
+	mux := http.NewServeMux()
+	mux.Handle("/", http.FileServerFS(files))
+	mux.HandleFunc("GET /api/devices", u.handleDevices)
+	mux.HandleFunc("GET /api/devices/{bridge}/schema", u.handleSchema)
+	mux.Handle("/call/", http.StripPrefix("/call", headersFromQuery(u.Gateway.Handler())))
+	return u.checkHost(mux)
+}
+
+// checkHost rejects requests whose Host header names neither Addr nor a
+// loopback host
+func (u *UI) checkHost(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !u.allowedHost(r.Host) {
+			http.Error(w, "unexpected host", http.StatusMisdirectedRequest)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (u *UI) allowedHost(host string) bool {
+	if host == u.Addr {
+		return true
+	}
+	name := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		name = h
+	}
+	name = strings.Trim(name, "[]")
+	if name == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(name)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (u *UI) logger() *zap.Logger {
+	if u.Logger == nil {
+		return zap.NewNop()
+	}
+	return u.Logger
+}
+
+// WatchDevices keeps the list of devices up to date from their presence
+// announcements until ctx is done
+func (u *UI) WatchDevices(ctx context.Context) error {
+	return client.WatchPresence(ctx, u.Pool.MQTTClient(), func(presence server.Presence) {
+		u.mu.Lock()
+		defer u.mu.Unlock()
+		if u.devices == nil {
+			u.devices = make(map[string]server.Presence)
+		}
+		u.devices[presence.BridgeID] = presence
+	})
+}
+
+// handleDevices lists the announced devices, online ones first
+func (u *UI) handleDevices(w http.ResponseWriter, r *http.Request) {
+	u.mu.Lock()
+	devices := make([]server.Presence, 0, len(u.devices)+1)
+	for _, presence := range u.devices {
+		devices = append(devices, presence)
+	}
+	_, announced := u.devices[u.BridgeID]
+	u.mu.Unlock()
+	if u.BridgeID != "" && !announced {
+		devices = append(devices, server.Presence{BridgeID: u.BridgeID})
+	}
+	sort.Slice(devices, func(i, j int) bool {
+		if devices[i].Online != devices[j].Online {
+			return devices[i].Online
+		}
+		return devices[i].BridgeID < devices[j].BridgeID
+	})
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"devices": devices,
+		"default": u.BridgeID,
+	})
+}
+
+// handleSchema describes the services of a device through reflection
+func (u *UI) handleSchema(w http.ResponseWriter, r *http.Request) {
+	bridgeID := r.PathValue("bridge")
+	s, err := u.schema(r.Context(), bridgeID)
+	if err != nil {
+		u.logger().Warn("Failed to load schema", zap.String("bridge_id", bridgeID), zap.Error(err))
+		st, _ := status.FromError(err)
+		writeJSON(w, gateway.HTTPStatusFromCode(st.Code()), map[string]any{"code": st.Code(), "message": st.Message()})
+		return
+	}
+	writeJSON(w, http.StatusOK, s)
+}
+
+func (u *UI) schema(ctx context.Context, bridgeID string) (*schema, error) {
+	if err := gateway.ValidateBridgeID(bridgeID); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	drs, err := u.Pool.Client(bridgeID)
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "failed to open client for %s: %v", bridgeID, err)
+	}
+	if u.SchemaTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, u.SchemaTimeout)
+		defer cancel()
+	}
+	return loadSchema(ctx, drs)
+}
+
+// headersFromQuery turns the metadata query parameters of a call, given as
+// "name: value", into the headers the gateway forwards, since browsers cannot
+// set headers on WebSockets
+func headersFromQuery(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, header := range r.URL.Query()["metadata"] {
+			name, value, ok := strings.Cut(header, ":")
+			if name = strings.TrimSpace(name); ok && name != "" {
+				r.Header.Add(gateway.MetadataHeaderPrefix+name, strings.TrimSpace(value))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
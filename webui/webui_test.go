@@ -0,0 +1,35 @@
+package webui
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	gateway "github.com/vedantkulkarni/reflect-poc/gateway"
+)
+
+// TestHandlerChecksHost checks that only requests naming the UI's address or
+// a loopback host are served, which stops DNS rebinding
+func TestHandlerChecksHost(t *testing.T) {
+	handler := (&UI{Gateway: &gateway.Gateway{}, Addr: "192.168.1.5:8082"}).Handler()
+	for _, tc := range []struct {
+		host string
+		ok   bool
+	}{
+		{"127.0.0.1:8082", true},
+		{"localhost:8082", true},
+		{"[::1]:8082", true},
+		{"192.168.1.5:8082", true},
+		{"rebind.example:8082", false},
+		{"192.168.1.5:9000", false},
+		{"127.0.0.1.nip.io:8082", false},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Host = tc.host
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		if ok := recorder.Code != http.StatusMisdirectedRequest; ok != tc.ok {
+			t.Errorf("host %s: status %d, want served %v", tc.host, recorder.Code, tc.ok)
+		}
+	}
+}